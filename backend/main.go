@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"khairul169/garage-webui/middleware"
 	"khairul169/garage-webui/router"
 	"khairul169/garage-webui/ui"
 	"khairul169/garage-webui/utils"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,19 +20,51 @@ import (
 func main() {
 	// Initialize app
 	godotenv.Load()
+	utils.InitLogger()
+	shutdownTracing := utils.InitTracing()
+	utils.InitMetrics()
 	utils.InitCacheManager()
+	utils.InitThumbnailService()
+	utils.InitJobManager()
 	sessionMgr := utils.InitSessionManager()
 
 	if err := utils.Garage.LoadConfig(); err != nil {
-		log.Println("Cannot load garage config!", err)
+		slog.Warn("Cannot load garage config.", "error", err)
 	}
+	utils.InitSessionKeyManager()
+	utils.InitS3ClientFactory()
+	utils.InitHistoryStore()
 
 	basePath := os.Getenv("BASE_PATH")
 	mux := http.NewServeMux()
 
 	// Serve API
 	apiPrefix := basePath + "/api"
-	mux.Handle(apiPrefix+"/", http.StripPrefix(apiPrefix, router.HandleApiRouter()))
+	mux.Handle(apiPrefix+"/", middleware.RequestLogger(http.StripPrefix(apiPrefix, router.HandleApiRouter())))
+	router.InitSync()
+	router.InitDedup()
+	router.InitReplication()
+	router.InitSnapshots()
+	router.InitBucketTemplates()
+	router.InitReconciler()
+	router.InitHistory()
+	router.InitAlerting()
+	router.InitReports()
+	router.InitEvents()
+	utils.Jobs.Resume()
+
+	// Serve metrics, protected by their own token
+	metricsToken := os.Getenv("METRICS_TOKEN")
+	if metricsToken == "" {
+		slog.Info("METRICS_TOKEN is not set, the metrics endpoint is disabled.")
+	}
+	mux.Handle(basePath+"/metrics", utils.MetricsHandler(metricsToken))
+
+	// Serve probes, without auth for the orchestrator
+	router.InitProbes()
+	probes := &router.Probes{}
+	mux.HandleFunc("GET "+basePath+"/healthz", probes.Live)
+	mux.HandleFunc("GET "+basePath+"/readyz", probes.Ready)
 
 	// Static files
 	ui.ServeUI(mux)
@@ -41,9 +78,38 @@ func main() {
 	port := utils.GetEnv("PORT", "3909")
 
 	addr := fmt.Sprintf("%s:%s", host, port)
-	log.Printf("Starting server on http://%s", addr)
+	slog.Info("Starting server.", "url", "http://"+addr)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := http.ListenAndServe(addr, sessionMgr.LoadAndSave(mux)); err != nil {
-		log.Fatal(err)
+	server := &http.Server{Addr: addr, Handler: sessionMgr.LoadAndSave(mux)}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		slog.Error("Cannot start server.", "error", err)
+		exitCode = 1
+	case <-ctx.Done():
+		slog.Info("Shutting down server.")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Cannot close all connections.", "error", err)
+		}
+		cancel()
+	}
+
+	// Flush the spans not exported yet
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Cannot flush traces.", "error", err)
+	}
+	cancel()
+	if exitCode != 0 {
+		os.Exit(exitCode)
 	}
 }
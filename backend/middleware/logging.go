@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const maxRequestIDLength = 128
+
+// RequestLogger gives each request an ID, taken from the X-Request-ID header
+// of the client if valid, and logs the request once served. The ID is echoed
+// in the response and passed on to the calls made for the request.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(utils.RequestIDHeader)
+		if !isValidRequestID(id) {
+			id = utils.NewRequestID()
+		}
+		w.Header().Set(utils.RequestIDHeader, id)
+		r = r.WithContext(utils.WithRequestID(r.Context(), id))
+
+		// Logins set the user while serving, logouts clear it
+		user := sessionUser(r)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		if current := sessionUser(r); current != "" {
+			user = current
+		}
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "Request.",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Int64("bytes", recorder.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("user", user),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+func sessionUser(r *http.Request) string {
+	user, _ := utils.Session.Get(r, "username").(string)
+	return user
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlnum && c != '-' && c != '_' && c != '.' && c != ':' {
+			return false
+		}
+	}
+	return true
+}
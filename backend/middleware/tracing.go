@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingMiddleware serves each request in a span, continuing the trace of
+// the client if it sent a trace context. The span is named after the route
+// that matched the request.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := utils.ExtractTraceContext(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := utils.Tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		))
+		defer span.End()
+		if id := utils.RequestID(ctx); id != "" {
+			span.SetAttributes(semconv.HTTPRequestHeader("x-request-id", id))
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(recorder, r)
+
+		// As in MetricsMiddleware, the pattern is set by the muxes while serving
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+			_, route, _ := strings.Cut(r.Pattern, " ")
+			if route == "" {
+				route = r.Pattern
+			}
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(recorder.status),
+			semconv.HTTPResponseBodySize(int(recorder.bytes)),
+		)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
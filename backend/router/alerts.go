@@ -0,0 +1,793 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+const alertHistorySeries = "alerts"
+
+var errSilenceNotFound = errors.New("silence not found")
+
+type Alerts struct{}
+
+type alertManager struct {
+	// evalMu serializes evaluations, mu guards the fields below
+	evalMu sync.Mutex
+	mu     sync.Mutex
+
+	enabled      bool
+	config       schema.AlertConfig
+	interval     time.Duration
+	pendingFor   map[string]time.Duration
+	active       map[string]*schema.Alert
+	silences     []schema.AlertSilence
+	silencesPath string
+	evaluatedAt  *time.Time
+	lastError    string
+}
+
+var alerting = &alertManager{
+	pendingFor: map[string]time.Duration{},
+	active:     map[string]*schema.Alert{},
+}
+
+// alertObservation is a subject currently matching the condition of a rule.
+// If unknown, the condition could not be checked for the subject, so its
+// alert is kept as is.
+type alertObservation struct {
+	subject string
+	summary string
+	value   float64
+	unknown bool
+}
+
+// alertInputs holds the cluster state the rules are evaluated against. The
+// buckets and block errors are only fetched if a rule needs them.
+type alertInputs struct {
+	cluster      schema.ClusterSample
+	nodes        schema.NodesSample
+	clusterErr   error
+	buckets      []schema.Bucket
+	failed       []schema.Bucket
+	bucketsErr   error
+	blockErrors  map[string]int
+	blockErrsErr error
+}
+
+// InitAlerting loads the silences from ALERT_SILENCES_FILE and the alert
+// rules from ALERT_CONFIG, evaluating the rules at the configured interval.
+func InitAlerting() {
+	alerting.silencesPath = utils.GetEnv("ALERT_SILENCES_FILE", utils.DataPath("silences.json"))
+	if err := alerting.loadSilences(); err != nil {
+		slog.Error("Cannot read alert silences.", "error", err)
+	}
+
+	configPath := os.Getenv("ALERT_CONFIG")
+	if configPath == "" {
+		return
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		slog.Error("Cannot read alert config.", "error", err)
+		return
+	}
+
+	var config schema.AlertConfig
+	if err := toml.Unmarshal(data, &config); err != nil {
+		slog.Error("Cannot parse alert config.", "error", err)
+		return
+	}
+	if err := validateAlertConfig(&config); err != nil {
+		slog.Error("Invalid alert config.", "error", err)
+		return
+	}
+
+	alerting.mu.Lock()
+	alerting.enabled = true
+	alerting.config = config
+	alerting.interval, _ = time.ParseDuration(config.Interval)
+	for _, rule := range config.Rules {
+		alerting.pendingFor[rule.Name], _ = time.ParseDuration(rule.For)
+	}
+	alerting.mu.Unlock()
+
+	go alerting.schedule()
+}
+
+func validateAlertConfig(config *schema.AlertConfig) error {
+	if config.Interval == "" {
+		config.Interval = "1m"
+	}
+	if interval, err := time.ParseDuration(config.Interval); err != nil || interval < 10*time.Second {
+		return fmt.Errorf("invalid interval %q", config.Interval)
+	}
+
+	receivers := map[string]bool{}
+	for _, receiver := range config.Receivers {
+		if receiver.Name == "" || receivers[receiver.Name] {
+			return fmt.Errorf("receivers need a unique name, got %q", receiver.Name)
+		}
+		receivers[receiver.Name] = true
+
+		switch receiver.Type {
+		case schema.AlertReceiverWebhook, schema.AlertReceiverSlack:
+			if receiver.URL == "" {
+				return fmt.Errorf("receiver %s needs a url", receiver.Name)
+			}
+		case schema.AlertReceiverEmail:
+			if len(receiver.To) == 0 {
+				return fmt.Errorf("receiver %s needs recipients", receiver.Name)
+			}
+			if config.SMTP.Host == "" || config.SMTP.From == "" {
+				return fmt.Errorf("receiver %s needs the smtp host and from address", receiver.Name)
+			}
+		default:
+			return fmt.Errorf("unknown type %q of receiver %s", receiver.Type, receiver.Name)
+		}
+	}
+	if config.SMTP.Port == 0 {
+		config.SMTP.Port = 587
+	}
+
+	names := map[string]bool{}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Name == "" {
+			rule.Name = rule.Type
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Type {
+		case schema.AlertRuleNodeDown, schema.AlertRuleClusterHealth:
+		case schema.AlertRuleDiskFree:
+			if rule.Threshold == 0 {
+				rule.Threshold = 10
+			}
+			switch rule.Partition {
+			case "":
+				rule.Partition = "data"
+			case "data", "metadata":
+			default:
+				return fmt.Errorf("unknown partition %q of rule %s", rule.Partition, rule.Name)
+			}
+		case schema.AlertRuleBucketQuota:
+			if rule.Threshold == 0 {
+				rule.Threshold = 90
+			}
+		case schema.AlertRuleBlockErrors:
+		default:
+			return fmt.Errorf("unknown type %q of rule %s", rule.Type, rule.Name)
+		}
+
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+		if rule.For != "" {
+			if _, err := time.ParseDuration(rule.For); err != nil {
+				return fmt.Errorf("invalid for %q of rule %s", rule.For, rule.Name)
+			}
+		}
+		for _, name := range rule.Receivers {
+			if !receivers[name] {
+				return fmt.Errorf("unknown receiver %q of rule %s", name, rule.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *alertManager) schedule() {
+	for {
+		ctx, span := utils.StartTask(context.Background(), "alerts evaluation")
+		m.evaluate(ctx)
+		span.End()
+		time.Sleep(m.interval)
+	}
+}
+
+// evaluate checks all rules, updates the active alerts and sends the
+// notifications of alerts that started firing or resolved. Rules whose
+// inputs cannot be read keep their alerts unchanged.
+func (m *alertManager) evaluate(ctx context.Context) {
+	config, notify := m.update(ctx)
+
+	// Notifications are sent outside of evalMu, so a slow receiver does not
+	// hold up the next evaluation
+	for _, alert := range notify {
+		if err := sendAlertNotification(config, alert); err != nil {
+			slog.Error("Cannot send alert notification.", "alert", alert.ID, "error", err)
+			if alert.State == schema.AlertStateFiring {
+				// Retry on the next evaluation
+				m.mu.Lock()
+				if active, ok := m.active[alert.ID]; ok {
+					active.Notified = false
+				}
+				m.mu.Unlock()
+			}
+		}
+	}
+}
+
+// update evaluates the rules and updates the active alerts, returning the
+// alerts to notify.
+func (m *alertManager) update(ctx context.Context) (schema.AlertConfig, []schema.Alert) {
+	m.evalMu.Lock()
+	defer m.evalMu.Unlock()
+
+	m.mu.Lock()
+	config := m.config
+	m.mu.Unlock()
+
+	now := time.Now().UTC()
+	inputs := loadAlertInputs(ctx, config.Rules)
+
+	observed := map[string]map[string]alertObservation{}
+	var evalErrors []error
+	for _, rule := range config.Rules {
+		observations, err := evaluateAlertRule(rule, inputs)
+		if err != nil {
+			evalErrors = append(evalErrors, fmt.Errorf("rule %s: %w", rule.Name, err))
+			continue
+		}
+		observed[rule.Name] = observations
+	}
+
+	var events []schema.AlertEvent
+	var notify []schema.Alert
+
+	m.mu.Lock()
+	for _, rule := range config.Rules {
+		observations, ok := observed[rule.Name]
+		if !ok {
+			continue
+		}
+
+		for id, observation := range observations {
+			if observation.unknown {
+				continue
+			}
+			alert, exists := m.active[id]
+			if !exists {
+				alert = &schema.Alert{
+					ID:       id,
+					Rule:     rule.Name,
+					Type:     rule.Type,
+					Severity: rule.Severity,
+					Subject:  observation.subject,
+					State:    schema.AlertStatePending,
+					StartsAt: now,
+				}
+				m.active[id] = alert
+			}
+			alert.Summary = observation.summary
+			alert.Value = observation.value
+
+			if alert.State == schema.AlertStatePending && now.Sub(alert.StartsAt) >= m.pendingFor[rule.Name] {
+				alert.State = schema.AlertStateFiring
+				alert.FiredAt = &now
+				events = append(events, schema.AlertEvent{Time: now, State: schema.AlertStateFiring, Alert: *alert})
+			}
+		}
+
+		for id, alert := range m.active {
+			if alert.Rule != rule.Name {
+				continue
+			}
+			if _, ok := observations[id]; ok {
+				continue
+			}
+
+			delete(m.active, id)
+			if alert.State != schema.AlertStateFiring {
+				continue
+			}
+			alert.State = schema.AlertStateResolved
+			alert.ResolvedAt = &now
+			events = append(events, schema.AlertEvent{Time: now, State: schema.AlertStateResolved, Alert: *alert})
+			if alert.Notified {
+				notify = append(notify, *alert)
+			}
+		}
+	}
+
+	for _, alert := range m.active {
+		alert.Silenced = m.isSilencedLocked(*alert, now)
+		if alert.State == schema.AlertStateFiring && !alert.Notified && !alert.Silenced {
+			alert.Notified = true
+			notify = append(notify, *alert)
+		}
+	}
+
+	m.evaluatedAt = &now
+	m.lastError = ""
+	if err := errors.Join(evalErrors...); err != nil {
+		m.lastError = err.Error()
+	}
+	m.mu.Unlock()
+
+	for _, err := range evalErrors {
+		slog.Warn("Cannot evaluate alert rule.", "error", err)
+	}
+
+	if len(events) > 0 && utils.History != nil {
+		if err := utils.History.Append(alertHistorySeries, now, events); err != nil {
+			slog.Error("Cannot save alert history.", "error", err)
+		}
+	}
+
+	return config, notify
+}
+
+func loadAlertInputs(ctx context.Context, rules []schema.AlertRule) *alertInputs {
+	inputs := &alertInputs{}
+	inputs.cluster, inputs.nodes, inputs.clusterErr = sampleCluster(ctx, time.Now().UTC())
+
+	needs := map[string]bool{}
+	for _, rule := range rules {
+		needs[rule.Type] = true
+	}
+	if needs[schema.AlertRuleBucketQuota] {
+		inputs.buckets, inputs.failed, inputs.bucketsErr = readBucketInfos(ctx)
+	}
+	if needs[schema.AlertRuleBlockErrors] {
+		inputs.blockErrors, inputs.blockErrsErr = countBlockErrors(ctx)
+	}
+
+	return inputs
+}
+
+// countBlockErrors returns the number of blocks with errors per node.
+func countBlockErrors(ctx context.Context) (map[string]int, error) {
+	body, err := utils.Garage.Fetch("/v2/ListBlockErrors", &utils.FetchOptions{
+		Context: ctx,
+		Params:  map[string]string{"node": "*"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list block errors: %w", err)
+	}
+
+	var result struct {
+		Success map[string][]json.RawMessage `json:"success"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for node, errs := range result.Success {
+		counts[node] = len(errs)
+	}
+	return counts, nil
+}
+
+func nodeDisplayName(node schema.NodeSample) string {
+	if node.Hostname != "" {
+		return node.Hostname
+	}
+	return node.ID[:min(len(node.ID), 16)]
+}
+
+// evaluateAlertRule returns the subjects matching the condition of the rule,
+// by alert ID.
+func evaluateAlertRule(rule schema.AlertRule, inputs *alertInputs) (map[string]alertObservation, error) {
+	observations := map[string]alertObservation{}
+	add := func(key string, subject string, value float64, summary string) {
+		id := rule.Name + "/" + key
+		observations[id] = alertObservation{subject: subject, summary: summary, value: value}
+	}
+
+	if inputs.clusterErr != nil {
+		return nil, inputs.clusterErr
+	}
+
+	switch rule.Type {
+	case schema.AlertRuleClusterHealth:
+		cluster := inputs.cluster
+		if cluster.Status != "healthy" {
+			add("cluster", "cluster", float64(cluster.StorageNodesUp), fmt.Sprintf("Cluster is %s: %d of %d storage nodes up, %d of %d partitions healthy",
+				cluster.Status, cluster.StorageNodesUp, cluster.StorageNodes, cluster.PartitionsAllOk, cluster.Partitions))
+		}
+
+	case schema.AlertRuleNodeDown:
+		for _, node := range inputs.nodes.Nodes {
+			if !node.IsUp {
+				add(node.ID, nodeDisplayName(node), 1, fmt.Sprintf("Node %s is down", nodeDisplayName(node)))
+			}
+		}
+
+	case schema.AlertRuleDiskFree:
+		for _, node := range inputs.nodes.Nodes {
+			total, available := node.DataTotal, node.DataAvailable
+			if rule.Partition == "metadata" {
+				total, available = node.MetadataTotal, node.MetadataAvailable
+			}
+			if !node.IsUp || total == 0 {
+				continue
+			}
+
+			free := float64(available) / float64(total) * 100
+			if free < rule.Threshold {
+				add(node.ID, nodeDisplayName(node), free, fmt.Sprintf("The %s partition of node %s has %.1f%% free space left",
+					rule.Partition, nodeDisplayName(node), free))
+			}
+		}
+
+	case schema.AlertRuleBucketQuota:
+		if inputs.bucketsErr != nil {
+			return nil, inputs.bucketsErr
+		}
+
+		matches := func(bucket schema.Bucket) (string, bool) {
+			name := bucket.ID
+			if len(bucket.GlobalAliases) > 0 {
+				name = bucket.GlobalAliases[0]
+			}
+			return name, len(rule.Buckets) == 0 || containsString(rule.Buckets, name) || containsString(rule.Buckets, bucket.ID)
+		}
+
+		// Buckets whose usage cannot be read keep their alerts
+		for _, bucket := range inputs.failed {
+			if name, ok := matches(bucket); ok {
+				observations[rule.Name+"/"+bucket.ID] = alertObservation{subject: name, unknown: true}
+			}
+		}
+
+		for _, bucket := range inputs.buckets {
+			name, ok := matches(bucket)
+			if !ok {
+				continue
+			}
+
+			var used float64
+			if bucket.Quotas.MaxSize > 0 {
+				used = float64(bucket.Bytes) / float64(bucket.Quotas.MaxSize) * 100
+			}
+			if bucket.Quotas.MaxObjects > 0 {
+				used = max(used, float64(bucket.Objects)/float64(bucket.Quotas.MaxObjects)*100)
+			}
+			if used >= rule.Threshold {
+				add(bucket.ID, name, used, fmt.Sprintf("Bucket %s uses %.1f%% of its quota", name, used))
+			}
+		}
+
+	case schema.AlertRuleBlockErrors:
+		if inputs.blockErrsErr != nil {
+			return nil, inputs.blockErrsErr
+		}
+
+		names := map[string]string{}
+		for _, node := range inputs.nodes.Nodes {
+			names[node.ID] = nodeDisplayName(node)
+		}
+		for node, count := range inputs.blockErrors {
+			name, ok := names[node]
+			if !ok {
+				name = node[:min(len(node), 16)]
+			}
+			if float64(count) > rule.Threshold {
+				add(node, name, float64(count), fmt.Sprintf("Node %s has %d blocks with errors", name, count))
+			}
+		}
+	}
+
+	return observations, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *alertManager) isSilencedLocked(alert schema.Alert, now time.Time) bool {
+	for _, silence := range m.silences {
+		if now.After(silence.EndsAt) {
+			continue
+		}
+		if silence.Rule != "" && silence.Rule != alert.Rule {
+			continue
+		}
+		if silence.Subject != "" && silence.Subject != alert.Subject {
+			if ok, _ := path.Match(silence.Subject, alert.Subject); !ok {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (m *alertManager) loadSilences() error {
+	data, err := os.ReadFile(m.silencesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Unmarshal(data, &m.silences)
+}
+
+// saveSilencesLocked writes the silences that have not ended yet.
+func (m *alertManager) saveSilencesLocked() error {
+	now := time.Now()
+	silences := []schema.AlertSilence{}
+	for _, silence := range m.silences {
+		if silence.EndsAt.After(now) {
+			silences = append(silences, silence)
+		}
+	}
+	m.silences = silences
+
+	data, err := json.MarshalIndent(silences, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.silencesPath), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.silencesPath+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(m.silencesPath+".tmp", m.silencesPath)
+}
+
+// activeAlerts returns the pending and firing alerts, oldest first.
+func (m *alertManager) activeAlerts() []schema.Alert {
+	m.mu.Lock()
+	alerts := []schema.Alert{}
+	for _, alert := range m.active {
+		alerts = append(alerts, *alert)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].StartsAt.Before(alerts[j].StartsAt) })
+	return alerts
+}
+
+func (m *alertManager) updateSilencedLocked() {
+	now := time.Now()
+	for _, alert := range m.active {
+		alert.Silenced = m.isSilencedLocked(*alert, now)
+	}
+}
+
+func (a *Alerts) GetStatus(w http.ResponseWriter, r *http.Request) {
+	alerting.mu.Lock()
+	status := schema.AlertStatus{
+		Enabled:     alerting.enabled,
+		EvaluatedAt: alerting.evaluatedAt,
+		Error:       alerting.lastError,
+		Rules:       alerting.config.Rules,
+		Receivers:   alerting.config.Receivers,
+	}
+	if alerting.enabled {
+		status.Interval = alerting.interval.String()
+	}
+	alerting.mu.Unlock()
+	status.Alerts = alerting.activeAlerts()
+
+	if status.Rules == nil {
+		status.Rules = []schema.AlertRule{}
+	}
+	if status.Receivers == nil {
+		status.Receivers = []schema.AlertReceiver{}
+	}
+
+	utils.ResponseSuccess(w, status)
+}
+
+// Evaluate checks the rules now instead of waiting for the next interval.
+func (a *Alerts) Evaluate(w http.ResponseWriter, r *http.Request) {
+	alerting.mu.Lock()
+	enabled := alerting.enabled
+	alerting.mu.Unlock()
+	if !enabled {
+		utils.ResponseErrorStatus(w, errors.New("alerting is not configured"), http.StatusBadRequest)
+		return
+	}
+
+	alerting.evaluate(r.Context())
+	a.GetStatus(w, r)
+}
+
+// GetHistory returns the alerts that fired and resolved in the time range,
+// optionally filtered by rule and subject.
+func (a *Alerts) GetHistory(w http.ResponseWriter, r *http.Request) {
+	if utils.History == nil {
+		utils.ResponseErrorStatus(w, errHistoryDisabled, http.StatusServiceUnavailable)
+		return
+	}
+
+	from, to, _, err := parseHistoryRange(r)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+	rule := r.URL.Query().Get("rule")
+	subject := r.URL.Query().Get("subject")
+
+	events := []schema.AlertEvent{}
+	err = utils.History.Range(alertHistorySeries, from, to, func(at time.Time, data []byte) error {
+		var batch []schema.AlertEvent
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return err
+		}
+		for _, event := range batch {
+			if (rule == "" || event.Alert.Rule == rule) && (subject == "" || event.Alert.Subject == subject) {
+				events = append(events, event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot read alert history: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, events)
+}
+
+func (a *Alerts) GetSilences(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	silences := []schema.AlertSilence{}
+
+	alerting.mu.Lock()
+	for _, silence := range alerting.silences {
+		if silence.EndsAt.After(now) {
+			silences = append(silences, silence)
+		}
+	}
+	alerting.mu.Unlock()
+
+	utils.ResponseSuccess(w, silences)
+}
+
+func (a *Alerts) CreateSilence(w http.ResponseWriter, r *http.Request) {
+	var body schema.CreateAlertSilenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	silence := schema.AlertSilence{
+		Rule:      body.Rule,
+		Subject:   body.Subject,
+		Comment:   body.Comment,
+		CreatedAt: now,
+	}
+
+	switch {
+	case body.EndsAt != nil:
+		silence.EndsAt = body.EndsAt.UTC()
+	case body.Duration != "":
+		duration, err := time.ParseDuration(body.Duration)
+		if err != nil {
+			utils.ResponseErrorStatus(w, fmt.Errorf("invalid duration: %w", err), http.StatusBadRequest)
+			return
+		}
+		silence.EndsAt = now.Add(duration)
+	}
+	if !silence.EndsAt.After(now) {
+		utils.ResponseErrorStatus(w, errors.New("the silence needs a duration or an end time in the future"), http.StatusBadRequest)
+		return
+	}
+	if _, err := path.Match(silence.Subject, ""); err != nil {
+		utils.ResponseErrorStatus(w, fmt.Errorf("invalid subject pattern: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	silence.ID = hex.EncodeToString(buf)
+
+	alerting.mu.Lock()
+	defer alerting.mu.Unlock()
+
+	alerting.silences = append(alerting.silences, silence)
+	if err := alerting.saveSilencesLocked(); err != nil {
+		alerting.silences = alerting.silences[:len(alerting.silences)-1]
+		utils.ResponseError(w, fmt.Errorf("cannot save silence: %w", err))
+		return
+	}
+	alerting.updateSilencedLocked()
+
+	utils.ResponseSuccess(w, silence)
+}
+
+func (a *Alerts) DeleteSilence(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	alerting.mu.Lock()
+	defer alerting.mu.Unlock()
+
+	previous := alerting.silences
+	silences := []schema.AlertSilence{}
+	for _, silence := range previous {
+		if silence.ID != id {
+			silences = append(silences, silence)
+		}
+	}
+	if len(silences) == len(previous) {
+		utils.ResponseErrorStatus(w, errSilenceNotFound, http.StatusNotFound)
+		return
+	}
+
+	alerting.silences = silences
+	if err := alerting.saveSilencesLocked(); err != nil {
+		alerting.silences = previous
+		utils.ResponseError(w, fmt.Errorf("cannot delete silence: %w", err))
+		return
+	}
+	alerting.updateSilencedLocked()
+
+	utils.ResponseSuccess(w, map[string]bool{"ok": true})
+}
+
+// Test sends a test alert to the receiver given by the query, or to all
+// receivers, and reports the result per receiver.
+func (a *Alerts) Test(w http.ResponseWriter, r *http.Request) {
+	alerting.mu.Lock()
+	config := alerting.config
+	alerting.mu.Unlock()
+
+	name := r.URL.Query().Get("receiver")
+	now := time.Now().UTC()
+	alert := schema.Alert{
+		ID:       "test/" + now.Format(time.RFC3339),
+		Rule:     "test",
+		Type:     "test",
+		Severity: "info",
+		Subject:  "garage-webui",
+		Summary:  "Test notification from Garage Web UI",
+		State:    schema.AlertStateFiring,
+		StartsAt: now,
+		FiredAt:  &now,
+	}
+
+	results := map[string]string{}
+	for _, receiver := range config.Receivers {
+		if name != "" && receiver.Name != name {
+			continue
+		}
+		results[receiver.Name] = "ok"
+		if err := sendToAlertReceiver(config, receiver, alert); err != nil {
+			results[receiver.Name] = err.Error()
+		}
+	}
+	if len(results) == 0 {
+		utils.ResponseErrorStatus(w, errors.New("no matching receiver configured"), http.StatusNotFound)
+		return
+	}
+
+	utils.ResponseSuccess(w, results)
+}
@@ -0,0 +1,103 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var alertHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// sendAlertNotification delivers the alert to the receivers of its rule, or
+// to all receivers if the rule has none. Resolved alerts are only sent to
+// receivers with send_resolved.
+func sendAlertNotification(config schema.AlertConfig, alert schema.Alert) error {
+	var names []string
+	for _, rule := range config.Rules {
+		if rule.Name == alert.Rule {
+			names = rule.Receivers
+		}
+	}
+
+	var errs []error
+	for _, receiver := range config.Receivers {
+		if len(names) > 0 && !containsString(names, receiver.Name) {
+			continue
+		}
+		if alert.State == schema.AlertStateResolved && !receiver.SendResolved {
+			continue
+		}
+		if err := sendToAlertReceiver(config, receiver, alert); err != nil {
+			errs = append(errs, fmt.Errorf("receiver %s: %w", receiver.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func sendToAlertReceiver(config schema.AlertConfig, receiver schema.AlertReceiver, alert schema.Alert) error {
+	switch receiver.Type {
+	case schema.AlertReceiverWebhook:
+		return postAlertJSON(receiver, map[string]interface{}{
+			"status": alert.State,
+			"alert":  alert,
+		})
+	case schema.AlertReceiverSlack:
+		return postAlertJSON(receiver, map[string]interface{}{
+			"text": formatAlertTitle(alert) + "\n" + alert.Summary,
+		})
+	case schema.AlertReceiverEmail:
+		return sendAlertEmail(config.SMTP, receiver.To, alert)
+	}
+	return fmt.Errorf("unknown receiver type %q", receiver.Type)
+}
+
+func formatAlertTitle(alert schema.Alert) string {
+	return fmt.Sprintf("[%s] %s: %s (%s)", strings.ToUpper(alert.State), alert.Rule, alert.Subject, alert.Severity)
+}
+
+func postAlertJSON(receiver schema.AlertReceiver, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, receiver.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range receiver.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := alertHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
+
+func sendAlertEmail(config schema.SMTPConfig, to []string, alert schema.Alert) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\r\n\r\n", alert.Summary)
+	fmt.Fprintf(&body, "Rule: %s\r\nSubject: %s\r\nSeverity: %s\r\nState: %s\r\nStarted: %s\r\n",
+		alert.Rule, alert.Subject, alert.Severity, alert.State, alert.StartsAt.Format(time.RFC1123Z))
+	if alert.ResolvedAt != nil {
+		fmt.Fprintf(&body, "Resolved: %s\r\n", alert.ResolvedAt.Format(time.RFC1123Z))
+	}
+
+	return utils.SendMail(config, to, formatAlertTitle(alert), body.String())
+}
@@ -0,0 +1,269 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"khairul169/garage-webui/schema"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEvaluateAlertRule(t *testing.T) {
+	nodes := schema.NodesSample{Nodes: []schema.NodeSample{
+		{ID: "n1", Hostname: "garage-1", IsUp: true, DataTotal: 100, DataAvailable: 5, MetadataTotal: 100, MetadataAvailable: 50},
+		{ID: "n2", Hostname: "garage-2", IsUp: false, DataTotal: 100, DataAvailable: 1},
+	}}
+	buckets := []schema.Bucket{
+		{ID: "b1", GlobalAliases: []string{"photos"}, Bytes: 90, Quotas: schema.Quotas{MaxSize: 100}},
+		{ID: "b2", GlobalAliases: []string{"docs"}, Objects: 50, Quotas: schema.Quotas{MaxObjects: 100}},
+		{ID: "b3", Bytes: 1000},
+	}
+
+	tests := []struct {
+		name   string
+		rule   schema.AlertRule
+		inputs alertInputs
+		want   []string
+	}{
+		{
+			name:   "healthy cluster",
+			rule:   schema.AlertRule{Name: "health", Type: schema.AlertRuleClusterHealth},
+			inputs: alertInputs{cluster: schema.ClusterSample{Status: "healthy"}},
+			want:   []string{},
+		},
+		{
+			name:   "degraded cluster",
+			rule:   schema.AlertRule{Name: "health", Type: schema.AlertRuleClusterHealth},
+			inputs: alertInputs{cluster: schema.ClusterSample{Status: "degraded"}},
+			want:   []string{"health/cluster"},
+		},
+		{
+			name:   "node down",
+			rule:   schema.AlertRule{Name: "down", Type: schema.AlertRuleNodeDown},
+			inputs: alertInputs{nodes: nodes},
+			want:   []string{"down/n2"},
+		},
+		{
+			name:   "data disk free, skipping down nodes",
+			rule:   schema.AlertRule{Name: "disk", Type: schema.AlertRuleDiskFree, Threshold: 10, Partition: "data"},
+			inputs: alertInputs{nodes: nodes},
+			want:   []string{"disk/n1"},
+		},
+		{
+			name:   "metadata disk free",
+			rule:   schema.AlertRule{Name: "disk", Type: schema.AlertRuleDiskFree, Threshold: 10, Partition: "metadata"},
+			inputs: alertInputs{nodes: nodes},
+			want:   []string{},
+		},
+		{
+			name:   "bucket quota by size and objects",
+			rule:   schema.AlertRule{Name: "quota", Type: schema.AlertRuleBucketQuota, Threshold: 50},
+			inputs: alertInputs{buckets: buckets},
+			want:   []string{"quota/b1", "quota/b2"},
+		},
+		{
+			name:   "bucket quota of selected buckets",
+			rule:   schema.AlertRule{Name: "quota", Type: schema.AlertRuleBucketQuota, Threshold: 50, Buckets: []string{"docs"}},
+			inputs: alertInputs{buckets: buckets},
+			want:   []string{"quota/b2"},
+		},
+		{
+			name:   "bucket quota with unreadable bucket",
+			rule:   schema.AlertRule{Name: "quota", Type: schema.AlertRuleBucketQuota, Threshold: 80},
+			inputs: alertInputs{buckets: buckets[1:], failed: buckets[:1]},
+			want:   []string{"quota/b1 unknown"},
+		},
+		{
+			name:   "block errors above threshold",
+			rule:   schema.AlertRule{Name: "blocks", Type: schema.AlertRuleBlockErrors, Threshold: 2},
+			inputs: alertInputs{nodes: nodes, blockErrors: map[string]int{"n1": 3, "n2": 2}},
+			want:   []string{"blocks/n1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observations, err := evaluateAlertRule(tt.rule, &tt.inputs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for id, observation := range observations {
+				if observation.unknown {
+					id += " unknown"
+				}
+				got = append(got, id)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("alerts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// webhookSink records the alert states posted to it. Requests block while
+// hold is set.
+type webhookSink struct {
+	mu       sync.Mutex
+	states   []string
+	received chan struct{}
+	hold     chan struct{}
+}
+
+func newWebhookSink(t *testing.T) (*webhookSink, string) {
+	sink := &webhookSink{received: make(chan struct{}, 10)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Status string       `json:"status"`
+			Alert  schema.Alert `json:"alert"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		sink.mu.Lock()
+		sink.states = append(sink.states, payload.Alert.ID+" "+payload.Status)
+		hold := sink.hold
+		sink.mu.Unlock()
+		sink.received <- struct{}{}
+		if hold != nil {
+			<-hold
+		}
+	}))
+	t.Cleanup(server.Close)
+	return sink, server.URL
+}
+
+func (s *webhookSink) take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := s.states
+	s.states = nil
+	return states
+}
+
+func newTestAlertManager(url string) *alertManager {
+	return &alertManager{
+		enabled:    true,
+		pendingFor: map[string]time.Duration{},
+		active:     map[string]*schema.Alert{},
+		config: schema.AlertConfig{
+			Rules:     []schema.AlertRule{{Name: "quota", Type: schema.AlertRuleBucketQuota, Threshold: 80}},
+			Receivers: []schema.AlertReceiver{{Name: "hook", Type: schema.AlertReceiverWebhook, URL: url, SendResolved: true}},
+		},
+	}
+}
+
+func TestAlertUnreadableBucketKeepsAlert(t *testing.T) {
+	sink, url := newWebhookSink(t)
+	garage := newGarageStub(t)
+	garage.respond("GetClusterHealth", http.StatusOK, map[string]string{"status": "healthy"})
+	garage.respond("GetClusterStatus", http.StatusOK, map[string]interface{}{"nodes": []interface{}{}})
+	garage.respond("ListBuckets", http.StatusOK, []schema.GetBucketsRes{{ID: "b1", GlobalAliases: []string{"photos"}}})
+
+	manager := newTestAlertManager(url)
+	steps := []struct {
+		name      string
+		status    int
+		bytes     int64
+		want      []string
+		wantState string
+	}{
+		{"quota exceeded", http.StatusOK, 90, []string{"quota/b1 firing"}, schema.AlertStateFiring},
+		{"usage cannot be read", http.StatusInternalServerError, 0, nil, schema.AlertStateFiring},
+		{"usage back to normal", http.StatusOK, 10, []string{"quota/b1 resolved"}, ""},
+	}
+
+	for _, step := range steps {
+		garage.respond("GetBucketInfo", step.status, schema.Bucket{ID: "b1", GlobalAliases: []string{"photos"}, Bytes: step.bytes, Quotas: schema.Quotas{MaxSize: 100}})
+		manager.evaluate(context.Background())
+
+		if got := sink.take(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: notifications = %v, want %v", step.name, got, step.want)
+		}
+		state := ""
+		if alert, ok := manager.active["quota/b1"]; ok {
+			state = alert.State
+		}
+		if state != step.wantState {
+			t.Errorf("%s: alert state = %q, want %q", step.name, state, step.wantState)
+		}
+	}
+}
+
+func TestAlertNotificationOutsideEvaluationLock(t *testing.T) {
+	sink, url := newWebhookSink(t)
+	sink.hold = make(chan struct{})
+	garage := newGarageStub(t)
+	garage.respond("GetClusterHealth", http.StatusOK, map[string]string{"status": "healthy"})
+	garage.respond("GetClusterStatus", http.StatusOK, map[string]interface{}{"nodes": []interface{}{}})
+	garage.respond("ListBuckets", http.StatusOK, []schema.GetBucketsRes{{ID: "b1"}})
+	garage.respond("GetBucketInfo", http.StatusOK, schema.Bucket{ID: "b1", Bytes: 90, Quotas: schema.Quotas{MaxSize: 100}})
+
+	manager := newTestAlertManager(url)
+	done := make(chan struct{})
+	go func() {
+		manager.evaluate(context.Background())
+		close(done)
+	}()
+
+	<-sink.received
+	locked := manager.evalMu.TryLock()
+	if locked {
+		manager.evalMu.Unlock()
+	}
+	close(sink.hold)
+	<-done
+
+	if !locked {
+		t.Error("the evaluation lock is held while sending notifications")
+	}
+}
+
+func TestAlertSilencesPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		silencesFile string
+		want         string
+	}{
+		{"data dir", "", "silences.json"},
+		{"silences file", "alerts/silences.json", "alerts/silences.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("DATA_DIR", dir)
+			t.Setenv("ALERT_CONFIG", "")
+			silencesFile := tt.silencesFile
+			if silencesFile != "" {
+				silencesFile = filepath.Join(dir, silencesFile)
+			}
+			t.Setenv("ALERT_SILENCES_FILE", silencesFile)
+
+			previous := alerting
+			alerting = &alertManager{pendingFor: map[string]time.Duration{}, active: map[string]*schema.Alert{}}
+			t.Cleanup(func() { alerting = previous })
+
+			InitAlerting()
+			alerting.mu.Lock()
+			alerting.silences = []schema.AlertSilence{{ID: "s1", Rule: "quota", EndsAt: time.Now().Add(time.Hour)}}
+			err := alerting.saveSilencesLocked()
+			alerting.mu.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reloaded := &alertManager{silencesPath: filepath.Join(dir, tt.want)}
+			if err := reloaded.loadSilences(); err != nil || len(reloaded.silences) != 1 {
+				t.Errorf("silences not saved at %s: %v %v", tt.want, reloaded.silences, err)
+			}
+		})
+	}
+}
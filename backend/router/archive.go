@@ -0,0 +1,527 @@
+package router
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	archiveManifestName = "manifest.json"
+	archiveObjectsDir   = "objects/"
+
+	// Prefix of the PAX records storing object metadata in export archives
+	archivePAXPrefix     = "GARAGEWEBUI."
+	archivePAXMetaPrefix = archivePAXPrefix + "meta."
+)
+
+// Export streams a tar archive of the bucket: a manifest with its settings
+// followed by all objects.
+func (b *Buckets) Export(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	bucket, err := getBucketInfo(r.Context(), id)
+	if err != nil {
+		utils.ResponseErrorStatus(w, fmt.Errorf("cannot get bucket info: %w", err), http.StatusNotFound)
+		return
+	}
+
+	client, bucketName, err := getS3Client(r, id)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	manifest, err := createBucketManifest(r, client, bucketName, bucket)
+	if err != nil {
+		utils.ResponseError(w, err)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.tar", bucketName, time.Now().Format("20060102-150405"))
+	var out io.Writer = w
+	if r.URL.Query().Get("compress") == "gzip" {
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+		filename += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+	} else {
+		w.Header().Set("Content-Type", "application/x-tar")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	tw := tar.NewWriter(out)
+	if err := writeBucketArchive(r, tw, client, bucketName, manifest); err != nil {
+		// Headers are already sent, leave the archive incomplete so the
+		// client notices the failure
+		slog.ErrorContext(r.Context(), "Cannot export bucket.", "bucket", id, "error", err)
+		return
+	}
+	tw.Close()
+}
+
+func createBucketManifest(r *http.Request, client *s3.Client, bucketName string, bucket *schema.Bucket) (*schema.BucketManifest, error) {
+	manifest := &schema.BucketManifest{
+		Version:       schema.BucketManifestVersion,
+		ExportedAt:    time.Now(),
+		BucketID:      bucket.ID,
+		GlobalAliases: bucket.GlobalAliases,
+		LocalAliases:  []schema.LocalAlias{},
+		WebsiteAccess: bucket.WebsiteAccess,
+		WebsiteConfig: bucket.WebsiteConfig,
+		Quotas:        bucket.Quotas,
+		Cors:          []schema.CorsRule{},
+		Lifecycle:     []schema.LifecycleRule{},
+		Keys:          []schema.ManifestKey{},
+		Objects:       bucket.Objects,
+		Bytes:         bucket.Bytes,
+	}
+
+	for _, key := range bucket.Keys {
+		// Keys of sessions and jobs expire, they must not be restored
+		if isTemporaryKey(key.Name) {
+			continue
+		}
+		manifest.Keys = append(manifest.Keys, schema.ManifestKey{
+			AccessKeyID: key.AccessKeyID,
+			Name:        key.Name,
+			Permissions: key.Permissions,
+		})
+		for _, alias := range key.BucketLocalAliases {
+			manifest.LocalAliases = append(manifest.LocalAliases, schema.LocalAlias{AccessKeyID: key.AccessKeyID, Alias: alias})
+		}
+	}
+
+	cors, err := client.GetBucketCors(r.Context(), &s3.GetBucketCorsInput{Bucket: aws.String(bucketName)})
+	if err != nil && !isAPIErrorCode(err, "NoSuchCORSConfiguration") {
+		return nil, fmt.Errorf("cannot get cors: %w", err)
+	}
+	if cors != nil {
+		manifest.Cors = fromS3CorsRules(cors.CORSRules)
+	}
+
+	lifecycle, err := client.GetBucketLifecycleConfiguration(r.Context(), &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucketName)})
+	if err != nil && !isAPIErrorCode(err, "NoSuchLifecycleConfiguration") {
+		return nil, fmt.Errorf("cannot get lifecycle: %w", err)
+	}
+	if lifecycle != nil {
+		manifest.Lifecycle = fromS3LifecycleRules(lifecycle.Rules)
+	}
+
+	return manifest, nil
+}
+
+func writeBucketArchive(r *http.Request, tw *tar.Writer, client *s3.Client, bucketName string, manifest *schema.BucketManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    archiveManifestName,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: manifest.ExportedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	var continuationToken *string
+	for {
+		objects, err := client.ListObjectsV2(r.Context(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String(bucketName),
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, object := range objects.Contents {
+			if err := writeArchiveObject(r, tw, client, bucketName, aws.ToString(object.Key)); err != nil {
+				return fmt.Errorf("cannot export %s: %w", aws.ToString(object.Key), err)
+			}
+		}
+
+		if !aws.ToBool(objects.IsTruncated) {
+			return nil
+		}
+		continuationToken = objects.NextContinuationToken
+	}
+}
+
+func writeArchiveObject(r *http.Request, tw *tar.Writer, client *s3.Client, bucketName string, key string) error {
+	object, err := client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	records := map[string]string{}
+	setRecord := func(name string, value *string) {
+		if value != nil && *value != "" {
+			records[archivePAXPrefix+name] = *value
+		}
+	}
+	setRecord("content-type", object.ContentType)
+	setRecord("content-encoding", object.ContentEncoding)
+	setRecord("content-disposition", object.ContentDisposition)
+	setRecord("cache-control", object.CacheControl)
+	for k, v := range object.Metadata {
+		records[archivePAXMetaPrefix+k] = v
+	}
+
+	header := &tar.Header{
+		Name:       archiveObjectsDir + key,
+		Mode:       0o644,
+		Size:       aws.ToInt64(object.ContentLength),
+		Format:     tar.FormatPAX,
+		PAXRecords: records,
+	}
+	if object.LastModified != nil {
+		header.ModTime = *object.LastModified
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, object.Body)
+	return err
+}
+
+// Import recreates a bucket from an export archive: it creates the bucket,
+// restores its settings, aliases and key permissions and uploads the objects.
+// The global alias can be overridden with the alias query parameter.
+func (b *Buckets) Import(w http.ResponseWriter, r *http.Request) {
+	body := bufio.NewReader(r.Body)
+
+	var archive io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		archive = gz
+	}
+
+	tr := tar.NewReader(archive)
+	header, err := tr.Next()
+	if err != nil || header.Name != archiveManifestName {
+		utils.ResponseErrorStatus(w, errors.New("archive does not start with a manifest"), http.StatusBadRequest)
+		return
+	}
+
+	var manifest schema.BucketManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		utils.ResponseErrorStatus(w, fmt.Errorf("cannot parse manifest: %w", err), http.StatusBadRequest)
+		return
+	}
+	if manifest.Version > schema.BucketManifestVersion {
+		utils.ResponseErrorStatus(w, fmt.Errorf("unsupported manifest version %d", manifest.Version), http.StatusBadRequest)
+		return
+	}
+
+	globalAliases := manifest.GlobalAliases
+	if alias := r.URL.Query().Get("alias"); alias != "" {
+		globalAliases = []string{alias}
+	}
+
+	result := &schema.BucketImportResult{Warnings: []string{}}
+	bucketID, err := createImportedBucket(r.Context(), &manifest, globalAliases, result)
+	if err != nil {
+		utils.ResponseError(w, err)
+		return
+	}
+	result.BucketID = bucketID
+
+	// The bucket is new, so a failed import deletes it again along with the
+	// uploaded objects, even if the request was cancelled
+	ctx := context.WithoutCancel(r.Context())
+	var client *s3.Client
+	var bucketName string
+	fail := func(err error) {
+		rbErr := error(nil)
+		if client != nil {
+			_, rbErr = deleteAllObjects(ctx, client, bucketName, "")
+		}
+		if rbErr == nil {
+			rbErr = deleteEmptyBucket(ctx, bucketID)
+		}
+		if rbErr != nil {
+			slog.ErrorContext(ctx, "Cannot roll back bucket import.", "bucket", bucketID, "error", rbErr)
+			utils.ResponseError(w, fmt.Errorf("cannot import bucket, bucket %s was left behind: %w", bucketID, err))
+			return
+		}
+		utils.ResponseError(w, fmt.Errorf("cannot import bucket, changes were rolled back: %w", err))
+	}
+
+	// Owner is needed to restore the cors and lifecycle configuration
+	client, names, accessKeyID, err := createJobClient(r.Context(), "import-"+bucketID[:min(len(bucketID), 16)], []utils.KeyGrant{
+		{BucketID: bucketID, Read: true, Write: true, Owner: true},
+	})
+	if err != nil {
+		fail(fmt.Errorf("cannot access bucket: %w", err))
+		return
+	}
+	defer func() {
+		utils.DeleteScopedKey(ctx, accessKeyID)
+		utils.S3Clients.Remove(accessKeyID)
+	}()
+	bucketName = names[bucketID]
+
+	if err := applyBucketS3Config(r.Context(), client, bucketName, manifest.Cors, manifest.Lifecycle); err != nil {
+		result.Warnings = append(result.Warnings, err.Error())
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(fmt.Errorf("cannot read archive after %d objects: %w", result.Objects, err))
+			return
+		}
+		// Directory marker objects are read back as directories
+		isObject := header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeDir
+		key := strings.TrimPrefix(header.Name, archiveObjectsDir)
+		if !isObject || !strings.HasPrefix(header.Name, archiveObjectsDir) || key == "" {
+			continue
+		}
+		if err := putArchiveObject(r, client, bucketName, key, header, tr); err != nil {
+			fail(fmt.Errorf("cannot upload %s: %w", key, err))
+			return
+		}
+		result.Objects++
+		result.Bytes += header.Size
+	}
+
+	invalidateBucketCredentials(r.Context(), "")
+	utils.ResponseSuccess(w, result)
+}
+
+// createImportedBucket creates the bucket through the admin API and restores
+// the settings stored in the manifest. Problems with individual keys are
+// reported as warnings, since keys may not exist on this cluster.
+func createImportedBucket(ctx context.Context, manifest *schema.BucketManifest, globalAliases []string, result *schema.BucketImportResult) (string, error) {
+	createBody := map[string]interface{}{}
+	if len(globalAliases) > 0 {
+		createBody["globalAlias"] = globalAliases[0]
+	}
+
+	body, err := utils.Garage.Fetch("/v2/CreateBucket", &utils.FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+		Body:    createBody,
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot create bucket: %w", err)
+	}
+
+	var created schema.Bucket
+	if err := json.Unmarshal(body, &created); err != nil || created.ID == "" {
+		// The bucket may exist without its ID being known, find it by its alias
+		if len(globalAliases) > 0 {
+			if rbErr := deleteBucketByAlias(context.WithoutCancel(ctx), globalAliases[0]); rbErr != nil {
+				slog.ErrorContext(ctx, "Cannot roll back bucket import.", "alias", globalAliases[0], "error", rbErr)
+			}
+		}
+		if err == nil {
+			err = errors.New("the created bucket has no ID")
+		}
+		return "", fmt.Errorf("cannot read created bucket: %w", err)
+	}
+
+	for _, alias := range globalAliases[min(len(globalAliases), 1):] {
+		_, err := utils.Garage.Fetch("/v2/AddBucketAlias", &utils.FetchOptions{
+			Context: ctx,
+			Method:  http.MethodPost,
+			Body:    map[string]string{"bucketId": created.ID, "globalAlias": alias},
+		})
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("cannot add alias %s: %v", alias, err))
+		}
+	}
+
+	if err := updateBucketSettings(ctx, created.ID, manifest.WebsiteAccess, manifest.WebsiteConfig, manifest.Quotas); err != nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("cannot restore website and quota settings: %v", err))
+	}
+
+	for _, key := range manifest.Keys {
+		_, err := utils.Garage.Fetch("/v2/AllowBucketKey", &utils.FetchOptions{
+			Context: ctx,
+			Method:  http.MethodPost,
+			Body: map[string]interface{}{
+				"bucketId":    created.ID,
+				"accessKeyId": key.AccessKeyID,
+				"permissions": key.Permissions,
+			},
+		})
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("cannot restore permissions of key %s (%s): %v", key.AccessKeyID, key.Name, err))
+		}
+	}
+
+	for _, alias := range manifest.LocalAliases {
+		_, err := utils.Garage.Fetch("/v2/AddBucketAlias", &utils.FetchOptions{
+			Context: ctx,
+			Method:  http.MethodPost,
+			Body:    map[string]string{"bucketId": created.ID, "accessKeyId": alias.AccessKeyID, "localAlias": alias.Alias},
+		})
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("cannot restore local alias %s of key %s: %v", alias.Alias, alias.AccessKeyID, err))
+		}
+	}
+
+	return created.ID, nil
+}
+
+// updateBucketSettings sets the website access and quotas of a bucket.
+func updateBucketSettings(ctx context.Context, bucketID string, websiteAccess bool, websiteConfig schema.WebsiteConfig, quotas schema.Quotas) error {
+	website := map[string]interface{}{"enabled": websiteAccess}
+	if websiteAccess {
+		website["indexDocument"] = websiteConfig.IndexDocument
+		website["errorDocument"] = websiteConfig.ErrorDocument
+	}
+
+	_, err := utils.Garage.Fetch("/v2/UpdateBucket", &utils.FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+		Params:  map[string]string{"id": bucketID},
+		Body: map[string]interface{}{
+			"websiteAccess": website,
+			"quotas": map[string]interface{}{
+				"maxSize":    nilIfZero(quotas.MaxSize),
+				"maxObjects": nilIfZero(quotas.MaxObjects),
+			},
+		},
+	})
+	return err
+}
+
+// applyBucketS3Config sets the cors and lifecycle rules of a bucket. Empty
+// rules are left unset. The client needs to own the bucket.
+func applyBucketS3Config(ctx context.Context, client *s3.Client, bucketName string, cors []schema.CorsRule, lifecycle []schema.LifecycleRule) error {
+	if len(cors) > 0 {
+		_, err := client.PutBucketCors(ctx, &s3.PutBucketCorsInput{
+			Bucket:            aws.String(bucketName),
+			CORSConfiguration: &types.CORSConfiguration{CORSRules: toS3CorsRules(cors)},
+		})
+		if err != nil {
+			return fmt.Errorf("cannot set cors: %w", err)
+		}
+	}
+
+	if len(lifecycle) > 0 {
+		_, err := client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+			Bucket: aws.String(bucketName),
+			LifecycleConfiguration: &types.BucketLifecycleConfiguration{
+				Rules: toS3LifecycleRules(lifecycle),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("cannot set lifecycle: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func fromS3CorsRules(rules []types.CORSRule) []schema.CorsRule {
+	result := make([]schema.CorsRule, 0, len(rules))
+	for _, rule := range rules {
+		result = append(result, schema.CorsRule{
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedHeaders: rule.AllowedHeaders,
+			ExposeHeaders:  rule.ExposeHeaders,
+			MaxAgeSeconds:  aws.ToInt32(rule.MaxAgeSeconds),
+		})
+	}
+	return result
+}
+
+func toS3CorsRules(rules []schema.CorsRule) []types.CORSRule {
+	result := make([]types.CORSRule, 0, len(rules))
+	for _, rule := range rules {
+		result = append(result, types.CORSRule{
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedHeaders: rule.AllowedHeaders,
+			ExposeHeaders:  rule.ExposeHeaders,
+			MaxAgeSeconds:  aws.Int32(rule.MaxAgeSeconds),
+		})
+	}
+	return result
+}
+
+func putArchiveObject(r *http.Request, client *s3.Client, bucketName string, key string, header *tar.Header, content io.Reader) error {
+	body, cleanup, err := spoolBody(content, header.Size)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(header.Size),
+		Metadata:      map[string]string{},
+	}
+
+	for name, value := range header.PAXRecords {
+		switch {
+		case strings.HasPrefix(name, archivePAXMetaPrefix):
+			input.Metadata[strings.TrimPrefix(name, archivePAXMetaPrefix)] = value
+		case name == archivePAXPrefix+"content-type":
+			input.ContentType = aws.String(value)
+		case name == archivePAXPrefix+"content-encoding":
+			input.ContentEncoding = aws.String(value)
+		case name == archivePAXPrefix+"content-disposition":
+			input.ContentDisposition = aws.String(value)
+		case name == archivePAXPrefix+"cache-control":
+			input.CacheControl = aws.String(value)
+		}
+	}
+
+	_, err = client.PutObject(r.Context(), input)
+	return err
+}
+
+func isAPIErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return isAPIError(err, &apiErr) && apiErr.ErrorCode() == code
+}
+
+func nilIfZero(value int64) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,168 @@
+package router
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCreateImportedBucketCarriesRequestID(t *testing.T) {
+	garage := newGarageStub(t)
+	garage.respond("CreateBucket", http.StatusOK, schema.Bucket{ID: "b1"})
+	garage.respond("AddBucketAlias", http.StatusOK, map[string]string{})
+	garage.respond("UpdateBucket", http.StatusOK, map[string]string{})
+	garage.respond("AllowBucketKey", http.StatusOK, map[string]string{})
+
+	manifest := &schema.BucketManifest{
+		Keys:         []schema.ManifestKey{{AccessKeyID: "GK1", Permissions: schema.Permissions{Read: true}}},
+		LocalAliases: []schema.LocalAlias{{AccessKeyID: "GK1", Alias: "photos"}},
+	}
+	result := &schema.BucketImportResult{Warnings: []string{}}
+	bucketID, err := createImportedBucket(utils.WithRequestID(context.Background(), "req-1"), manifest, []string{"photos", "pictures"}, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucketID != "b1" || len(result.Warnings) != 0 {
+		t.Fatalf("bucket = %s, warnings = %v", bucketID, result.Warnings)
+	}
+	garage.checkRequestID(t, "req-1")
+}
+
+func buildArchive(t *testing.T, objects map[string]string, truncate int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	manifest, _ := json.Marshal(schema.BucketManifest{Version: schema.BucketManifestVersion, GlobalAliases: []string{"photos"}})
+	files := []struct{ name, content string }{{archiveManifestName, string(manifest)}}
+	for _, key := range slices.Sorted(maps.Keys(objects)) {
+		files = append(files, struct{ name, content string }{archiveObjectsDir + key, objects[key]})
+	}
+	for _, file := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(file.content))
+	}
+	tw.Close()
+	return buf.Bytes()[:buf.Len()-truncate]
+}
+
+func TestImportRollsBack(t *testing.T) {
+	const bucketID = "0123456789abcdef"
+	objects := map[string]string{"a.txt": "first", "b.txt": strings.Repeat("x", 2048)}
+
+	tests := []struct {
+		name          string
+		truncate      int
+		createKeyFail bool
+		wantStatus    int
+		wantDeleted   bool
+	}{
+		{"imported", 0, false, http.StatusOK, false},
+		{"truncated archive", 3072, false, http.StatusInternalServerError, true},
+		{"no access to the bucket", 0, true, http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, _ := newS3Stub(t)
+			garage := newGarageStub(t)
+			garage.respond("CreateBucket", http.StatusOK, schema.Bucket{ID: bucketID, GlobalAliases: []string{"photos"}})
+			garage.respond("GetBucketInfo", http.StatusOK, schema.Bucket{ID: bucketID, GlobalAliases: []string{"photos"}})
+			garage.respond("UpdateBucket", http.StatusOK, map[string]string{})
+			garage.respond("CreateKey", http.StatusOK, schema.KeyElement{AccessKeyID: "GKimport", SecretAccessKey: "secret"})
+			garage.respond("AllowBucketKey", http.StatusOK, map[string]string{})
+			garage.respond("DeleteKey", http.StatusOK, map[string]string{})
+			garage.respond("DeleteBucket", http.StatusOK, map[string]string{})
+			if tt.createKeyFail {
+				garage.respond("CreateKey", http.StatusInternalServerError, map[string]string{"message": "unavailable"})
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/buckets/import", bytes.NewReader(buildArchive(t, objects, tt.truncate)))
+			w := httptest.NewRecorder()
+			(&Buckets{}).Import(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			deleted := garage.called("DeleteBucket")
+			if tt.wantDeleted != (len(deleted) == 1) {
+				t.Fatalf("deleted bucket %d times, want deleted %v", len(deleted), tt.wantDeleted)
+			}
+			if tt.wantDeleted && deleted[0].Query != "id="+bucketID {
+				t.Errorf("deleted %s, want id=%s", deleted[0].Query, bucketID)
+			}
+
+			wantKeys := []string{"a.txt", "b.txt"}
+			if tt.wantDeleted {
+				wantKeys = nil
+			}
+			if keys := stub.keys("photos"); !slices.Equal(keys, wantKeys) {
+				t.Errorf("objects = %v, want %v", keys, wantKeys)
+			}
+			if tt.truncate > 0 && stub.count("DeleteObjects photos/") != 1 {
+				t.Errorf("uploaded objects were not deleted")
+			}
+		})
+	}
+}
+
+func TestCreateBucketManifestSkipsTemporaryKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		keys        []schema.KeyElement
+		wantKeys    []string
+		wantAliases []string
+	}{
+		{
+			name:        "bucket keys",
+			keys:        []schema.KeyElement{{AccessKeyID: "GKapp", Name: "app", BucketLocalAliases: []string{"uploads"}}},
+			wantKeys:    []string{"GKapp"},
+			wantAliases: []string{"uploads"},
+		},
+		{
+			name: "session and job keys",
+			keys: []schema.KeyElement{
+				{AccessKeyID: "GKapp", Name: "app"},
+				{AccessKeyID: "GKsession", Name: utils.SessionKeyPrefix + "a-b", BucketLocalAliases: []string{"webui-0123456789abcdef"}},
+				{AccessKeyID: "GKjob", Name: utils.JobKeyPrefix + "sync-1", BucketLocalAliases: []string{"webui-0123456789abcdef"}},
+			},
+			wantKeys:    []string{"GKapp"},
+			wantAliases: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newS3Stub(t)
+			client := utils.S3Clients.Get("GKexport", "secret")
+			bucket := &schema.Bucket{ID: "0123456789abcdef", GlobalAliases: []string{"photos"}, Keys: tt.keys}
+
+			manifest, err := createBucketManifest(httptest.NewRequest(http.MethodGet, "/buckets/photos/export", nil), client, "photos", bucket)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := []string{}
+			for _, key := range manifest.Keys {
+				keys = append(keys, key.AccessKeyID)
+			}
+			aliases := []string{}
+			for _, alias := range manifest.LocalAliases {
+				aliases = append(aliases, alias.Alias)
+			}
+			if !slices.Equal(keys, tt.wantKeys) || !slices.Equal(aliases, tt.wantAliases) {
+				t.Errorf("keys = %v, aliases = %v, want %v and %v", keys, aliases, tt.wantKeys, tt.wantAliases)
+			}
+		})
+	}
+}
@@ -37,6 +37,9 @@ func (c *Auth) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Default: password-based login
+	authenticated := false
+	defer func() { utils.ObserveLogin("password", authenticated) }()
+
 	var body struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
@@ -59,12 +62,17 @@ func (c *Auth) Login(w http.ResponseWriter, r *http.Request) {
 
 	utils.Session.Set(r, "authenticated", true)
 	utils.Session.Set(r, "auth_provider", "password")
+	utils.Session.Set(r, "username", strings.TrimSpace(body.Username))
+	authenticated = true
 	utils.ResponseSuccess(w, map[string]bool{
 		"authenticated": true,
 	})
 }
 
 func (c *Auth) Logout(w http.ResponseWriter, r *http.Request) {
+	if utils.SessionKeys.Enabled() {
+		utils.SessionKeys.Revoke(r.Context(), utils.Session.ID(r))
+	}
 	utils.Session.Clear(r)
 	utils.ResponseSuccess(w, true)
 }
@@ -58,6 +58,9 @@ func NewLDAPAuth() *LDAPAuth {
 }
 
 func (l *LDAPAuth) Login(w http.ResponseWriter, r *http.Request) {
+	authenticated := false
+	defer func() { utils.ObserveLogin("ldap", authenticated) }()
+
 	var body struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
@@ -176,6 +179,8 @@ func (l *LDAPAuth) Login(w http.ResponseWriter, r *http.Request) {
 
 	utils.Session.Set(r, "authenticated", true)
 	utils.Session.Set(r, "auth_provider", "ldap")
+	utils.Session.Set(r, "username", body.Username)
+	authenticated = true
 	utils.ResponseSuccess(w, map[string]bool{
 		"authenticated": true,
 	})
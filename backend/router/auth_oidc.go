@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"khairul169/garage-webui/utils"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
@@ -33,7 +34,7 @@ func NewOIDCAuth() *OIDCAuth {
 
 	provider, err := oidc.NewProvider(ctx, issuer)
 	if err != nil {
-		fmt.Printf("OIDC: failed to initialize provider: %v\n", err)
+		slog.Error("OIDC: failed to initialize provider.", "error", err)
 		return nil
 	}
 
@@ -77,6 +78,9 @@ func (o *OIDCAuth) RedirectToLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (o *OIDCAuth) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	authenticated := false
+	defer func() { utils.ObserveLogin("oidc", authenticated) }()
+
 	savedState := utils.Session.Get(r, "oidc_state")
 	if savedState == nil {
 		utils.ResponseErrorStatus(w, fmt.Errorf("no state in session"), http.StatusBadRequest)
@@ -142,6 +146,8 @@ func (o *OIDCAuth) HandleCallback(w http.ResponseWriter, r *http.Request) {
 
 	utils.Session.Set(r, "authenticated", true)
 	utils.Session.Set(r, "auth_provider", "oidc")
+	utils.Session.Set(r, "username", oidcUsername(claims, idToken.Subject))
+	authenticated = true
 
 	// Redirect to the app
 	basePath := os.Getenv("BASE_PATH")
@@ -149,6 +155,16 @@ func (o *OIDCAuth) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redirectTo, http.StatusTemporaryRedirect)
 }
 
+// oidcUsername returns the name identifying the user in the logs.
+func oidcUsername(claims map[string]interface{}, subject string) string {
+	for _, claim := range []string{"preferred_username", "email"} {
+		if value, ok := claims[claim].(string); ok && value != "" {
+			return value
+		}
+	}
+	return subject
+}
+
 func checkClaim(claims map[string]interface{}, claimName string, requiredValue string) bool {
 	claim, ok := claims[claimName]
 	if !ok {
@@ -2,19 +2,22 @@ package router
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"khairul169/garage-webui/schema"
 	"khairul169/garage-webui/utils"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
@@ -33,14 +36,14 @@ func (b *Browse) GetObjects(w http.ResponseWriter, r *http.Request) {
 		limit = 100
 	}
 
-	client, err := getS3Client(bucket)
+	client, bucketName, err := getS3Client(r, bucket)
 	if err != nil {
-		utils.ResponseError(w, err)
+		responseS3ClientError(w, err)
 		return
 	}
 
-	objects, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
-		Bucket:            aws.String(bucket),
+	objects, err := client.ListObjectsV2(r.Context(), &s3.ListObjectsV2Input{
+		Bucket:            aws.String(bucketName),
 		Prefix:            aws.String(prefix),
 		Delimiter:         aws.String("/"),
 		MaxKeys:           aws.Int32(int32(limit)),
@@ -59,6 +62,10 @@ func (b *Browse) GetObjects(w http.ResponseWriter, r *http.Request) {
 		NextToken: objects.NextContinuationToken,
 	}
 
+	if creds, err := getBucketCredentials(r, bucket); err == nil {
+		result.ReadOnly = creds.ReadOnly
+	}
+
 	for _, prefix := range objects.CommonPrefixes {
 		result.Prefixes = append(result.Prefixes, *prefix.Prefix)
 	}
@@ -73,10 +80,39 @@ func (b *Browse) GetObjects(w http.ResponseWriter, r *http.Request) {
 			ObjectKey:    &key,
 			LastModified: object.LastModified,
 			Size:         object.Size,
+			ETag:         strings.Trim(aws.ToString(object.ETag), "\""),
 			Url:          fmt.Sprintf("/browse/%s/%s", bucket, *object.Key),
 		})
 	}
 
+	// Listings don't include user metadata, so checksums need a HEAD per object
+	if query.Get("checksums") == "1" {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, 8)
+
+		for i := range result.Objects {
+			object := &result.Objects[i]
+			sem <- struct{}{}
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				head, err := client.HeadObject(r.Context(), &s3.HeadObjectInput{
+					Bucket: aws.String(bucketName),
+					Key:    aws.String(prefix + *object.ObjectKey),
+				})
+				if err != nil {
+					return
+				}
+				object.SHA256 = head.Metadata[utils.ChecksumMetaSHA256]
+				object.CRC32C = head.Metadata[utils.ChecksumMetaCRC32C]
+			}()
+		}
+		wg.Wait()
+	}
+
 	utils.ResponseSuccess(w, result)
 }
 
@@ -87,28 +123,41 @@ func (b *Browse) GetOneObject(w http.ResponseWriter, r *http.Request) {
 	view := queryParams.Get("view") == "1"
 	thumbnail := queryParams.Get("thumb") == "1"
 	download := queryParams.Get("dl") == "1"
+	var versionId *string
+	if v := queryParams.Get("versionId"); v != "" {
+		versionId = aws.String(v)
+	}
 
-	client, err := getS3Client(bucket)
+	client, bucketName, err := getS3Client(r, bucket)
 	if err != nil {
-		utils.ResponseError(w, err)
+		responseS3ClientError(w, err)
 		return
 	}
 
-	if !view && !download && !thumbnail {
-		object, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
+	if thumbnail {
+		b.getThumbnail(w, r, client, bucketName, key, queryParams.Get("size"))
+		return
+	}
+
+	if !view && !download {
+		object, err := client.HeadObject(r.Context(), &s3.HeadObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			VersionId:    versionId,
+			ChecksumMode: types.ChecksumModeEnabled,
 		})
 		if err != nil {
 			utils.ResponseError(w, err)
+			return
 		}
 		utils.ResponseSuccess(w, object)
 		return
 	}
 
-	object, err := client.GetObject(context.Background(), &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+	object, err := client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket:    aws.String(bucketName),
+		Key:       aws.String(key),
+		VersionId: versionId,
 	})
 
 	if err != nil {
@@ -127,23 +176,6 @@ func (b *Browse) GetOneObject(w http.ResponseWriter, r *http.Request) {
 
 	if download {
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", keys[len(keys)-1]))
-	} else if thumbnail {
-		body, err := io.ReadAll(object.Body)
-		if err != nil {
-			utils.ResponseError(w, err)
-			return
-		}
-
-		thumb, err := utils.CreateThumbnailImage(body, 64, 64)
-		if err != nil {
-
-			utils.ResponseError(w, err)
-			return
-		}
-
-		w.Header().Set("Content-Type", "image/png")
-		w.Write(thumb)
-		return
 	}
 
 	w.Header().Set("Cache-Control", "max-age=86400")
@@ -160,8 +192,15 @@ func (b *Browse) GetOneObject(w http.ResponseWriter, r *http.Request) {
 	if object.ETag != nil {
 		w.Header().Set("Etag", *object.ETag)
 	}
+	if value := object.Metadata[utils.ChecksumMetaSHA256]; value != "" {
+		w.Header().Set("X-Checksum-Sha256", value)
+	}
+	if value := object.Metadata[utils.ChecksumMetaCRC32C]; value != "" {
+		w.Header().Set("X-Checksum-Crc32c", value)
+	}
 
-	_, err = io.Copy(w, object.Body)
+	written, err := io.Copy(w, object.Body)
+	utils.AddTransferBytes("download", written)
 
 	if err != nil {
 		utils.ResponseError(w, err)
@@ -169,6 +208,62 @@ func (b *Browse) GetOneObject(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (b *Browse) getThumbnail(w http.ResponseWriter, r *http.Request, client *s3.Client, bucket string, key string, size string) {
+	head, err := client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var ae smithy.APIError
+		if errors.As(err, &ae) && (ae.ErrorCode() == "NotFound" || ae.ErrorCode() == "NoSuchKey") {
+			utils.ResponseErrorStatus(w, err, http.StatusNotFound)
+			return
+		}
+
+		utils.ResponseError(w, err)
+		return
+	}
+
+	if head.ContentLength != nil && *head.ContentLength > utils.Thumbnail.MaxInputSize() {
+		utils.ResponseErrorStatus(w, utils.ErrThumbnailTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	thumbSize := utils.Thumbnail.Size(size)
+	cacheKey := utils.Thumbnail.CacheKey(bucket, key, aws.ToString(head.ETag), thumbSize)
+	thumb := utils.Thumbnail.GetCached(cacheKey)
+
+	if thumb == nil {
+		object, err := client.GetObject(r.Context(), &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			utils.ResponseError(w, err)
+			return
+		}
+		defer object.Body.Close()
+
+		thumb, err = utils.Thumbnail.Create(object.Body, thumbSize)
+		if errors.Is(err, utils.ErrThumbnailTooLarge) {
+			utils.ResponseErrorStatus(w, err, http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			utils.ResponseError(w, err)
+			return
+		}
+
+		utils.Thumbnail.Store(cacheKey, thumb)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(thumb)))
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.Header().Set("Etag", fmt.Sprintf("\"%s\"", cacheKey))
+	w.Write(thumb)
+}
+
 func (b *Browse) PutObject(w http.ResponseWriter, r *http.Request) {
 	bucket := r.PathValue("bucket")
 	key := r.PathValue("key")
@@ -184,51 +279,192 @@ func (b *Browse) PutObject(w http.ResponseWriter, r *http.Request) {
 		defer file.Close()
 	}
 
-	client, err := getS3Client(bucket)
+	client, bucketName, err := getWritableS3Client(r, bucket)
 	if err != nil {
-		utils.ResponseError(w, err)
+		responseS3ClientError(w, err)
 		return
 	}
 
-	var contentType string = ""
-	var size int64 = 0
-
-	if file != nil {
-		contentType = headers.Header.Get("Content-Type")
-		size = headers.Size
+	if file == nil {
+		result, err := client.PutObject(r.Context(), &s3.PutObjectInput{
+			Bucket:        aws.String(bucketName),
+			Key:           aws.String(key),
+			ContentLength: aws.Int64(0),
+		})
+		if err != nil {
+			utils.ResponseError(w, fmt.Errorf("cannot put object: %w", err))
+			return
+		}
+		utils.ResponseSuccess(w, result)
+		return
 	}
 
-	result, err := client.PutObject(context.Background(), &s3.PutObjectInput{
-		Bucket:        aws.String(bucket),
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
 		Key:           aws.String(key),
 		Body:          file,
-		ContentLength: aws.Int64(size),
-		ContentType:   aws.String(contentType),
-	})
+		ContentLength: aws.Int64(headers.Size),
+		ContentType:   aws.String(headers.Header.Get("Content-Type")),
+		Metadata:      map[string]string{},
+	}
+
+	// S3 verifies the checksum sent along with the content and rejects the
+	// upload on a mismatch, so a corrupted upload never replaces the object.
+	// Only one checksum can be sent, the other one is kept as metadata.
+	if value := getUploadChecksum(r, utils.ChecksumMetaSHA256); value != "" {
+		digest := utils.DecodeChecksum(value)
+		if len(digest) != sha256.Size {
+			utils.ResponseErrorStatus(w, errors.New("invalid sha256 checksum"), http.StatusBadRequest)
+			return
+		}
+		input.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(digest))
+		input.Metadata[utils.ChecksumMetaSHA256] = hex.EncodeToString(digest)
+	}
+	if value := getUploadChecksum(r, utils.ChecksumMetaCRC32C); value != "" {
+		digest := utils.DecodeChecksum(value)
+		if len(digest) != crc32.Size {
+			utils.ResponseErrorStatus(w, errors.New("invalid crc32c checksum"), http.StatusBadRequest)
+			return
+		}
+		if input.ChecksumSHA256 == nil {
+			input.ChecksumCRC32C = aws.String(base64.StdEncoding.EncodeToString(digest))
+		}
+		input.Metadata[utils.ChecksumMetaCRC32C] = hex.EncodeToString(digest)
+	}
 
+	result, err := client.PutObject(r.Context(), input)
+	if isAPIErrorCode(err, "BadDigest") || isAPIErrorCode(err, "InvalidDigest") {
+		utils.ResponseErrorStatus(w, errors.New("checksum mismatch, the object was not replaced"), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		utils.ResponseError(w, fmt.Errorf("cannot put object: %w", err))
 		return
 	}
+	utils.AddTransferBytes("upload", headers.Size)
+
+	utils.ResponseSuccess(w, result)
+}
+
+// getUploadChecksum returns the client supplied checksum from the form or
+// the x-checksum-* header.
+func getUploadChecksum(r *http.Request, algorithm string) string {
+	if value := r.FormValue(algorithm); value != "" {
+		return value
+	}
+	return r.Header.Get("X-Checksum-" + algorithm)
+}
+
+func (b *Browse) VerifyObject(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	key := r.PathValue("key")
+
+	client, bucketName, err := getS3Client(r, bucket)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	object, err := client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket:       aws.String(bucketName),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		var ae smithy.APIError
+		if errors.As(err, &ae) && ae.ErrorCode() == "NoSuchKey" {
+			utils.ResponseErrorStatus(w, err, http.StatusNotFound)
+			return
+		}
+
+		utils.ResponseError(w, err)
+		return
+	}
+	defer object.Body.Close()
+
+	checksums, size, err := utils.ComputeChecksums(object.Body)
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot read object: %w", err))
+		return
+	}
+
+	result := schema.VerifyObjectResult{
+		Valid:  true,
+		Size:   size,
+		Checks: []schema.ChecksumCheck{},
+	}
+
+	compared := 0
+	addCheck := func(algorithm string, source string, expected string, actual []byte) {
+		check := schema.ChecksumCheck{
+			Algorithm: algorithm,
+			Source:    source,
+			Expected:  expected,
+			Actual:    hex.EncodeToString(actual),
+		}
+		if isCompositeChecksum(source, expected) {
+			check.Skipped = true
+		} else {
+			check.Match = utils.ChecksumMatches(expected, actual)
+			result.Valid = result.Valid && check.Match
+			compared++
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	if value := object.Metadata[utils.ChecksumMetaSHA256]; value != "" {
+		addCheck("sha256", "metadata", value, checksums.SHA256)
+	}
+	if value := object.Metadata[utils.ChecksumMetaCRC32C]; value != "" {
+		addCheck("crc32c", "metadata", value, checksums.CRC32C)
+	}
+	// Checksums of multipart uploads combine the digests of the parts, they
+	// are listed but cannot be compared
+	if value := aws.ToString(object.ChecksumSHA256); value != "" {
+		addCheck("sha256", "s3", value, checksums.SHA256)
+	}
+	if value := aws.ToString(object.ChecksumCRC32C); value != "" {
+		addCheck("crc32c", "s3", value, checksums.CRC32C)
+	}
+	if etag := aws.ToString(object.ETag); etag != "" {
+		addCheck("md5", "etag", etag, checksums.MD5)
+	}
+	if object.ContentLength != nil && *object.ContentLength != size {
+		result.Valid = false
+	}
+
+	// Nothing to compare against
+	if compared == 0 {
+		result.Valid = false
+	}
 
 	utils.ResponseSuccess(w, result)
 }
 
+// isCompositeChecksum reports whether the checksum covers the parts of a
+// multipart upload rather than the whole object.
+func isCompositeChecksum(source string, value string) bool {
+	if source == "etag" {
+		return isMultipartETag(strings.Trim(value, "\""))
+	}
+	return source == "s3" && utils.IsCompositeChecksum(value)
+}
+
 func (b *Browse) DeleteObject(w http.ResponseWriter, r *http.Request) {
 	bucket := r.PathValue("bucket")
 	key := r.PathValue("key")
 	recursive := r.URL.Query().Get("recursive") == "true"
 	isDirectory := strings.HasSuffix(key, "/")
 
-	client, err := getS3Client(bucket)
+	client, bucketName, err := getWritableS3Client(r, bucket)
 	if err != nil {
-		utils.ResponseError(w, err)
+		responseS3ClientError(w, err)
 		return
 	}
 
 	// Delete directory and its content
 	if isDirectory && recursive {
-		deleted, err := deleteAllObjects(client, bucket, key)
+		deleted, err := deleteAllObjects(r.Context(), client, bucketName, key)
 		if err != nil {
 			utils.ResponseError(w, fmt.Errorf("cannot delete objects: %w", err))
 			return
@@ -239,8 +475,8 @@ func (b *Browse) DeleteObject(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete single object
-	res, err := client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
-		Bucket: aws.String(bucket),
+	res, err := client.DeleteObject(r.Context(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
 		Key:    aws.String(key),
 	})
 
@@ -254,7 +490,7 @@ func (b *Browse) DeleteObject(w http.ResponseWriter, r *http.Request) {
 
 // deleteAllObjects deletes all objects in a bucket matching the given prefix,
 // handling pagination for buckets with more than 1000 objects.
-func deleteAllObjects(client *s3.Client, bucket string, prefix string) (int64, error) {
+func deleteAllObjects(ctx context.Context, client *s3.Client, bucket string, prefix string) (int64, error) {
 	var totalDeleted int64
 	var continuationToken *string
 
@@ -268,7 +504,7 @@ func deleteAllObjects(client *s3.Client, bucket string, prefix string) (int64, e
 			input.ContinuationToken = continuationToken
 		}
 
-		objects, err := client.ListObjectsV2(context.Background(), input)
+		objects, err := client.ListObjectsV2(ctx, input)
 		if err != nil {
 			return totalDeleted, err
 		}
@@ -282,7 +518,7 @@ func deleteAllObjects(client *s3.Client, bucket string, prefix string) (int64, e
 			keys = append(keys, types.ObjectIdentifier{Key: object.Key})
 		}
 
-		res, err := client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+		res, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
 			Bucket: aws.String(bucket),
 			Delete: &types.Delete{Objects: keys},
 		})
@@ -304,75 +540,3 @@ func deleteAllObjects(client *s3.Client, bucket string, prefix string) (int64, e
 
 	return totalDeleted, nil
 }
-
-func getBucketCredentials(bucket string) (aws.CredentialsProvider, error) {
-	cacheKey := fmt.Sprintf("key:%s", bucket)
-	cacheData := utils.Cache.Get(cacheKey)
-
-	if cacheData != nil {
-		return cacheData.(aws.CredentialsProvider), nil
-	}
-
-	body, err := utils.Garage.Fetch("/v2/GetBucketInfo?globalAlias="+bucket, &utils.FetchOptions{})
-	if err != nil {
-		return nil, err
-	}
-
-	var bucketData schema.Bucket
-	if err := json.Unmarshal(body, &bucketData); err != nil {
-		return nil, err
-	}
-
-	var key schema.KeyElement
-
-	for _, k := range bucketData.Keys {
-		if !k.Permissions.Read || !k.Permissions.Write {
-			continue
-		}
-
-		body, err := utils.Garage.Fetch(fmt.Sprintf("/v2/GetKeyInfo?id=%s&showSecretKey=true", k.AccessKeyID), &utils.FetchOptions{})
-		if err != nil {
-			return nil, err
-		}
-		if err := json.Unmarshal(body, &key); err != nil {
-			return nil, err
-		}
-		break
-	}
-
-	credential := credentials.NewStaticCredentialsProvider(key.AccessKeyID, key.SecretAccessKey, "")
-	utils.Cache.Set(cacheKey, credential, time.Hour)
-
-	return credential, nil
-}
-
-func getS3Client(bucket string) (*s3.Client, error) {
-	creds, err := getBucketCredentials(bucket)
-	if err != nil {
-		return nil, fmt.Errorf("cannot get credentials for bucket %s: %w", bucket, err)
-	}
-
-	// Determine endpoint and whether to disable HTTPS
-	endpoint := utils.Garage.GetS3Endpoint()
-	disableHTTPS := !strings.HasPrefix(endpoint, "https://")
-
-	// AWS config without BaseEndpoint
-	awsConfig := aws.Config{
-		Credentials: creds,
-		Region:      utils.Garage.GetS3Region(),
-	}
-
-	// Build S3 client with custom endpoint resolver for proper signing
-	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
-		o.UsePathStyle = true
-		o.EndpointOptions.DisableHTTPS = disableHTTPS
-		o.EndpointResolver = s3.EndpointResolverFunc(func(region string, opts s3.EndpointResolverOptions) (aws.Endpoint, error) {
-			return aws.Endpoint{
-				URL:           endpoint,
-				SigningRegion: utils.Garage.GetS3Region(),
-			}, nil
-		})
-	})
-
-	return client, nil
-}
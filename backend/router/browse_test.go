@@ -0,0 +1,177 @@
+package router
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"hash/crc32"
+	"khairul169/garage-webui/schema"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newBrowseMux() *http.ServeMux {
+	browse := &Browse{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /browse/{bucket}/{key...}", browse.PutObject)
+	mux.HandleFunc("POST /verify/{bucket}/{key...}", browse.VerifyObject)
+	return mux
+}
+
+func uploadRequest(t *testing.T, path string, data []byte, fields map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	part, err := form.CreateFormFile("file", "upload.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPut, path, body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestPutObjectVerifiesUpload(t *testing.T) {
+	previous := []byte("previous content")
+	data := []byte("new content of the object")
+	sum := sha256.Sum256(data)
+	crc := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+	crcHex := hex.EncodeToString([]byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)})
+
+	tests := []struct {
+		name         string
+		fields       map[string]string
+		wantStatus   int
+		wantData     []byte
+		wantChecksum string
+	}{
+		{"upload", nil, http.StatusOK, data, ""},
+		{"matching sha256", map[string]string{"sha256": hex.EncodeToString(sum[:])}, http.StatusOK, data, "X-Amz-Checksum-Sha256"},
+		{"matching crc32c", map[string]string{"crc32c": crcHex}, http.StatusOK, data, "X-Amz-Checksum-Crc32c"},
+		{"both checksums", map[string]string{"sha256": hex.EncodeToString(sum[:]), "crc32c": crcHex}, http.StatusOK, data, "X-Amz-Checksum-Sha256"},
+		{"sha256 mismatch", map[string]string{"sha256": strings.Repeat("00", 32)}, http.StatusBadRequest, previous, ""},
+		{"crc32c mismatch", map[string]string{"crc32c": "00000000"}, http.StatusBadRequest, previous, ""},
+		{"invalid checksum", map[string]string{"sha256": "abc"}, http.StatusBadRequest, previous, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, _ := newS3Stub(t)
+			allowBucket("docs", false)
+			stub.put("docs", "dir/file.txt", previous, nil)
+
+			w := httptest.NewRecorder()
+			newBrowseMux().ServeHTTP(w, uploadRequest(t, "/browse/docs/dir/file.txt", data, tt.fields))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			object := stub.get("docs", "dir/file.txt")
+			if object == nil || !bytes.Equal(object.data, tt.wantData) {
+				t.Fatalf("object = %v, want %q", object, tt.wantData)
+			}
+			// The upload is written once, straight to its key
+			if keys := stub.keys("docs"); len(keys) != 1 {
+				t.Errorf("bucket keys = %v, want only the object", keys)
+			}
+			if n := stub.count("CopyObject"); n != 0 {
+				t.Errorf("copied the upload %d times, want none", n)
+			}
+			if tt.wantChecksum != "" && object.headers[tt.wantChecksum] == "" {
+				t.Errorf("checksum headers = %v, want %s", object.headers, tt.wantChecksum)
+			}
+			for name, value := range tt.fields {
+				if tt.wantStatus == http.StatusOK && object.metadata[name] != value {
+					t.Errorf("%s metadata = %q, want %q", name, object.metadata[name], value)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyObject(t *testing.T) {
+	data := []byte("stored content")
+	sum := sha256.Sum256(data)
+	crc := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+	crcBytes := []byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}
+
+	tests := []struct {
+		name        string
+		object      stubObject
+		wantValid   bool
+		wantSkipped int
+	}{
+		{
+			name:      "metadata and etag match",
+			object:    stubObject{data: data, etag: md5Hex(data), metadata: map[string]string{"sha256": hex.EncodeToString(sum[:]), "crc32c": hex.EncodeToString(crcBytes)}},
+			wantValid: true,
+		},
+		{
+			name:      "corrupted content",
+			object:    stubObject{data: []byte("stored c0ntent"), etag: md5Hex(data), metadata: map[string]string{"sha256": hex.EncodeToString(sum[:])}},
+			wantValid: false,
+		},
+		{
+			name:      "s3 checksum matches",
+			object:    stubObject{data: data, etag: md5Hex(data), headers: map[string]string{"X-Amz-Checksum-Sha256": base64.StdEncoding.EncodeToString(sum[:])}},
+			wantValid: true,
+		},
+		{
+			name:        "multipart upload with full object checksum",
+			object:      stubObject{data: data, etag: md5Hex(data) + "-2", metadata: map[string]string{"sha256": hex.EncodeToString(sum[:])}, headers: map[string]string{"X-Amz-Checksum-Sha256": "AAAA-2"}},
+			wantValid:   true,
+			wantSkipped: 2,
+		},
+		{
+			name:        "multipart upload only",
+			object:      stubObject{data: data, etag: md5Hex(data) + "-2"},
+			wantValid:   false,
+			wantSkipped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, _ := newS3Stub(t)
+			allowBucket("docs", true)
+			object := tt.object
+			stub.objects["docs/file.txt"] = &object
+
+			w := httptest.NewRecorder()
+			newBrowseMux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/verify/docs/file.txt", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+
+			var result schema.VerifyObjectResult
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid != tt.wantValid {
+				t.Errorf("valid = %v, want %v: %+v", result.Valid, tt.wantValid, result.Checks)
+			}
+			skipped := 0
+			for _, check := range result.Checks {
+				if check.Skipped {
+					skipped++
+					if check.Match {
+						t.Errorf("skipped check %s/%s reported as match", check.Algorithm, check.Source)
+					}
+				}
+			}
+			if skipped != tt.wantSkipped {
+				t.Errorf("skipped checks = %d, want %d", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
@@ -1,57 +1,89 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"khairul169/garage-webui/schema"
 	"khairul169/garage-webui/utils"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
 type Buckets struct{}
 
 func (b *Buckets) GetAll(w http.ResponseWriter, r *http.Request) {
-	body, err := utils.Garage.Fetch("/v2/ListBuckets", &utils.FetchOptions{})
+	res, err := listBucketInfos(r.Context())
 	if err != nil {
 		utils.ResponseError(w, err)
 		return
 	}
 
+	utils.ResponseSuccess(w, res)
+}
+
+// listBucketInfos returns the info of all buckets. Buckets whose info cannot
+// be fetched only have their ID and aliases set.
+func listBucketInfos(ctx context.Context) ([]schema.Bucket, error) {
+	infos, failed, err := readBucketInfos(ctx)
+	return append(infos, failed...), err
+}
+
+// readBucketInfos returns the info of all buckets. The buckets whose info
+// cannot be read are returned separately with only their ID and aliases, so
+// usage consumers do not take them for empty buckets.
+func readBucketInfos(ctx context.Context) ([]schema.Bucket, []schema.Bucket, error) {
+	body, err := utils.Garage.Fetch("/v2/ListBuckets", &utils.FetchOptions{Context: ctx})
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var buckets []schema.GetBucketsRes
 	if err := json.Unmarshal(body, &buckets); err != nil {
-		utils.ResponseError(w, err)
-		return
+		return nil, nil, err
 	}
 
-	ch := make(chan schema.Bucket, len(buckets))
+	type result struct {
+		bucket schema.Bucket
+		ok     bool
+	}
+	ch := make(chan result, len(buckets))
 
 	for _, bucket := range buckets {
 		go func() {
-			body, err := utils.Garage.Fetch(fmt.Sprintf("/v2/GetBucketInfo?id=%s", bucket.ID), &utils.FetchOptions{})
+			fallback := schema.Bucket{ID: bucket.ID, GlobalAliases: bucket.GlobalAliases, LocalAliases: bucket.LocalAliases}
+			body, err := utils.Garage.Fetch(fmt.Sprintf("/v2/GetBucketInfo?id=%s", bucket.ID), &utils.FetchOptions{Context: ctx})
 
 			if err != nil {
-				ch <- schema.Bucket{ID: bucket.ID, GlobalAliases: bucket.GlobalAliases}
+				slog.Warn("Cannot get bucket info.", "bucket", bucket.ID, "error", err)
+				ch <- result{bucket: fallback}
 				return
 			}
 
 			var data schema.Bucket
 			if err := json.Unmarshal(body, &data); err != nil {
-				ch <- schema.Bucket{ID: bucket.ID, GlobalAliases: bucket.GlobalAliases}
+				slog.Warn("Cannot parse bucket info.", "bucket", bucket.ID, "error", err)
+				ch <- result{bucket: fallback}
 				return
 			}
 
 			data.LocalAliases = bucket.LocalAliases
-			ch <- data
+			ch <- result{bucket: data, ok: true}
 		}()
 	}
 
-	res := make([]schema.Bucket, 0, len(buckets))
+	infos := make([]schema.Bucket, 0, len(buckets))
+	var failed []schema.Bucket
 	for i := 0; i < len(buckets); i++ {
-		res = append(res, <-ch)
+		res := <-ch
+		if res.ok {
+			infos = append(infos, res.bucket)
+		} else {
+			failed = append(failed, res.bucket)
+		}
 	}
 
-	utils.ResponseSuccess(w, res)
+	return infos, failed, nil
 }
 
 func (b *Buckets) ForceDelete(w http.ResponseWriter, r *http.Request) {
@@ -62,7 +94,7 @@ func (b *Buckets) ForceDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get bucket info to find the alias for S3 operations
-	body, err := utils.Garage.Fetch(fmt.Sprintf("/v2/GetBucketInfo?id=%s", bucketID), &utils.FetchOptions{})
+	body, err := utils.Garage.Fetch(fmt.Sprintf("/v2/GetBucketInfo?id=%s", bucketID), &utils.FetchOptions{Context: r.Context()})
 	if err != nil {
 		utils.ResponseError(w, fmt.Errorf("cannot get bucket info: %w", err))
 		return
@@ -74,58 +106,92 @@ func (b *Buckets) ForceDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var bucketName string
-	if len(bucket.GlobalAliases) > 0 {
-		bucketName = bucket.GlobalAliases[0]
-	} else if len(bucket.LocalAliases) > 0 {
-		bucketName = bucket.LocalAliases[0].Alias
-	} else {
-		utils.ResponseErrorStatus(w, fmt.Errorf("bucket has no alias, cannot access via S3"), http.StatusBadRequest)
-		return
+	// Buckets without a global alias get a temporary one, so they can be
+	// emptied regardless of which keys own their local aliases
+	globalAliases := bucket.GlobalAliases
+	tmpAlias := ""
+	if len(globalAliases) == 0 {
+		alias := "webui-tmp-" + bucketID[:min(len(bucketID), 16)]
+		_, err := utils.Garage.Fetch("/v2/AddBucketAlias", &utils.FetchOptions{
+			Context: r.Context(),
+			Method:  http.MethodPost,
+			Body:    map[string]string{"bucketId": bucketID, "globalAlias": alias},
+		})
+		if err != nil {
+			utils.ResponseError(w, fmt.Errorf("cannot add temporary alias: %w", err))
+			return
+		}
+		tmpAlias = alias
+		globalAliases = append(globalAliases, alias)
+		invalidateBucketCredentials(r.Context(), bucketID)
+
+		// Remove it again if deleting fails, even if the request was cancelled
+		defer func() {
+			if tmpAlias == "" {
+				return
+			}
+			_, err := utils.Garage.Fetch("/v2/RemoveBucketAlias", &utils.FetchOptions{
+				Context: context.WithoutCancel(r.Context()),
+				Method:  http.MethodPost,
+				Body:    map[string]string{"bucketId": bucketID, "globalAlias": tmpAlias},
+			})
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Cannot remove temporary alias.", "bucket", bucketID, "alias", tmpAlias, "error", err)
+			}
+			invalidateBucketCredentials(r.Context(), bucketID)
+		}()
 	}
 
 	// Empty the bucket first
 	if bucket.Objects > 0 {
-		client, err := getS3Client(bucketName)
+		client, bucketName, err := getWritableS3Client(r, bucketID)
 		if err != nil {
-			utils.ResponseError(w, fmt.Errorf("cannot get S3 client: %w", err))
+			responseS3ClientError(w, fmt.Errorf("cannot get S3 client: %w", err))
 			return
 		}
 
-		deleted, err := deleteAllObjects(client, bucketName, "")
+		deleted, err := deleteAllObjects(r.Context(), client, bucketName, "")
 		if err != nil {
 			utils.ResponseError(w, fmt.Errorf("failed to empty bucket after deleting %d objects: %w", deleted, err))
 			return
 		}
-		log.Printf("Emptied bucket %s: deleted %d objects", bucketName, deleted)
+		slog.InfoContext(r.Context(), "Emptied bucket.", "bucket", bucketName, "deleted", deleted)
 	}
 
 	// Remove all aliases before deleting
-	for _, alias := range bucket.GlobalAliases {
+	for _, alias := range globalAliases {
 		_, err := utils.Garage.Fetch("/v2/RemoveBucketAlias", &utils.FetchOptions{
-			Method: http.MethodPost,
-			Body:   map[string]string{"bucketId": bucketID, "globalAlias": alias},
+			Context: r.Context(),
+			Method:  http.MethodPost,
+			Body:    map[string]string{"bucketId": bucketID, "globalAlias": alias},
 		})
 		if err != nil {
 			utils.ResponseError(w, fmt.Errorf("cannot remove alias %s: %w", alias, err))
 			return
 		}
+		if alias == tmpAlias {
+			tmpAlias = ""
+		}
 	}
 
-	for _, la := range bucket.LocalAliases {
-		_, err := utils.Garage.Fetch("/v2/RemoveBucketAlias", &utils.FetchOptions{
-			Method: http.MethodPost,
-			Body:   map[string]string{"bucketId": bucketID, "accessKeyId": la.AccessKeyID, "localAlias": la.Alias},
-		})
-		if err != nil {
-			utils.ResponseError(w, fmt.Errorf("cannot remove local alias %s: %w", la.Alias, err))
-			return
+	for _, key := range bucket.Keys {
+		for _, alias := range key.BucketLocalAliases {
+			_, err := utils.Garage.Fetch("/v2/RemoveBucketAlias", &utils.FetchOptions{
+				Context: r.Context(),
+				Method:  http.MethodPost,
+				Body:    map[string]string{"bucketId": bucketID, "accessKeyId": key.AccessKeyID, "localAlias": alias},
+			})
+			if err != nil {
+				utils.ResponseError(w, fmt.Errorf("cannot remove local alias %s: %w", alias, err))
+				return
+			}
 		}
 	}
 
 	// Delete the bucket via Garage admin API
 	_, err = utils.Garage.Fetch(fmt.Sprintf("/v2/DeleteBucket?id=%s", bucketID), &utils.FetchOptions{
-		Method: http.MethodPost,
+		Context: r.Context(),
+		Method:  http.MethodPost,
 	})
 	if err != nil {
 		utils.ResponseError(w, fmt.Errorf("cannot delete bucket: %w", err))
@@ -0,0 +1,63 @@
+package router
+
+import (
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForceDeleteRemovesTemporaryAlias(t *testing.T) {
+	const bucketID = "0123456789abcdef0123456789abcdef"
+	const tmpAlias = "webui-tmp-0123456789abcdef"
+
+	tests := []struct {
+		name          string
+		globalAliases []string
+		objects       int64
+		deleteStatus  int
+		wantStatus    int
+		wantTmpAlias  bool
+	}{
+		{"deleted", nil, 0, http.StatusOK, http.StatusOK, true},
+		{"emptying fails", nil, 3, http.StatusOK, http.StatusForbidden, true},
+		{"delete fails", nil, 0, http.StatusConflict, http.StatusInternalServerError, true},
+		{"global alias", []string{"photos"}, 0, http.StatusOK, http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			garage := newGarageStub(t)
+			utils.InitCacheManager()
+			utils.InitSessionKeyManager()
+
+			// No key is allowed on the bucket, so it cannot be emptied
+			garage.respond("GetBucketInfo", http.StatusOK, schema.Bucket{ID: bucketID, GlobalAliases: tt.globalAliases, Objects: tt.objects})
+			garage.respond("AddBucketAlias", http.StatusOK, map[string]string{})
+			garage.respond("RemoveBucketAlias", http.StatusOK, map[string]string{})
+			garage.respond("DeleteBucket", tt.deleteStatus, map[string]string{"message": "bucket not empty"})
+
+			w := httptest.NewRecorder()
+			(&Buckets{}).ForceDelete(w, httptest.NewRequest(http.MethodPost, "/buckets/force-delete?id="+bucketID, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			added, removed := 0, 0
+			for _, call := range garage.called("AddBucketAlias") {
+				if call.Body["globalAlias"] == tmpAlias {
+					added++
+				}
+			}
+			for _, call := range garage.called("RemoveBucketAlias") {
+				if call.Body["globalAlias"] == tmpAlias {
+					removed++
+				}
+			}
+			if want := map[bool]int{true: 1, false: 0}[tt.wantTmpAlias]; added != want || removed != want {
+				t.Errorf("temporary alias added %d and removed %d times, want %d", added, removed, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package router
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const dedupJobType = "dedup"
+
+type Dedup struct{}
+
+type dedupObject struct {
+	key  string
+	etag string
+	size int64
+	hash string
+}
+
+// dedupStartMu makes checking for a running scan and starting one atomic.
+var dedupStartMu sync.Mutex
+
+// dedupCheckpoint is the state persisted to clean up after a restart. The
+// scan itself starts over when resumed.
+type dedupCheckpoint struct {
+	AccessKeyID string `json:"accessKeyId"`
+}
+
+// InitDedup registers the dedup job, so interrupted scans resume after a
+// restart.
+func InitDedup() {
+	utils.Jobs.Register(dedupJobType, runDedupJob)
+}
+
+// StartReport starts a background job that scans the bucket for duplicate
+// objects.
+func (d *Dedup) StartReport(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	verify := r.URL.Query().Get("verify") == "true"
+
+	target, err := getBucketTarget(r.Context(), bucket)
+	if err != nil {
+		utils.ResponseErrorStatus(w, fmt.Errorf("cannot find bucket: %w", err), http.StatusNotFound)
+		return
+	}
+
+	dedupStartMu.Lock()
+	defer dedupStartMu.Unlock()
+
+	// Only one scan per bucket at a time
+	for _, job := range utils.Jobs.List(dedupJobType) {
+		if job.Params["bucketId"] == target.ID && job.Status == schema.JobStatusRunning {
+			utils.ResponseErrorStatus(w, fmt.Errorf("dedup report for bucket %s is already running", bucket), http.StatusConflict)
+			return
+		}
+	}
+
+	params := map[string]string{"bucket": bucket, "bucketId": target.ID, "verify": strconv.FormatBool(verify)}
+	job := utils.Jobs.Start(dedupJobType, params, runDedupJob)
+
+	utils.ResponseSuccess(w, job.Snapshot())
+}
+
+// runDedupJob scans the bucket with a key of its own, so the scan neither
+// depends on the browser session nor on the keys of bucket owners.
+func runDedupJob(ctx context.Context, job *utils.Job) (interface{}, error) {
+	params := job.Snapshot().Params
+
+	checkpoint := dedupCheckpoint{}
+	if job.Checkpoint(&checkpoint) && checkpoint.AccessKeyID != "" {
+		// Key of the interrupted run
+		utils.DeleteScopedKey(ctx, checkpoint.AccessKeyID)
+	}
+
+	bucketID := params["bucketId"]
+	client, names, accessKeyID, err := createJobClient(ctx, job.ID(), []utils.KeyGrant{{BucketID: bucketID, Read: true}})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		utils.DeleteScopedKey(context.WithoutCancel(ctx), accessKeyID)
+		utils.S3Clients.Remove(accessKeyID)
+	}()
+
+	checkpoint.AccessKeyID = accessKeyID
+	if err := job.SetCheckpoint(checkpoint); err != nil {
+		return nil, err
+	}
+
+	report, err := createDedupReport(ctx, job, client, names[bucketID], params["verify"] == "true")
+	if report != nil {
+		report.Bucket = params["bucket"]
+	}
+	return report, err
+}
+
+// GetReport returns the latest finished dedup report of the bucket.
+func (d *Dedup) GetReport(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	for _, job := range utils.Jobs.List(dedupJobType) {
+		if job.Params["bucket"] == bucket && job.Status == schema.JobStatusCompleted {
+			utils.ResponseSuccess(w, job.Result)
+			return
+		}
+	}
+
+	utils.ResponseErrorStatus(w, errors.New("no dedup report available"), http.StatusNotFound)
+}
+
+func createDedupReport(ctx context.Context, job *utils.Job, client *s3.Client, bucket string, verify bool) (*schema.DedupReport, error) {
+	report := &schema.DedupReport{
+		Bucket:   bucket,
+		Verified: verify,
+		Groups:   []schema.DedupGroup{},
+	}
+
+	// Group objects by size first, as only objects of equal size can be duplicates
+	bySize := map[int64][]*dedupObject{}
+	var continuationToken *string
+
+	for {
+		objects, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            aws.String(bucket),
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range objects.Contents {
+			size := aws.ToInt64(object.Size)
+			bySize[size] = append(bySize[size], &dedupObject{
+				key:  aws.ToString(object.Key),
+				etag: strings.Trim(aws.ToString(object.ETag), "\""),
+				size: size,
+			})
+			report.ScannedObjects++
+			report.ScannedBytes += size
+		}
+		job.SetProgress(report.ScannedObjects, 0, "listing objects")
+
+		if objects.IsTruncated == nil || !*objects.IsTruncated {
+			break
+		}
+		continuationToken = objects.NextContinuationToken
+	}
+
+	if verify {
+		if err := hashAmbiguousObjects(ctx, job, client, bucket, bySize); err != nil {
+			return nil, err
+		}
+	}
+
+	for size, objects := range bySize {
+		if len(objects) < 2 || size == 0 {
+			continue
+		}
+
+		groups := map[string][]*dedupObject{}
+		for _, object := range objects {
+			groupKey := "etag:" + object.etag
+			if object.hash != "" {
+				groupKey = "sha256:" + object.hash
+			}
+			groups[groupKey] = append(groups[groupKey], object)
+		}
+
+		for _, objects := range groups {
+			if len(objects) < 2 {
+				continue
+			}
+
+			group := schema.DedupGroup{
+				ETag:             objects[0].etag,
+				SHA256:           objects[0].hash,
+				Size:             size,
+				Keys:             make([]string, 0, len(objects)),
+				Verified:         objects[0].hash != "" || !isMultipartETag(objects[0].etag),
+				ReclaimableBytes: size * int64(len(objects)-1),
+			}
+			for _, object := range objects {
+				group.Keys = append(group.Keys, object.key)
+			}
+			sort.Strings(group.Keys)
+
+			report.Groups = append(report.Groups, group)
+			report.DuplicateObjects += int64(len(objects) - 1)
+			report.ReclaimableBytes += group.ReclaimableBytes
+		}
+	}
+
+	sort.Slice(report.Groups, func(i, j int) bool {
+		return report.Groups[i].ReclaimableBytes > report.Groups[j].ReclaimableBytes
+	})
+
+	report.GeneratedAt = time.Now()
+	return report, nil
+}
+
+// hashAmbiguousObjects computes the SHA-256 of objects whose ETag is not a
+// plain MD5 (multipart uploads) and that share their size with another
+// object, since equal content can have different multipart ETags.
+func hashAmbiguousObjects(ctx context.Context, job *utils.Job, client *s3.Client, bucket string, bySize map[int64][]*dedupObject) error {
+	pending := []*dedupObject{}
+	for size, objects := range bySize {
+		if len(objects) < 2 || size == 0 {
+			continue
+		}
+
+		hasMultipart := false
+		for _, object := range objects {
+			hasMultipart = hasMultipart || isMultipartETag(object.etag)
+		}
+		if hasMultipart {
+			pending = append(pending, objects...)
+		}
+	}
+
+	concurrency, err := strconv.Atoi(utils.GetEnv("DEDUP_CONCURRENCY", "4"))
+	if err != nil || concurrency < 1 {
+		concurrency = 4
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	var hashed int64
+	sem := make(chan struct{}, concurrency)
+
+	for _, object := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			hash, err := hashObject(ctx, client, bucket, object.key)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("cannot hash %s: %w", object.key, err)
+				}
+				return
+			}
+			object.hash = hash
+			hashed++
+			job.SetProgress(hashed, int64(len(pending)), "verifying multipart objects")
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
+
+func hashObject(ctx context.Context, client *s3.Client, bucket string, key string) (string, error) {
+	object, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer object.Body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, object.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func isMultipartETag(etag string) bool {
+	return strings.Contains(etag, "-")
+}
@@ -0,0 +1,160 @@
+package router
+
+import (
+	"encoding/json"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newJobManager runs jobs in memory for the test.
+func newJobManager(t *testing.T) {
+	t.Setenv("JOBS_DIR", "off")
+	previous := utils.Jobs
+	utils.InitJobManager()
+	t.Cleanup(func() { utils.Jobs = previous })
+}
+
+func TestDedupReportUsesJobKey(t *testing.T) {
+	const bucketID = "0123456789abcdef"
+
+	tests := []struct {
+		name   string
+		cached bool
+	}{
+		{"without session", false},
+		{"bucket key cached", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newJobManager(t)
+			stub, _ := newS3Stub(t)
+			garage := newGarageStub(t)
+			garage.respond("GetBucketInfo", http.StatusOK, schema.Bucket{ID: bucketID, GlobalAliases: []string{"photos"}})
+			garage.respond("CreateKey", http.StatusOK, schema.KeyElement{AccessKeyID: "GKjob", SecretAccessKey: "secret"})
+			garage.respond("AllowBucketKey", http.StatusOK, map[string]string{})
+			garage.respond("DeleteKey", http.StatusOK, map[string]string{})
+			stub.put("photos", "a.txt", []byte("same"), nil)
+			stub.put("photos", "b.txt", []byte("same"), nil)
+			stub.put("photos", "c.txt", []byte("diff"), nil)
+			if tt.cached {
+				// Credentials of the request, e.g. a session key, must not
+				// be used, as they may expire before the scan finishes
+				allowBucket("photos", false)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/dedup/photos", nil)
+			r.SetPathValue("bucket", "photos")
+			w := httptest.NewRecorder()
+			(&Dedup{}).StartReport(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+
+			var started schema.Job
+			json.Unmarshal(w.Body.Bytes(), &started)
+			job := utils.Jobs.Get(started.ID)
+			job.Wait()
+
+			snapshot := job.Snapshot()
+			if snapshot.Status != schema.JobStatusCompleted {
+				t.Fatalf("job %s: %s", snapshot.Status, snapshot.Error)
+			}
+			report := snapshot.Result.(*schema.DedupReport)
+			if report.Bucket != "photos" || report.DuplicateObjects != 1 {
+				t.Errorf("report = %+v, want one duplicate in photos", report)
+			}
+
+			created := garage.called("CreateKey")
+			if len(created) != 1 || !strings.HasPrefix(created[0].Body["name"].(string), utils.JobKeyPrefix) {
+				t.Fatalf("created keys %+v, want one job key", created)
+			}
+			if deleted := garage.called("DeleteKey"); len(deleted) != 1 || deleted[0].Query != "id=GKjob" {
+				t.Errorf("deleted keys %+v, want the job key", deleted)
+			}
+		})
+	}
+}
+
+func TestDedupReportStartsOncePerBucket(t *testing.T) {
+	newJobManager(t)
+	newS3Stub(t)
+	garage := newGarageStub(t)
+	garage.respond("GetBucketInfo", http.StatusOK, schema.Bucket{ID: "0123456789abcdef", GlobalAliases: []string{"photos"}})
+
+	// The scans stay running until the key can be created
+	release := make(chan struct{})
+	garage.handle("CreateKey", func(*http.Request, map[string]interface{}) (int, interface{}) {
+		<-release
+		return http.StatusInternalServerError, map[string]string{"message": "unavailable"}
+	})
+
+	const requests = 8
+	var wg sync.WaitGroup
+	codes := make([]int, requests)
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/dedup/photos", nil)
+			r.SetPathValue("bucket", "photos")
+			w := httptest.NewRecorder()
+			(&Dedup{}).StartReport(w, r)
+			codes[i] = w.Code
+		}()
+	}
+	wg.Wait()
+	close(release)
+
+	started := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			started++
+		case http.StatusConflict:
+		default:
+			t.Errorf("status = %d", code)
+		}
+	}
+	if started != 1 {
+		t.Errorf("started %d scans, want 1", started)
+	}
+	for _, job := range utils.Jobs.List(dedupJobType) {
+		utils.Jobs.Get(job.ID).Wait()
+	}
+}
+
+func TestDedupReportResumes(t *testing.T) {
+	dir := t.TempDir()
+	job := schema.Job{ID: "dedup-1", Type: dedupJobType, Status: schema.JobStatusRunning, Params: map[string]string{"bucket": "photos", "bucketId": "0123456789abcdef", "verify": "false"}}
+	data, _ := json.Marshal(job)
+	if err := os.WriteFile(filepath.Join(dir, job.ID+".json"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JOBS_DIR", dir)
+	previous := utils.Jobs
+	utils.InitJobManager()
+	t.Cleanup(func() { utils.Jobs = previous })
+
+	newS3Stub(t)
+	garage := newGarageStub(t)
+	garage.respond("GetBucketInfo", http.StatusOK, schema.Bucket{ID: "0123456789abcdef", GlobalAliases: []string{"photos"}})
+	garage.respond("CreateKey", http.StatusOK, schema.KeyElement{AccessKeyID: "GKjob", SecretAccessKey: "secret"})
+	garage.respond("AllowBucketKey", http.StatusOK, map[string]string{})
+	garage.respond("DeleteKey", http.StatusOK, map[string]string{})
+
+	InitDedup()
+	utils.Jobs.Resume()
+	resumed := utils.Jobs.Get(job.ID)
+	resumed.Wait()
+	if snapshot := resumed.Snapshot(); snapshot.Status != schema.JobStatusCompleted {
+		t.Errorf("resumed job %s: %s", snapshot.Status, snapshot.Error)
+	}
+}
@@ -0,0 +1,524 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventClientBuffer = 64
+	eventKeepAlive    = 15 * time.Second
+)
+
+type Events struct{}
+
+// eventHub polls the cluster while clients are connected and pushes the
+// changes to all of them, so browser tabs do not poll the admin API on
+// their own.
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[chan schema.Event]bool
+	running bool
+	seq     uint64
+
+	interval       time.Duration
+	bucketInterval time.Duration
+
+	// State of the last poll, the changes are sent against it. It is reset
+	// when the last client disconnects.
+	primed        bool
+	layoutVersion int
+	nodes         map[string]schema.NodeEvent
+	buckets       map[string]schema.BucketUsageEvent
+	bucketsAt     time.Time
+	jobs          map[string]schema.Job
+	workers       map[string]schema.WorkerEvent
+	alerts        map[string]schema.Alert
+	lastError     string
+}
+
+var eventStream = &eventHub{
+	clients:        map[chan schema.Event]bool{},
+	interval:       5 * time.Second,
+	bucketInterval: 30 * time.Second,
+}
+
+// eventPoll is the state fetched by one poll.
+type eventPoll struct {
+	layoutVersion int
+	nodes         map[string]schema.NodeEvent
+	buckets       map[string]schema.BucketUsageEvent
+	// failedBuckets are the buckets whose usage could not be read, they keep
+	// their previous usage
+	failedBuckets map[string]bool
+	jobs          map[string]schema.Job
+	workers       map[string]schema.WorkerEvent
+	alerts        map[string]schema.Alert
+	errors        []string
+}
+
+// InitEvents reads the poll intervals of the event stream from
+// EVENTS_INTERVAL and EVENTS_BUCKET_INTERVAL.
+func InitEvents() {
+	value := utils.GetEnv("EVENTS_INTERVAL", "5s")
+	if interval, err := time.ParseDuration(value); err == nil && interval >= time.Second {
+		eventStream.interval = interval
+	} else {
+		slog.Warn("Invalid EVENTS_INTERVAL, using the default.", "value", value, "default", eventStream.interval)
+	}
+
+	value = utils.GetEnv("EVENTS_BUCKET_INTERVAL", "30s")
+	if interval, err := time.ParseDuration(value); err == nil && interval >= time.Second {
+		eventStream.bucketInterval = interval
+	} else {
+		slog.Warn("Invalid EVENTS_BUCKET_INTERVAL, using the default.", "value", value, "default", eventStream.bucketInterval)
+	}
+}
+
+// subscribe registers a client and starts the poller if it is the first
+// one. The client gets a snapshot of the current state first.
+func (h *eventHub) subscribe() chan schema.Event {
+	ch := make(chan schema.Event, eventClientBuffer)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.clients[ch] = true
+	if h.primed {
+		ch <- h.newEventLocked(schema.EventSnapshot, h.snapshotLocked())
+	}
+	if !h.running {
+		h.running = true
+		go h.run()
+	}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan schema.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.clients[ch] {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+func (h *eventHub) run() {
+	for {
+		h.poll()
+		time.Sleep(h.interval)
+
+		h.mu.Lock()
+		if len(h.clients) == 0 {
+			h.running = false
+			h.primed = false
+			h.lastError = ""
+			h.mu.Unlock()
+			return
+		}
+		h.mu.Unlock()
+	}
+}
+
+// poll fetches the state and sends it to the clients.
+func (h *eventHub) poll() {
+	h.mu.Lock()
+	pollBuckets := !h.primed || time.Since(h.bucketsAt) >= h.bucketInterval
+	h.mu.Unlock()
+
+	ctx, span := utils.StartTask(context.Background(), "events poll")
+	state := fetchEventState(ctx, pollBuckets)
+	span.End()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.applyLocked(state)
+}
+
+// applyLocked sends the changes of the polled state against the state of
+// the last poll, or a snapshot if it is the first one.
+func (h *eventHub) applyLocked(state eventPoll) {
+	message := strings.Join(state.errors, "; ")
+	if message != h.lastError && message != "" {
+		h.broadcastLocked(schema.EventError, schema.ErrorEvent{Message: message})
+	}
+	h.lastError = message
+
+	if !h.primed {
+		h.layoutVersion = state.layoutVersion
+		h.nodes = state.nodes
+		h.buckets = state.buckets
+		h.bucketsAt = time.Now()
+		h.jobs = state.jobs
+		h.workers = state.workers
+		h.alerts = state.alerts
+		h.primed = true
+		h.broadcastLocked(schema.EventSnapshot, h.snapshotLocked())
+		return
+	}
+
+	// Keep the previous state of the parts that could not be fetched
+	if state.nodes != nil {
+		if state.layoutVersion != h.layoutVersion {
+			h.broadcastLocked(schema.EventLayout, schema.LayoutEvent{Version: state.layoutVersion, PreviousVersion: h.layoutVersion})
+			h.layoutVersion = state.layoutVersion
+		}
+		for _, id := range sortedKeys(state.nodes) {
+			node := state.nodes[id]
+			if prev, ok := h.nodes[id]; !ok || prev != node {
+				h.broadcastLocked(schema.EventNode, node)
+			}
+		}
+		h.nodes = state.nodes
+	}
+
+	if state.buckets != nil {
+		for id := range state.failedBuckets {
+			if prev, ok := h.buckets[id]; ok {
+				state.buckets[id] = prev
+			}
+		}
+		for _, id := range sortedKeys(state.buckets) {
+			bucket := state.buckets[id]
+			prev := h.buckets[id]
+			if bucket.Objects != prev.Objects || bucket.Bytes != prev.Bytes || bucket.Name != prev.Name {
+				bucket.ObjectsDelta = bucket.Objects - prev.Objects
+				bucket.BytesDelta = bucket.Bytes - prev.Bytes
+				h.broadcastLocked(schema.EventBucket, bucket)
+			}
+		}
+		for _, id := range sortedKeys(h.buckets) {
+			if _, ok := state.buckets[id]; !ok {
+				prev := h.buckets[id]
+				h.broadcastLocked(schema.EventBucket, schema.BucketUsageEvent{
+					ID:           id,
+					Name:         prev.Name,
+					ObjectsDelta: -prev.Objects,
+					BytesDelta:   -prev.Bytes,
+					Removed:      true,
+				})
+			}
+		}
+		h.buckets = state.buckets
+		h.bucketsAt = time.Now()
+	}
+
+	for _, id := range sortedKeys(state.jobs) {
+		job := state.jobs[id]
+		prev, ok := h.jobs[id]
+		if !ok || prev.Status != job.Status || !prev.UpdatedAt.Equal(job.UpdatedAt) {
+			h.broadcastLocked(schema.EventJob, job)
+		}
+	}
+	h.jobs = state.jobs
+
+	if state.workers != nil {
+		for _, key := range sortedKeys(state.workers) {
+			worker := state.workers[key]
+			if prev, ok := h.workers[key]; !ok || !equalWorkers(prev, worker) {
+				h.broadcastLocked(schema.EventWorker, worker)
+			}
+		}
+		for _, key := range sortedKeys(h.workers) {
+			if _, ok := state.workers[key]; !ok {
+				worker := h.workers[key]
+				worker.State = "idle"
+				worker.Progress = ""
+				worker.QueueLength = nil
+				h.broadcastLocked(schema.EventWorker, worker)
+			}
+		}
+		h.workers = state.workers
+	}
+
+	for _, id := range sortedKeys(state.alerts) {
+		alert := state.alerts[id]
+		if prev, ok := h.alerts[id]; !ok || prev.State != alert.State || prev.Silenced != alert.Silenced {
+			h.broadcastLocked(schema.EventAlert, alert)
+		}
+	}
+	for _, id := range sortedKeys(h.alerts) {
+		if _, ok := state.alerts[id]; !ok {
+			alert := h.alerts[id]
+			now := time.Now().UTC()
+			alert.State = schema.AlertStateResolved
+			alert.ResolvedAt = &now
+			h.broadcastLocked(schema.EventAlert, alert)
+		}
+	}
+	h.alerts = state.alerts
+}
+
+func (h *eventHub) newEventLocked(eventType string, data interface{}) schema.Event {
+	h.seq++
+	return schema.Event{ID: h.seq, Type: eventType, Time: time.Now().UTC(), Data: data}
+}
+
+// broadcastLocked sends the event to all clients. Clients too slow to keep
+// up are disconnected, they get a new snapshot when they reconnect.
+func (h *eventHub) broadcastLocked(eventType string, data interface{}) {
+	event := h.newEventLocked(eventType, data)
+	for ch := range h.clients {
+		select {
+		case ch <- event:
+		default:
+			delete(h.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *eventHub) snapshotLocked() schema.EventSnapshotData {
+	snapshot := schema.EventSnapshotData{
+		LayoutVersion: h.layoutVersion,
+		Nodes:         []schema.NodeEvent{},
+		Buckets:       []schema.BucketUsageEvent{},
+		Jobs:          []schema.Job{},
+		Workers:       []schema.WorkerEvent{},
+		Alerts:        []schema.Alert{},
+	}
+	for _, id := range sortedKeys(h.nodes) {
+		snapshot.Nodes = append(snapshot.Nodes, h.nodes[id])
+	}
+	for _, id := range sortedKeys(h.buckets) {
+		snapshot.Buckets = append(snapshot.Buckets, h.buckets[id])
+	}
+	for _, id := range sortedKeys(h.jobs) {
+		snapshot.Jobs = append(snapshot.Jobs, h.jobs[id])
+	}
+	for _, key := range sortedKeys(h.workers) {
+		snapshot.Workers = append(snapshot.Workers, h.workers[key])
+	}
+	for _, id := range sortedKeys(h.alerts) {
+		snapshot.Alerts = append(snapshot.Alerts, h.alerts[id])
+	}
+	return snapshot
+}
+
+// fetchEventState fetches the cluster status, the busy workers and the
+// local jobs and alerts. Parts that fail are left nil.
+func fetchEventState(ctx context.Context, withBuckets bool) eventPoll {
+	state := eventPoll{
+		jobs:   map[string]schema.Job{},
+		alerts: map[string]schema.Alert{},
+	}
+
+	if body, err := utils.Garage.Fetch("/v2/GetClusterStatus", &utils.FetchOptions{Context: ctx}); err != nil {
+		state.errors = append(state.errors, fmt.Sprintf("cannot get cluster status: %v", err))
+	} else {
+		var status ClusterStatusResponse
+		if err := json.Unmarshal(body, &status); err != nil {
+			state.errors = append(state.errors, fmt.Sprintf("cannot get cluster status: %v", err))
+		} else {
+			state.layoutVersion = status.LayoutVersion
+			if state.layoutVersion == 0 && status.Layout != nil {
+				state.layoutVersion = status.Layout.Version
+			}
+
+			nodes := status.KnownNodes
+			if len(nodes) == 0 {
+				nodes = status.Nodes
+			}
+			state.nodes = map[string]schema.NodeEvent{}
+			for _, node := range nodes {
+				state.nodes[node.ID] = schema.NodeEvent{ID: node.ID, Hostname: node.Hostname, IsUp: node.IsUp, Draining: node.Draining}
+			}
+		}
+	}
+
+	if withBuckets {
+		if buckets, failed, err := readBucketInfos(ctx); err != nil {
+			state.errors = append(state.errors, fmt.Sprintf("cannot list buckets: %v", err))
+		} else {
+			if len(failed) > 0 {
+				state.errors = append(state.errors, fmt.Sprintf("cannot read usage of %d buckets", len(failed)))
+			}
+			state.failedBuckets = map[string]bool{}
+			for _, bucket := range failed {
+				state.failedBuckets[bucket.ID] = true
+			}
+			state.buckets = map[string]schema.BucketUsageEvent{}
+			for _, bucket := range buckets {
+				state.buckets[bucket.ID] = schema.BucketUsageEvent{
+					ID:      bucket.ID,
+					Name:    bucketDisplayName(bucket),
+					Objects: bucket.Objects,
+					Bytes:   bucket.Bytes,
+				}
+			}
+		}
+	}
+
+	if workers, err := listBusyWorkers(ctx); err != nil {
+		state.errors = append(state.errors, fmt.Sprintf("cannot list workers: %v", err))
+	} else {
+		state.workers = workers
+	}
+
+	for _, job := range utils.Jobs.List("") {
+		state.jobs[job.ID] = job
+	}
+	for _, alert := range alerting.activeAlerts() {
+		state.alerts[alert.ID] = alert
+	}
+
+	return state
+}
+
+// listBusyWorkers returns the busy background workers of all nodes, keyed
+// by node and worker ID.
+func listBusyWorkers(ctx context.Context) (map[string]schema.WorkerEvent, error) {
+	body, err := utils.Garage.Fetch("/v2/ListWorkers", &utils.FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+		Params:  map[string]string{"node": "*"},
+		Body:    map[string]bool{"busyOnly": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Success map[string][]struct {
+			ID          int64           `json:"id"`
+			Name        string          `json:"name"`
+			State       json.RawMessage `json:"state"`
+			Errors      int64           `json:"errors"`
+			Progress    *string         `json:"progress"`
+			QueueLength *int64          `json:"queueLength"`
+		} `json:"success"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	workers := map[string]schema.WorkerEvent{}
+	for node, list := range res.Success {
+		for _, info := range list {
+			worker := schema.WorkerEvent{
+				Node:        node,
+				ID:          info.ID,
+				Name:        info.Name,
+				State:       workerStateName(info.State),
+				QueueLength: info.QueueLength,
+				Errors:      info.Errors,
+			}
+			if info.Progress != nil {
+				worker.Progress = *info.Progress
+			}
+			workers[fmt.Sprintf("%s/%d", node, info.ID)] = worker
+		}
+	}
+	return workers, nil
+}
+
+// workerStateName returns the name of a worker state, which is either a
+// string or an object keyed by the state, e.g. {"throttled": {...}}.
+func workerStateName(state json.RawMessage) string {
+	var name string
+	if err := json.Unmarshal(state, &name); err == nil {
+		return name
+	}
+
+	var states map[string]json.RawMessage
+	if err := json.Unmarshal(state, &states); err == nil {
+		for name := range states {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+func equalWorkers(a schema.WorkerEvent, b schema.WorkerEvent) bool {
+	if (a.QueueLength == nil) != (b.QueueLength == nil) || (a.QueueLength != nil && *a.QueueLength != *b.QueueLength) {
+		return false
+	}
+	return a.State == b.State && a.Progress == b.Progress && a.Errors == b.Errors && a.Name == b.Name
+}
+
+func bucketDisplayName(bucket schema.Bucket) string {
+	if len(bucket.GlobalAliases) > 0 {
+		return bucket.GlobalAliases[0]
+	}
+	if len(bucket.LocalAliases) > 0 {
+		return bucket.LocalAliases[0].Alias
+	}
+	return bucket.ID
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Stream pushes the events as Server-Sent Events. The first event is a
+// snapshot of the current state. ?types= limits the stream to a comma
+// separated list of event types.
+func (e *Events) Stream(w http.ResponseWriter, r *http.Request) {
+	var types map[string]bool
+	if value := r.URL.Query().Get("types"); value != "" {
+		types = map[string]bool{schema.EventSnapshot: true, schema.EventError: true}
+		for _, eventType := range strings.Split(value, ",") {
+			types[strings.TrimSpace(eventType)] = true
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventStream.interval.Milliseconds())
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ch := eventStream.subscribe()
+	defer eventStream.unsubscribe(ch)
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if types != nil && !types[event.Type] {
+				continue
+			}
+
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Cannot encode event.", "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package router
+
+import (
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"reflect"
+	"testing"
+)
+
+func eventBuckets(buckets ...schema.BucketUsageEvent) map[string]schema.BucketUsageEvent {
+	result := map[string]schema.BucketUsageEvent{}
+	for _, bucket := range buckets {
+		result[bucket.ID] = bucket
+	}
+	return result
+}
+
+func TestEventHubApplyDiff(t *testing.T) {
+	photos := schema.BucketUsageEvent{ID: "b1", Name: "photos", Objects: 10, Bytes: 1000}
+	docs := schema.BucketUsageEvent{ID: "b2", Name: "docs", Objects: 5, Bytes: 500}
+	node := schema.NodeEvent{ID: "n1", Hostname: "garage-1", IsUp: true}
+
+	initial := eventPoll{
+		layoutVersion: 1,
+		nodes:         map[string]schema.NodeEvent{"n1": node},
+		buckets:       eventBuckets(photos, docs),
+	}
+
+	tests := []struct {
+		name        string
+		next        eventPoll
+		want        []string
+		wantBuckets map[string]schema.BucketUsageEvent
+	}{
+		{
+			name:        "unchanged",
+			next:        initial,
+			want:        []string{},
+			wantBuckets: eventBuckets(photos, docs),
+		},
+		{
+			name: "bucket usage grows",
+			next: eventPoll{buckets: eventBuckets(schema.BucketUsageEvent{ID: "b1", Name: "photos", Objects: 12, Bytes: 1500}, docs)},
+			want: []string{"bucket b1 objects=12 bytes=1500 delta=2/500"},
+			wantBuckets: eventBuckets(
+				schema.BucketUsageEvent{ID: "b1", Name: "photos", Objects: 12, Bytes: 1500}, docs),
+		},
+		{
+			name:        "bucket removed",
+			next:        eventPoll{buckets: eventBuckets(photos)},
+			want:        []string{"bucket b2 objects=0 bytes=0 delta=-5/-500 removed"},
+			wantBuckets: eventBuckets(photos),
+		},
+		{
+			name: "usage of a bucket cannot be read",
+			next: eventPoll{
+				buckets:       eventBuckets(photos),
+				failedBuckets: map[string]bool{"b2": true},
+				errors:        []string{"cannot read usage of 1 buckets"},
+			},
+			want:        []string{"error cannot read usage of 1 buckets"},
+			wantBuckets: eventBuckets(photos, docs),
+		},
+		{
+			name:        "buckets not polled",
+			next:        eventPoll{},
+			want:        []string{},
+			wantBuckets: eventBuckets(photos, docs),
+		},
+		{
+			name: "node down and new layout",
+			next: eventPoll{
+				layoutVersion: 2,
+				nodes:         map[string]schema.NodeEvent{"n1": {ID: "n1", Hostname: "garage-1"}},
+			},
+			want:        []string{"layout 1->2", "node n1 up=false"},
+			wantBuckets: eventBuckets(photos, docs),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan schema.Event, eventClientBuffer)
+			hub := &eventHub{clients: map[chan schema.Event]bool{ch: true}}
+			hub.applyLocked(initial)
+			if event := <-ch; event.Type != schema.EventSnapshot {
+				t.Fatalf("first event = %s, want snapshot", event.Type)
+			}
+
+			hub.applyLocked(tt.next)
+			close(ch)
+
+			got := []string{}
+			for event := range ch {
+				got = append(got, describeEvent(event))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(hub.buckets, tt.wantBuckets) {
+				t.Errorf("buckets = %v, want %v", hub.buckets, tt.wantBuckets)
+			}
+		})
+	}
+}
+
+func describeEvent(event schema.Event) string {
+	switch data := event.Data.(type) {
+	case schema.BucketUsageEvent:
+		s := fmt.Sprintf("bucket %s objects=%d bytes=%d delta=%d/%d", data.ID, data.Objects, data.Bytes, data.ObjectsDelta, data.BytesDelta)
+		if data.Removed {
+			s += " removed"
+		}
+		return s
+	case schema.NodeEvent:
+		return fmt.Sprintf("node %s up=%v", data.ID, data.IsUp)
+	case schema.LayoutEvent:
+		return fmt.Sprintf("layout %d->%d", data.PreviousVersion, data.Version)
+	case schema.ErrorEvent:
+		return "error " + data.Message
+	}
+	return event.Type
+}
@@ -0,0 +1,292 @@
+package router
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultForecastWindow = 7 * 24 * time.Hour
+
+type Forecast struct{}
+
+// linearTrend fits a line through the values by least squares and returns
+// its slope per day. It needs at least two samples at different times.
+func linearTrend(times []time.Time, values []float64) (float64, bool) {
+	if len(times) < 2 {
+		return 0, false
+	}
+
+	origin := times[0]
+	var sumX, sumY, sumXY, sumXX float64
+	for i := range times {
+		x := times[i].Sub(origin).Hours() / 24
+		sumX += x
+		sumY += values[i]
+		sumXY += x * values[i]
+		sumXX += x * x
+	}
+
+	n := float64(len(times))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denominator, true
+}
+
+// forecastLimit returns when the current value reaches the limit growing by
+// perDay, or nil if there is no limit or no growth.
+func forecastLimit(from time.Time, current float64, limit float64, perDay float64) *time.Time {
+	if limit <= 0 {
+		return nil
+	}
+	if current >= limit {
+		return &from
+	}
+	if perDay <= 0 {
+		return nil
+	}
+
+	days := (limit - current) / perDay
+	if days > 365*100 {
+		return nil
+	}
+	at := from.Add(time.Duration(days * float64(24*time.Hour)))
+	return &at
+}
+
+func daysUntil(from time.Time, at *time.Time) *float64 {
+	if at == nil {
+		return nil
+	}
+	days := at.Sub(from).Hours() / 24
+	return &days
+}
+
+func parseForecastWindow(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("window")
+	if value == "" {
+		return defaultForecastWindow, nil
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid window %q", value)
+	}
+	return window, nil
+}
+
+func forecastBucket(id string, from time.Time, to time.Time) (*schema.BucketForecast, error) {
+	var times []time.Time
+	var bytes, objects []float64
+	var last schema.BucketSample
+
+	err := readHistory(historyBucketPrefix+id, from, to, 0, func(data []byte) error {
+		if err := json.Unmarshal(data, &last); err != nil {
+			return err
+		}
+		times = append(times, last.Time)
+		bytes = append(bytes, float64(last.Bytes))
+		objects = append(objects, float64(last.Objects))
+		return nil
+	})
+	if err != nil || len(times) == 0 {
+		return nil, err
+	}
+
+	forecast := &schema.BucketForecast{
+		ID:      id,
+		Name:    last.Name,
+		Samples: len(times),
+		Bytes:   last.Bytes,
+		Objects: last.Objects,
+		Quotas:  last.Quotas,
+	}
+	forecast.BytesPerDay, _ = linearTrend(times, bytes)
+	forecast.ObjectsPerDay, _ = linearTrend(times, objects)
+	forecast.MaxSizeAt = forecastLimit(last.Time, float64(last.Bytes), float64(last.Quotas.MaxSize), forecast.BytesPerDay)
+	forecast.MaxObjectsAt = forecastLimit(last.Time, float64(last.Objects), float64(last.Quotas.MaxObjects), forecast.ObjectsPerDay)
+
+	first := forecast.MaxSizeAt
+	if first == nil || (forecast.MaxObjectsAt != nil && forecast.MaxObjectsAt.Before(*first)) {
+		first = forecast.MaxObjectsAt
+	}
+	forecast.DaysToQuota = daysUntil(last.Time, first)
+
+	return forecast, nil
+}
+
+// GetBuckets forecasts when each bucket reaches its quota from the usage
+// trend over the window, 168h by default. Buckets closest to their quota
+// come first. With ?format=csv the result is a CSV file.
+func (f *Forecast) GetBuckets(w http.ResponseWriter, r *http.Request) {
+	if utils.History == nil {
+		utils.ResponseErrorStatus(w, errHistoryDisabled, http.StatusServiceUnavailable)
+		return
+	}
+
+	window, err := parseForecastWindow(r)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	series, err := utils.History.Series(historyBucketPrefix)
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot read bucket history: %w", err))
+		return
+	}
+
+	to := time.Now().UTC()
+	result := []schema.BucketForecast{}
+	for _, name := range series {
+		forecast, err := forecastBucket(strings.TrimPrefix(name, historyBucketPrefix), to.Add(-window), to)
+		if err != nil {
+			utils.ResponseError(w, fmt.Errorf("cannot forecast bucket usage: %w", err))
+			return
+		}
+		if forecast != nil {
+			result = append(result, *forecast)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i].DaysToQuota, result[j].DaysToQuota
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return *a < *b
+	})
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeBucketForecastCSV(w, result)
+		return
+	}
+
+	utils.ResponseSuccess(w, result)
+}
+
+func writeBucketForecastCSV(w http.ResponseWriter, forecasts []schema.BucketForecast) {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	formatFloat := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="bucket-forecast.csv"`)
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "name", "bytes", "objects", "max_size", "max_objects", "bytes_per_day", "objects_per_day", "max_size_at", "max_objects_at", "days_to_quota"})
+	for _, f := range forecasts {
+		days := ""
+		if f.DaysToQuota != nil {
+			days = formatFloat(*f.DaysToQuota)
+		}
+		writer.Write([]string{
+			f.ID,
+			f.Name,
+			strconv.FormatInt(f.Bytes, 10),
+			strconv.FormatInt(f.Objects, 10),
+			strconv.FormatInt(f.Quotas.MaxSize, 10),
+			strconv.FormatInt(f.Quotas.MaxObjects, 10),
+			formatFloat(f.BytesPerDay),
+			formatFloat(f.ObjectsPerDay),
+			formatTime(f.MaxSizeAt),
+			formatTime(f.MaxObjectsAt),
+			days,
+		})
+	}
+	writer.Flush()
+}
+
+func (f *Forecast) GetBucket(w http.ResponseWriter, r *http.Request) {
+	if utils.History == nil {
+		utils.ResponseErrorStatus(w, errHistoryDisabled, http.StatusServiceUnavailable)
+		return
+	}
+
+	window, err := parseForecastWindow(r)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	forecast, err := forecastBucket(r.PathValue("id"), to.Add(-window), to)
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot forecast bucket usage: %w", err))
+		return
+	}
+	if forecast == nil {
+		utils.ResponseErrorStatus(w, fmt.Errorf("no usage history for bucket %s", r.PathValue("id")), http.StatusNotFound)
+		return
+	}
+
+	utils.ResponseSuccess(w, forecast)
+}
+
+// GetCluster forecasts when the data partitions of the cluster are full from
+// the trend of their used space over the window.
+func (f *Forecast) GetCluster(w http.ResponseWriter, r *http.Request) {
+	if utils.History == nil {
+		utils.ResponseErrorStatus(w, errHistoryDisabled, http.StatusServiceUnavailable)
+		return
+	}
+
+	window, err := parseForecastWindow(r)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var times []time.Time
+	var used []float64
+	var last schema.ClusterSample
+
+	to := time.Now().UTC()
+	err = readHistory(historyClusterSeries, to.Add(-window), to, 0, func(data []byte) error {
+		var sample schema.ClusterSample
+		if err := json.Unmarshal(data, &sample); err != nil {
+			return err
+		}
+		// Samples without partition info, e.g. all nodes down, would
+		// distort the trend
+		if sample.DataTotal == 0 {
+			return nil
+		}
+		last = sample
+		times = append(times, sample.Time)
+		used = append(used, float64(sample.DataUsed))
+		return nil
+	})
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot read cluster history: %w", err))
+		return
+	}
+
+	forecast := schema.ClusterForecast{
+		Samples:       len(times),
+		DataTotal:     last.DataTotal,
+		DataUsed:      last.DataUsed,
+		DataAvailable: last.DataAvailable,
+	}
+	if len(times) > 0 {
+		forecast.BytesPerDay, _ = linearTrend(times, used)
+		forecast.FullAt = forecastLimit(last.Time, float64(last.DataUsed), float64(last.DataTotal), forecast.BytesPerDay)
+		forecast.DaysLeft = daysUntil(last.Time, forecast.FullAt)
+	}
+
+	utils.ResponseSuccess(w, forecast)
+}
@@ -0,0 +1,328 @@
+package router
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinearTrend(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	days := func(offsets ...float64) []time.Time {
+		times := make([]time.Time, len(offsets))
+		for i, offset := range offsets {
+			times[i] = start.Add(time.Duration(offset * float64(24*time.Hour)))
+		}
+		return times
+	}
+
+	tests := []struct {
+		name   string
+		times  []time.Time
+		values []float64
+		want   float64
+		wantOK bool
+	}{
+		{"no samples", nil, nil, 0, false},
+		{"single sample", days(0), []float64{10}, 0, false},
+		{"same time", days(1, 1), []float64{10, 20}, 0, false},
+		{"constant", days(0, 1, 2), []float64{10, 10, 10}, 0, true},
+		{"growing", days(0, 1, 2, 3), []float64{100, 110, 120, 130}, 10, true},
+		{"shrinking", days(0, 2), []float64{100, 60}, -20, true},
+		{"hourly samples", days(0, 0.25, 0.5, 0.75, 1), []float64{0, 25, 50, 75, 100}, 100, true},
+		{"noisy", days(0, 1, 2, 3), []float64{0, 12, 18, 30}, 9.6, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := linearTrend(tt.times, tt.values)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("slope = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForecastLimit(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(days float64) *time.Time {
+		t := from.Add(time.Duration(days * float64(24*time.Hour)))
+		return &t
+	}
+
+	tests := []struct {
+		name    string
+		current float64
+		limit   float64
+		perDay  float64
+		want    *time.Time
+	}{
+		{"no limit", 100, 0, 10, nil},
+		{"reached", 100, 100, 0, at(0)},
+		{"exceeded", 150, 100, -10, at(0)},
+		{"no growth", 50, 100, 0, nil},
+		{"shrinking", 50, 100, -5, nil},
+		{"growing", 50, 100, 10, at(5)},
+		{"fraction of a day", 99, 100, 4, at(0.25)},
+		{"beyond a century", 0, 1e12, 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := forecastLimit(from, tt.current, tt.limit, tt.perDay)
+			if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(*tt.want)) {
+				t.Errorf("forecast = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func appendHistory(t *testing.T, series string, at time.Time, sample interface{}) {
+	t.Helper()
+	if err := utils.History.Append(series, at, sample); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForecastBuckets(t *testing.T) {
+	newHistoryStore(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	// b1 grows 100 bytes and b2 10 objects per day over the last three
+	// days, b3 does not grow and b4 only has samples outside the window
+	for day := 3; day >= 0; day-- {
+		at := now.Add(-time.Duration(day) * 24 * time.Hour)
+		grown := int64(3 - day)
+		appendHistory(t, historyBucketPrefix+"b1", at, schema.BucketSample{Time: at, Name: "photos", Bytes: 1000 + 100*grown, Objects: 5, Quotas: schema.Quotas{MaxSize: 2000}})
+		appendHistory(t, historyBucketPrefix+"b2", at, schema.BucketSample{Time: at, Name: "logs", Bytes: 10, Objects: 100 + 10*grown, Quotas: schema.Quotas{MaxSize: 1e9, MaxObjects: 180}})
+		appendHistory(t, historyBucketPrefix+"b3", at, schema.BucketSample{Time: at, Name: "archive", Bytes: 500, Objects: 1, Quotas: schema.Quotas{MaxSize: 600}})
+	}
+	old := now.Add(-30 * 24 * time.Hour)
+	appendHistory(t, historyBucketPrefix+"b4", old, schema.BucketSample{Time: old, Name: "stale", Bytes: 1})
+
+	type want struct {
+		id          string
+		samples     int
+		bytesPerDay float64
+		objsPerDay  float64
+		daysToQuota float64
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []want
+	}{
+		{
+			name:  "default window",
+			query: "",
+			want: []want{
+				{"b2", 4, 0, 10, 5},
+				{"b1", 4, 100, 0, 7},
+				{"b3", 4, 0, 0, -1},
+			},
+		},
+		{
+			name:  "window of one day",
+			query: "?window=25h",
+			want: []want{
+				{"b2", 2, 0, 10, 5},
+				{"b1", 2, 100, 0, 7},
+				{"b3", 2, 0, 0, -1},
+			},
+		},
+		{
+			name:  "window covering the stale bucket",
+			query: "?window=744h",
+			want: []want{
+				{"b2", 4, 0, 10, 5},
+				{"b1", 4, 100, 0, 7},
+				{"b3", 4, 0, 0, -1},
+				{"b4", 1, 0, 0, -1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			(&Forecast{}).GetBuckets(w, httptest.NewRequest(http.MethodGet, "/stats/forecast/buckets"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+
+			var got []schema.BucketForecast
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d forecasts, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				f := got[i]
+				if f.ID != want.id || f.Samples != want.samples {
+					t.Errorf("forecast %d = %s with %d samples, want %s with %d", i, f.ID, f.Samples, want.id, want.samples)
+					continue
+				}
+				if math.Abs(f.BytesPerDay-want.bytesPerDay) > 1e-6 || math.Abs(f.ObjectsPerDay-want.objsPerDay) > 1e-6 {
+					t.Errorf("%s grows %v bytes and %v objects per day, want %v and %v", f.ID, f.BytesPerDay, f.ObjectsPerDay, want.bytesPerDay, want.objsPerDay)
+				}
+				if want.daysToQuota < 0 {
+					if f.DaysToQuota != nil {
+						t.Errorf("%s reaches its quota in %v days, want never", f.ID, *f.DaysToQuota)
+					}
+				} else if f.DaysToQuota == nil || math.Abs(*f.DaysToQuota-want.daysToQuota) > 1e-6 {
+					t.Errorf("%s reaches its quota in %v days, want %v", f.ID, f.DaysToQuota, want.daysToQuota)
+				}
+			}
+		})
+	}
+}
+
+func TestForecastBucketsCSV(t *testing.T) {
+	newHistoryStore(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	for day := 1; day >= 0; day-- {
+		at := now.Add(-time.Duration(day) * 24 * time.Hour)
+		appendHistory(t, historyBucketPrefix+"b1", at, schema.BucketSample{Time: at, Name: "photos, raw", Bytes: 1000 - 500*int64(day), Quotas: schema.Quotas{MaxSize: 2000}})
+	}
+
+	w := httptest.NewRecorder()
+	(&Forecast{}).GetBuckets(w, httptest.NewRequest(http.MethodGet, "/stats/forecast/buckets?format=csv", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("status = %d, content type = %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	records, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"id", "name", "bytes", "objects", "max_size", "max_objects", "bytes_per_day", "objects_per_day", "max_size_at", "max_objects_at", "days_to_quota"},
+		{"b1", "photos, raw", "1000", "0", "2000", "0", "500.00", "0.00", now.Add(48 * time.Hour).Format(time.RFC3339), "", "2.00"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("csv = %v, want %v", records, want)
+	}
+}
+
+func TestForecastBucketWindow(t *testing.T) {
+	newHistoryStore(t)
+	now := time.Now().UTC()
+	appendHistory(t, historyBucketPrefix+"b1", now.Add(-time.Hour), schema.BucketSample{Time: now.Add(-time.Hour), Bytes: 10})
+
+	tests := []struct {
+		name       string
+		id         string
+		query      string
+		wantStatus int
+	}{
+		{"found", "b1", "", http.StatusOK},
+		{"unknown bucket", "b2", "", http.StatusNotFound},
+		{"outside window", "b1", "?window=30m", http.StatusNotFound},
+		{"invalid window", "b1", "?window=week", http.StatusBadRequest},
+		{"negative window", "b1", "?window=-1h", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/stats/forecast/buckets/"+tt.id+tt.query, nil)
+			r.SetPathValue("id", tt.id)
+			w := httptest.NewRecorder()
+			(&Forecast{}).GetBucket(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestForecastCluster(t *testing.T) {
+	tests := []struct {
+		name         string
+		samples      []schema.ClusterSample
+		wantSamples  int
+		wantPerDay   float64
+		wantDaysLeft float64
+	}{
+		{
+			name:         "no history",
+			wantDaysLeft: -1,
+		},
+		{
+			name: "growing",
+			samples: []schema.ClusterSample{
+				{DataTotal: 1000, DataUsed: 400},
+				{DataTotal: 1000, DataUsed: 500},
+				{DataTotal: 1000, DataUsed: 600},
+			},
+			wantSamples:  3,
+			wantPerDay:   100,
+			wantDaysLeft: 4,
+		},
+		{
+			name: "samples without partitions skipped",
+			samples: []schema.ClusterSample{
+				{DataTotal: 1000, DataUsed: 400},
+				{},
+				{DataTotal: 1000, DataUsed: 600},
+			},
+			wantSamples:  2,
+			wantPerDay:   100,
+			wantDaysLeft: 4,
+		},
+		{
+			name: "shrinking",
+			samples: []schema.ClusterSample{
+				{DataTotal: 1000, DataUsed: 600},
+				{DataTotal: 1000, DataUsed: 500},
+			},
+			wantSamples:  2,
+			wantPerDay:   -100,
+			wantDaysLeft: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newHistoryStore(t)
+			now := time.Now().UTC().Truncate(time.Second)
+			for i, sample := range tt.samples {
+				sample.Time = now.Add(-time.Duration(len(tt.samples)-1-i) * 24 * time.Hour)
+				appendHistory(t, historyClusterSeries, sample.Time, sample)
+			}
+
+			w := httptest.NewRecorder()
+			(&Forecast{}).GetCluster(w, httptest.NewRequest(http.MethodGet, "/stats/forecast/cluster", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+
+			var got schema.ClusterForecast
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Samples != tt.wantSamples || math.Abs(got.BytesPerDay-tt.wantPerDay) > 1e-6 {
+				t.Errorf("forecast = %d samples, %v bytes per day, want %d and %v", got.Samples, got.BytesPerDay, tt.wantSamples, tt.wantPerDay)
+			}
+			if tt.wantDaysLeft < 0 {
+				if got.DaysLeft != nil {
+					t.Errorf("full in %v days, want never", *got.DaysLeft)
+				}
+			} else if got.DaysLeft == nil || math.Abs(*got.DaysLeft-tt.wantDaysLeft) > 1e-6 {
+				t.Errorf("full in %v days, want %v", got.DaysLeft, tt.wantDaysLeft)
+			}
+		})
+	}
+}
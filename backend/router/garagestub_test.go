@@ -0,0 +1,106 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// garageStub is a stand-in for the admin API of Garage. Endpoints without
+// a handler respond with 404.
+type garageStub struct {
+	mu       sync.Mutex
+	handlers map[string]func(r *http.Request, body map[string]interface{}) (int, interface{})
+	calls    []garageCall
+}
+
+type garageCall struct {
+	Endpoint  string
+	Query     string
+	Body      map[string]interface{}
+	RequestID string
+}
+
+// newGarageStub starts the stub and points the admin API client to it.
+func newGarageStub(t *testing.T) *garageStub {
+	stub := &garageStub{handlers: map[string]func(*http.Request, map[string]interface{}) (int, interface{}){}}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	t.Setenv("API_BASE_URL", server.URL)
+	t.Setenv("API_ADMIN_KEY", "admin-token")
+	return stub
+}
+
+func (s *garageStub) handle(endpoint string, handler func(r *http.Request, body map[string]interface{}) (int, interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[endpoint] = handler
+}
+
+// respond registers a handler always returning the same response.
+func (s *garageStub) respond(endpoint string, status int, response interface{}) {
+	s.handle(endpoint, func(*http.Request, map[string]interface{}) (int, interface{}) {
+		return status, response
+	})
+}
+
+// called returns the calls made to the endpoint.
+func (s *garageStub) called(endpoint string) []garageCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls []garageCall
+	for _, call := range s.calls {
+		if call.Endpoint == endpoint {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (s *garageStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+		json.Unmarshal(data, &body)
+	}
+	endpoint := strings.TrimPrefix(r.URL.Path, "/v2/")
+
+	s.mu.Lock()
+	s.calls = append(s.calls, garageCall{Endpoint: endpoint, Query: r.URL.RawQuery, Body: body, RequestID: r.Header.Get(utils.RequestIDHeader)})
+	handler := s.handlers[endpoint]
+	s.mu.Unlock()
+
+	status, response := http.StatusNotFound, interface{}(map[string]string{"message": "no such endpoint: " + endpoint})
+	if handler != nil {
+		status, response = handler(r, body)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// all returns every call made to the stub.
+func (s *garageStub) all() []garageCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]garageCall(nil), s.calls...)
+}
+
+// checkRequestID fails the test unless every call carried the request ID.
+func (s *garageStub) checkRequestID(t *testing.T, id string) {
+	t.Helper()
+	calls := s.all()
+	if len(calls) == 0 {
+		t.Fatal("no admin API calls")
+	}
+	for _, call := range calls {
+		if call.RequestID != id {
+			t.Errorf("call to %s has request ID %q, want %q", call.Endpoint, call.RequestID, id)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	historyClusterSeries = "cluster"
+	historyNodesSeries   = "nodes"
+	historyBucketPrefix  = "bucket/"
+
+	defaultHistoryRange = 24 * time.Hour
+)
+
+var errHistoryDisabled = errors.New("metrics history is not available")
+
+type History struct{}
+
+type historyCollector struct {
+	interval  time.Duration
+	retention time.Duration
+}
+
+var clusterHistory = &historyCollector{}
+
+// InitHistory samples the cluster health and capacity, the nodes and the
+// bucket usage every HISTORY_INTERVAL into the history store, keeping the
+// samples for HISTORY_RETENTION.
+func InitHistory() {
+	if utils.History == nil {
+		return
+	}
+
+	value := utils.GetEnv("HISTORY_INTERVAL", "5m")
+	if value == "0" {
+		return
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 10*time.Second {
+		slog.Warn("Invalid HISTORY_INTERVAL, metrics history disabled.", "value", value)
+		return
+	}
+
+	retention, err := time.ParseDuration(utils.GetEnv("HISTORY_RETENTION", "2160h"))
+	if err != nil || retention <= 0 {
+		slog.Warn("Invalid HISTORY_RETENTION, using 2160h.", "value", os.Getenv("HISTORY_RETENTION"))
+		retention = 2160 * time.Hour
+	}
+
+	clusterHistory.interval = interval
+	clusterHistory.retention = retention
+	go clusterHistory.schedule()
+}
+
+func (c *historyCollector) schedule() {
+	for {
+		c.collect()
+		time.Sleep(c.interval)
+	}
+}
+
+// collect records one sample of each series and prunes the expired ones.
+// Buckets whose usage cannot be read get no sample, and neither does the
+// cluster, as its totals would be too low.
+func (c *historyCollector) collect() {
+	ctx, span := utils.StartTask(context.Background(), "history collection")
+	defer span.End()
+	now := time.Now().UTC()
+
+	cluster, nodes, err := sampleCluster(ctx, now)
+	if err != nil {
+		slog.Error("Cannot sample cluster status.", "error", err)
+		return
+	}
+
+	buckets, failed, err := readBucketInfos(ctx)
+	if err != nil {
+		slog.Error("Cannot sample bucket usage.", "error", err)
+	} else if len(failed) > 0 {
+		slog.Error("Cannot sample usage of some buckets.", "failed", len(failed))
+	}
+	cluster.Buckets = len(buckets)
+
+	for _, bucket := range buckets {
+		cluster.Objects += bucket.Objects
+		cluster.Bytes += bucket.Bytes
+
+		sample := schema.BucketSample{
+			Time:                           now,
+			Objects:                        bucket.Objects,
+			Bytes:                          bucket.Bytes,
+			UnfinishedUploads:              bucket.UnfinishedUploads,
+			UnfinishedMultipartUploads:     bucket.UnfinishedMultipartUploads,
+			UnfinishedMultipartUploadBytes: bucket.UnfinishedMultipartUploadBytes,
+			Quotas:                         bucket.Quotas,
+		}
+		if len(bucket.GlobalAliases) > 0 {
+			sample.Name = bucket.GlobalAliases[0]
+		}
+		if err := utils.History.Append(historyBucketPrefix+bucket.ID, now, sample); err != nil {
+			slog.Error("Cannot save bucket usage sample.", "error", err)
+		}
+	}
+
+	if err == nil && len(failed) == 0 {
+		if err := utils.History.Append(historyClusterSeries, now, cluster); err != nil {
+			slog.Error("Cannot save cluster sample.", "error", err)
+		}
+	}
+	if err := utils.History.Append(historyNodesSeries, now, nodes); err != nil {
+		slog.Error("Cannot save nodes sample.", "error", err)
+	}
+
+	if err := utils.History.Prune(now.Add(-c.retention)); err != nil {
+		slog.Error("Cannot prune metrics history.", "error", err)
+	}
+}
+
+// sampleCluster reads the cluster health and the status of the storage nodes.
+// GetClusterStatistics and GetNodeStatistics are not sampled, as they only
+// return free-form text; the partition usage is read from the cluster status.
+func sampleCluster(ctx context.Context, now time.Time) (schema.ClusterSample, schema.NodesSample, error) {
+	cluster := schema.ClusterSample{Time: now}
+	nodes := schema.NodesSample{Time: now, Nodes: []schema.NodeSample{}}
+
+	body, err := utils.Garage.Fetch("/v2/GetClusterHealth", &utils.FetchOptions{Context: ctx})
+	if err != nil {
+		return cluster, nodes, fmt.Errorf("cannot get cluster health: %w", err)
+	}
+	if err := json.Unmarshal(body, &cluster); err != nil {
+		return cluster, nodes, err
+	}
+	cluster.Time = now
+
+	body, err = utils.Garage.Fetch("/v2/GetClusterStatus", &utils.FetchOptions{Context: ctx})
+	if err != nil {
+		return cluster, nodes, fmt.Errorf("cannot get cluster status: %w", err)
+	}
+	var status ClusterStatusResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		return cluster, nodes, err
+	}
+
+	roles := map[string]bool{}
+	if status.Layout != nil {
+		for _, role := range status.Layout.Roles {
+			roles[role.ID] = true
+		}
+	}
+
+	known := status.KnownNodes
+	if len(known) == 0 {
+		known = status.Nodes
+	}
+
+	for _, node := range known {
+		if !roles[node.ID] {
+			continue
+		}
+
+		sample := schema.NodeSample{ID: node.ID, Hostname: node.Hostname, IsUp: node.IsUp, Draining: node.Draining}
+		if node.DataPartition != nil {
+			sample.DataTotal = node.DataPartition.Total
+			sample.DataAvailable = node.DataPartition.Available
+		}
+		if node.MetadataPartition != nil {
+			sample.MetadataTotal = node.MetadataPartition.Total
+			sample.MetadataAvailable = node.MetadataPartition.Available
+		}
+		nodes.Nodes = append(nodes.Nodes, sample)
+
+		if !node.IsUp {
+			continue
+		}
+		cluster.DataTotal += sample.DataTotal
+		cluster.DataAvailable += sample.DataAvailable
+		cluster.MetadataTotal += sample.MetadataTotal
+		cluster.MetadataAvailable += sample.MetadataAvailable
+	}
+	cluster.DataUsed = cluster.DataTotal - cluster.DataAvailable
+
+	return cluster, nodes, nil
+}
+
+// parseHistoryRange reads the from and to times (RFC 3339) and the minimum
+// step between samples of the query, defaulting to the last day.
+func parseHistoryRange(r *http.Request) (time.Time, time.Time, time.Duration, error) {
+	query := r.URL.Query()
+	to := time.Now().UTC()
+	var step time.Duration
+
+	if value := query.Get("to"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid to: %w", err)
+		}
+		to = t
+	}
+
+	from := to.Add(-defaultHistoryRange)
+	if value := query.Get("from"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid from: %w", err)
+		}
+		from = t
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, 0, errors.New("from is after to")
+	}
+
+	if value := query.Get("step"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid step %q", value)
+		}
+		step = d
+	}
+
+	return from, to, step, nil
+}
+
+// readHistory decodes the samples of a series in the range into fn, skipping
+// samples closer than step to the previous one.
+func readHistory(series string, from time.Time, to time.Time, step time.Duration, fn func(data []byte) error) error {
+	var last time.Time
+	return utils.History.Range(series, from, to, func(at time.Time, data []byte) error {
+		if step > 0 && !last.IsZero() && at.Sub(last) < step {
+			return nil
+		}
+		last = at
+		return fn(data)
+	})
+}
+
+func readBucketHistory(id string, from time.Time, to time.Time, step time.Duration) (schema.BucketHistory, error) {
+	history := schema.BucketHistory{ID: id, Samples: []schema.BucketSample{}}
+
+	err := readHistory(historyBucketPrefix+id, from, to, step, func(data []byte) error {
+		var sample schema.BucketSample
+		if err := json.Unmarshal(data, &sample); err != nil {
+			return err
+		}
+		history.Samples = append(history.Samples, sample)
+		return nil
+	})
+	if err != nil || len(history.Samples) == 0 {
+		return history, err
+	}
+
+	first, last := history.Samples[0], history.Samples[len(history.Samples)-1]
+	history.Name = last.Name
+	history.BytesGrowth = last.Bytes - first.Bytes
+	history.ObjectsGrowth = last.Objects - first.Objects
+	return history, nil
+}
+
+// buildNodeTimelines turns the node samples into periods of the same state
+// per node. A period lasts until the sample where the state changed.
+func buildNodeTimelines(samples []schema.NodesSample) []schema.NodeTimeline {
+	timelines := []schema.NodeTimeline{}
+	index := map[string]int{}
+
+	for _, sample := range samples {
+		for _, node := range sample.Nodes {
+			i, ok := index[node.ID]
+			if !ok {
+				index[node.ID] = len(timelines)
+				timelines = append(timelines, schema.NodeTimeline{
+					ID:       node.ID,
+					Hostname: node.Hostname,
+					Periods:  []schema.NodePeriod{{IsUp: node.IsUp, From: sample.Time, To: sample.Time}},
+				})
+				continue
+			}
+
+			timeline := &timelines[i]
+			timeline.Hostname = node.Hostname
+			current := &timeline.Periods[len(timeline.Periods)-1]
+			current.To = sample.Time
+			if current.IsUp != node.IsUp {
+				timeline.Periods = append(timeline.Periods, schema.NodePeriod{IsUp: node.IsUp, From: sample.Time, To: sample.Time})
+			}
+		}
+	}
+
+	for i := range timelines {
+		var up, total time.Duration
+		for _, period := range timelines[i].Periods {
+			total += period.To.Sub(period.From)
+			if period.IsUp {
+				up += period.To.Sub(period.From)
+			}
+		}
+
+		last := timelines[i].Periods[len(timelines[i].Periods)-1]
+		switch {
+		case total > 0:
+			timelines[i].Uptime = float64(up) / float64(total)
+		case last.IsUp:
+			timelines[i].Uptime = 1
+		}
+	}
+
+	return timelines
+}
+
+func (h *History) GetCluster(w http.ResponseWriter, r *http.Request) {
+	if utils.History == nil {
+		utils.ResponseErrorStatus(w, errHistoryDisabled, http.StatusServiceUnavailable)
+		return
+	}
+
+	from, to, step, err := parseHistoryRange(r)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	samples := []schema.ClusterSample{}
+	err = readHistory(historyClusterSeries, from, to, step, func(data []byte) error {
+		var sample schema.ClusterSample
+		if err := json.Unmarshal(data, &sample); err != nil {
+			return err
+		}
+		samples = append(samples, sample)
+		return nil
+	})
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot read cluster history: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, samples)
+}
+
+// GetBuckets returns the usage history of all buckets, sorted by growth.
+func (h *History) GetBuckets(w http.ResponseWriter, r *http.Request) {
+	if utils.History == nil {
+		utils.ResponseErrorStatus(w, errHistoryDisabled, http.StatusServiceUnavailable)
+		return
+	}
+
+	from, to, step, err := parseHistoryRange(r)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	series, err := utils.History.Series(historyBucketPrefix)
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot read bucket history: %w", err))
+		return
+	}
+
+	result := []schema.BucketHistory{}
+	for _, name := range series {
+		history, err := readBucketHistory(strings.TrimPrefix(name, historyBucketPrefix), from, to, step)
+		if err != nil {
+			utils.ResponseError(w, fmt.Errorf("cannot read bucket history: %w", err))
+			return
+		}
+		if len(history.Samples) > 0 {
+			result = append(result, history)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].BytesGrowth > result[j].BytesGrowth })
+
+	utils.ResponseSuccess(w, result)
+}
+
+func (h *History) GetBucket(w http.ResponseWriter, r *http.Request) {
+	if utils.History == nil {
+		utils.ResponseErrorStatus(w, errHistoryDisabled, http.StatusServiceUnavailable)
+		return
+	}
+
+	from, to, step, err := parseHistoryRange(r)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	history, err := readBucketHistory(r.PathValue("id"), from, to, step)
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot read bucket history: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, history)
+}
+
+func (h *History) GetNodes(w http.ResponseWriter, r *http.Request) {
+	if utils.History == nil {
+		utils.ResponseErrorStatus(w, errHistoryDisabled, http.StatusServiceUnavailable)
+		return
+	}
+
+	from, to, _, err := parseHistoryRange(r)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var samples []schema.NodesSample
+	err = readHistory(historyNodesSeries, from, to, 0, func(data []byte) error {
+		var sample schema.NodesSample
+		if err := json.Unmarshal(data, &sample); err != nil {
+			return err
+		}
+		samples = append(samples, sample)
+		return nil
+	})
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot read node history: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, buildNodeTimelines(samples))
+}
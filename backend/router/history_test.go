@@ -0,0 +1,97 @@
+package router
+
+import (
+	"encoding/json"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newHistoryStore opens an empty history database for the test.
+func newHistoryStore(t *testing.T) {
+	t.Setenv("HISTORY_DB", filepath.Join(t.TempDir(), "history.db"))
+	utils.InitHistoryStore()
+	if utils.History == nil {
+		t.Fatal("cannot open history database")
+	}
+	t.Cleanup(func() { utils.History = nil })
+}
+
+func TestHistoryCollectSkipsFailedBuckets(t *testing.T) {
+	tests := []struct {
+		name        string
+		listFails   bool
+		failed      map[string]bool
+		wantBuckets []string
+		wantCluster *schema.ClusterSample
+	}{
+		{
+			name:        "all buckets",
+			wantBuckets: []string{"bucket/b1", "bucket/b2"},
+			wantCluster: &schema.ClusterSample{Buckets: 2, Objects: 30, Bytes: 3000},
+		},
+		{
+			name:        "bucket info fails",
+			failed:      map[string]bool{"b2": true},
+			wantBuckets: []string{"bucket/b1"},
+		},
+		{
+			name:      "bucket list fails",
+			listFails: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newHistoryStore(t)
+			garage := newGarageStub(t)
+			garage.respond("GetClusterHealth", http.StatusOK, map[string]string{"status": "healthy"})
+			garage.respond("GetClusterStatus", http.StatusOK, map[string]interface{}{"nodes": []interface{}{}})
+			if tt.listFails {
+				garage.respond("ListBuckets", http.StatusInternalServerError, map[string]string{"message": "unavailable"})
+			} else {
+				garage.respond("ListBuckets", http.StatusOK, []schema.GetBucketsRes{{ID: "b1"}, {ID: "b2"}})
+			}
+			garage.handle("GetBucketInfo", func(r *http.Request, _ map[string]interface{}) (int, interface{}) {
+				id := r.URL.Query().Get("id")
+				if tt.failed[id] {
+					return http.StatusInternalServerError, map[string]string{"message": "timeout"}
+				}
+				n := map[string]int64{"b1": 1, "b2": 2}[id]
+				return http.StatusOK, schema.Bucket{ID: id, Objects: 10 * n, Bytes: 1000 * n}
+			})
+
+			(&historyCollector{retention: time.Hour}).collect()
+
+			series, err := utils.History.Series(historyBucketPrefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(series, tt.wantBuckets) {
+				t.Errorf("bucket series = %v, want %v", series, tt.wantBuckets)
+			}
+
+			_, data, err := utils.History.Last(historyClusterSeries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantCluster == nil {
+				if data != nil {
+					t.Errorf("cluster sampled with incomplete bucket usage: %s", data)
+				}
+				return
+			}
+			var cluster schema.ClusterSample
+			if err := json.Unmarshal(data, &cluster); err != nil {
+				t.Fatal(err)
+			}
+			if cluster.Buckets != tt.wantCluster.Buckets || cluster.Objects != tt.wantCluster.Objects || cluster.Bytes != tt.wantCluster.Bytes {
+				t.Errorf("cluster = %d buckets, %d objects, %d bytes, want %+v", cluster.Buckets, cluster.Objects, cluster.Bytes, tt.wantCluster)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package router
+
+import (
+	"errors"
+	"khairul169/garage-webui/utils"
+	"net/http"
+)
+
+type Jobs struct{}
+
+func (j *Jobs) GetAll(w http.ResponseWriter, r *http.Request) {
+	utils.ResponseSuccess(w, utils.Jobs.List(r.URL.Query().Get("type")))
+}
+
+func (j *Jobs) GetOne(w http.ResponseWriter, r *http.Request) {
+	job := utils.Jobs.Get(r.PathValue("id"))
+	if job == nil {
+		utils.ResponseErrorStatus(w, errors.New("job not found"), http.StatusNotFound)
+		return
+	}
+
+	utils.ResponseSuccess(w, job.Snapshot())
+}
+
+func (j *Jobs) Cancel(w http.ResponseWriter, r *http.Request) {
+	if !utils.Jobs.Cancel(r.PathValue("id")) {
+		utils.ResponseErrorStatus(w, errors.New("job not found"), http.StatusNotFound)
+		return
+	}
+
+	utils.ResponseSuccess(w, map[string]bool{"ok": true})
+}
@@ -1,9 +1,9 @@
 package router
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
+	"khairul169/garage-webui/schema"
 	"khairul169/garage-webui/utils"
 	"net/http"
 	"strconv"
@@ -16,46 +16,111 @@ import (
 
 type Lifecycle struct{}
 
-type LifecycleRule struct {
-	ID                             string `json:"id"`
-	Enabled                        bool   `json:"enabled"`
-	Prefix                         string `json:"prefix"`
-	ExpirationDays                 *int32 `json:"expirationDays,omitempty"`
-	ExpirationDate                 string `json:"expirationDate,omitempty"`
-	AbortIncompleteMultipartDays   *int32 `json:"abortIncompleteMultipartDays,omitempty"`
+func (l *Lifecycle) GetLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	client, bucketName, err := getS3Client(r, bucket)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	result, err := client.GetBucketLifecycleConfiguration(r.Context(), &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucketName),
+	})
+
+	if err != nil {
+		// NoSuchLifecycleConfiguration means no rules set — return empty
+		var apiErr smithy.APIError
+		if ok := isAPIError(err, &apiErr); ok && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
+			utils.ResponseSuccess(w, schema.LifecycleConfig{Rules: []schema.LifecycleRule{}})
+			return
+		}
+		utils.ResponseError(w, fmt.Errorf("cannot get lifecycle: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, schema.LifecycleConfig{Rules: fromS3LifecycleRules(result.Rules)})
 }
 
-type LifecycleConfig struct {
-	Rules []LifecycleRule `json:"rules"`
+func (l *Lifecycle) PutLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	var body schema.LifecycleConfig
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.ResponseError(w, err)
+		return
+	}
+
+	client, bucketName, err := getWritableS3Client(r, bucket)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	// If no rules, delete the lifecycle configuration
+	if len(body.Rules) == 0 {
+		_, err := client.DeleteBucketLifecycle(r.Context(), &s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			var apiErr smithy.APIError
+			if ok := isAPIError(err, &apiErr); ok && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
+				utils.ResponseSuccess(w, map[string]bool{"ok": true})
+				return
+			}
+			utils.ResponseError(w, fmt.Errorf("cannot delete lifecycle: %w", err))
+			return
+		}
+		utils.ResponseSuccess(w, map[string]bool{"ok": true})
+		return
+	}
+
+	_, err = client.PutBucketLifecycleConfiguration(r.Context(), &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucketName),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{
+			Rules: toS3LifecycleRules(body.Rules),
+		},
+	})
+
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot set lifecycle: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, map[string]bool{"ok": true})
 }
 
-func (l *Lifecycle) GetLifecycle(w http.ResponseWriter, r *http.Request) {
+func (l *Lifecycle) DeleteLifecycle(w http.ResponseWriter, r *http.Request) {
 	bucket := r.PathValue("bucket")
 
-	client, err := getS3Client(bucket)
+	client, bucketName, err := getWritableS3Client(r, bucket)
 	if err != nil {
-		utils.ResponseError(w, fmt.Errorf("cannot get S3 client: %w", err))
+		responseS3ClientError(w, err)
 		return
 	}
 
-	result, err := client.GetBucketLifecycleConfiguration(context.Background(), &s3.GetBucketLifecycleConfigurationInput{
-		Bucket: aws.String(bucket),
+	_, err = client.DeleteBucketLifecycle(r.Context(), &s3.DeleteBucketLifecycleInput{
+		Bucket: aws.String(bucketName),
 	})
 
 	if err != nil {
-		// NoSuchLifecycleConfiguration means no rules set — return empty
 		var apiErr smithy.APIError
 		if ok := isAPIError(err, &apiErr); ok && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
-			utils.ResponseSuccess(w, LifecycleConfig{Rules: []LifecycleRule{}})
+			utils.ResponseSuccess(w, map[string]bool{"ok": true})
 			return
 		}
-		utils.ResponseError(w, fmt.Errorf("cannot get lifecycle: %w", err))
+		utils.ResponseError(w, fmt.Errorf("cannot delete lifecycle: %w", err))
 		return
 	}
 
-	rules := make([]LifecycleRule, 0, len(result.Rules))
-	for _, rule := range result.Rules {
-		lr := LifecycleRule{
+	utils.ResponseSuccess(w, map[string]bool{"ok": true})
+}
+
+func fromS3LifecycleRules(s3Rules []types.LifecycleRule) []schema.LifecycleRule {
+	rules := make([]schema.LifecycleRule, 0, len(s3Rules))
+	for _, rule := range s3Rules {
+		lr := schema.LifecycleRule{
 			ID:      aws.ToString(rule.ID),
 			Enabled: rule.Status == types.ExpirationStatusEnabled,
 		}
@@ -85,44 +150,12 @@ func (l *Lifecycle) GetLifecycle(w http.ResponseWriter, r *http.Request) {
 		rules = append(rules, lr)
 	}
 
-	utils.ResponseSuccess(w, LifecycleConfig{Rules: rules})
+	return rules
 }
 
-func (l *Lifecycle) PutLifecycle(w http.ResponseWriter, r *http.Request) {
-	bucket := r.PathValue("bucket")
-
-	var body LifecycleConfig
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		utils.ResponseError(w, err)
-		return
-	}
-
-	client, err := getS3Client(bucket)
-	if err != nil {
-		utils.ResponseError(w, fmt.Errorf("cannot get S3 client: %w", err))
-		return
-	}
-
-	// If no rules, delete the lifecycle configuration
-	if len(body.Rules) == 0 {
-		_, err := client.DeleteBucketLifecycle(context.Background(), &s3.DeleteBucketLifecycleInput{
-			Bucket: aws.String(bucket),
-		})
-		if err != nil {
-			var apiErr smithy.APIError
-			if ok := isAPIError(err, &apiErr); ok && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
-				utils.ResponseSuccess(w, map[string]bool{"ok": true})
-				return
-			}
-			utils.ResponseError(w, fmt.Errorf("cannot delete lifecycle: %w", err))
-			return
-		}
-		utils.ResponseSuccess(w, map[string]bool{"ok": true})
-		return
-	}
-
-	rules := make([]types.LifecycleRule, 0, len(body.Rules))
-	for i, rule := range body.Rules {
+func toS3LifecycleRules(lifecycleRules []schema.LifecycleRule) []types.LifecycleRule {
+	rules := make([]types.LifecycleRule, 0, len(lifecycleRules))
+	for i, rule := range lifecycleRules {
 		status := types.ExpirationStatusDisabled
 		if rule.Enabled {
 			status = types.ExpirationStatusEnabled
@@ -155,46 +188,7 @@ func (l *Lifecycle) PutLifecycle(w http.ResponseWriter, r *http.Request) {
 
 		rules = append(rules, lr)
 	}
-
-	_, err = client.PutBucketLifecycleConfiguration(context.Background(), &s3.PutBucketLifecycleConfigurationInput{
-		Bucket: aws.String(bucket),
-		LifecycleConfiguration: &types.BucketLifecycleConfiguration{
-			Rules: rules,
-		},
-	})
-
-	if err != nil {
-		utils.ResponseError(w, fmt.Errorf("cannot set lifecycle: %w", err))
-		return
-	}
-
-	utils.ResponseSuccess(w, map[string]bool{"ok": true})
-}
-
-func (l *Lifecycle) DeleteLifecycle(w http.ResponseWriter, r *http.Request) {
-	bucket := r.PathValue("bucket")
-
-	client, err := getS3Client(bucket)
-	if err != nil {
-		utils.ResponseError(w, fmt.Errorf("cannot get S3 client: %w", err))
-		return
-	}
-
-	_, err = client.DeleteBucketLifecycle(context.Background(), &s3.DeleteBucketLifecycleInput{
-		Bucket: aws.String(bucket),
-	})
-
-	if err != nil {
-		var apiErr smithy.APIError
-		if ok := isAPIError(err, &apiErr); ok && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
-			utils.ResponseSuccess(w, map[string]bool{"ok": true})
-			return
-		}
-		utils.ResponseError(w, fmt.Errorf("cannot delete lifecycle: %w", err))
-		return
-	}
-
-	utils.ResponseSuccess(w, map[string]bool{"ok": true})
+	return rules
 }
 
 func isAPIError(err error, target *smithy.APIError) bool {
@@ -0,0 +1,299 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+type Preview struct{}
+
+func (p *Preview) GetPreview(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	key := r.PathValue("key")
+	query := r.URL.Query()
+
+	client, bucketName, err := getS3Client(r, bucket)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	head, err := client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var ae smithy.APIError
+		if errors.As(err, &ae) && (ae.ErrorCode() == "NotFound" || ae.ErrorCode() == "NoSuchKey") {
+			utils.ResponseErrorStatus(w, err, http.StatusNotFound)
+			return
+		}
+
+		utils.ResponseError(w, err)
+		return
+	}
+
+	size := aws.ToInt64(head.ContentLength)
+	contentType := aws.ToString(head.ContentType)
+	if contentType == "" || contentType == "application/octet-stream" {
+		if ext := mime.TypeByExtension(path.Ext(key)); ext != "" {
+			contentType = ext
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	reader := newS3ObjectReader(r.Context(), client, bucketName, key, size)
+
+	if query.Get("render") == "1" {
+		if mediaType != "application/pdf" {
+			utils.ResponseErrorStatus(w, errors.New("only pdf files can be rendered"), http.StatusBadRequest)
+			return
+		}
+		p.renderPDF(w, reader, bucket, key, aws.ToString(head.ETag), query)
+		return
+	}
+
+	result := schema.ObjectPreview{
+		Type:        "binary",
+		ContentType: contentType,
+		Size:        size,
+	}
+
+	switch {
+	case mediaType == "application/pdf":
+		result.Type = "pdf"
+		result.PDF, err = utils.GetPDFMetadata(reader, size)
+		if result.PDF != nil {
+			result.PDF.PreviewUrl = pdfPreviewURL(bucket, key)
+		}
+
+	case strings.HasPrefix(mediaType, "image/"):
+		result.Type = "image"
+		result.Image, err = utils.GetImageMetadata(io.NewSectionReader(reader, 0, size))
+
+	case strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "video/"), mediaType == "application/ogg":
+		result.Type = strings.Split(mediaType, "/")[0]
+		if result.Type == "application" {
+			result.Type = "audio"
+		}
+		result.Media, err = utils.GetMediaMetadata(reader, size)
+
+	default:
+		result.Text, err = getTextPreview(reader, key, size, query.Get("kb"))
+		if result.Text != nil {
+			result.Type = "text"
+		}
+	}
+
+	// Unparseable files still return the basic object info
+	if err != nil && !errors.Is(err, utils.ErrUnknownMediaFormat) {
+		slog.WarnContext(r.Context(), "Cannot create preview.", "bucket", bucket, "key", key, "error", err)
+	}
+
+	utils.ResponseSuccess(w, result)
+}
+
+func (p *Preview) renderPDF(w http.ResponseWriter, reader *s3ObjectReader, bucket string, key string, etag string, query url.Values) {
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	width, err := strconv.Atoi(query.Get("width"))
+	if err != nil {
+		width = 800
+	}
+	width = min(max(width, 64), 2000)
+
+	cacheKey := utils.Thumbnail.CacheKey(bucket, fmt.Sprintf("%s#page=%d", key, page), etag, uint(width))
+	image := utils.Thumbnail.GetCached(cacheKey)
+
+	if image == nil {
+		image, err = utils.RenderPDFPage(reader, reader.size, page, uint(width))
+		if err != nil {
+			utils.ResponseError(w, err)
+			return
+		}
+		utils.Thumbnail.Store(cacheKey, image)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.Write(image)
+}
+
+func getTextPreview(reader io.ReaderAt, key string, size int64, kb string) (*schema.TextPreview, error) {
+	maxSize, err := strconv.ParseInt(utils.GetEnv("PREVIEW_TEXT_MAX_SIZE", "65536"), 10, 64)
+	if err != nil || maxSize <= 0 {
+		maxSize = 65536
+	}
+
+	limit := maxSize
+	if value, err := strconv.ParseInt(kb, 10, 64); err == nil && value > 0 {
+		limit = min(value*1024, maxSize)
+	}
+	limit = min(limit, size)
+
+	buffer := make([]byte, limit)
+	n, err := reader.ReadAt(buffer, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	buffer = buffer[:n]
+
+	// Only sniff files without a known text extension
+	if !utils.IsTextExtension(key) {
+		sniffed := http.DetectContentType(buffer)
+		if !strings.HasPrefix(sniffed, "text/") && !strings.Contains(sniffed, "json") {
+			return nil, nil
+		}
+	}
+
+	truncated := int64(n) < size
+	content, charset, ok := utils.DecodeText(buffer, truncated)
+	if !ok {
+		return nil, nil
+	}
+
+	return &schema.TextPreview{
+		Content:   content,
+		Charset:   charset,
+		Language:  utils.GetSyntaxHint(key),
+		Truncated: truncated,
+	}, nil
+}
+
+const s3ReaderBlockSize = 64 << 10
+const s3ReaderMaxBlocks = 512
+
+// s3ObjectReader implements io.ReaderAt on top of ranged GetObject requests,
+// so parsers can read headers of large objects without downloading them.
+type s3ObjectReader struct {
+	ctx    context.Context
+	client *s3.Client
+	bucket string
+	key    string
+	size   int64
+
+	mu     sync.Mutex
+	blocks map[int64][]byte
+}
+
+func newS3ObjectReader(ctx context.Context, client *s3.Client, bucket string, key string, size int64) *s3ObjectReader {
+	return &s3ObjectReader{
+		ctx:    ctx,
+		client: client,
+		bucket: bucket,
+		key:    key,
+		size:   size,
+		blocks: map[int64][]byte{},
+	}
+}
+
+func (o *s3ObjectReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= o.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), o.size)
+	if err := o.fetch(off/s3ReaderBlockSize, (end-1)/s3ReaderBlockSize); err != nil {
+		return 0, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	n := 0
+	for pos := off; pos < end; {
+		block := o.blocks[pos/s3ReaderBlockSize]
+		start := pos % s3ReaderBlockSize
+		if start >= int64(len(block)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		copied := copy(p[n:end-off], block[start:])
+		n += copied
+		pos += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetch downloads the missing blocks in [first, last], merging contiguous
+// missing blocks into a single ranged request.
+func (o *s3ObjectReader) fetch(first int64, last int64) error {
+	o.mu.Lock()
+	missing := []int64{}
+	for i := first; i <= last; i++ {
+		if _, ok := o.blocks[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	o.mu.Unlock()
+
+	for len(missing) > 0 {
+		runEnd := 1
+		for runEnd < len(missing) && missing[runEnd] == missing[runEnd-1]+1 {
+			runEnd++
+		}
+		run := missing[:runEnd]
+		missing = missing[runEnd:]
+
+		start := run[0] * s3ReaderBlockSize
+		end := min((run[len(run)-1]+1)*s3ReaderBlockSize, o.size) - 1
+
+		object, err := o.client.GetObject(o.ctx, &s3.GetObjectInput{
+			Bucket: aws.String(o.bucket),
+			Key:    aws.String(o.key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(object.Body)
+		object.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		o.mu.Lock()
+		if len(o.blocks)+len(run) > s3ReaderMaxBlocks {
+			o.blocks = map[int64][]byte{}
+		}
+		for i, block := range run {
+			from := min(int64(i)*s3ReaderBlockSize, int64(len(data)))
+			to := min(from+s3ReaderBlockSize, int64(len(data)))
+			o.blocks[block] = data[from:to]
+		}
+		o.mu.Unlock()
+	}
+
+	return nil
+}
+
+// pdfPreviewURL returns the path rendering the pages of a PDF, relative to
+// the API.
+func pdfPreviewURL(bucket string, key string) string {
+	return fmt.Sprintf("/preview/%s/%s?render=1", url.PathEscape(bucket), escapeObjectKey(key))
+}
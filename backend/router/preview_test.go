@@ -0,0 +1,24 @@
+package router
+
+import "testing"
+
+func TestPDFPreviewURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		bucket string
+		key    string
+		want   string
+	}{
+		{"plain key", "docs", "reports/2024.pdf", "/preview/docs/reports/2024.pdf?render=1"},
+		{"query characters", "docs", "what?#1.pdf", "/preview/docs/what%3F%231.pdf?render=1"},
+		{"percent and spaces", "docs", "100% done/final v2.pdf", "/preview/docs/100%25%20done/final%20v2.pdf?render=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pdfPreviewURL(tt.bucket, tt.key); got != tt.want {
+				t.Errorf("url = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
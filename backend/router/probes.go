@@ -0,0 +1,271 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const probeTimeout = 5 * time.Second
+
+type Probes struct{}
+
+// readiness caches the last readiness report, so frequent probes do not hit
+// Garage and the auth providers on every call.
+type readiness struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	report *schema.ReadinessReport
+
+	// authEnabled hides the details of the checks from callers who are not
+	// signed in, as they reveal internal addresses and errors
+	authEnabled bool
+}
+
+var probes = &readiness{ttl: 10 * time.Second}
+
+type readinessCheck struct {
+	name   string
+	target string
+	run    func(ctx context.Context) (string, error)
+}
+
+// InitProbes reads how long the readiness report is cached from
+// READINESS_CACHE_TTL.
+func InitProbes() {
+	// An OIDC provider counts even if it cannot be reached yet, so the
+	// details are not exposed while it is down
+	probes.authEnabled = utils.GetEnv("AUTH_USER_PASS", "") != "" || os.Getenv("OIDC_ISSUER_URL") != "" || NewLDAPAuth() != nil
+
+	value := utils.GetEnv("READINESS_CACHE_TTL", "10s")
+	if ttl, err := time.ParseDuration(value); err == nil && ttl >= 0 {
+		probes.ttl = ttl
+	} else {
+		slog.Warn("Invalid READINESS_CACHE_TTL, using the default.", "value", value, "default", probes.ttl)
+	}
+}
+
+// Live reports that the server is up. It checks no dependency, so an outage
+// of Garage does not get the web UI restarted.
+func (p *Probes) Live(w http.ResponseWriter, r *http.Request) {
+	utils.ResponseSuccess(w, map[string]string{"status": schema.ProbeOK})
+}
+
+// Ready checks the dependencies of the web UI and responds with 503 if any
+// of them fails. Callers who are not signed in only get the name and status
+// of each check, the details of failures are logged.
+func (p *Probes) Ready(w http.ResponseWriter, r *http.Request) {
+	report := probes.get(r.Context())
+
+	status := http.StatusOK
+	if report.Status != schema.ProbeOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	var body interface{} = report
+	if probes.authEnabled && !isAuthenticated(r) {
+		body = summarizeReadiness(report)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func isAuthenticated(r *http.Request) bool {
+	authenticated, _ := utils.Session.Get(r, "authenticated").(bool)
+	return authenticated
+}
+
+func summarizeReadiness(report *schema.ReadinessReport) *schema.ReadinessSummary {
+	summary := &schema.ReadinessSummary{
+		Status:    report.Status,
+		CheckedAt: report.CheckedAt,
+		Checks:    make([]schema.ReadinessCheckStatus, 0, len(report.Checks)),
+	}
+	for _, check := range report.Checks {
+		summary.Checks = append(summary.Checks, schema.ReadinessCheckStatus{Name: check.Name, Status: check.Status})
+	}
+	return summary
+}
+
+// get returns the cached report, or runs the checks if it is stale. Probes
+// arriving meanwhile wait for the same run.
+func (p *readiness) get(ctx context.Context) *schema.ReadinessReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.report != nil && time.Since(p.report.CheckedAt) < p.ttl {
+		return p.report
+	}
+
+	// The checks outlive a probe that gives up, the next ones use the result
+	ctx = context.WithoutCancel(ctx)
+	p.report = runReadinessChecks(ctx, readinessChecks())
+	if p.report.Status != schema.ProbeOK {
+		for _, check := range p.report.Checks {
+			if check.Status != schema.ProbeOK {
+				slog.WarnContext(ctx, "Readiness check failed.", "check", check.Name, "target", check.Target, "error", check.Message)
+			}
+		}
+	}
+	return p.report
+}
+
+func readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{name: "config", target: utils.GetEnv("CONFIG_PATH", "/etc/garage.toml"), run: checkGarageConfig},
+		{name: "admin", target: utils.Garage.GetAdminEndpoint(), run: checkAdminAPI},
+		{name: "s3", target: utils.Garage.GetS3Endpoint(), run: checkS3Endpoint},
+	}
+
+	if issuer := os.Getenv("OIDC_ISSUER_URL"); issuer != "" {
+		checks = append(checks, readinessCheck{name: "oidc", target: issuer, run: func(ctx context.Context) (string, error) {
+			return checkOIDCProvider(ctx, issuer)
+		}})
+	}
+	if ldapAuth := NewLDAPAuth(); ldapAuth != nil {
+		checks = append(checks, readinessCheck{name: "ldap", target: ldapAuth.URL, run: func(ctx context.Context) (string, error) {
+			return checkLDAPServer(ldapAuth)
+		}})
+	}
+
+	return checks
+}
+
+// runReadinessChecks runs the checks in parallel, each with its own timeout.
+func runReadinessChecks(ctx context.Context, checks []readinessCheck) *schema.ReadinessReport {
+	report := &schema.ReadinessReport{
+		Status: schema.ProbeOK,
+		Checks: make([]schema.ReadinessCheck, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			defer cancel()
+
+			start := time.Now()
+			message, err := check.run(checkCtx)
+			result := schema.ReadinessCheck{
+				Name:       check.name,
+				Status:     schema.ProbeOK,
+				Target:     check.target,
+				Message:    message,
+				DurationMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				result.Status = schema.ProbeFail
+				result.Message = err.Error()
+			}
+			report.Checks[i] = result
+		}()
+	}
+	wg.Wait()
+
+	for _, check := range report.Checks {
+		if check.Status != schema.ProbeOK {
+			report.Status = schema.ProbeFail
+		}
+	}
+	report.CheckedAt = time.Now()
+
+	return report
+}
+
+// checkGarageConfig passes if the Garage config is loaded, or if the admin
+// API is configured by the environment instead.
+func checkGarageConfig(ctx context.Context) (string, error) {
+	err := utils.Garage.ConfigError()
+	if err == nil {
+		return "", nil
+	}
+	if os.Getenv("API_BASE_URL") != "" && os.Getenv("API_ADMIN_KEY") != "" {
+		return fmt.Sprintf("not loaded, using API_BASE_URL and API_ADMIN_KEY: %v", err), nil
+	}
+	return "", fmt.Errorf("cannot load garage config: %w", err)
+}
+
+// checkAdminAPI calls the admin API, which also verifies the admin token.
+func checkAdminAPI(ctx context.Context) (string, error) {
+	if _, err := utils.Garage.Fetch("/v2/GetClusterHealth", &utils.FetchOptions{Context: ctx}); err != nil {
+		return "", fmt.Errorf("cannot reach admin API: %w", err)
+	}
+	return "", nil
+}
+
+// checkS3Endpoint passes on any response below 500, an anonymous request
+// being denied is expected.
+func checkS3Endpoint(ctx context.Context) (string, error) {
+	status, err := probeHTTP(ctx, utils.Garage.GetS3Endpoint())
+	if err != nil {
+		return "", fmt.Errorf("cannot reach S3 endpoint: %w", err)
+	}
+	if status >= http.StatusInternalServerError {
+		return "", fmt.Errorf("S3 endpoint responded with status %d", status)
+	}
+	return fmt.Sprintf("status %d", status), nil
+}
+
+func checkOIDCProvider(ctx context.Context, issuer string) (string, error) {
+	status, err := probeHTTP(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("cannot reach OIDC provider: %w", err)
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("OIDC discovery responded with status %d", status)
+	}
+	return "", nil
+}
+
+// checkLDAPServer connects to the LDAP server, and binds with the service
+// account if one is configured.
+func checkLDAPServer(l *LDAPAuth) (string, error) {
+	conn, err := ldap.DialURL(l.URL, ldap.DialWithDialer(&net.Dialer{Timeout: probeTimeout}))
+	if err != nil {
+		return "", fmt.Errorf("cannot connect to LDAP server: %w", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(probeTimeout)
+
+	if l.BindDN == "" {
+		return "", nil
+	}
+	if err := conn.Bind(l.BindDN, l.BindPassword); err != nil {
+		return "", fmt.Errorf("LDAP service bind failed: %w", err)
+	}
+	return "", nil
+}
+
+func probeHTTP(ctx context.Context, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return 0, fmt.Errorf("no response within %s", probeTimeout)
+		}
+		return 0, err
+	}
+	res.Body.Close()
+	return res.StatusCode, nil
+}
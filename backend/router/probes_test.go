@@ -0,0 +1,104 @@
+package router
+
+import (
+	"encoding/json"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyHidesDetails(t *testing.T) {
+	report := &schema.ReadinessReport{
+		Status:    schema.ProbeFail,
+		CheckedAt: time.Now(),
+		Checks: []schema.ReadinessCheck{
+			{Name: "admin", Status: schema.ProbeFail, Target: "http://10.0.0.2:3903", Message: "connection refused", DurationMs: 3},
+			{Name: "s3", Status: schema.ProbeOK, Target: "http://10.0.0.2:3900", Message: "status 403", DurationMs: 1},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		authEnabled   bool
+		authenticated bool
+		wantDetails   bool
+	}{
+		{"auth disabled", false, false, true},
+		{"signed in", true, true, true},
+		{"not signed in", true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := probes
+			probes = &readiness{ttl: time.Hour, report: report, authEnabled: tt.authEnabled}
+			t.Cleanup(func() { probes = previous })
+
+			sessions := utils.InitSessionManager()
+			r := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			ctx, err := sessions.Load(r.Context(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = r.WithContext(ctx)
+			if tt.authenticated {
+				utils.Session.Set(r, "authenticated", true)
+			}
+
+			w := httptest.NewRecorder()
+			(&Probes{}).Ready(w, r)
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+			}
+
+			var got schema.ReadinessReport
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != schema.ProbeFail || len(got.Checks) != 2 {
+				t.Fatalf("report = %+v", got)
+			}
+			for i, check := range got.Checks {
+				if check.Name != report.Checks[i].Name || check.Status != report.Checks[i].Status {
+					t.Errorf("check %d = %+v, want %s %s", i, check, report.Checks[i].Name, report.Checks[i].Status)
+				}
+				hasDetails := check.Target != "" || check.Message != "" || check.DurationMs != 0
+				if hasDetails != tt.wantDetails {
+					t.Errorf("check %s = %+v, want details %v", check.Name, check, tt.wantDetails)
+				}
+			}
+		})
+	}
+}
+
+func TestProbesAuthEnabled(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want bool
+	}{
+		{"no auth", map[string]string{}, false},
+		{"password", map[string]string{"AUTH_USER_PASS": "admin:hash"}, true},
+		{"oidc", map[string]string{"OIDC_ISSUER_URL": "http://127.0.0.1:1"}, true},
+		{"ldap", map[string]string{"LDAP_URL": "ldap://127.0.0.1:1", "LDAP_BASE_DN": "dc=example,dc=org"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"AUTH_USER_PASS", "OIDC_ISSUER_URL", "LDAP_URL", "LDAP_BASE_DN"} {
+				t.Setenv(name, tt.env[name])
+			}
+			previous := probes
+			probes = &readiness{ttl: time.Hour}
+			t.Cleanup(func() { probes = previous })
+
+			InitProbes()
+			if probes.authEnabled != tt.want {
+				t.Errorf("authEnabled = %v, want %v", probes.authEnabled, tt.want)
+			}
+		})
+	}
+}
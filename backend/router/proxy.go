@@ -6,9 +6,22 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"path"
 	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
 )
 
+var invalidatesBucketCredentials = map[string]bool{
+	"AllowBucketKey":    true,
+	"DenyBucketKey":     true,
+	"DeleteKey":         true,
+	"UpdateKey":         true,
+	"DeleteBucket":      true,
+	"AddBucketAlias":    true,
+	"RemoveBucketAlias": true,
+}
+
 func ProxyHandler(w http.ResponseWriter, r *http.Request) {
 	target, err := url.Parse(utils.Garage.GetAdminEndpoint())
 	if err != nil {
@@ -21,6 +34,17 @@ func ProxyHandler(w http.ResponseWriter, r *http.Request) {
 			r.SetURL(target)
 			r.Out.URL.Path = strings.TrimPrefix(r.In.URL.Path, "/api")
 			r.Out.Header.Set("Authorization", fmt.Sprintf("Bearer %s", utils.Garage.GetAdminKey()))
+			if id := utils.RequestID(r.In.Context()); id != "" {
+				r.Out.Header.Set(utils.RequestIDHeader, id)
+			}
+			utils.InjectTraceContext(r.In.Context(), propagation.HeaderCarrier(r.Out.Header))
+		},
+		ModifyResponse: func(res *http.Response) error {
+			// Key permission changes may invalidate the credentials used for S3 access
+			if res.StatusCode == http.StatusOK && invalidatesBucketCredentials[path.Base(res.Request.URL.Path)] {
+				invalidateBucketCredentials(res.Request.Context(), "")
+			}
+			return nil
 		},
 	}
 
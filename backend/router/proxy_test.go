@@ -0,0 +1,59 @@
+package router
+
+import (
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProxyInvalidatesResolvedAliases(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+	}{
+		{"alias removed", "RemoveBucketAlias", "new"},
+		{"alias added", "AddBucketAlias", "new"},
+		{"bucket deleted", "DeleteBucket", "new"},
+		{"read only call", "ListBuckets", "old"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			garage := newGarageStub(t)
+			utils.InitCacheManager()
+			utils.InitSessionKeyManager()
+
+			var owner atomic.Value
+			owner.Store("old")
+			garage.handle("GetBucketInfo", func(r *http.Request, _ map[string]interface{}) (int, interface{}) {
+				return http.StatusOK, schema.Bucket{ID: owner.Load().(string), GlobalAliases: []string{"photos"}}
+			})
+			garage.respond(tt.endpoint, http.StatusOK, map[string]string{})
+
+			if target, err := getBucketTarget(t.Context(), "photos"); err != nil || target.ID != "old" {
+				t.Fatalf("alias resolved to %v: %v", target, err)
+			}
+
+			// The alias moves to another bucket through the proxied call
+			owner.Store("new")
+			w := httptest.NewRecorder()
+			ProxyHandler(w, httptest.NewRequest(http.MethodPost, "/v2/"+tt.endpoint, strings.NewReader("{}")))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+
+			target, err := getBucketTarget(t.Context(), "photos")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target.ID != tt.want {
+				t.Errorf("alias resolved to %s, want %s", target.ID, tt.want)
+			}
+		})
+	}
+}
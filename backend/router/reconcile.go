@@ -0,0 +1,794 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Maximum size of a desired state document sent in a request body
+const reconcileMaxBodySize = 4 << 20
+
+var errNoDesiredState = errors.New("no desired state given and RECONCILE_FILE is not set")
+
+type Reconcile struct{}
+
+type reconciler struct {
+	// Serializes runs, so two applies never race on the same resources
+	runMu sync.Mutex
+
+	mu       sync.Mutex
+	source   string
+	interval time.Duration
+	enforce  bool
+	status   schema.ReconcileStatus
+}
+
+var gitops = &reconciler{}
+
+// reconcileStep is a planned change along with the function applying it.
+type reconcileStep struct {
+	change schema.ReconcileChange
+	apply  func(ctx context.Context, state *reconcileState) error
+}
+
+// reconcileState holds the IDs of the managed keys and buckets by name. IDs
+// of resources created by the plan are only known once they are applied.
+type reconcileState struct {
+	keyIDs      map[string]string
+	bucketIDs   map[string]string
+	createdKeys []schema.ReconcileCreatedKey
+}
+
+// InitReconciler reads the desired state settings and periodically checks
+// RECONCILE_FILE for drift if RECONCILE_INTERVAL is set. With
+// RECONCILE_ENFORCE, drift is corrected by applying the plan.
+func InitReconciler() {
+	gitops.source = os.Getenv("RECONCILE_FILE")
+	gitops.enforce = utils.GetEnv("RECONCILE_ENFORCE", "false") == "true"
+	gitops.status = schema.ReconcileStatus{Source: gitops.source, Enforce: gitops.enforce}
+
+	value := os.Getenv("RECONCILE_INTERVAL")
+	if gitops.source == "" || value == "" {
+		return
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Minute {
+		slog.Warn("Invalid RECONCILE_INTERVAL, periodic reconciliation disabled.", "value", value)
+		return
+	}
+	gitops.interval = interval
+	gitops.status.Interval = interval.String()
+
+	go gitops.schedule()
+}
+
+func (rc *reconciler) schedule() {
+	for {
+		time.Sleep(rc.interval)
+		rc.check()
+	}
+}
+
+// check plans the desired state of the source file and records the drift,
+// applying the plan if enforcing.
+func (rc *reconciler) check() {
+	rc.runMu.Lock()
+	defer rc.runMu.Unlock()
+
+	ctx, span := utils.StartTask(context.Background(), "reconcile check")
+	defer span.End()
+	now := time.Now()
+	status := schema.ReconcileStatus{Source: rc.source, Interval: rc.interval.String(), Enforce: rc.enforce, CheckedAt: &now}
+
+	rc.mu.Lock()
+	status.LastApply = rc.status.LastApply
+	rc.mu.Unlock()
+
+	desired, err := readDesiredStateFile(rc.source)
+	var steps []reconcileStep
+	var state *reconcileState
+	var plan schema.ReconcilePlan
+	if err == nil {
+		steps, state, plan, err = planReconcile(ctx, desired)
+	}
+
+	switch {
+	case err != nil:
+		status.Error = err.Error()
+		slog.Error("Reconcile: cannot plan desired state.", "error", err)
+	case len(steps) > 0 && rc.enforce:
+		result := applyReconcile(ctx, steps, state, plan)
+		status.LastApply = withoutSecrets(result)
+		status.Plan = &result.Plan
+		status.Drift = result.Failed > 0
+		slog.Info("Reconcile: corrected drift.", "applied", result.Applied, "failed", result.Failed)
+	default:
+		status.Plan = &plan
+		status.Drift = len(steps) > 0
+		if status.Drift {
+			slog.Warn("Reconcile: drifted from the desired state.", "changes", len(steps))
+		}
+	}
+
+	rc.mu.Lock()
+	rc.status = status
+	rc.mu.Unlock()
+}
+
+// Plan computes the changes needed to reach the desired state sent as YAML
+// in the request body, or read from RECONCILE_FILE if the body is empty.
+func (rcl *Reconcile) Plan(w http.ResponseWriter, r *http.Request) {
+	desired, err := readDesiredState(r)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	gitops.runMu.Lock()
+	defer gitops.runMu.Unlock()
+
+	_, _, plan, err := planReconcile(r.Context(), desired)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	utils.ResponseSuccess(w, plan)
+}
+
+// Apply computes the plan like Plan and applies it. Failed changes are
+// reported in the result without stopping the other changes.
+func (rcl *Reconcile) Apply(w http.ResponseWriter, r *http.Request) {
+	desired, err := readDesiredState(r)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	gitops.runMu.Lock()
+	defer gitops.runMu.Unlock()
+
+	steps, state, plan, err := planReconcile(r.Context(), desired)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	result := applyReconcile(r.Context(), steps, state, plan)
+
+	gitops.mu.Lock()
+	gitops.status.LastApply = withoutSecrets(result)
+	gitops.mu.Unlock()
+
+	utils.ResponseSuccess(w, result)
+}
+
+// withoutSecrets returns a copy of the result without the secrets of the
+// created keys, to be kept in the status. Secrets are only returned by the
+// apply that created the keys.
+func withoutSecrets(result *schema.ReconcileResult) *schema.ReconcileResult {
+	stripped := *result
+	stripped.CreatedKeys = make([]schema.ReconcileCreatedKey, len(result.CreatedKeys))
+	for i, key := range result.CreatedKeys {
+		key.SecretAccessKey = ""
+		stripped.CreatedKeys[i] = key
+	}
+	return &stripped
+}
+
+// GetStatus returns the drift found by the last periodic check.
+func (rcl *Reconcile) GetStatus(w http.ResponseWriter, r *http.Request) {
+	gitops.mu.Lock()
+	status := gitops.status
+	gitops.mu.Unlock()
+
+	utils.ResponseSuccess(w, status)
+}
+
+func readDesiredState(r *http.Request) (*schema.DesiredState, error) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, reconcileMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		if gitops.source == "" {
+			return nil, errNoDesiredState
+		}
+		return readDesiredStateFile(gitops.source)
+	}
+	return parseDesiredState(data)
+}
+
+func readDesiredStateFile(path string) (*schema.DesiredState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read desired state: %w", err)
+	}
+	return parseDesiredState(data)
+}
+
+// parseDesiredState parses a YAML document. It is converted to JSON so the
+// schema only needs JSON tags, and unknown fields are rejected to catch typos.
+func parseDesiredState(data []byte) (*schema.DesiredState, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("cannot parse desired state: %w", err)
+	}
+
+	jsonData, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse desired state: %w", err)
+	}
+
+	var desired schema.DesiredState
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&desired); err != nil {
+		return nil, fmt.Errorf("invalid desired state: %w", err)
+	}
+
+	keys := map[string]bool{}
+	for _, key := range desired.Keys {
+		if key.Name == "" {
+			return nil, errors.New("invalid desired state: keys need a name")
+		}
+		if keys[key.Name] {
+			return nil, fmt.Errorf("invalid desired state: duplicate key %q", key.Name)
+		}
+		keys[key.Name] = true
+	}
+
+	buckets := map[string]bool{}
+	for _, bucket := range desired.Buckets {
+		if bucket.Name == "" {
+			return nil, errors.New("invalid desired state: buckets need a name")
+		}
+		if buckets[bucket.Name] {
+			return nil, fmt.Errorf("invalid desired state: duplicate bucket %q", bucket.Name)
+		}
+		buckets[bucket.Name] = true
+	}
+
+	return &desired, nil
+}
+
+// planReconcile compares the desired state with the live configuration.
+// Buckets are matched by global alias and keys by ID or name. Resources that
+// are not in the desired state are reported as unmanaged but never deleted.
+func planReconcile(ctx context.Context, desired *schema.DesiredState) ([]reconcileStep, *reconcileState, schema.ReconcilePlan, error) {
+	plan := schema.ReconcilePlan{
+		Changes:          []schema.ReconcileChange{},
+		UnmanagedBuckets: []string{},
+		UnmanagedKeys:    []string{},
+	}
+
+	live, err := readClusterConfig(ctx, false)
+	if err != nil {
+		return nil, nil, plan, err
+	}
+
+	state := &reconcileState{keyIDs: map[string]string{}, bucketIDs: map[string]string{}}
+	steps := []reconcileStep{}
+
+	keySteps, managedKeys, err := planReconcileKeys(desired, live, state)
+	if err != nil {
+		return nil, nil, plan, err
+	}
+	steps = append(steps, keySteps...)
+
+	bucketSteps, managedBuckets, err := planReconcileBuckets(ctx, desired, live, state)
+	if err != nil {
+		return nil, nil, plan, err
+	}
+	steps = append(steps, bucketSteps...)
+
+	for _, step := range steps {
+		plan.Changes = append(plan.Changes, step.change)
+	}
+	for _, key := range live.Keys {
+		if !managedKeys[key.AccessKeyID] {
+			plan.UnmanagedKeys = append(plan.UnmanagedKeys, key.Name+" ("+key.AccessKeyID+")")
+		}
+	}
+	for _, bucket := range live.Buckets {
+		if !managedBuckets[bucket.ID] {
+			name := getSnapshotBucketName(&bucket)
+			if name == "" {
+				name = bucket.ID
+			}
+			plan.UnmanagedBuckets = append(plan.UnmanagedBuckets, name)
+		}
+	}
+	sort.Strings(plan.UnmanagedKeys)
+	sort.Strings(plan.UnmanagedBuckets)
+
+	return steps, state, plan, nil
+}
+
+func planReconcileKeys(desired *schema.DesiredState, live *schema.ConfigSnapshot, state *reconcileState) ([]reconcileStep, map[string]bool, error) {
+	steps := []reconcileStep{}
+	managed := map[string]bool{}
+
+	for _, key := range desired.Keys {
+		existing, err := findLiveKey(live, key.ID, key.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if existing == nil {
+			if key.ID != "" {
+				return nil, nil, fmt.Errorf("key %s does not exist, new keys cannot have a fixed ID", key.ID)
+			}
+
+			// The ID is only known once the key is created
+			state.keyIDs[key.Name] = ""
+
+			change := schema.ReconcileChange{Action: schema.ReconcileActionCreate, Type: "key", Name: key.Name}
+			if key.CreateBucket != nil && *key.CreateBucket {
+				change.Fields = []schema.ReconcileFieldChange{{Field: "createBucket", Current: false, Desired: true}}
+			}
+
+			steps = append(steps, reconcileStep{change: change, apply: func(ctx context.Context, state *reconcileState) error {
+				data, err := utils.Garage.Fetch("/v2/CreateKey", &utils.FetchOptions{
+					Context: ctx,
+					Method:  http.MethodPost,
+					Body:    map[string]string{"name": key.Name},
+				})
+				if err != nil {
+					return err
+				}
+
+				var created schema.KeyElement
+				if err := json.Unmarshal(data, &created); err != nil {
+					return err
+				}
+				state.keyIDs[key.Name] = created.AccessKeyID
+				state.createdKeys = append(state.createdKeys, schema.ReconcileCreatedKey{
+					Name:            key.Name,
+					AccessKeyID:     created.AccessKeyID,
+					SecretAccessKey: created.SecretAccessKey,
+				})
+
+				if key.CreateBucket != nil && *key.CreateBucket {
+					return updateKeyPermissions(ctx, created.AccessKeyID, key.Name, true)
+				}
+				return nil
+			}})
+			continue
+		}
+
+		managed[existing.AccessKeyID] = true
+		state.keyIDs[key.Name] = existing.AccessKeyID
+
+		fields := []schema.ReconcileFieldChange{}
+		if existing.Name != key.Name {
+			fields = append(fields, schema.ReconcileFieldChange{Field: "name", Current: existing.Name, Desired: key.Name})
+		}
+		createBucket := existing.CreateBucket
+		if key.CreateBucket != nil && *key.CreateBucket != existing.CreateBucket {
+			createBucket = *key.CreateBucket
+			fields = append(fields, schema.ReconcileFieldChange{Field: "createBucket", Current: existing.CreateBucket, Desired: createBucket})
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		accessKeyID := existing.AccessKeyID
+		steps = append(steps, reconcileStep{
+			change: schema.ReconcileChange{Action: schema.ReconcileActionUpdate, Type: "key", Name: key.Name, Fields: fields},
+			apply: func(ctx context.Context, state *reconcileState) error {
+				return updateKeyPermissions(ctx, accessKeyID, key.Name, createBucket)
+			},
+		})
+	}
+
+	return steps, managed, nil
+}
+
+// findLiveKey looks up a key by ID, or by name if no ID is given. Names must
+// be unique to be matched.
+func findLiveKey(live *schema.ConfigSnapshot, id string, name string) (*schema.SnapshotKey, error) {
+	var found *schema.SnapshotKey
+	for i := range live.Keys {
+		key := &live.Keys[i]
+		if id != "" {
+			if key.AccessKeyID == id {
+				return key, nil
+			}
+			continue
+		}
+		if key.Name == name {
+			if found != nil {
+				return nil, fmt.Errorf("several keys are named %q, set the id of the key", name)
+			}
+			found = key
+		}
+	}
+	return found, nil
+}
+
+func updateKeyPermissions(ctx context.Context, accessKeyID string, name string, createBucket bool) error {
+	update := map[string]interface{}{"name": name}
+	permission := map[string]bool{"createBucket": true}
+	if createBucket {
+		update["allow"] = permission
+	} else {
+		update["deny"] = permission
+	}
+
+	_, err := utils.Garage.Fetch("/v2/UpdateKey", &utils.FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+		Params:  map[string]string{"id": accessKeyID},
+		Body:    update,
+	})
+	return err
+}
+
+func planReconcileBuckets(ctx context.Context, desired *schema.DesiredState, live *schema.ConfigSnapshot, state *reconcileState) ([]reconcileStep, map[string]bool, error) {
+	steps := []reconcileStep{}
+	managed := map[string]bool{}
+
+	liveBuckets := map[string]*schema.SnapshotBucket{}
+	for i := range live.Buckets {
+		for _, alias := range live.Buckets[i].GlobalAliases {
+			liveBuckets[alias] = &live.Buckets[i]
+		}
+	}
+
+	lifecycles, err := readReconcileLifecycles(ctx, desired, liveBuckets)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, bucket := range desired.Buckets {
+		existing := liveBuckets[bucket.Name]
+		if existing == nil {
+			existing = &schema.SnapshotBucket{}
+			steps = append(steps, reconcileStep{
+				change: schema.ReconcileChange{Action: schema.ReconcileActionCreate, Type: "bucket", Name: bucket.Name},
+				apply: func(ctx context.Context, state *reconcileState) error {
+					data, err := utils.Garage.Fetch("/v2/CreateBucket", &utils.FetchOptions{
+						Context: ctx,
+						Method:  http.MethodPost,
+						Body:    map[string]string{"globalAlias": bucket.Name},
+					})
+					if err != nil {
+						return err
+					}
+
+					var created schema.Bucket
+					if err := json.Unmarshal(data, &created); err != nil {
+						return err
+					}
+					state.bucketIDs[bucket.Name] = created.ID
+					return nil
+				},
+			})
+		} else {
+			managed[existing.ID] = true
+			state.bucketIDs[bucket.Name] = existing.ID
+		}
+
+		if step := planBucketSettings(&bucket, existing); step != nil {
+			steps = append(steps, *step)
+		}
+
+		permissionSteps, err := planBucketPermissions(&bucket, existing, live, state)
+		if err != nil {
+			return nil, nil, err
+		}
+		steps = append(steps, permissionSteps...)
+
+		if step := planBucketLifecycle(&bucket, lifecycles[bucket.Name]); step != nil {
+			steps = append(steps, *step)
+		}
+	}
+
+	return steps, managed, nil
+}
+
+func planBucketSettings(bucket *schema.DesiredBucket, existing *schema.SnapshotBucket) *reconcileStep {
+	fields := []schema.ReconcileFieldChange{}
+
+	quotas := existing.Quotas
+	if bucket.Quotas != nil && *bucket.Quotas != existing.Quotas {
+		quotas = *bucket.Quotas
+		fields = append(fields, schema.ReconcileFieldChange{Field: "quotas", Current: existing.Quotas, Desired: quotas})
+	}
+
+	websiteAccess, websiteConfig := existing.WebsiteAccess, existing.WebsiteConfig
+	if bucket.Website != nil {
+		current := schema.DesiredWebsite{Enabled: existing.WebsiteAccess}
+		desired := schema.DesiredWebsite{Enabled: bucket.Website.Enabled}
+		if existing.WebsiteAccess {
+			current.IndexDocument, current.ErrorDocument = existing.WebsiteConfig.IndexDocument, existing.WebsiteConfig.ErrorDocument
+		}
+		if bucket.Website.Enabled {
+			desired.IndexDocument, desired.ErrorDocument = bucket.Website.IndexDocument, bucket.Website.ErrorDocument
+			if desired.IndexDocument == "" {
+				desired.IndexDocument = "index.html"
+			}
+		}
+
+		if current != desired {
+			websiteAccess = desired.Enabled
+			websiteConfig = schema.WebsiteConfig{IndexDocument: desired.IndexDocument, ErrorDocument: desired.ErrorDocument}
+			fields = append(fields, schema.ReconcileFieldChange{Field: "website", Current: current, Desired: desired})
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	name := bucket.Name
+	return &reconcileStep{
+		change: schema.ReconcileChange{Action: schema.ReconcileActionUpdate, Type: "bucket", Name: name, Fields: fields},
+		apply: func(ctx context.Context, state *reconcileState) error {
+			bucketID, err := state.bucketID(name)
+			if err != nil {
+				return err
+			}
+			return updateBucketSettings(ctx, bucketID, websiteAccess, websiteConfig, quotas)
+		},
+	}
+}
+
+// planBucketPermissions grants the listed keys their permissions and revokes
+// the permissions of all other keys, if the permissions are managed.
+func planBucketPermissions(bucket *schema.DesiredBucket, existing *schema.SnapshotBucket, live *schema.ConfigSnapshot, state *reconcileState) ([]reconcileStep, error) {
+	if bucket.Permissions == nil {
+		return nil, nil
+	}
+
+	current := map[string]schema.ManifestKey{}
+	for _, key := range existing.Keys {
+		current[key.AccessKeyID] = key
+	}
+
+	steps := []reconcileStep{}
+	for _, permission := range bucket.Permissions {
+		keyRef := permission.Key
+		accessKeyID, planned := state.keyIDs[keyRef]
+		if !planned {
+			// Keys that are not managed can be referenced by ID or name too
+			key, err := findLiveKey(live, "", keyRef)
+			if err == nil && key == nil {
+				key, err = findLiveKey(live, keyRef, "")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if key == nil {
+				return nil, fmt.Errorf("bucket %s: unknown key %q", bucket.Name, keyRef)
+			}
+			accessKeyID = key.AccessKeyID
+		}
+
+		desired := schema.Permissions{Read: permission.Read, Write: permission.Write, Owner: permission.Owner}
+		action := schema.ReconcileActionUpdate
+		var currentPermissions schema.Permissions
+		if key, ok := current[accessKeyID]; ok && accessKeyID != "" {
+			currentPermissions = key.Permissions
+			delete(current, accessKeyID)
+			if currentPermissions == desired {
+				continue
+			}
+		} else {
+			action = schema.ReconcileActionCreate
+		}
+
+		steps = append(steps, reconcileStep{
+			change: schema.ReconcileChange{
+				Action: action,
+				Type:   "permission",
+				Name:   bucket.Name + "/" + keyRef,
+				Fields: []schema.ReconcileFieldChange{{Field: "permissions", Current: currentPermissions, Desired: desired}},
+			},
+			apply: func(ctx context.Context, state *reconcileState) error {
+				bucketID, err := state.bucketID(bucket.Name)
+				if err != nil {
+					return err
+				}
+				keyID := state.keyIDs[keyRef]
+				if keyID == "" {
+					keyID = accessKeyID
+				}
+				if keyID == "" {
+					return fmt.Errorf("key %s was not created", keyRef)
+				}
+				return setBucketKeyPermissions(ctx, bucketID, keyID, currentPermissions, desired)
+			},
+		})
+	}
+
+	for accessKeyID, key := range current {
+		steps = append(steps, reconcileStep{
+			change: schema.ReconcileChange{
+				Action: schema.ReconcileActionRevoke,
+				Type:   "permission",
+				Name:   bucket.Name + "/" + key.Name,
+				Fields: []schema.ReconcileFieldChange{{Field: "permissions", Current: key.Permissions, Desired: schema.Permissions{}}},
+			},
+			apply: func(ctx context.Context, state *reconcileState) error {
+				bucketID, err := state.bucketID(bucket.Name)
+				if err != nil {
+					return err
+				}
+				return setBucketKeyPermissions(ctx, bucketID, accessKeyID, key.Permissions, schema.Permissions{})
+			},
+		})
+	}
+
+	return steps, nil
+}
+
+// setBucketKeyPermissions allows the desired permissions and denies the
+// current ones that are no longer desired.
+func setBucketKeyPermissions(ctx context.Context, bucketID string, accessKeyID string, current schema.Permissions, desired schema.Permissions) error {
+	if desired.Read || desired.Write || desired.Owner {
+		_, err := utils.Garage.Fetch("/v2/AllowBucketKey", &utils.FetchOptions{
+			Context: ctx,
+			Method:  http.MethodPost,
+			Body:    map[string]interface{}{"bucketId": bucketID, "accessKeyId": accessKeyID, "permissions": desired},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	deny := schema.Permissions{
+		Read:  current.Read && !desired.Read,
+		Write: current.Write && !desired.Write,
+		Owner: current.Owner && !desired.Owner,
+	}
+	if deny.Read || deny.Write || deny.Owner {
+		_, err := utils.Garage.Fetch("/v2/DenyBucketKey", &utils.FetchOptions{
+			Context: ctx,
+			Method:  http.MethodPost,
+			Body:    map[string]interface{}{"bucketId": bucketID, "accessKeyId": accessKeyID, "permissions": deny},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readReconcileLifecycles reads the lifecycle rules of the existing buckets
+// whose lifecycle is managed. Planning must not change the cluster, so the
+// rules are read with the existing keys of the buckets. Buckets without a
+// usable key get no entry, so their rules are applied again.
+func readReconcileLifecycles(ctx context.Context, desired *schema.DesiredState, liveBuckets map[string]*schema.SnapshotBucket) (map[string][]schema.LifecycleRule, error) {
+	lifecycles := map[string][]schema.LifecycleRule{}
+
+	for _, bucket := range desired.Buckets {
+		existing := liveBuckets[bucket.Name]
+		if existing == nil || bucket.Lifecycle == nil {
+			continue
+		}
+
+		creds, err := getBucketKeyCredentials(ctx, existing.ID)
+		if errors.Is(err, errNoBucketKey) || errors.Is(err, errBucketNoAlias) {
+			slog.WarnContext(ctx, "Cannot read bucket lifecycle without a bucket key.", "bucket", bucket.Name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot get credentials of bucket %s: %w", bucket.Name, err)
+		}
+
+		result, err := newS3Client(creds).GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+			Bucket: aws.String(creds.Bucket),
+		})
+		if err != nil && !isAPIErrorCode(err, "NoSuchLifecycleConfiguration") {
+			return nil, fmt.Errorf("cannot get lifecycle of bucket %s: %w", bucket.Name, err)
+		}
+
+		lifecycles[bucket.Name] = []schema.LifecycleRule{}
+		if result != nil {
+			lifecycles[bucket.Name] = fromS3LifecycleRules(result.Rules)
+		}
+	}
+
+	return lifecycles, nil
+}
+
+func planBucketLifecycle(bucket *schema.DesiredBucket, current []schema.LifecycleRule) *reconcileStep {
+	if bucket.Lifecycle == nil {
+		return nil
+	}
+	if current == nil {
+		current = []schema.LifecycleRule{}
+	}
+
+	// Round trip the rules so defaults such as rule IDs match
+	desired := fromS3LifecycleRules(toS3LifecycleRules(bucket.Lifecycle))
+	currentData, _ := json.Marshal(current)
+	desiredData, _ := json.Marshal(desired)
+	if bytes.Equal(currentData, desiredData) {
+		return nil
+	}
+
+	name := bucket.Name
+	return &reconcileStep{
+		change: schema.ReconcileChange{
+			Action: schema.ReconcileActionUpdate,
+			Type:   "lifecycle",
+			Name:   name,
+			Fields: []schema.ReconcileFieldChange{{Field: "rules", Current: current, Desired: desired}},
+		},
+		apply: func(ctx context.Context, state *reconcileState) error {
+			bucketID, err := state.bucketID(name)
+			if err != nil {
+				return err
+			}
+
+			client, names, accessKeyID, err := createJobClient(ctx, "reconcile-"+bucketID[:min(len(bucketID), 16)], []utils.KeyGrant{
+				{BucketID: bucketID, Read: true, Owner: true},
+			})
+			if err != nil {
+				return err
+			}
+			defer func() {
+				utils.DeleteScopedKey(context.WithoutCancel(ctx), accessKeyID)
+				utils.S3Clients.Remove(accessKeyID)
+			}()
+
+			if len(desired) == 0 {
+				_, err = client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{Bucket: aws.String(names[bucketID])})
+				return err
+			}
+			_, err = client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+				Bucket:                 aws.String(names[bucketID]),
+				LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: toS3LifecycleRules(bucket.Lifecycle)},
+			})
+			return err
+		},
+	}
+}
+
+func (s *reconcileState) bucketID(name string) (string, error) {
+	if id := s.bucketIDs[name]; id != "" {
+		return id, nil
+	}
+	return "", fmt.Errorf("bucket %s was not created", name)
+}
+
+// applyReconcile applies the planned steps in order. A failed step is
+// recorded in its change; later steps still run.
+func applyReconcile(ctx context.Context, steps []reconcileStep, state *reconcileState, plan schema.ReconcilePlan) *schema.ReconcileResult {
+	result := &schema.ReconcileResult{Plan: plan, CreatedKeys: []schema.ReconcileCreatedKey{}}
+
+	for i, step := range steps {
+		if err := step.apply(ctx, state); err != nil {
+			result.Plan.Changes[i].Error = err.Error()
+			result.Failed++
+			continue
+		}
+		result.Applied++
+	}
+
+	result.CreatedKeys = append(result.CreatedKeys, state.createdKeys...)
+	invalidateBucketCredentials(ctx, "")
+	return result
+}
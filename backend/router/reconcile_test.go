@@ -0,0 +1,289 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDesiredState(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"valid", "keys:\n  - name: app\nbuckets:\n  - name: photos\n", ""},
+		{"empty", "", ""},
+		{"unknown field", "buckets:\n  - name: photos\n    quota: 1\n", "unknown field"},
+		{"key without name", "keys:\n  - id: GK1\n", "keys need a name"},
+		{"duplicate key", "keys:\n  - name: app\n  - name: app\n", "duplicate key"},
+		{"duplicate bucket", "buckets:\n  - name: photos\n  - name: photos\n", "duplicate bucket"},
+		{"invalid yaml", "keys: [", "cannot parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseDesiredState([]byte(tt.yaml))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func reconcileLiveState() *schema.ConfigSnapshot {
+	return &schema.ConfigSnapshot{
+		Keys: []schema.SnapshotKey{
+			{AccessKeyID: "GK1", Name: "app"},
+			{AccessKeyID: "GK2", Name: "legacy"},
+		},
+		Buckets: []schema.SnapshotBucket{{
+			ID:            "b1",
+			GlobalAliases: []string{"photos"},
+			Keys: []schema.ManifestKey{
+				{AccessKeyID: "GK1", Name: "app", Permissions: schema.Permissions{Read: true, Write: true}},
+				{AccessKeyID: "GK2", Name: "legacy", Permissions: schema.Permissions{Read: true}},
+			},
+		}},
+	}
+}
+
+func TestPlanReconcile(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "in sync",
+			yaml: `
+keys: [{name: app}]
+buckets:
+  - name: photos
+    permissions: [{key: app, read: true, write: true}, {key: legacy, read: true}]
+`,
+			want: []string{},
+		},
+		{
+			name: "new key and bucket",
+			yaml: `
+keys: [{name: worker}]
+buckets:
+  - name: docs
+    permissions: [{key: worker, read: true}]
+`,
+			want: []string{"create key worker", "create bucket docs", "create permission docs/worker"},
+		},
+		{
+			name: "quota and revoked key",
+			yaml: `
+buckets:
+  - name: photos
+    quotas: {maxSize: 100, maxObjects: 0}
+    permissions: [{key: app, read: true, write: true}]
+`,
+			want: []string{"update bucket photos", "revoke permission photos/legacy"},
+		},
+		{
+			name: "changed permission",
+			yaml: `
+buckets:
+  - name: photos
+    permissions: [{key: app, read: true}, {key: GK2, read: true}]
+`,
+			want: []string{"update permission photos/app"},
+		},
+		{
+			name: "website and create bucket permission",
+			yaml: `
+keys: [{name: app, createBucket: true}]
+buckets:
+  - name: photos
+    website: {enabled: true}
+`,
+			want: []string{"update key app", "update bucket photos"},
+		},
+		{
+			name: "rename by id",
+			yaml: "keys: [{name: renamed, id: GK2}]",
+			want: []string{"update key renamed"},
+		},
+		{
+			name:    "unknown id",
+			yaml:    "keys: [{name: ghost, id: GK9}]",
+			wantErr: "does not exist",
+		},
+		{
+			name:    "unknown key in permissions",
+			yaml:    "buckets: [{name: photos, permissions: [{key: ghost, read: true}]}]",
+			wantErr: "unknown key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired, err := parseDesiredState([]byte(tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			live := reconcileLiveState()
+			state := &reconcileState{keyIDs: map[string]string{}, bucketIDs: map[string]string{}}
+			steps, _, err := planReconcileKeys(desired, live, state)
+			if err == nil {
+				var bucketSteps []reconcileStep
+				bucketSteps, _, err = planReconcileBuckets(t.Context(), desired, live, state)
+				steps = append(steps, bucketSteps...)
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, step := range steps {
+				got = append(got, fmt.Sprintf("%s %s %s", step.change.Action, step.change.Type, step.change.Name))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("plan = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileStatusOmitsSecrets(t *testing.T) {
+	garage := newGarageStub(t)
+	garage.respond("ListKeys", http.StatusOK, []interface{}{})
+	garage.respond("ListBuckets", http.StatusOK, []interface{}{})
+	garage.respond("GetClusterLayout", http.StatusOK, map[string]interface{}{"version": 1})
+	garage.respond("CreateKey", http.StatusOK, schema.KeyElement{AccessKeyID: "GKnew", Name: "worker", SecretAccessKey: "top-secret"})
+	utils.InitCacheManager()
+	gitops.status = schema.ReconcileStatus{}
+
+	w := httptest.NewRecorder()
+	(&Reconcile{}).Apply(w, httptest.NewRequest(http.MethodPost, "/reconcile/apply", strings.NewReader("keys: [{name: worker}]")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("apply status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var result schema.ReconcileResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.CreatedKeys) != 1 || result.CreatedKeys[0].SecretAccessKey != "top-secret" {
+		t.Fatalf("apply created keys = %+v, want the secret of the new key", result.CreatedKeys)
+	}
+
+	w = httptest.NewRecorder()
+	(&Reconcile{}).GetStatus(w, httptest.NewRequest(http.MethodGet, "/reconcile/status", nil))
+	if strings.Contains(w.Body.String(), "top-secret") {
+		t.Fatalf("status exposes the secret: %s", w.Body.String())
+	}
+
+	var status schema.ReconcileStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.LastApply == nil || len(status.LastApply.CreatedKeys) != 1 || status.LastApply.CreatedKeys[0].AccessKeyID != "GKnew" {
+		t.Errorf("status last apply = %+v, want the created key without secret", status.LastApply)
+	}
+}
+
+func TestReconcileApplyCarriesRequestID(t *testing.T) {
+	garage := newGarageStub(t)
+	garage.respond("ListKeys", http.StatusOK, []interface{}{})
+	garage.respond("ListBuckets", http.StatusOK, []interface{}{})
+	garage.respond("GetClusterLayout", http.StatusOK, map[string]interface{}{"version": 1})
+	garage.respond("CreateKey", http.StatusOK, schema.KeyElement{AccessKeyID: "GKnew", Name: "worker"})
+	garage.respond("UpdateKey", http.StatusOK, map[string]string{})
+	garage.respond("CreateBucket", http.StatusOK, schema.Bucket{ID: "b1"})
+	garage.respond("AllowBucketKey", http.StatusOK, map[string]string{})
+	utils.InitCacheManager()
+
+	desired := `
+keys: [{name: worker, createBucket: true}]
+buckets:
+  - name: photos
+    permissions: [{key: worker, read: true}]
+`
+	r := httptest.NewRequest(http.MethodPost, "/reconcile/apply", strings.NewReader(desired))
+	r = r.WithContext(utils.WithRequestID(r.Context(), "req-1"))
+	w := httptest.NewRecorder()
+	(&Reconcile{}).Apply(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("apply status = %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(garage.called("AllowBucketKey")) != 1 {
+		t.Fatalf("permission was not applied: %s", w.Body.String())
+	}
+	garage.checkRequestID(t, "req-1")
+}
+
+func TestPlanReconcileLifecycleCreatesNoKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		bucketKeys []schema.KeyElement
+		wantRead   bool
+	}{
+		{"bucket key", []schema.KeyElement{{AccessKeyID: "GK1", Name: "app", Permissions: schema.Permissions{Read: true}}}, true},
+		{"no bucket key", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, _ := newS3Stub(t)
+			garage := newGarageStub(t)
+			garage.respond("GetBucketInfo", http.StatusOK, schema.Bucket{ID: "b1", GlobalAliases: []string{"photos"}, Keys: tt.bucketKeys})
+			garage.respond("GetKeyInfo", http.StatusOK, schema.KeyElement{AccessKeyID: "GK1", Name: "app", SecretAccessKey: "secret"})
+
+			desired, err := parseDesiredState([]byte(`
+buckets:
+  - name: photos
+    permissions: [{key: app, read: true, write: true}, {key: legacy, read: true}]
+    lifecycle: [{id: expire, enabled: true, prefix: tmp/, expirationDays: 7}]
+`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			state := &reconcileState{keyIDs: map[string]string{}, bucketIDs: map[string]string{}}
+			live := reconcileLiveState()
+			if _, _, err := planReconcileKeys(desired, live, state); err != nil {
+				t.Fatal(err)
+			}
+			steps, _, err := planReconcileBuckets(t.Context(), desired, live, state)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(steps) != 1 || steps[0].change.Type != "lifecycle" {
+				t.Errorf("plan = %+v, want the lifecycle update", steps)
+			}
+			if read := stub.count("ListObjectsV2 photos") > 0; read != tt.wantRead {
+				t.Errorf("lifecycle read = %v, want %v", read, tt.wantRead)
+			}
+			for _, endpoint := range []string{"CreateKey", "AllowBucketKey", "AddBucketAlias", "DeleteKey"} {
+				if calls := garage.called(endpoint); len(calls) != 0 {
+					t.Errorf("planning called %s %d times", endpoint, len(calls))
+				}
+			}
+		})
+	}
+}
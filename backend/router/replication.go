@@ -0,0 +1,457 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pelletier/go-toml/v2"
+)
+
+const (
+	replicationJobType = "replication"
+
+	// Metadata key storing the source ETag on replicated objects, as the
+	// ETag of the copy can differ, e.g. for multipart uploads
+	replicationMetaSourceETag = "replication-source-etag"
+
+	// Objects larger than a part are uploaded in parts, with this many parts
+	// buffered in memory at once
+	replicationMinPartSize     = 16 << 20
+	replicationPartConcurrency = 4
+)
+
+type Replication struct{}
+
+type replicationScheduler struct {
+	mu      sync.Mutex
+	config  schema.ReplicationConfig
+	nextRun map[string]time.Time
+}
+
+var replicator = &replicationScheduler{nextRun: map[string]time.Time{}}
+
+// InitReplication loads the replication config from REPLICATION_CONFIG and
+// schedules the rules with an interval.
+func InitReplication() {
+	utils.Jobs.Register(replicationJobType, runReplicationJob)
+
+	path := os.Getenv("REPLICATION_CONFIG")
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("Cannot read replication config.", "error", err)
+		return
+	}
+
+	var config schema.ReplicationConfig
+	if err := toml.Unmarshal(data, &config); err != nil {
+		slog.Error("Cannot parse replication config.", "error", err)
+		return
+	}
+	if err := validateReplicationConfig(&config); err != nil {
+		slog.Error("Invalid replication config.", "error", err)
+		return
+	}
+
+	replicator.mu.Lock()
+	replicator.config = config
+	replicator.mu.Unlock()
+
+	for _, rule := range config.Rules {
+		if rule.Interval != "" {
+			interval, _ := time.ParseDuration(rule.Interval)
+			go replicator.schedule(rule.Name, interval)
+		}
+	}
+}
+
+func validateReplicationConfig(config *schema.ReplicationConfig) error {
+	targets := map[string]bool{}
+	for _, target := range config.Targets {
+		if target.Name == "" || target.Endpoint == "" {
+			return errors.New("targets need a name and an endpoint")
+		}
+		targets[target.Name] = true
+	}
+
+	names := map[string]bool{}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Bucket == "" || rule.TargetBucket == "" {
+			return errors.New("rules need a bucket and a target bucket")
+		}
+		if !targets[rule.Target] {
+			return fmt.Errorf("unknown target %q", rule.Target)
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Bucket + "-" + rule.Target
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Conflict {
+		case schema.ReplicationConflictOverwrite, schema.ReplicationConflictNewer, schema.ReplicationConflictSkip:
+		case "":
+			rule.Conflict = schema.ReplicationConflictNewer
+		default:
+			return fmt.Errorf("unknown conflict policy %q", rule.Conflict)
+		}
+
+		if rule.Interval != "" {
+			if interval, err := time.ParseDuration(rule.Interval); err != nil || interval < time.Minute {
+				return fmt.Errorf("invalid interval %q of rule %s", rule.Interval, rule.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *replicationScheduler) schedule(name string, interval time.Duration) {
+	for {
+		next := time.Now().Add(interval)
+		s.mu.Lock()
+		s.nextRun[name] = next
+		s.mu.Unlock()
+
+		time.Sleep(time.Until(next))
+
+		if _, err := startReplication(name); err != nil {
+			slog.Error("Cannot start scheduled replication.", "rule", name, "error", err)
+		}
+	}
+}
+
+func (s *replicationScheduler) getRule(name string) (*schema.ReplicationRule, *schema.ReplicationTarget) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rule := range s.config.Rules {
+		if rule.Name != name {
+			continue
+		}
+		for _, target := range s.config.Targets {
+			if target.Name == rule.Target {
+				return &rule, &target
+			}
+		}
+	}
+	return nil, nil
+}
+
+var errReplicationRunning = errors.New("replication is already running")
+
+func startReplication(name string) (*utils.Job, error) {
+	for _, job := range utils.Jobs.List(replicationJobType) {
+		if job.Params["rule"] == name && job.Status == schema.JobStatusRunning {
+			return nil, errReplicationRunning
+		}
+	}
+
+	return utils.Jobs.Start(replicationJobType, map[string]string{"rule": name}, runReplicationJob), nil
+}
+
+// GetStatus returns the replication rules along with their last run.
+func (rp *Replication) GetStatus(w http.ResponseWriter, r *http.Request) {
+	replicator.mu.Lock()
+	rules := replicator.config.Rules
+	nextRun := map[string]time.Time{}
+	for name, next := range replicator.nextRun {
+		nextRun[name] = next
+	}
+	replicator.mu.Unlock()
+
+	jobs := utils.Jobs.List(replicationJobType)
+	result := make([]schema.ReplicationStatus, 0, len(rules))
+
+	for _, rule := range rules {
+		status := schema.ReplicationStatus{Rule: rule}
+		if next, ok := nextRun[rule.Name]; ok {
+			status.NextRun = &next
+		}
+
+		// Jobs are sorted newest first
+		for _, job := range jobs {
+			if job.Params["rule"] == rule.Name {
+				status.LastJob = &job
+				status.Running = job.Status == schema.JobStatusRunning
+				break
+			}
+		}
+
+		result = append(result, status)
+	}
+
+	utils.ResponseSuccess(w, result)
+}
+
+// Run starts the replication of a rule immediately.
+func (rp *Replication) Run(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if rule, _ := replicator.getRule(name); rule == nil {
+		utils.ResponseErrorStatus(w, fmt.Errorf("replication rule %s not found", name), http.StatusNotFound)
+		return
+	}
+
+	job, err := startReplication(name)
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusConflict)
+		return
+	}
+
+	utils.ResponseSuccess(w, job.Snapshot())
+}
+
+type replicationCheckpoint struct {
+	LastKey     string                   `json:"lastKey"`
+	Result      schema.ReplicationResult `json:"result"`
+	AccessKeyID string                   `json:"accessKeyId"`
+}
+
+// runReplicationJob pushes the objects of the rule's bucket to the remote
+// target. Objects that are missing or differ on the target are uploaded, so
+// each run only transfers what changed since the previous one.
+func runReplicationJob(ctx context.Context, job *utils.Job) (interface{}, error) {
+	rule, target := replicator.getRule(job.Snapshot().Params["rule"])
+	if rule == nil {
+		return nil, errors.New("replication rule no longer exists")
+	}
+
+	checkpoint := replicationCheckpoint{}
+	if job.Checkpoint(&checkpoint) && checkpoint.AccessKeyID != "" {
+		utils.DeleteScopedKey(ctx, checkpoint.AccessKeyID)
+	}
+	result := &checkpoint.Result
+
+	bucket, err := getBucketTarget(ctx, rule.Bucket)
+	if err != nil {
+		return result, fmt.Errorf("cannot find bucket %s: %w", rule.Bucket, err)
+	}
+
+	client, names, accessKeyID, err := createJobClient(ctx, job.ID(), []utils.KeyGrant{{BucketID: bucket.ID, Read: true}})
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		utils.DeleteScopedKey(context.WithoutCancel(ctx), accessKeyID)
+		utils.S3Clients.Remove(accessKeyID)
+	}()
+
+	remote, err := utils.S3Clients.NewRemote(*target)
+	if err != nil {
+		return result, err
+	}
+
+	checkpoint.AccessKeyID = accessKeyID
+	if err := job.SetCheckpoint(checkpoint); err != nil {
+		return result, err
+	}
+
+	sources := newObjectIterator(ctx, client, names[bucket.ID], rule.Prefix, checkpoint.LastKey)
+	targets := newObjectIterator(ctx, remote, rule.TargetBucket, rule.TargetPrefix, checkpoint.LastKey)
+	lastCheckpoint := time.Now()
+
+	for {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		src, err := sources.Peek()
+		if err != nil {
+			return result, fmt.Errorf("cannot list source objects: %w", err)
+		}
+		dst, err := targets.Peek()
+		if err != nil {
+			return result, fmt.Errorf("cannot list target objects: %w", err)
+		}
+		if src == nil && dst == nil {
+			break
+		}
+
+		var key string
+
+		switch {
+		case dst == nil || (src != nil && strings.TrimPrefix(*src.Key, rule.Prefix) < strings.TrimPrefix(*dst.Key, rule.TargetPrefix)):
+			key = strings.TrimPrefix(*src.Key, rule.Prefix)
+			if err := replicateObject(ctx, client, names[bucket.ID], remote, rule, src); err != nil {
+				return result, fmt.Errorf("cannot replicate %s: %w", key, err)
+			}
+			sources.Pop()
+			result.Scanned++
+			result.Copied++
+			result.CopiedBytes += aws.ToInt64(src.Size)
+
+		case src == nil || strings.TrimPrefix(*dst.Key, rule.TargetPrefix) < strings.TrimPrefix(*src.Key, rule.Prefix):
+			key = strings.TrimPrefix(*dst.Key, rule.TargetPrefix)
+			deleted, err := deleteReplicatedObject(ctx, remote, rule, dst)
+			if err != nil {
+				return result, fmt.Errorf("cannot delete %s: %w", key, err)
+			}
+			if deleted {
+				result.Deleted++
+			}
+			targets.Pop()
+
+		default:
+			key = strings.TrimPrefix(*src.Key, rule.Prefix)
+			action, err := getReplicationAction(ctx, remote, rule, src, dst)
+			if err != nil {
+				return result, fmt.Errorf("cannot compare %s: %w", key, err)
+			}
+
+			switch action {
+			case "copy":
+				if err := replicateObject(ctx, client, names[bucket.ID], remote, rule, src); err != nil {
+					return result, fmt.Errorf("cannot replicate %s: %w", key, err)
+				}
+				result.Copied++
+				result.CopiedBytes += aws.ToInt64(src.Size)
+			case "conflict":
+				result.Conflicts++
+			default:
+				result.Skipped++
+			}
+			sources.Pop()
+			targets.Pop()
+			result.Scanned++
+		}
+
+		checkpoint.LastKey = key
+		job.SetProgress(result.Scanned, 0, fmt.Sprintf("copied %d, deleted %d, conflicts %d", result.Copied, result.Deleted, result.Conflicts))
+
+		if time.Since(lastCheckpoint) > syncCheckpointInterval {
+			if err := job.SetCheckpoint(checkpoint); err != nil {
+				slog.Error("Cannot save job checkpoint.", "job", job.ID(), "error", err)
+			}
+			lastCheckpoint = time.Now()
+		}
+	}
+
+	return result, nil
+}
+
+// getReplicationAction compares an object existing on both sides. It
+// returns "skip" if the target is up to date, "copy" if it must be replaced
+// and "conflict" if it differs but the conflict policy keeps it.
+func getReplicationAction(ctx context.Context, remote *s3.Client, rule *schema.ReplicationRule, src *types.Object, dst *types.Object) (string, error) {
+	if aws.ToInt64(src.Size) == aws.ToInt64(dst.Size) && aws.ToString(src.ETag) == aws.ToString(dst.ETag) {
+		return "skip", nil
+	}
+
+	head, err := remote.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(rule.TargetBucket),
+		Key:    dst.Key,
+	})
+	if err != nil {
+		return "", err
+	}
+	// A copy made by the replication is outdated once the source changed,
+	// only objects written by others are subject to the conflict policy
+	switch head.Metadata[replicationMetaSourceETag] {
+	case strings.Trim(aws.ToString(src.ETag), "\""):
+		return "skip", nil
+	case "":
+	default:
+		return "copy", nil
+	}
+
+	switch rule.Conflict {
+	case schema.ReplicationConflictOverwrite:
+		return "copy", nil
+	case schema.ReplicationConflictNewer:
+		if src.LastModified != nil && dst.LastModified != nil && src.LastModified.After(*dst.LastModified) {
+			return "copy", nil
+		}
+	}
+	return "conflict", nil
+}
+
+// deleteReplicatedObject deletes an object that no longer exists in the
+// source if the rule propagates deletions. Objects not created by the
+// replication are kept.
+func deleteReplicatedObject(ctx context.Context, remote *s3.Client, rule *schema.ReplicationRule, dst *types.Object) (bool, error) {
+	if !rule.Delete {
+		return false, nil
+	}
+
+	head, err := remote.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(rule.TargetBucket),
+		Key:    dst.Key,
+	})
+	if err != nil {
+		return false, err
+	}
+	if head.Metadata[replicationMetaSourceETag] == "" {
+		return false, nil
+	}
+
+	_, err = remote.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(rule.TargetBucket),
+		Key:    dst.Key,
+	})
+	return err == nil, err
+}
+
+// replicateObject streams the object to the target with its metadata. Large
+// objects are uploaded in parts, as a single PutObject is limited to 5 GiB.
+func replicateObject(ctx context.Context, client *s3.Client, bucket string, remote *s3.Client, rule *schema.ReplicationRule, src *types.Object) error {
+	object, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    src.Key,
+	})
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	metadata := map[string]string{}
+	for k, v := range object.Metadata {
+		metadata[k] = v
+	}
+	metadata[replicationMetaSourceETag] = strings.Trim(aws.ToString(object.ETag), "\"")
+
+	uploader := manager.NewUploader(remote, func(u *manager.Uploader) {
+		u.PartSize = replicationPartSize(aws.ToInt64(object.ContentLength))
+		u.Concurrency = replicationPartConcurrency
+	})
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:             aws.String(rule.TargetBucket),
+		Key:                aws.String(rule.TargetPrefix + strings.TrimPrefix(*src.Key, rule.Prefix)),
+		Body:               object.Body,
+		ContentType:        object.ContentType,
+		ContentEncoding:    object.ContentEncoding,
+		ContentDisposition: object.ContentDisposition,
+		CacheControl:       object.CacheControl,
+		Metadata:           metadata,
+	})
+	return err
+}
+
+// replicationPartSize returns the part size for an object of the size, so
+// it fits in the maximum number of parts.
+func replicationPartSize(size int64) int64 {
+	partSize := int64(replicationMinPartSize)
+	maxParts := int64(manager.MaxUploadParts)
+	if size > partSize*maxParts {
+		partSize = (size + maxParts - 1) / maxParts
+	}
+	return partSize
+}
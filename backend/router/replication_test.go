@@ -0,0 +1,142 @@
+package router
+
+import (
+	"bytes"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestReplicationPartSize(t *testing.T) {
+	const maxParts = int64(manager.MaxUploadParts)
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		{0, replicationMinPartSize},
+		{6 << 30, replicationMinPartSize},
+		{replicationMinPartSize * maxParts, replicationMinPartSize},
+		{replicationMinPartSize*maxParts + 1, replicationMinPartSize + 1},
+		{5 << 40, (5<<40 + maxParts - 1) / maxParts},
+	}
+
+	for _, tt := range tests {
+		got := replicationPartSize(tt.size)
+		if got != tt.want {
+			t.Errorf("replicationPartSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+		if got*maxParts < tt.size {
+			t.Errorf("replicationPartSize(%d) = %d does not fit in %d parts", tt.size, got, maxParts)
+		}
+	}
+}
+
+func TestReplicateObject(t *testing.T) {
+	tests := []struct {
+		name          string
+		size          int
+		wantMultipart bool
+	}{
+		{"small object", 1024, false},
+		{"object larger than a part", replicationMinPartSize*2 + 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, server := newS3Stub(t)
+			data := bytes.Repeat([]byte("0123456789abcdef"), tt.size/16+1)[:tt.size]
+			stub.put("source", "dir/object.bin", data, map[string]string{"owner": "alice"})
+			source := stub.get("source", "dir/object.bin")
+			source.contentType = "application/octet-stream"
+
+			client := utils.S3Clients.Get("GKsource", "secret")
+			remote, err := utils.S3Clients.NewRemote(schema.ReplicationTarget{Endpoint: server.URL, Region: "garage", AccessKeyID: "GKremote", SecretAccessKey: "secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rule := &schema.ReplicationRule{Prefix: "dir/", TargetBucket: "target", TargetPrefix: "backup/"}
+			src := &types.Object{Key: aws.String("dir/object.bin"), Size: aws.Int64(int64(tt.size))}
+			if err := replicateObject(t.Context(), client, "source", remote, rule, src); err != nil {
+				t.Fatalf("replicateObject: %v", err)
+			}
+
+			copied := stub.get("target", "backup/object.bin")
+			if copied == nil {
+				t.Fatal("object was not replicated")
+			}
+			if !bytes.Equal(copied.data, data) {
+				t.Errorf("replicated %d bytes differing from the %d source bytes", len(copied.data), len(data))
+			}
+			if copied.contentType != "application/octet-stream" || copied.metadata["owner"] != "alice" {
+				t.Errorf("content type %q and metadata %v were not kept", copied.contentType, copied.metadata)
+			}
+			if copied.metadata[replicationMetaSourceETag] != source.etag {
+				t.Errorf("source etag = %q, want %q", copied.metadata[replicationMetaSourceETag], source.etag)
+			}
+
+			multipart := stub.count("CreateMultipartUpload target/") > 0
+			if multipart != tt.wantMultipart || strings.Contains(copied.etag, "-") != tt.wantMultipart {
+				t.Errorf("multipart upload = %v, want %v", multipart, tt.wantMultipart)
+			}
+		})
+	}
+}
+
+func TestReplicationActionAfterSourceChange(t *testing.T) {
+	tests := []struct {
+		name       string
+		conflict   string
+		replicated bool
+		want       string
+	}{
+		{"replicated copy with skip", schema.ReplicationConflictSkip, true, "copy"},
+		{"replicated copy with newer", schema.ReplicationConflictNewer, true, "copy"},
+		{"foreign object with skip", schema.ReplicationConflictSkip, false, "conflict"},
+		{"foreign object with overwrite", schema.ReplicationConflictOverwrite, false, "copy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, server := newS3Stub(t)
+			client := utils.S3Clients.Get("GKsource", "secret")
+			remote, err := utils.S3Clients.NewRemote(schema.ReplicationTarget{Endpoint: server.URL, Region: "garage", AccessKeyID: "GKremote", SecretAccessKey: "secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule := &schema.ReplicationRule{TargetBucket: "target", Conflict: tt.conflict}
+
+			stub.put("source", "object.txt", []byte("first"), nil)
+			if tt.replicated {
+				src := &types.Object{Key: aws.String("object.txt"), Size: aws.Int64(5)}
+				if err := replicateObject(t.Context(), client, "source", remote, rule, src); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				stub.put("target", "object.txt", []byte("other"), nil)
+			}
+
+			// The source changes after the target was written, with a
+			// timestamp the target cluster sees as older
+			stub.put("source", "object.txt", []byte("second"), nil)
+			source := stub.get("source", "object.txt")
+			target := stub.get("target", "object.txt")
+			src := &types.Object{Key: aws.String("object.txt"), Size: aws.Int64(6), ETag: aws.String(`"` + source.etag + `"`), LastModified: aws.Time(target.modified.Add(-time.Hour))}
+			dst := &types.Object{Key: aws.String("object.txt"), Size: aws.Int64(int64(len(target.data))), ETag: aws.String(`"` + target.etag + `"`), LastModified: aws.Time(target.modified)}
+
+			got, err := getReplicationAction(t.Context(), remote, rule, src, dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("action = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,545 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+const reportMonthLayout = "2006-01"
+
+var errReportNotCovered = errors.New("the metrics history does not cover the report month")
+
+type Reports struct{}
+
+type reportScheduler struct {
+	mu        sync.Mutex
+	config    schema.ReportConfig
+	statePath string
+	lastSent  string
+}
+
+var usageReports = &reportScheduler{}
+
+// reportState is persisted so a restart does not send a report twice.
+type reportState struct {
+	LastSent string `json:"lastSent"`
+}
+
+// reportOwner is an owner a bucket is attributed to.
+type reportOwner struct {
+	name string
+	kind string
+}
+
+// InitReports loads the tenants and the schedule from REPORT_CONFIG and
+// mails the monthly report on the configured day.
+func InitReports() {
+	configPath := os.Getenv("REPORT_CONFIG")
+	if configPath == "" {
+		return
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		slog.Error("Cannot read report config.", "error", err)
+		return
+	}
+
+	var config schema.ReportConfig
+	if err := toml.Unmarshal(data, &config); err != nil {
+		slog.Error("Cannot parse report config.", "error", err)
+		return
+	}
+	if err := validateReportConfig(&config); err != nil {
+		slog.Error("Invalid report config.", "error", err)
+		return
+	}
+
+	usageReports.mu.Lock()
+	usageReports.config = config
+	usageReports.statePath = utils.GetEnv("REPORT_STATE_FILE", utils.DataPath("reports.json"))
+	usageReports.mu.Unlock()
+
+	if config.Schedule.DayOfMonth > 0 {
+		// The previous month has to be kept until the report is sent
+		required := time.Duration(config.Schedule.DayOfMonth+31) * 24 * time.Hour
+		if clusterHistory.retention > 0 && clusterHistory.retention < required {
+			slog.Error("HISTORY_RETENTION is too short for the monthly usage report, it cannot be built.",
+				"retention", clusterHistory.retention, "required", required)
+		}
+
+		usageReports.loadState()
+		go usageReports.schedule()
+	}
+}
+
+func validateReportConfig(config *schema.ReportConfig) error {
+	names := map[string]bool{}
+	for _, tenant := range config.Tenants {
+		if tenant.Name == "" || names[tenant.Name] {
+			return fmt.Errorf("tenants need a unique name, got %q", tenant.Name)
+		}
+		names[tenant.Name] = true
+
+		for _, pattern := range tenant.Buckets {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid bucket pattern %q of tenant %s", pattern, tenant.Name)
+			}
+		}
+	}
+
+	schedule := &config.Schedule
+	if schedule.DayOfMonth < 0 || schedule.DayOfMonth > 28 {
+		return fmt.Errorf("day_of_month must be between 1 and 28, got %d", schedule.DayOfMonth)
+	}
+	switch schedule.Format {
+	case "":
+		schedule.Format = "csv"
+	case "csv", "json":
+	default:
+		return fmt.Errorf("unknown report format %q", schedule.Format)
+	}
+	if schedule.DayOfMonth > 0 && len(schedule.To) == 0 {
+		return errors.New("the schedule needs recipients")
+	}
+	if len(schedule.To) > 0 && (config.SMTP.Host == "" || config.SMTP.From == "") {
+		return errors.New("mailing reports needs the smtp host and from address")
+	}
+	if config.SMTP.Port == 0 {
+		config.SMTP.Port = 587
+	}
+
+	return nil
+}
+
+func (s *reportScheduler) schedule() {
+	for {
+		s.check()
+		time.Sleep(time.Hour)
+	}
+}
+
+// check mails the report of the previous month once the day of the month
+// is reached, unless it was sent already.
+func (s *reportScheduler) check() {
+	s.mu.Lock()
+	config := s.config
+	lastSent := s.lastSent
+	s.mu.Unlock()
+
+	now := time.Now().UTC()
+	if now.Day() < config.Schedule.DayOfMonth {
+		return
+	}
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	if lastSent == month.Format(reportMonthLayout) {
+		return
+	}
+
+	ctx, span := utils.StartTask(context.Background(), "usage report")
+	err := sendUsageReport(ctx, config, month)
+	utils.EndSpan(span, err)
+	if err != nil {
+		slog.Error("Cannot send usage report.", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSent = month.Format(reportMonthLayout)
+	if err := s.saveStateLocked(); err != nil {
+		slog.Error("Cannot save report state.", "error", err)
+	}
+}
+
+func (s *reportScheduler) loadState() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.statePath)
+	if err != nil {
+		return
+	}
+	var saved reportState
+	if err := json.Unmarshal(data, &saved); err == nil {
+		s.lastSent = saved.LastSent
+	}
+}
+
+func (s *reportScheduler) saveStateLocked() error {
+	data, err := json.Marshal(reportState{LastSent: s.lastSent})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.statePath+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(s.statePath+".tmp", s.statePath)
+}
+
+func sendUsageReport(ctx context.Context, config schema.ReportConfig, month time.Time) error {
+	report, err := buildUsageReport(ctx, config, month)
+	if err != nil {
+		return err
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Storage usage from %s to %s (%d days with samples), %.2f GB-days in total.\r\n\r\n",
+		report.From.Format(time.DateOnly), report.To.Format(time.DateOnly), report.Days, report.GBDays)
+	if len(report.MissingDays) > 0 {
+		fmt.Fprintf(&body, "No usage was recorded on %d days: %s.\r\n\r\n", len(report.MissingDays), strings.Join(report.MissingDays, ", "))
+	}
+	for _, owner := range report.Owners {
+		fmt.Fprintf(&body, "%s (%s): %.2f GB-days, %.0f objects on average\r\n", owner.Owner, owner.Kind, owner.GBDays, owner.AverageObjects)
+	}
+
+	var data bytes.Buffer
+	attachment := utils.MailAttachment{Name: "usage-" + report.Month + "." + config.Schedule.Format}
+	if config.Schedule.Format == "json" {
+		attachment.ContentType = "application/json"
+		if err := json.NewEncoder(&data).Encode(report); err != nil {
+			return err
+		}
+	} else {
+		attachment.ContentType = "text/csv"
+		if err := writeUsageReportCSV(&data, report); err != nil {
+			return err
+		}
+	}
+	attachment.Data = data.Bytes()
+
+	return utils.SendMail(config.SMTP, config.Schedule.To, "Storage usage report "+report.Month, body.String(), attachment)
+}
+
+// attributeBucket returns the owners of a bucket: the tenant whose bucket
+// patterns match it, or else the tenants or keys of its owner keys.
+func attributeBucket(config schema.ReportConfig, id string, names []string, info *schema.Bucket) []reportOwner {
+	for _, tenant := range config.Tenants {
+		for _, pattern := range tenant.Buckets {
+			for _, name := range append([]string{id}, names...) {
+				if ok, _ := path.Match(pattern, name); ok {
+					return []reportOwner{{name: tenant.Name, kind: schema.ReportOwnerTenant}}
+				}
+			}
+		}
+	}
+
+	var owners []reportOwner
+	seen := map[reportOwner]bool{}
+	if info != nil {
+		for _, key := range info.Keys {
+			if !key.Permissions.Owner || isTemporaryKey(key.Name) {
+				continue
+			}
+
+			owner := reportOwner{name: key.Name, kind: schema.ReportOwnerKey}
+			if owner.name == "" {
+				owner.name = key.AccessKeyID
+			}
+			for _, tenant := range config.Tenants {
+				if containsString(tenant.Keys, key.Name) || containsString(tenant.Keys, key.AccessKeyID) {
+					owner = reportOwner{name: tenant.Name, kind: schema.ReportOwnerTenant}
+					break
+				}
+			}
+
+			if !seen[owner] {
+				seen[owner] = true
+				owners = append(owners, owner)
+			}
+		}
+	}
+
+	if len(owners) == 0 {
+		return []reportOwner{{name: schema.ReportOwnerUnassigned, kind: schema.ReportOwnerUnassigned}}
+	}
+	return owners
+}
+
+// summarizeBucketUsage averages the samples of a bucket per day.
+func summarizeBucketUsage(id string, from time.Time, to time.Time) (schema.UsageReportBucket, string, map[string]bool, error) {
+	type dayTotal struct {
+		bytes, objects float64
+		samples        int
+	}
+	summary := schema.UsageReportBucket{ID: id}
+	totals := map[string]*dayTotal{}
+	var name string
+
+	err := utils.History.Range(historyBucketPrefix+id, from, to.Add(-time.Nanosecond), func(at time.Time, data []byte) error {
+		var sample schema.BucketSample
+		if err := json.Unmarshal(data, &sample); err != nil {
+			return err
+		}
+		if sample.Name != "" {
+			name = sample.Name
+		}
+
+		day := at.UTC().Format(time.DateOnly)
+		total, ok := totals[day]
+		if !ok {
+			total = &dayTotal{}
+			totals[day] = total
+		}
+		total.bytes += float64(sample.Bytes)
+		total.objects += float64(sample.Objects)
+		total.samples++
+		summary.PeakBytes = max(summary.PeakBytes, float64(sample.Bytes))
+		return nil
+	})
+
+	days := map[string]bool{}
+	for day, total := range totals {
+		days[day] = true
+		averageBytes := total.bytes / float64(total.samples)
+		summary.GBDays += averageBytes / 1e9
+		summary.AverageBytes += averageBytes
+		summary.AverageObjects += total.objects / float64(total.samples)
+	}
+	summary.Days = len(totals)
+	if summary.Days > 0 {
+		summary.AverageBytes /= float64(summary.Days)
+		summary.AverageObjects /= float64(summary.Days)
+	}
+
+	return summary, name, days, err
+}
+
+// buildUsageReport attributes the bucket usage in the month to the owners.
+// Ownership is taken from the current bucket permissions.
+func buildUsageReport(ctx context.Context, config schema.ReportConfig, month time.Time) (*schema.UsageReport, error) {
+	if utils.History == nil {
+		return nil, errHistoryDisabled
+	}
+
+	now := time.Now().UTC()
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	if to.After(now) {
+		to = now
+	}
+	if clusterHistory.retention > 0 && from.Before(now.Add(-clusterHistory.retention)) {
+		return nil, fmt.Errorf("%w: samples are kept for %s (HISTORY_RETENTION)", errReportNotCovered, clusterHistory.retention)
+	}
+
+	buckets, err := listBucketInfos(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list buckets: %w", err)
+	}
+	infos := map[string]*schema.Bucket{}
+	for i := range buckets {
+		infos[buckets[i].ID] = &buckets[i]
+	}
+
+	series, err := utils.History.Series(historyBucketPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read bucket history: %w", err)
+	}
+
+	report := &schema.UsageReport{
+		Month:       from.Format(reportMonthLayout),
+		From:        from,
+		To:          to,
+		GeneratedAt: now,
+		Owners:      []schema.UsageReportOwner{},
+	}
+	owners := map[reportOwner]*schema.UsageReportOwner{}
+	allDays := map[string]bool{}
+
+	for _, name := range series {
+		id := strings.TrimPrefix(name, historyBucketPrefix)
+		summary, sampleName, days, err := summarizeBucketUsage(id, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read usage of bucket %s: %w", id, err)
+		}
+		if summary.Days == 0 {
+			continue
+		}
+		for day := range days {
+			allDays[day] = true
+		}
+
+		info := infos[id]
+		var aliases []string
+		if info != nil {
+			aliases = info.GlobalAliases
+		} else if sampleName != "" {
+			aliases = []string{sampleName}
+		}
+		summary.Name = sampleName
+		if len(aliases) > 0 {
+			summary.Name = aliases[0]
+		}
+
+		bucketOwners := attributeBucket(config, id, aliases, info)
+		share := 1 / float64(len(bucketOwners))
+		for _, owner := range bucketOwners {
+			entry, ok := owners[owner]
+			if !ok {
+				entry = &schema.UsageReportOwner{Owner: owner.name, Kind: owner.kind}
+				owners[owner] = entry
+			}
+
+			part := summary
+			part.Share = share
+			part.GBDays *= share
+			part.AverageBytes *= share
+			part.AverageObjects *= share
+			part.PeakBytes *= share
+
+			entry.Buckets = append(entry.Buckets, part)
+			entry.GBDays += part.GBDays
+			entry.AverageBytes += part.AverageBytes
+			entry.AverageObjects += part.AverageObjects
+			entry.PeakBytes += part.PeakBytes
+			report.GBDays += part.GBDays
+		}
+	}
+
+	for _, owner := range owners {
+		sort.Slice(owner.Buckets, func(i, j int) bool { return owner.Buckets[i].GBDays > owner.Buckets[j].GBDays })
+		report.Owners = append(report.Owners, *owner)
+	}
+	sort.Slice(report.Owners, func(i, j int) bool {
+		if report.Owners[i].GBDays != report.Owners[j].GBDays {
+			return report.Owners[i].GBDays > report.Owners[j].GBDays
+		}
+		return report.Owners[i].Owner < report.Owners[j].Owner
+	})
+	report.Days = len(allDays)
+	report.MissingDays = []string{}
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !allDays[day.Format(time.DateOnly)] {
+			report.MissingDays = append(report.MissingDays, day.Format(time.DateOnly))
+		}
+	}
+
+	return report, nil
+}
+
+// writeUsageReportCSV writes a row per bucket and owner.
+func writeUsageReportCSV(w io.Writer, report *schema.UsageReport) error {
+	formatFloat := func(v float64, precision int) string {
+		return strconv.FormatFloat(v, 'f', precision, 64)
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"month", "owner", "kind", "bucket_id", "bucket_name", "share", "days", "gb_days", "average_bytes", "average_objects", "peak_bytes"})
+	for _, owner := range report.Owners {
+		for _, bucket := range owner.Buckets {
+			writer.Write([]string{
+				report.Month,
+				owner.Owner,
+				owner.Kind,
+				bucket.ID,
+				bucket.Name,
+				formatFloat(bucket.Share, 4),
+				strconv.Itoa(bucket.Days),
+				formatFloat(bucket.GBDays, 4),
+				formatFloat(bucket.AverageBytes, 0),
+				formatFloat(bucket.AverageObjects, 0),
+				formatFloat(bucket.PeakBytes, 0),
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func parseReportMonth(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	month, err := time.Parse(reportMonthLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid month %q, expected YYYY-MM", value)
+	}
+	return month, nil
+}
+
+// GetUsage returns the usage report of the month given as YYYY-MM, the
+// current month by default. With ?format=csv the report is a CSV file.
+func (rp *Reports) GetUsage(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	month, err := parseReportMonth(r.URL.Query().Get("month"), time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	usageReports.mu.Lock()
+	config := usageReports.config
+	usageReports.mu.Unlock()
+
+	report, err := buildUsageReport(r.Context(), config, month)
+	if errors.Is(err, errHistoryDisabled) {
+		utils.ResponseErrorStatus(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, errReportNotCovered) {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot build usage report: %w", err))
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s.csv"`, report.Month))
+		writeUsageReportCSV(w, report)
+		return
+	}
+
+	utils.ResponseSuccess(w, report)
+}
+
+// SendUsage mails the report of the month, the previous month by default,
+// to the recipients of the schedule.
+func (rp *Reports) SendUsage(w http.ResponseWriter, r *http.Request) {
+	usageReports.mu.Lock()
+	config := usageReports.config
+	usageReports.mu.Unlock()
+
+	if len(config.Schedule.To) == 0 {
+		utils.ResponseErrorStatus(w, errors.New("no report recipients configured"), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	month, err := parseReportMonth(r.URL.Query().Get("month"), time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0))
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := sendUsageReport(r.Context(), config, month); err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot send usage report: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, map[string]bool{"ok": true})
+}
@@ -0,0 +1,199 @@
+package router
+
+import (
+	"errors"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildUsageReport(t *testing.T) {
+	month := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int, hour int) time.Time { return month.AddDate(0, 0, n-1).Add(time.Duration(hour) * time.Hour) }
+
+	type sample struct {
+		bucket string
+		at     time.Time
+		bytes  int64
+	}
+	everyDay := func(bucket string, bytes int64) []sample {
+		var samples []sample
+		for n := 1; n <= 29; n++ {
+			samples = append(samples, sample{bucket, day(n, 12), bytes})
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name        string
+		samples     []sample
+		wantGBDays  map[string]float64
+		wantDays    int
+		wantMissing int
+	}{
+		{
+			name:       "constant usage",
+			samples:    everyDay("b1", 2e9),
+			wantGBDays: map[string]float64{"tenant-a": 58},
+			wantDays:   29,
+		},
+		{
+			name: "samples averaged per day",
+			samples: []sample{
+				{"b1", day(1, 0), 1e9}, {"b1", day(1, 12), 3e9},
+				{"b1", day(2, 6), 4e9},
+			},
+			wantGBDays:  map[string]float64{"tenant-a": 2 + 4},
+			wantDays:    2,
+			wantMissing: 27,
+		},
+		{
+			name:        "samples outside of the month",
+			samples:     []sample{{"b1", day(0, 23), 5e9}, {"b1", day(15, 0), 1e9}, {"b1", day(30, 0), 5e9}},
+			wantGBDays:  map[string]float64{"tenant-a": 1},
+			wantDays:    1,
+			wantMissing: 28,
+		},
+		{
+			name:       "bucket split between owner keys",
+			samples:    everyDay("b2", 1e9),
+			wantGBDays: map[string]float64{"key-1": 14.5, "key-2": 14.5},
+			wantDays:   29,
+		},
+		{
+			name:        "no samples",
+			wantGBDays:  map[string]float64{},
+			wantMissing: 29,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newHistoryStore(t)
+			garage := newGarageStub(t)
+			garage.respond("ListBuckets", http.StatusOK, []schema.GetBucketsRes{{ID: "b1"}, {ID: "b2"}})
+			garage.handle("GetBucketInfo", func(r *http.Request, _ map[string]interface{}) (int, interface{}) {
+				if r.URL.Query().Get("id") == "b1" {
+					return http.StatusOK, schema.Bucket{ID: "b1", GlobalAliases: []string{"photos"}}
+				}
+				return http.StatusOK, schema.Bucket{ID: "b2", GlobalAliases: []string{"docs"}, Keys: []schema.KeyElement{
+					{AccessKeyID: "GK1", Name: "key-1", Permissions: schema.Permissions{Owner: true}},
+					{AccessKeyID: "GK2", Name: "key-2", Permissions: schema.Permissions{Owner: true}},
+				}}
+			})
+			for _, s := range tt.samples {
+				if err := utils.History.Append(historyBucketPrefix+s.bucket, s.at, schema.BucketSample{Time: s.at, Bytes: s.bytes}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config := schema.ReportConfig{Tenants: []schema.ReportTenant{{Name: "tenant-a", Buckets: []string{"photos"}}}}
+			report, err := buildUsageReport(t.Context(), config, month)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]float64{}
+			for _, owner := range report.Owners {
+				got[owner.Owner] = owner.GBDays
+			}
+			if len(got) != len(tt.wantGBDays) {
+				t.Errorf("owners = %v, want %v", got, tt.wantGBDays)
+			}
+			var total float64
+			for owner, want := range tt.wantGBDays {
+				total += want
+				if math.Abs(got[owner]-want) > 1e-9 {
+					t.Errorf("GB-days of %s = %v, want %v", owner, got[owner], want)
+				}
+			}
+			if math.Abs(report.GBDays-total) > 1e-9 {
+				t.Errorf("total GB-days = %v, want %v", report.GBDays, total)
+			}
+			if report.Days != tt.wantDays || len(report.MissingDays) != tt.wantMissing {
+				t.Errorf("days = %d, missing %d, want %d and %d missing", report.Days, len(report.MissingDays), tt.wantDays, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestBuildUsageReportRetention(t *testing.T) {
+	newHistoryStore(t)
+	retention := clusterHistory.retention
+	clusterHistory.retention = 720 * time.Hour
+	t.Cleanup(func() { clusterHistory.retention = retention })
+
+	now := time.Now().UTC()
+	tests := []struct {
+		name    string
+		month   time.Time
+		wantErr bool
+	}{
+		{"current month", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), false},
+		{"older than the retention", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -2, 0), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			garage := newGarageStub(t)
+			garage.respond("ListBuckets", http.StatusOK, []schema.GetBucketsRes{})
+
+			_, err := buildUsageReport(t.Context(), schema.ReportConfig{}, tt.month)
+			if errors.Is(err, errReportNotCovered) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReportStatePath(t *testing.T) {
+	tests := []struct {
+		name      string
+		stateFile string
+		want      string
+	}{
+		{"data dir", "", "reports.json"},
+		{"state file", "reports/state.json", "reports/state.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			configPath := filepath.Join(dir, "reports.toml")
+			if err := os.WriteFile(configPath, []byte("[schedule]\nformat = \"csv\"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("REPORT_CONFIG", configPath)
+			t.Setenv("DATA_DIR", filepath.Join(dir, "data"))
+			stateFile := tt.stateFile
+			if stateFile != "" {
+				stateFile = filepath.Join(dir, "data", stateFile)
+			}
+			t.Setenv("REPORT_STATE_FILE", stateFile)
+
+			previous := usageReports
+			usageReports = &reportScheduler{}
+			t.Cleanup(func() { usageReports = previous })
+
+			InitReports()
+			usageReports.mu.Lock()
+			usageReports.lastSent = "2024-02"
+			err := usageReports.saveStateLocked()
+			usageReports.mu.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reloaded := &reportScheduler{statePath: filepath.Join(dir, "data", tt.want)}
+			reloaded.loadState()
+			if reloaded.lastSent != "2024-02" {
+				t.Errorf("last sent = %q, want 2024-02", reloaded.lastSent)
+			}
+		})
+	}
+}
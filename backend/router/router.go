@@ -5,7 +5,7 @@ import (
 	"net/http"
 )
 
-func HandleApiRouter() *http.ServeMux {
+func HandleApiRouter() http.Handler {
 	mux := http.NewServeMux()
 
 	auth := NewAuth()
@@ -28,11 +28,31 @@ func HandleApiRouter() *http.ServeMux {
 
 	buckets := &Buckets{}
 	router.HandleFunc("GET /buckets", buckets.GetAll)
+	router.HandleFunc("POST /buckets", buckets.Create)
 	router.HandleFunc("POST /buckets/force-delete", buckets.ForceDelete)
+	router.HandleFunc("GET /buckets/{id}/export", buckets.Export)
+	router.HandleFunc("POST /buckets/import", buckets.Import)
+
+	templates := &Templates{}
+	router.HandleFunc("GET /templates", templates.GetAll)
+	router.HandleFunc("PUT /templates/{name}", templates.Put)
+	router.HandleFunc("DELETE /templates/{name}", templates.Delete)
 
 	stats := &Stats{}
 	router.HandleFunc("GET /stats/cluster", stats.GetClusterStats)
 	router.HandleFunc("GET /stats/nodes", stats.GetNodeStats)
+	router.HandleFunc("GET /stats/health", stats.GetHealth)
+
+	history := &History{}
+	router.HandleFunc("GET /stats/history/cluster", history.GetCluster)
+	router.HandleFunc("GET /stats/history/buckets", history.GetBuckets)
+	router.HandleFunc("GET /stats/history/buckets/{id}", history.GetBucket)
+	router.HandleFunc("GET /stats/history/nodes", history.GetNodes)
+
+	forecast := &Forecast{}
+	router.HandleFunc("GET /stats/forecast/cluster", forecast.GetCluster)
+	router.HandleFunc("GET /stats/forecast/buckets", forecast.GetBuckets)
+	router.HandleFunc("GET /stats/forecast/buckets/{id}", forecast.GetBucket)
 
 	lifecycle := &Lifecycle{}
 	router.HandleFunc("GET /lifecycle/{bucket}", lifecycle.GetLifecycle)
@@ -44,10 +64,63 @@ func HandleApiRouter() *http.ServeMux {
 	router.HandleFunc("GET /browse/{bucket}/{key...}", browse.GetOneObject)
 	router.HandleFunc("PUT /browse/{bucket}/{key...}", browse.PutObject)
 	router.HandleFunc("DELETE /browse/{bucket}/{key...}", browse.DeleteObject)
+	router.HandleFunc("POST /verify/{bucket}/{key...}", browse.VerifyObject)
+
+	versions := &Versions{}
+	router.HandleFunc("GET /versions/{bucket}", versions.GetVersions)
+	router.HandleFunc("POST /versions/{bucket}/{key...}", versions.RestoreVersion)
+	router.HandleFunc("DELETE /versions/{bucket}/{key...}", versions.DeleteVersion)
+
+	preview := &Preview{}
+	router.HandleFunc("GET /preview/{bucket}/{key...}", preview.GetPreview)
+
+	jobs := &Jobs{}
+	router.HandleFunc("GET /jobs", jobs.GetAll)
+	router.HandleFunc("GET /jobs/{id}", jobs.GetOne)
+	router.HandleFunc("DELETE /jobs/{id}", jobs.Cancel)
+
+	dedup := &Dedup{}
+	router.HandleFunc("GET /dedup/{bucket}", dedup.GetReport)
+	router.HandleFunc("POST /dedup/{bucket}", dedup.StartReport)
+
+	bucketSync := &Sync{}
+	router.HandleFunc("POST /sync", bucketSync.StartSync)
+
+	replication := &Replication{}
+	router.HandleFunc("GET /replication", replication.GetStatus)
+	router.HandleFunc("POST /replication/{name}/run", replication.Run)
+
+	reconcile := &Reconcile{}
+	router.HandleFunc("POST /reconcile/plan", reconcile.Plan)
+	router.HandleFunc("POST /reconcile/apply", reconcile.Apply)
+	router.HandleFunc("GET /reconcile/status", reconcile.GetStatus)
+
+	reports := &Reports{}
+	router.HandleFunc("GET /reports/usage", reports.GetUsage)
+	router.HandleFunc("POST /reports/usage/send", reports.SendUsage)
+
+	events := &Events{}
+	router.HandleFunc("GET /events", events.Stream)
+
+	alerts := &Alerts{}
+	router.HandleFunc("GET /alerts", alerts.GetStatus)
+	router.HandleFunc("POST /alerts/evaluate", alerts.Evaluate)
+	router.HandleFunc("GET /alerts/history", alerts.GetHistory)
+	router.HandleFunc("POST /alerts/test", alerts.Test)
+	router.HandleFunc("GET /alerts/silences", alerts.GetSilences)
+	router.HandleFunc("POST /alerts/silences", alerts.CreateSilence)
+	router.HandleFunc("DELETE /alerts/silences/{id}", alerts.DeleteSilence)
+
+	snapshots := &Snapshots{}
+	router.HandleFunc("GET /snapshots", snapshots.GetAll)
+	router.HandleFunc("POST /snapshots", snapshots.Create)
+	router.HandleFunc("GET /snapshots/diff", snapshots.Diff)
+	router.HandleFunc("GET /snapshots/{name}", snapshots.GetOne)
+	router.HandleFunc("POST /snapshots/{name}/restore", snapshots.Restore)
 
 	// Proxy request to garage api endpoint
 	router.HandleFunc("/", ProxyHandler)
 
 	mux.Handle("/", middleware.AuthMiddleware(auth.IsEnabled(), router))
-	return mux
+	return middleware.TracingMiddleware(middleware.MetricsMiddleware(mux))
 }
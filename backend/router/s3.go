@@ -0,0 +1,406 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+var (
+	errNoBucketKey    = errors.New("no access key with read permission is assigned to this bucket")
+	errBucketReadOnly = errors.New("bucket is read-only: the web UI only has a read-only key for it")
+	errBucketNoAlias  = errors.New("bucket has no alias and cannot be accessed via S3")
+)
+
+type bucketCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Bucket          string
+	ReadOnly        bool
+}
+
+// getBucketInfo looks up a bucket by its global alias, falling back to its
+// ID so buckets without a global alias can be addressed too.
+func getBucketInfo(ctx context.Context, bucket string) (*schema.Bucket, error) {
+	body, err := utils.Garage.Fetch("/v2/GetBucketInfo", &utils.FetchOptions{
+		Context: ctx,
+		Params:  map[string]string{"globalAlias": bucket},
+	})
+	if err != nil {
+		var idErr error
+		body, idErr = utils.Garage.Fetch("/v2/GetBucketInfo", &utils.FetchOptions{
+			Context: ctx,
+			Params:  map[string]string{"id": bucket},
+		})
+		if idErr != nil {
+			return nil, err
+		}
+	}
+
+	var bucketData schema.Bucket
+	if err := json.Unmarshal(body, &bucketData); err != nil {
+		return nil, err
+	}
+	return &bucketData, nil
+}
+
+// getBucketLocalAliases returns the local aliases of the bucket by the
+// access key owning them.
+func getBucketLocalAliases(bucket *schema.Bucket) map[string]string {
+	result := map[string]string{}
+	for _, la := range bucket.LocalAliases {
+		result[la.AccessKeyID] = la.Alias
+	}
+	for _, k := range bucket.Keys {
+		if len(k.BucketLocalAliases) > 0 {
+			result[k.AccessKeyID] = k.BucketLocalAliases[0]
+		}
+	}
+	return result
+}
+
+func getBucketCredentials(r *http.Request, bucket string) (*bucketCredentials, error) {
+	if utils.SessionKeys.Enabled() {
+		return getSessionCredentials(r, bucket)
+	}
+	return getBucketKeyCredentials(r.Context(), bucket)
+}
+
+// getBucketKeyCredentials returns the credentials of an existing key of the
+// bucket, picked by selectBucketKey.
+func getBucketKeyCredentials(ctx context.Context, bucket string) (*bucketCredentials, error) {
+	cacheKey := fmt.Sprintf("key:%s", bucket)
+	cacheData := utils.Cache.Get(cacheKey)
+
+	if cacheData != nil {
+		return cacheData.(*bucketCredentials), nil
+	}
+
+	bucketData, err := getBucketInfo(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := bucketData.Keys
+	bucketName := ""
+	localAliases := getBucketLocalAliases(bucketData)
+
+	if len(bucketData.GlobalAliases) > 0 {
+		bucketName = bucketData.GlobalAliases[0]
+		if slices.Contains(bucketData.GlobalAliases, bucket) {
+			bucketName = bucket
+		}
+	} else {
+		// Local aliases only resolve for requests signed by the key owning them
+		keys = slices.DeleteFunc(slices.Clone(keys), func(k schema.KeyElement) bool {
+			return localAliases[k.AccessKeyID] == ""
+		})
+		if len(localAliases) == 0 {
+			return nil, errBucketNoAlias
+		}
+	}
+
+	configuredKey := getConfiguredBucketKeys()[bucket]
+	if configuredKey == "" {
+		configuredKey = getConfiguredBucketKeys()[bucketData.ID]
+	}
+
+	selected, err := selectBucketKey(bucket, configuredKey, keys)
+	if err != nil {
+		return nil, err
+	}
+	if bucketName == "" {
+		bucketName = localAliases[selected.AccessKeyID]
+	}
+
+	body, err := utils.Garage.Fetch(fmt.Sprintf("/v2/GetKeyInfo?id=%s&showSecretKey=true", selected.AccessKeyID), &utils.FetchOptions{Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+
+	var key schema.KeyElement
+	if err := json.Unmarshal(body, &key); err != nil {
+		return nil, err
+	}
+	if key.SecretAccessKey == "" {
+		return nil, fmt.Errorf("cannot get secret of access key %s", key.AccessKeyID)
+	}
+
+	credential := &bucketCredentials{
+		AccessKeyID:     key.AccessKeyID,
+		SecretAccessKey: key.SecretAccessKey,
+		Bucket:          bucketName,
+		ReadOnly:        !selected.Permissions.Write,
+	}
+	utils.Cache.Set(cacheKey, credential, time.Hour)
+
+	return credential, nil
+}
+
+type bucketTarget struct {
+	ID          string
+	GlobalAlias string
+}
+
+// getSessionCredentials returns a short-lived key of the current session
+// that is only allowed on the bucket, instead of borrowing a tenant's key.
+func getSessionCredentials(r *http.Request, bucket string) (*bucketCredentials, error) {
+	target, err := getBucketTarget(r.Context(), bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Buckets without a global alias get a local alias for the session key
+	localAlias := ""
+	if target.GlobalAlias == "" {
+		localAlias = getTempLocalAlias(target.ID)
+	}
+
+	key, err := utils.SessionKeys.Get(r.Context(), utils.Session.ID(r), target.ID, localAlias)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketName := target.GlobalAlias
+	if bucketName == "" {
+		bucketName = key.LocalAlias
+	}
+
+	return &bucketCredentials{
+		AccessKeyID:     key.AccessKeyID,
+		SecretAccessKey: key.SecretAccessKey,
+		Bucket:          bucketName,
+	}, nil
+}
+
+// getTempLocalAlias returns the local alias given to keys created by the web
+// UI for buckets without a global alias.
+func getTempLocalAlias(bucketID string) string {
+	return "webui-" + bucketID[:min(len(bucketID), 16)]
+}
+
+// isTemporaryKey reports whether the key was created by the web UI for a
+// browsing session or a background job.
+func isTemporaryKey(name string) bool {
+	return strings.HasPrefix(name, utils.SessionKeyPrefix) || strings.HasPrefix(name, utils.JobKeyPrefix)
+}
+
+func getBucketTarget(ctx context.Context, bucket string) (*bucketTarget, error) {
+	cacheKey := fmt.Sprintf("bucket-id:%s", bucket)
+	if cacheData := utils.Cache.Get(cacheKey); cacheData != nil {
+		return cacheData.(*bucketTarget), nil
+	}
+
+	bucketData, err := getBucketInfo(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &bucketTarget{ID: bucketData.ID}
+	if len(bucketData.GlobalAliases) > 0 {
+		target.GlobalAlias = bucketData.GlobalAliases[0]
+		if slices.Contains(bucketData.GlobalAliases, bucket) {
+			target.GlobalAlias = bucket
+		}
+	}
+
+	utils.Cache.Set(cacheKey, target, time.Hour)
+	return target, nil
+}
+
+// selectBucketKey picks the key used by the web UI to access the bucket. A key
+// configured in BUCKET_ACCESS_KEYS always wins; otherwise the key named
+// WEBUI_KEY_NAME is preferred, then read-write keys, then read-only keys.
+// Ties are broken by access key ID so the choice is deterministic.
+func selectBucketKey(bucket string, configuredKey string, keys []schema.KeyElement) (*schema.KeyElement, error) {
+	if configuredKey != "" {
+		for i := range keys {
+			if keys[i].AccessKeyID != configuredKey {
+				continue
+			}
+			if !keys[i].Permissions.Read {
+				return nil, fmt.Errorf("configured access key %s has no read permission on bucket %s", configuredKey, bucket)
+			}
+			return &keys[i], nil
+		}
+		return nil, fmt.Errorf("configured access key %s is not allowed on bucket %s", configuredKey, bucket)
+	}
+
+	webuiKeyName := utils.GetEnv("WEBUI_KEY_NAME", "webui")
+	candidates := make([]schema.KeyElement, 0, len(keys))
+	for _, k := range keys {
+		if k.Permissions.Read {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errNoBucketKey
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if (a.Name == webuiKeyName) != (b.Name == webuiKeyName) {
+			return a.Name == webuiKeyName
+		}
+		if a.Permissions.Write != b.Permissions.Write {
+			return a.Permissions.Write
+		}
+		return a.AccessKeyID < b.AccessKeyID
+	})
+
+	return &candidates[0], nil
+}
+
+// getConfiguredBucketKeys parses BUCKET_ACCESS_KEYS, a comma separated list
+// of bucket:accessKeyId pairs.
+func getConfiguredBucketKeys() map[string]string {
+	result := map[string]string{}
+	for _, pair := range strings.Split(utils.GetEnv("BUCKET_ACCESS_KEYS", ""), ",") {
+		bucket, keyID, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && bucket != "" && keyID != "" {
+			result[bucket] = keyID
+		}
+	}
+	return result
+}
+
+// invalidateBucketCredentials drops cached credentials, either of a single
+// bucket or of all buckets if bucket is empty. Resolved aliases and session
+// keys are dropped too, as aliases may have moved or grants been revoked.
+func invalidateBucketCredentials(ctx context.Context, bucket string) {
+	if bucket == "" {
+		utils.Cache.DeletePrefix("key:")
+		utils.Cache.DeletePrefix("bucket-id:")
+		utils.SessionKeys.Forget(ctx, "")
+		return
+	}
+	utils.Cache.Delete(fmt.Sprintf("key:%s", bucket))
+
+	if target, ok := utils.Cache.Get(fmt.Sprintf("bucket-id:%s", bucket)).(*bucketTarget); ok {
+		utils.Cache.Delete(fmt.Sprintf("bucket-id:%s", bucket))
+		utils.SessionKeys.Forget(ctx, target.ID)
+	}
+}
+
+// getS3Client returns a client for the bucket along with the name to use for
+// it in S3 requests, which differs from bucket if it was given by ID.
+func getS3Client(r *http.Request, bucket string) (*s3.Client, string, error) {
+	creds, err := getBucketCredentials(r, bucket)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot get credentials for bucket %s: %w", bucket, err)
+	}
+
+	return newS3Client(creds), creds.Bucket, nil
+}
+
+// getWritableS3Client is like getS3Client but fails with errBucketReadOnly if
+// the web UI only has read access to the bucket.
+func getWritableS3Client(r *http.Request, bucket string) (*s3.Client, string, error) {
+	creds, err := getBucketCredentials(r, bucket)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot get credentials for bucket %s: %w", bucket, err)
+	}
+	if creds.ReadOnly {
+		return nil, "", errBucketReadOnly
+	}
+
+	return newS3Client(creds), creds.Bucket, nil
+}
+
+// responseS3ClientError writes the error returned by getS3Client with a
+// matching status code.
+func responseS3ClientError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errBucketReadOnly), errors.Is(err, errNoBucketKey), errors.Is(err, errBucketNoAlias):
+		utils.ResponseErrorStatus(w, err, http.StatusForbidden)
+	default:
+		utils.ResponseError(w, err)
+	}
+}
+
+func newS3Client(creds *bucketCredentials) *s3.Client {
+	return utils.S3Clients.Get(creds.AccessKeyID, creds.SecretAccessKey, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, invalidateCredentialsOnDenied(creds.AccessKeyID))
+	})
+}
+
+// invalidateCredentialsOnDenied drops the cached credentials and client of the
+// access key when S3 does not know it, e.g. after the key was deleted outside
+// the web UI. Other denials, such as a key not allowed on a bucket, leave them
+// in place.
+func invalidateCredentialsOnDenied(accessKeyID string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("InvalidateBucketCredentials", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			out, md, err := next.HandleInitialize(ctx, in)
+
+			var apiErr smithy.APIError
+			if err != nil && errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidAccessKeyId" {
+				invalidateAccessKeyCredentials(accessKeyID)
+			}
+
+			return out, md, err
+		}), middleware.After)
+	}
+}
+
+// invalidateAccessKeyCredentials drops the cached credentials and client of
+// a single access key. A session key is only untracked, as it no longer
+// exists in Garage.
+func invalidateAccessKeyCredentials(accessKeyID string) {
+	utils.Cache.DeleteFunc(func(key string, value interface{}) bool {
+		creds, ok := value.(*bucketCredentials)
+		return ok && strings.HasPrefix(key, "key:") && creds.AccessKeyID == accessKeyID
+	})
+	utils.S3Clients.Remove(accessKeyID)
+	utils.SessionKeys.ForgetKey(accessKeyID)
+}
+
+// Bodies up to this size are buffered in memory by spoolBody, larger ones are
+// spooled to a temporary file
+const spoolMemoryLimit = 8 << 20
+
+// spoolBody makes a stream seekable, as uploads need a seekable body to be
+// signed. The returned function releases the buffer.
+func spoolBody(r io.Reader, size int64) (io.ReadSeeker, func(), error) {
+	if size >= 0 && size <= spoolMemoryLimit {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bytes.NewReader(data), func() {}, nil
+	}
+
+	file, err := os.CreateTemp("", "garage-webui-upload-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return file, cleanup, nil
+}
@@ -0,0 +1,64 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestInvalidateCredentialsOnDenied(t *testing.T) {
+	tests := []struct {
+		code            string
+		wantInvalidated bool
+	}{
+		{"AccessDenied", false},
+		{"SignatureDoesNotMatch", false},
+		{"NoSuchKey", false},
+		{"InvalidAccessKeyId", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>denied</Message></Error>`, tt.code)
+			}))
+			defer server.Close()
+
+			t.Setenv("S3_ENDPOINT_URL", server.URL)
+			t.Setenv("S3_MAX_ATTEMPTS", "1")
+			utils.InitCacheManager()
+			utils.InitS3ClientFactory()
+			utils.InitSessionKeyManager()
+
+			denied := &bucketCredentials{AccessKeyID: "GKdenied", SecretAccessKey: "secret", Bucket: "photos"}
+			other := &bucketCredentials{AccessKeyID: "GKother", SecretAccessKey: "secret", Bucket: "logs"}
+			utils.Cache.Set("key:photos", denied, time.Hour)
+			utils.Cache.Set("key:logs", other, time.Hour)
+
+			client := newS3Client(denied)
+			client.GetObject(context.Background(), &s3.GetObjectInput{
+				Bucket: aws.String("photos"),
+				Key:    aws.String("object"),
+			})
+
+			if invalidated := utils.Cache.Get("key:photos") == nil; invalidated != tt.wantInvalidated {
+				t.Errorf("credentials of the denied key invalidated = %v, want %v", invalidated, tt.wantInvalidated)
+			}
+			if utils.Cache.Get("key:logs") == nil {
+				t.Error("credentials of another key were invalidated")
+			}
+			if reused := newS3Client(denied) == client; reused == tt.wantInvalidated {
+				t.Errorf("client reused = %v, want %v", reused, !tt.wantInvalidated)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package router
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Stub is an in-memory stand-in for the S3 API of Garage, serving path
+// style requests for the operations used by the web UI.
+type s3Stub struct {
+	mu       sync.Mutex
+	objects  map[string]*stubObject
+	uploads  map[string]*stubUpload
+	nextID   int
+	requests []string
+}
+
+type stubObject struct {
+	data        []byte
+	contentType string
+	metadata    map[string]string
+	etag        string
+	modified    time.Time
+	// headers are added to the responses, e.g. x-amz-checksum-sha256
+	headers map[string]string
+}
+
+type stubUpload struct {
+	parts       map[int][]byte
+	contentType string
+	metadata    map[string]string
+}
+
+// newS3Stub starts the stub and points the S3 clients of the web UI to it.
+func newS3Stub(t *testing.T) (*s3Stub, *httptest.Server) {
+	stub := &s3Stub{objects: map[string]*stubObject{}, uploads: map[string]*stubUpload{}}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	t.Setenv("S3_ENDPOINT_URL", server.URL)
+	t.Setenv("S3_MAX_ATTEMPTS", "1")
+	utils.InitCacheManager()
+	utils.InitS3ClientFactory()
+	utils.InitSessionKeyManager()
+	return stub, server
+}
+
+// allowBucket caches credentials for the bucket, so handlers do not look
+// them up in the admin API.
+func allowBucket(bucket string, readOnly bool) {
+	utils.Cache.Set("key:"+bucket, &bucketCredentials{
+		AccessKeyID:     "GK" + bucket,
+		SecretAccessKey: "secret",
+		Bucket:          bucket,
+		ReadOnly:        readOnly,
+	}, time.Hour)
+}
+
+func (s *s3Stub) put(bucket string, key string, data []byte, metadata map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = &stubObject{data: data, metadata: metadata, etag: md5Hex(data), modified: time.Now()}
+}
+
+func (s *s3Stub) get(bucket string, key string) *stubObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[bucket+"/"+key]
+}
+
+func (s *s3Stub) keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for name := range s.objects {
+		if key, ok := strings.CutPrefix(name, bucket+"/"); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *s3Stub) count(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, request := range s.requests {
+		if strings.HasPrefix(request, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	// Bodies are written after unlocking, so clients can send other requests
+	// while reading them
+	var payload []byte
+	s.mu.Lock()
+	defer func() {
+		s.mu.Unlock()
+		w.Write(payload)
+	}()
+
+	operation := r.Method
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		operation = "ListObjectsV2"
+	case r.Method == http.MethodPost && query.Has("delete"):
+		operation = "DeleteObjects"
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		operation = "CreateMultipartUpload"
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		operation = "UploadPart"
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		operation = "CompleteMultipartUpload"
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		operation = "AbortMultipartUpload"
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		operation = "CopyObject"
+	case r.Method == http.MethodPut:
+		operation = "PutObject"
+	case r.Method == http.MethodGet:
+		operation = "GetObject"
+	case r.Method == http.MethodHead:
+		operation = "HeadObject"
+	case r.Method == http.MethodDelete:
+		operation = "DeleteObject"
+	}
+	s.requests = append(s.requests, operation+" "+bucket+"/"+key)
+
+	switch operation {
+	case "ListObjectsV2":
+		s.listObjects(w, bucket, query.Get("prefix"))
+
+	case "PutObject":
+		object := &stubObject{
+			data:        body,
+			contentType: r.Header.Get("Content-Type"),
+			metadata:    stubMetadata(r.Header),
+			etag:        md5Hex(body),
+			modified:    time.Now(),
+			headers:     map[string]string{},
+		}
+		for name, digest := range stubChecksums(body) {
+			value := r.Header.Get(name)
+			if value == "" {
+				continue
+			}
+			if value != digest {
+				stubError(w, http.StatusBadRequest, "BadDigest")
+				return
+			}
+			object.headers[name] = value
+		}
+		s.objects[bucket+"/"+key] = object
+		w.Header().Set("ETag", `"`+object.etag+`"`)
+
+	case "CopyObject":
+		source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		original, ok := s.objects[strings.TrimPrefix(source, "/")]
+		if !ok {
+			stubError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		object := *original
+		object.modified = time.Now()
+		if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			object.contentType = r.Header.Get("Content-Type")
+			object.metadata = stubMetadata(r.Header)
+		}
+		s.objects[bucket+"/"+key] = &object
+		fmt.Fprintf(w, `<CopyObjectResult><ETag>"%s"</ETag><LastModified>%s</LastModified></CopyObjectResult>`, object.etag, object.modified.UTC().Format(time.RFC3339))
+
+	case "GetObject", "HeadObject":
+		object, ok := s.objects[bucket+"/"+key]
+		if !ok {
+			stubError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for name, value := range object.metadata {
+			w.Header().Set("X-Amz-Meta-"+name, value)
+		}
+		for name, value := range object.headers {
+			w.Header().Set(name, value)
+		}
+		if object.contentType != "" {
+			w.Header().Set("Content-Type", object.contentType)
+		}
+		w.Header().Set("ETag", `"`+object.etag+`"`)
+		w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		if operation == "GetObject" {
+			payload = object.data
+		}
+
+	case "DeleteObject":
+		delete(s.objects, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+
+	case "DeleteObjects":
+		var request struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		if err := xml.Unmarshal(body, &request); err != nil {
+			stubError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		for _, object := range request.Objects {
+			delete(s.objects, bucket+"/"+object.Key)
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<DeleteResult></DeleteResult>`)
+
+	case "CreateMultipartUpload":
+		s.nextID++
+		uploadID := strconv.Itoa(s.nextID)
+		s.uploads[uploadID] = &stubUpload{parts: map[int][]byte{}, contentType: r.Header.Get("Content-Type"), metadata: stubMetadata(r.Header)}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, bucket, key, uploadID)
+
+	case "UploadPart":
+		upload, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			stubError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		upload.parts[partNumber] = body
+		w.Header().Set("ETag", `"`+md5Hex(body)+`"`)
+
+	case "CompleteMultipartUpload":
+		upload, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			stubError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		parts := upload.parts
+		numbers := make([]int, 0, len(parts))
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+
+		var data []byte
+		digests := md5.New()
+		for _, number := range numbers {
+			data = append(data, parts[number]...)
+			sum := md5.Sum(parts[number])
+			digests.Write(sum[:])
+		}
+		etag := fmt.Sprintf("%s-%d", hex.EncodeToString(digests.Sum(nil)), len(parts))
+		s.objects[bucket+"/"+key] = &stubObject{data: data, contentType: upload.contentType, metadata: upload.metadata, etag: etag, modified: time.Now()}
+		delete(s.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"%s"</ETag></CompleteMultipartUploadResult>`, bucket, key, etag)
+
+	case "AbortMultipartUpload":
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		stubError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *s3Stub) listObjects(w http.ResponseWriter, bucket string, prefix string) {
+	type content struct {
+		Key          string
+		Size         int64
+		ETag         string
+		LastModified string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: bucket, Prefix: prefix}
+
+	names := make([]string, 0, len(s.objects))
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key, ok := strings.CutPrefix(name, bucket+"/")
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		object := s.objects[name]
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			Size:         int64(len(object.data)),
+			ETag:         `"` + object.etag + `"`,
+			LastModified: object.modified.UTC().Format(time.RFC3339Nano),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func stubMetadata(header http.Header) map[string]string {
+	metadata := map[string]string{}
+	for name, values := range header {
+		if key, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok {
+			metadata[key] = values[0]
+		}
+	}
+	return metadata
+}
+
+// stubChecksums returns the S3 checksum headers of the content.
+func stubChecksums(data []byte) map[string]string {
+	sha := sha256.Sum256(data)
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	crc.Write(data)
+	return map[string]string{
+		"X-Amz-Checksum-Sha256": base64.StdEncoding.EncodeToString(sha[:]),
+		"X-Amz-Checksum-Crc32c": base64.StdEncoding.EncodeToString(crc.Sum(nil)),
+	}
+}
+
+func stubError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
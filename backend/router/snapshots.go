@@ -0,0 +1,1070 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	snapshotJobType = "snapshot"
+
+	// Snapshot names have microseconds so snapshots taken in the same second
+	// do not overwrite each other. Older names without them are still read.
+	snapshotTimeFormat = "20060102T150405.000000Z"
+
+	// Layout to read both kinds of names, as parsing accepts fractional
+	// seconds the layout does not have
+	snapshotParseFormat = "20060102T150405Z"
+
+	// Prefix of the snapshot objects when they are stored in a bucket
+	snapshotObjectPrefix = "config-snapshots/"
+
+	// Name given to the live configuration when comparing snapshots
+	snapshotCurrent = "current"
+)
+
+var (
+	snapshotNameRegex   = regexp.MustCompile(`^config-\d{8}T\d{6}(\.\d{6})?Z\.json$`)
+	errSnapshotNotFound = errors.New("snapshot not found")
+	errSnapshotRunning  = errors.New("a snapshot is already running")
+)
+
+type Snapshots struct{}
+
+type snapshotSettings struct {
+	dir      string
+	bucket   string
+	interval time.Duration
+	retain   int
+	secrets  bool
+}
+
+var snapshotConfig snapshotSettings
+
+// InitSnapshots reads the snapshot settings and schedules snapshots of the
+// cluster configuration if SNAPSHOT_INTERVAL is set.
+func InitSnapshots() {
+	utils.Jobs.Register(snapshotJobType, runSnapshotJob)
+
+	snapshotConfig = snapshotSettings{
+		dir:     utils.GetEnv("SNAPSHOT_DIR", utils.DataPath("snapshots")),
+		bucket:  os.Getenv("SNAPSHOT_BUCKET"),
+		retain:  30,
+		secrets: utils.GetEnv("SNAPSHOT_SECRETS", "false") == "true",
+	}
+	if retain, err := strconv.Atoi(os.Getenv("SNAPSHOT_RETAIN")); err == nil && retain >= 0 {
+		snapshotConfig.retain = retain
+	}
+
+	if value := os.Getenv("SNAPSHOT_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < time.Minute {
+			slog.Warn("Invalid SNAPSHOT_INTERVAL, scheduled snapshots disabled.", "value", value)
+			return
+		}
+		snapshotConfig.interval = interval
+		go scheduleSnapshots(interval)
+	}
+}
+
+func scheduleSnapshots(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if _, err := startSnapshot(snapshotConfig.secrets); err != nil {
+			slog.Error("Cannot start scheduled snapshot.", "error", err)
+		}
+	}
+}
+
+func startSnapshot(secrets bool) (*utils.Job, error) {
+	for _, job := range utils.Jobs.List(snapshotJobType) {
+		if job.Status == schema.JobStatusRunning {
+			return nil, errSnapshotRunning
+		}
+	}
+
+	params := map[string]string{"secrets": strconv.FormatBool(secrets)}
+	return utils.Jobs.Start(snapshotJobType, params, runSnapshotJob), nil
+}
+
+// GetAll lists the stored snapshots, newest first.
+func (sn *Snapshots) GetAll(w http.ResponseWriter, r *http.Request) {
+	store, err := openSnapshotStore(r)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	snapshots, err := store.List(r.Context())
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot list snapshots: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, snapshots)
+}
+
+// Create starts a snapshot of the cluster configuration. Secret keys are only
+// included with secrets=true.
+func (sn *Snapshots) Create(w http.ResponseWriter, r *http.Request) {
+	job, err := startSnapshot(r.URL.Query().Get("secrets") == "true")
+	if err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusConflict)
+		return
+	}
+
+	utils.ResponseSuccess(w, job.Snapshot())
+}
+
+// GetOne downloads a snapshot file.
+func (sn *Snapshots) GetOne(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	store, err := openSnapshotStore(r)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	data, err := readSnapshotFile(r.Context(), store, name)
+	if err != nil {
+		responseSnapshotError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Write(data)
+}
+
+// Diff compares two snapshots. The to parameter defaults to the current
+// configuration of the cluster. The cors rules are not compared against the
+// current configuration, as reading them needs a key owning all buckets.
+func (sn *Snapshots) Diff(w http.ResponseWriter, r *http.Request) {
+	fromName := r.URL.Query().Get("from")
+	toName := r.URL.Query().Get("to")
+	if fromName == "" {
+		utils.ResponseErrorStatus(w, errors.New("from is required"), http.StatusBadRequest)
+		return
+	}
+	if toName == "" {
+		toName = snapshotCurrent
+	}
+
+	store, err := openSnapshotStore(r)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	from, err := loadSnapshot(r.Context(), store, fromName)
+	if err != nil {
+		responseSnapshotError(w, err)
+		return
+	}
+	to, err := loadSnapshot(r.Context(), store, toName)
+	if err != nil {
+		responseSnapshotError(w, err)
+		return
+	}
+
+	diff := schema.SnapshotDiff{From: fromName, To: toName}
+	if fromName == snapshotCurrent || toName == snapshotCurrent {
+		clearSnapshotCors(from)
+		clearSnapshotCors(to)
+		diff.IgnoredFields = []string{"cors"}
+	}
+	diff.Changes = diffConfigSnapshots(from, to)
+
+	utils.ResponseSuccess(w, diff)
+}
+
+// Restore reapplies the buckets, aliases, keys and permissions of a snapshot.
+// Keys missing from the cluster can only be recreated if the snapshot
+// contains their secret. Buckets and keys not in the snapshot are left alone,
+// and the layout is never restored.
+func (sn *Snapshots) Restore(w http.ResponseWriter, r *http.Request) {
+	store, err := openSnapshotStore(r)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	snapshot, err := loadSnapshot(r.Context(), store, r.PathValue("name"))
+	if err != nil {
+		responseSnapshotError(w, err)
+		return
+	}
+
+	result, err := restoreConfigSnapshot(r.Context(), snapshot)
+	if err != nil {
+		utils.ResponseError(w, err)
+		return
+	}
+
+	utils.ResponseSuccess(w, result)
+}
+
+func responseSnapshotError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSnapshotNotFound) {
+		utils.ResponseErrorStatus(w, err, http.StatusNotFound)
+		return
+	}
+	utils.ResponseError(w, err)
+}
+
+func readSnapshotFile(ctx context.Context, store snapshotStore, name string) ([]byte, error) {
+	if !snapshotNameRegex.MatchString(name) {
+		return nil, errSnapshotNotFound
+	}
+	return store.Read(ctx, name)
+}
+
+// loadSnapshot reads a stored snapshot, or captures the live configuration
+// if name is "current".
+func loadSnapshot(ctx context.Context, store snapshotStore, name string) (*schema.ConfigSnapshot, error) {
+	if name == snapshotCurrent {
+		return captureConfigSnapshot(ctx)
+	}
+
+	data, err := readSnapshotFile(ctx, store, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot schema.ConfigSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("cannot parse snapshot %s: %w", name, err)
+	}
+	if snapshot.Version > schema.ConfigSnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	return &snapshot, nil
+}
+
+// snapshotCheckpoint is persisted so the key of an interrupted snapshot job
+// can be deleted when it is resumed.
+type snapshotCheckpoint struct {
+	AccessKeyID string `json:"accessKeyId"`
+}
+
+func runSnapshotJob(ctx context.Context, job *utils.Job) (interface{}, error) {
+	var checkpoint snapshotCheckpoint
+	if job.Checkpoint(&checkpoint) && checkpoint.AccessKeyID != "" {
+		utils.DeleteScopedKey(ctx, checkpoint.AccessKeyID)
+	}
+
+	storeBucketID := ""
+	if snapshotConfig.bucket != "" {
+		target, err := getBucketTarget(ctx, snapshotConfig.bucket)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find snapshot bucket: %w", err)
+		}
+		storeBucketID = target.ID
+	}
+
+	snapshot, err := readClusterConfig(ctx, job.Snapshot().Params["secrets"] == "true")
+	if err != nil {
+		return nil, err
+	}
+
+	client, names, accessKeyID, err := createSnapshotClient(ctx, job.ID(), snapshot, storeBucketID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		utils.DeleteScopedKey(context.WithoutCancel(ctx), accessKeyID)
+		utils.S3Clients.Remove(accessKeyID)
+	}()
+
+	if err := job.SetCheckpoint(snapshotCheckpoint{AccessKeyID: accessKeyID}); err != nil {
+		return nil, err
+	}
+	if err := readSnapshotCors(ctx, client, names, snapshot); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var store snapshotStore = &dirSnapshotStore{dir: snapshotConfig.dir}
+	if storeBucketID != "" {
+		store = &bucketSnapshotStore{client: client, bucket: names[storeBucketID]}
+	}
+
+	name := "config-" + snapshot.CreatedAt.UTC().Format(snapshotTimeFormat) + ".json"
+	if err := store.Write(ctx, name, data); err != nil {
+		return nil, fmt.Errorf("cannot save snapshot: %w", err)
+	}
+
+	if err := pruneSnapshots(ctx, store, snapshotConfig.retain); err != nil {
+		slog.Error("Cannot delete old snapshots.", "error", err)
+	}
+
+	return schema.SnapshotInfo{Name: name, CreatedAt: snapshot.CreatedAt, Size: int64(len(data))}, nil
+}
+
+// pruneSnapshots deletes all but the newest retain snapshots. A retain of 0
+// keeps all snapshots.
+func pruneSnapshots(ctx context.Context, store snapshotStore, retain int) error {
+	if retain <= 0 {
+		return nil
+	}
+
+	snapshots, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots[min(len(snapshots), retain):] {
+		if err := store.Delete(ctx, snapshot.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureConfigSnapshot reads the current configuration without secrets
+// and without the cors rules, so no key is created.
+func captureConfigSnapshot(ctx context.Context) (*schema.ConfigSnapshot, error) {
+	return readClusterConfig(ctx, false)
+}
+
+// clearSnapshotCors removes the cors rules from the snapshot, so they are
+// left out of a comparison.
+func clearSnapshotCors(snapshot *schema.ConfigSnapshot) {
+	for i := range snapshot.Buckets {
+		snapshot.Buckets[i].Cors = nil
+	}
+}
+
+// createSnapshotClient creates a single key allowed to read all buckets of
+// the snapshot, as the cors configuration is only available through S3. The
+// key is also allowed to write the bucket storing the snapshots, if any. The
+// local aliases given to buckets without a global alias belong to the key, so
+// they are removed with it.
+func createSnapshotClient(ctx context.Context, jobID string, snapshot *schema.ConfigSnapshot, storeBucketID string) (*s3.Client, map[string]string, string, error) {
+	grants := make([]utils.KeyGrant, 0, len(snapshot.Buckets))
+	for _, bucket := range snapshot.Buckets {
+		grants = append(grants, utils.KeyGrant{BucketID: bucket.ID, Read: true, Write: bucket.ID == storeBucketID})
+	}
+	return createJobClient(ctx, jobID, grants)
+}
+
+func readSnapshotCors(ctx context.Context, client *s3.Client, names map[string]string, snapshot *schema.ConfigSnapshot) error {
+	for i := range snapshot.Buckets {
+		bucket := &snapshot.Buckets[i]
+		cors, err := client.GetBucketCors(ctx, &s3.GetBucketCorsInput{Bucket: aws.String(names[bucket.ID])})
+		if err != nil && !isAPIErrorCode(err, "NoSuchCORSConfiguration") {
+			return fmt.Errorf("cannot get cors of bucket %s: %w", bucket.ID, err)
+		}
+		if cors != nil {
+			bucket.Cors = fromS3CorsRules(cors.CORSRules)
+		}
+	}
+	return nil
+}
+
+// readClusterConfig reads the buckets, keys and layout from the admin API.
+// Keys created by the web UI itself are left out.
+func readClusterConfig(ctx context.Context, secrets bool) (*schema.ConfigSnapshot, error) {
+	snapshot := &schema.ConfigSnapshot{
+		Version:   schema.ConfigSnapshotVersion,
+		CreatedAt: time.Now(),
+		Secrets:   secrets,
+		Buckets:   []schema.SnapshotBucket{},
+		Keys:      []schema.SnapshotKey{},
+	}
+
+	keys, err := readSnapshotKeys(ctx, secrets)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Keys = keys
+
+	body, err := utils.Garage.Fetch("/v2/ListBuckets", &utils.FetchOptions{Context: ctx})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list buckets: %w", err)
+	}
+
+	var buckets []schema.GetBucketsRes
+	if err := json.Unmarshal(body, &buckets); err != nil {
+		return nil, err
+	}
+
+	for _, item := range buckets {
+		body, err := utils.Garage.Fetch("/v2/GetBucketInfo", &utils.FetchOptions{
+			Context: ctx,
+			Params:  map[string]string{"id": item.ID},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot get bucket %s: %w", item.ID, err)
+		}
+
+		var bucket schema.Bucket
+		if err := json.Unmarshal(body, &bucket); err != nil {
+			return nil, err
+		}
+
+		snapshotBucket := schema.SnapshotBucket{
+			ID:            bucket.ID,
+			GlobalAliases: bucket.GlobalAliases,
+			LocalAliases:  []schema.LocalAlias{},
+			WebsiteAccess: bucket.WebsiteAccess,
+			WebsiteConfig: bucket.WebsiteConfig,
+			Quotas:        bucket.Quotas,
+			Cors:          []schema.CorsRule{},
+			Keys:          []schema.ManifestKey{},
+		}
+		if snapshotBucket.GlobalAliases == nil {
+			snapshotBucket.GlobalAliases = []string{}
+		}
+
+		for _, key := range bucket.Keys {
+			if isTemporaryKey(key.Name) {
+				continue
+			}
+			snapshotBucket.Keys = append(snapshotBucket.Keys, schema.ManifestKey{
+				AccessKeyID: key.AccessKeyID,
+				Name:        key.Name,
+				Permissions: key.Permissions,
+			})
+			for _, alias := range key.BucketLocalAliases {
+				snapshotBucket.LocalAliases = append(snapshotBucket.LocalAliases, schema.LocalAlias{AccessKeyID: key.AccessKeyID, Alias: alias})
+			}
+		}
+
+		snapshot.Buckets = append(snapshot.Buckets, snapshotBucket)
+	}
+	sort.Slice(snapshot.Buckets, func(i, j int) bool { return snapshot.Buckets[i].ID < snapshot.Buckets[j].ID })
+
+	layout, err := utils.Garage.Fetch("/v2/GetClusterLayout", &utils.FetchOptions{Context: ctx})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get cluster layout: %w", err)
+	}
+	snapshot.Layout = layout
+
+	return snapshot, nil
+}
+
+type garageKeyInfo struct {
+	AccessKeyID     string  `json:"accessKeyId"`
+	Name            string  `json:"name"`
+	SecretAccessKey string  `json:"secretAccessKey"`
+	Expiration      *string `json:"expiration"`
+	Permissions     struct {
+		CreateBucket bool `json:"createBucket"`
+	} `json:"permissions"`
+}
+
+func readSnapshotKeys(ctx context.Context, secrets bool) ([]schema.SnapshotKey, error) {
+	body, err := utils.Garage.Fetch("/v2/ListKeys", &utils.FetchOptions{Context: ctx})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list keys: %w", err)
+	}
+
+	var list []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+
+	keys := []schema.SnapshotKey{}
+	for _, item := range list {
+		if isTemporaryKey(item.Name) {
+			continue
+		}
+
+		params := map[string]string{"id": item.ID}
+		if secrets {
+			params["showSecretKey"] = "true"
+		}
+		body, err := utils.Garage.Fetch("/v2/GetKeyInfo", &utils.FetchOptions{Context: ctx, Params: params})
+		if err != nil {
+			return nil, fmt.Errorf("cannot get key %s: %w", item.ID, err)
+		}
+
+		var info garageKeyInfo
+		if err := json.Unmarshal(body, &info); err != nil {
+			return nil, err
+		}
+
+		key := schema.SnapshotKey{
+			AccessKeyID:  info.AccessKeyID,
+			Name:         info.Name,
+			CreateBucket: info.Permissions.CreateBucket,
+			Expiration:   info.Expiration,
+		}
+		if secrets {
+			key.SecretAccessKey = info.SecretAccessKey
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].AccessKeyID < keys[j].AccessKeyID })
+	return keys, nil
+}
+
+// diffConfigSnapshots lists the buckets, keys and layout that differ between
+// two snapshots, along with the fields that changed. Secrets are ignored, as
+// they are only part of some snapshots.
+func diffConfigSnapshots(from *schema.ConfigSnapshot, to *schema.ConfigSnapshot) []schema.SnapshotChange {
+	changes := []schema.SnapshotChange{}
+
+	diffItems := func(kind string, from map[string]interface{}, to map[string]interface{}, names map[string]string) {
+		ids := make([]string, 0, len(from)+len(to))
+		for id := range from {
+			ids = append(ids, id)
+		}
+		for id := range to {
+			if _, ok := from[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			change := schema.SnapshotChange{Type: kind, ID: id, Name: names[id]}
+			before, inFrom := from[id]
+			after, inTo := to[id]
+
+			switch {
+			case !inFrom:
+				change.Change = schema.SnapshotChangeAdded
+			case !inTo:
+				change.Change = schema.SnapshotChangeRemoved
+			default:
+				change.Fields = diffFields(before, after)
+				if len(change.Fields) == 0 {
+					continue
+				}
+				change.Change = schema.SnapshotChangeChanged
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	names := map[string]string{}
+	bucketItems := func(snapshot *schema.ConfigSnapshot) map[string]interface{} {
+		items := map[string]interface{}{}
+		for _, bucket := range snapshot.Buckets {
+			items[bucket.ID] = bucket
+			names[bucket.ID] = getSnapshotBucketName(&bucket)
+		}
+		return items
+	}
+	diffItems("bucket", bucketItems(from), bucketItems(to), names)
+
+	keyItems := func(snapshot *schema.ConfigSnapshot) map[string]interface{} {
+		items := map[string]interface{}{}
+		for _, key := range snapshot.Keys {
+			key.SecretAccessKey = ""
+			items[key.AccessKeyID] = key
+			names[key.AccessKeyID] = key.Name
+		}
+		return items
+	}
+	diffItems("key", keyItems(from), keyItems(to), names)
+
+	layoutItems := func(snapshot *schema.ConfigSnapshot) map[string]interface{} {
+		if len(snapshot.Layout) == 0 {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"layout": snapshot.Layout}
+	}
+	diffItems("layout", layoutItems(from), layoutItems(to), names)
+
+	return changes
+}
+
+// diffFields returns the JSON fields that differ between two objects.
+func diffFields(a interface{}, b interface{}) []string {
+	var fieldsA, fieldsB map[string]json.RawMessage
+	if data, err := json.Marshal(a); err == nil {
+		json.Unmarshal(data, &fieldsA)
+	}
+	if data, err := json.Marshal(b); err == nil {
+		json.Unmarshal(data, &fieldsB)
+	}
+
+	fields := []string{}
+	for name, value := range fieldsA {
+		if !bytes.Equal(value, fieldsB[name]) {
+			fields = append(fields, name)
+		}
+	}
+	for name := range fieldsB {
+		if _, ok := fieldsA[name]; !ok {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func getSnapshotBucketName(bucket *schema.SnapshotBucket) string {
+	if len(bucket.GlobalAliases) > 0 {
+		return bucket.GlobalAliases[0]
+	}
+	if len(bucket.LocalAliases) > 0 {
+		return bucket.LocalAliases[0].Alias
+	}
+	return ""
+}
+
+// restoreConfigSnapshot makes the keys and buckets of the snapshot match the
+// cluster. Buckets are matched by ID, then by global alias, and recreated if
+// they no longer exist. Failures of single changes are reported as warnings.
+func restoreConfigSnapshot(ctx context.Context, snapshot *schema.ConfigSnapshot) (*schema.SnapshotRestoreResult, error) {
+	current, err := readClusterConfig(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &schema.SnapshotRestoreResult{Warnings: []string{}}
+	warn := func(format string, args ...interface{}) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	restoreSnapshotKeys(ctx, snapshot, current, result, warn)
+
+	currentBuckets := map[string]*schema.SnapshotBucket{}
+	currentAliases := map[string]*schema.SnapshotBucket{}
+	for i := range current.Buckets {
+		bucket := &current.Buckets[i]
+		currentBuckets[bucket.ID] = bucket
+		for _, alias := range bucket.GlobalAliases {
+			currentAliases[alias] = bucket
+		}
+	}
+
+	grants := []utils.KeyGrant{}
+	cors := map[string][]schema.CorsRule{}
+
+	for _, bucket := range snapshot.Buckets {
+		existing := currentBuckets[bucket.ID]
+		for _, alias := range bucket.GlobalAliases {
+			if existing == nil {
+				existing = currentAliases[alias]
+			}
+		}
+
+		if existing == nil {
+			created, err := createSnapshotBucket(ctx, &bucket)
+			if err != nil {
+				warn("cannot recreate bucket %s (%s): %v", getSnapshotBucketName(&bucket), bucket.ID, err)
+				continue
+			}
+			existing = created
+			result.BucketsCreated++
+		}
+
+		if restoreSnapshotBucket(ctx, &bucket, existing, warn) {
+			result.BucketsUpdated++
+		}
+
+		grants = append(grants, utils.KeyGrant{BucketID: existing.ID, Read: true, Owner: true})
+		cors[existing.ID] = bucket.Cors
+	}
+
+	if len(grants) > 0 {
+		restoreSnapshotCors(ctx, grants, cors, warn)
+	}
+
+	invalidateBucketCredentials(ctx, "")
+	return result, nil
+}
+
+func restoreSnapshotKeys(ctx context.Context, snapshot *schema.ConfigSnapshot, current *schema.ConfigSnapshot, result *schema.SnapshotRestoreResult, warn func(string, ...interface{})) {
+	currentKeys := map[string]schema.SnapshotKey{}
+	for _, key := range current.Keys {
+		currentKeys[key.AccessKeyID] = key
+	}
+
+	for _, key := range snapshot.Keys {
+		existing, ok := currentKeys[key.AccessKeyID]
+		if ok && existing.Name == key.Name && existing.CreateBucket == key.CreateBucket {
+			continue
+		}
+
+		if !ok {
+			if key.SecretAccessKey == "" {
+				warn("key %s (%s) cannot be recreated, the snapshot does not contain its secret", key.AccessKeyID, key.Name)
+				continue
+			}
+
+			_, err := utils.Garage.Fetch("/v2/ImportKey", &utils.FetchOptions{
+				Context: ctx,
+				Method:  http.MethodPost,
+				Body: map[string]string{
+					"accessKeyId":     key.AccessKeyID,
+					"secretAccessKey": key.SecretAccessKey,
+					"name":            key.Name,
+				},
+			})
+			if err != nil {
+				warn("cannot recreate key %s (%s): %v", key.AccessKeyID, key.Name, err)
+				continue
+			}
+			result.KeysCreated++
+			if !key.CreateBucket {
+				continue
+			}
+		} else {
+			result.KeysUpdated++
+		}
+
+		update := map[string]interface{}{"name": key.Name}
+		permission := map[string]bool{"createBucket": true}
+		if key.CreateBucket {
+			update["allow"] = permission
+		} else {
+			update["deny"] = permission
+		}
+
+		_, err := utils.Garage.Fetch("/v2/UpdateKey", &utils.FetchOptions{
+			Context: ctx,
+			Method:  http.MethodPost,
+			Params:  map[string]string{"id": key.AccessKeyID},
+			Body:    update,
+		})
+		if err != nil {
+			warn("cannot update key %s (%s): %v", key.AccessKeyID, key.Name, err)
+		}
+	}
+}
+
+// createSnapshotBucket recreates a bucket under its first alias. The other
+// aliases are added when the bucket is restored.
+func createSnapshotBucket(ctx context.Context, bucket *schema.SnapshotBucket) (*schema.SnapshotBucket, error) {
+	body := map[string]interface{}{}
+	switch {
+	case len(bucket.GlobalAliases) > 0:
+		body["globalAlias"] = bucket.GlobalAliases[0]
+	case len(bucket.LocalAliases) > 0:
+		alias := bucket.LocalAliases[0]
+		body["localAlias"] = map[string]interface{}{
+			"accessKeyId": alias.AccessKeyID,
+			"alias":       alias.Alias,
+		}
+	default:
+		return nil, errors.New("the bucket has no alias")
+	}
+
+	data, err := utils.Garage.Fetch("/v2/CreateBucket", &utils.FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+		Body:    body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var created schema.Bucket
+	if err := json.Unmarshal(data, &created); err != nil {
+		return nil, err
+	}
+
+	result := &schema.SnapshotBucket{ID: created.ID, GlobalAliases: created.GlobalAliases}
+	for _, key := range created.Keys {
+		result.Keys = append(result.Keys, schema.ManifestKey{AccessKeyID: key.AccessKeyID, Name: key.Name, Permissions: key.Permissions})
+		for _, alias := range key.BucketLocalAliases {
+			result.LocalAliases = append(result.LocalAliases, schema.LocalAlias{AccessKeyID: key.AccessKeyID, Alias: alias})
+		}
+	}
+	return result, nil
+}
+
+// restoreSnapshotBucket applies the aliases, settings and key permissions of
+// the snapshot to an existing bucket. It returns whether anything changed.
+func restoreSnapshotBucket(ctx context.Context, bucket *schema.SnapshotBucket, existing *schema.SnapshotBucket, warn func(string, ...interface{})) bool {
+	changed := false
+	name := getSnapshotBucketName(bucket)
+
+	fetch := func(endpoint string, body interface{}, format string, args ...interface{}) {
+		_, err := utils.Garage.Fetch(endpoint, &utils.FetchOptions{Context: ctx, Method: http.MethodPost, Body: body})
+		if err != nil {
+			warn(format+": %v", append(args, err)...)
+			return
+		}
+		changed = true
+	}
+
+	// Permissions go first, as local aliases need a key with access
+	currentPermissions := map[string]schema.Permissions{}
+	for _, key := range existing.Keys {
+		currentPermissions[key.AccessKeyID] = key.Permissions
+	}
+	for _, key := range bucket.Keys {
+		permissions, ok := currentPermissions[key.AccessKeyID]
+		delete(currentPermissions, key.AccessKeyID)
+		if ok && permissions == key.Permissions {
+			continue
+		}
+
+		allow := key.Permissions
+		deny := schema.Permissions{Read: !allow.Read, Write: !allow.Write, Owner: !allow.Owner}
+		if allow.Read || allow.Write || allow.Owner {
+			fetch("/v2/AllowBucketKey", map[string]interface{}{"bucketId": existing.ID, "accessKeyId": key.AccessKeyID, "permissions": allow},
+				"cannot allow key %s on bucket %s", key.AccessKeyID, name)
+		}
+		if ok && (deny.Read || deny.Write || deny.Owner) {
+			fetch("/v2/DenyBucketKey", map[string]interface{}{"bucketId": existing.ID, "accessKeyId": key.AccessKeyID, "permissions": deny},
+				"cannot deny key %s on bucket %s", key.AccessKeyID, name)
+		}
+	}
+
+	for _, alias := range bucket.GlobalAliases {
+		if !slices.Contains(existing.GlobalAliases, alias) {
+			fetch("/v2/AddBucketAlias", map[string]string{"bucketId": existing.ID, "globalAlias": alias},
+				"cannot add alias %s to bucket %s", alias, name)
+		}
+	}
+	for _, alias := range existing.GlobalAliases {
+		if !slices.Contains(bucket.GlobalAliases, alias) {
+			fetch("/v2/RemoveBucketAlias", map[string]string{"bucketId": existing.ID, "globalAlias": alias},
+				"cannot remove alias %s from bucket %s", alias, name)
+		}
+	}
+
+	for _, alias := range bucket.LocalAliases {
+		if !slices.Contains(existing.LocalAliases, alias) {
+			fetch("/v2/AddBucketAlias", map[string]string{"bucketId": existing.ID, "accessKeyId": alias.AccessKeyID, "localAlias": alias.Alias},
+				"cannot add local alias %s of key %s to bucket %s", alias.Alias, alias.AccessKeyID, name)
+		}
+	}
+	for _, alias := range existing.LocalAliases {
+		if !slices.Contains(bucket.LocalAliases, alias) {
+			fetch("/v2/RemoveBucketAlias", map[string]string{"bucketId": existing.ID, "accessKeyId": alias.AccessKeyID, "localAlias": alias.Alias},
+				"cannot remove local alias %s of key %s from bucket %s", alias.Alias, alias.AccessKeyID, name)
+		}
+	}
+
+	// Keys that were given access after the snapshot
+	for accessKeyID := range currentPermissions {
+		fetch("/v2/DenyBucketKey", map[string]interface{}{
+			"bucketId":    existing.ID,
+			"accessKeyId": accessKeyID,
+			"permissions": schema.Permissions{Read: true, Write: true, Owner: true},
+		}, "cannot deny key %s on bucket %s", accessKeyID, name)
+	}
+
+	if bucket.WebsiteAccess != existing.WebsiteAccess || bucket.WebsiteConfig != existing.WebsiteConfig || bucket.Quotas != existing.Quotas {
+		if err := updateBucketSettings(ctx, existing.ID, bucket.WebsiteAccess, bucket.WebsiteConfig, bucket.Quotas); err != nil {
+			warn("cannot restore website and quota settings of bucket %s: %v", name, err)
+		} else {
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// restoreSnapshotCors applies the cors rules by bucket ID, removing the cors
+// configuration of buckets that had none.
+func restoreSnapshotCors(ctx context.Context, grants []utils.KeyGrant, cors map[string][]schema.CorsRule, warn func(string, ...interface{})) {
+	client, names, accessKeyID, err := createJobClient(ctx, "restore-"+time.Now().UTC().Format(snapshotTimeFormat), grants)
+	if err != nil {
+		warn("cannot restore cors: %v", err)
+		return
+	}
+	defer func() {
+		utils.DeleteScopedKey(context.WithoutCancel(ctx), accessKeyID)
+		utils.S3Clients.Remove(accessKeyID)
+	}()
+
+	for bucketID, rules := range cors {
+		var err error
+		if len(rules) > 0 {
+			_, err = client.PutBucketCors(ctx, &s3.PutBucketCorsInput{
+				Bucket:            aws.String(names[bucketID]),
+				CORSConfiguration: &types.CORSConfiguration{CORSRules: toS3CorsRules(rules)},
+			})
+		} else {
+			_, err = client.DeleteBucketCors(ctx, &s3.DeleteBucketCorsInput{Bucket: aws.String(names[bucketID])})
+		}
+		if err != nil {
+			warn("cannot restore cors of bucket %s: %v", bucketID, err)
+		}
+	}
+}
+
+// snapshotStore stores snapshot files in a local directory or in a bucket.
+type snapshotStore interface {
+	List(ctx context.Context) ([]schema.SnapshotInfo, error)
+	Read(ctx context.Context, name string) ([]byte, error)
+	Write(ctx context.Context, name string, data []byte) error
+	Delete(ctx context.Context, name string) error
+}
+
+// openSnapshotStore opens the configured store, accessing the snapshot bucket
+// with the credentials of the request.
+func openSnapshotStore(r *http.Request) (snapshotStore, error) {
+	if snapshotConfig.bucket == "" {
+		return &dirSnapshotStore{dir: snapshotConfig.dir}, nil
+	}
+
+	client, bucketName, err := getS3Client(r, snapshotConfig.bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &bucketSnapshotStore{client: client, bucket: bucketName}, nil
+}
+
+func newSnapshotInfo(name string, size int64) (schema.SnapshotInfo, bool) {
+	if !snapshotNameRegex.MatchString(name) {
+		return schema.SnapshotInfo{}, false
+	}
+
+	timestamp := strings.TrimSuffix(strings.TrimPrefix(name, "config-"), ".json")
+	createdAt, err := time.Parse(snapshotParseFormat, timestamp)
+	if err != nil {
+		return schema.SnapshotInfo{}, false
+	}
+	return schema.SnapshotInfo{Name: name, CreatedAt: createdAt, Size: size}, true
+}
+
+func sortSnapshots(snapshots []schema.SnapshotInfo) {
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+}
+
+type dirSnapshotStore struct {
+	dir string
+}
+
+func (s *dirSnapshotStore) List(ctx context.Context) ([]schema.SnapshotInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []schema.SnapshotInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []schema.SnapshotInfo{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if snapshot, ok := newSnapshotInfo(entry.Name(), info.Size()); ok {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+func (s *dirSnapshotStore) Read(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return nil, errSnapshotNotFound
+	}
+	return data, err
+}
+
+func (s *dirSnapshotStore) Write(ctx context.Context, name string, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial snapshot
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *dirSnapshotStore) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+type bucketSnapshotStore struct {
+	client *s3.Client
+	bucket string
+}
+
+func (s *bucketSnapshotStore) List(ctx context.Context) ([]schema.SnapshotInfo, error) {
+	snapshots := []schema.SnapshotInfo{}
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(snapshotObjectPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(object.Key), snapshotObjectPrefix)
+			if snapshot, ok := newSnapshotInfo(name, aws.ToInt64(object.Size)); ok {
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+	}
+
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+func (s *bucketSnapshotStore) Read(ctx context.Context, name string) ([]byte, error) {
+	object, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(snapshotObjectPrefix + name),
+	})
+	if isAPIErrorCode(err, "NoSuchKey") {
+		return nil, errSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer object.Body.Close()
+
+	return io.ReadAll(object.Body)
+}
+
+func (s *bucketSnapshotStore) Write(ctx context.Context, name string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(snapshotObjectPrefix + name),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func (s *bucketSnapshotStore) Delete(ctx context.Context, name string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(snapshotObjectPrefix + name),
+	})
+	return err
+}
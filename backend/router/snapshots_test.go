@@ -0,0 +1,283 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewSnapshotInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		wantOK bool
+		want   time.Time
+	}{
+		{"microseconds", "config-20240102T030405.123456Z.json", true, time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC)},
+		{"seconds", "config-20240102T030405Z.json", true, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"milliseconds", "config-20240102T030405.123Z.json", false, time.Time{}},
+		{"temporary file", "config-20240102T030405Z.json.tmp", false, time.Time{}},
+		{"other file", "notes.json", false, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, ok := newSnapshotInfo(tt.file, 10)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && !info.CreatedAt.Equal(tt.want) {
+				t.Errorf("createdAt = %v, want %v", info.CreatedAt, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotNamesAreUnique(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	names := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		name := "config-" + createdAt.Add(time.Duration(i)*time.Microsecond).Format(snapshotTimeFormat) + ".json"
+		if names[name] {
+			t.Fatalf("duplicate snapshot name %s", name)
+		}
+		names[name] = true
+
+		info, ok := newSnapshotInfo(name, 0)
+		if !ok || !info.CreatedAt.Equal(createdAt.Add(time.Duration(i)*time.Microsecond)) {
+			t.Errorf("name %s does not round-trip: %v %v", name, info.CreatedAt, ok)
+		}
+	}
+}
+
+func TestDiffConfigSnapshots(t *testing.T) {
+	bucket := schema.SnapshotBucket{ID: "b1", GlobalAliases: []string{"photos"}}
+	key := schema.SnapshotKey{AccessKeyID: "GK1", Name: "app"}
+
+	tests := []struct {
+		name string
+		from schema.ConfigSnapshot
+		to   schema.ConfigSnapshot
+		want []schema.SnapshotChange
+	}{
+		{
+			name: "unchanged",
+			from: schema.ConfigSnapshot{Buckets: []schema.SnapshotBucket{bucket}, Keys: []schema.SnapshotKey{key}},
+			to:   schema.ConfigSnapshot{Buckets: []schema.SnapshotBucket{bucket}, Keys: []schema.SnapshotKey{key}},
+			want: []schema.SnapshotChange{},
+		},
+		{
+			name: "bucket added and key removed",
+			from: schema.ConfigSnapshot{Keys: []schema.SnapshotKey{key}},
+			to:   schema.ConfigSnapshot{Buckets: []schema.SnapshotBucket{bucket}},
+			want: []schema.SnapshotChange{
+				{Type: "bucket", ID: "b1", Name: "photos", Change: schema.SnapshotChangeAdded},
+				{Type: "key", ID: "GK1", Name: "app", Change: schema.SnapshotChangeRemoved},
+			},
+		},
+		{
+			name: "quota changed",
+			from: schema.ConfigSnapshot{Buckets: []schema.SnapshotBucket{bucket}},
+			to:   schema.ConfigSnapshot{Buckets: []schema.SnapshotBucket{{ID: "b1", GlobalAliases: []string{"photos"}, Quotas: schema.Quotas{MaxSize: 100}}}},
+			want: []schema.SnapshotChange{
+				{Type: "bucket", ID: "b1", Name: "photos", Change: schema.SnapshotChangeChanged, Fields: []string{"quotas"}},
+			},
+		},
+		{
+			name: "secret ignored",
+			from: schema.ConfigSnapshot{Keys: []schema.SnapshotKey{key}},
+			to:   schema.ConfigSnapshot{Keys: []schema.SnapshotKey{{AccessKeyID: "GK1", Name: "app", SecretAccessKey: "secret"}}},
+			want: []schema.SnapshotChange{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffConfigSnapshots(&tt.from, &tt.to)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotDiffWithCurrent(t *testing.T) {
+	const name = "config-20240102T030405.000000Z.json"
+	layout := json.RawMessage(`{"version":1}`)
+	cors := []schema.CorsRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}}
+
+	tests := []struct {
+		name        string
+		from        string
+		to          string
+		wantIgnored []string
+	}{
+		{"against current", name, "", []string{"cors"}},
+		{"current first", snapshotCurrent, name, []string{"cors"}},
+		{"between snapshots", name, name, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			garage := newGarageStub(t)
+			garage.respond("ListKeys", http.StatusOK, []interface{}{})
+			garage.respond("ListBuckets", http.StatusOK, []schema.GetBucketsRes{{ID: "b1"}})
+			garage.respond("GetBucketInfo", http.StatusOK, schema.Bucket{ID: "b1", GlobalAliases: []string{"photos"}})
+			garage.respond("GetClusterLayout", http.StatusOK, layout)
+
+			dir := t.TempDir()
+			previous := snapshotConfig
+			snapshotConfig = snapshotSettings{dir: dir}
+			t.Cleanup(func() { snapshotConfig = previous })
+
+			stored := schema.ConfigSnapshot{
+				Version: schema.ConfigSnapshotVersion,
+				Buckets: []schema.SnapshotBucket{{
+					ID:            "b1",
+					GlobalAliases: []string{"photos"},
+					LocalAliases:  []schema.LocalAlias{},
+					Cors:          cors,
+					Keys:          []schema.ManifestKey{},
+				}},
+				Keys:   []schema.SnapshotKey{},
+				Layout: layout,
+			}
+			data, _ := json.Marshal(stored)
+			if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			(&Snapshots{}).Diff(w, httptest.NewRequest(http.MethodGet, "/snapshots/diff?from="+tt.from+"&to="+tt.to, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+
+			var diff schema.SnapshotDiff
+			if err := json.Unmarshal(w.Body.Bytes(), &diff); err != nil {
+				t.Fatal(err)
+			}
+			if len(diff.Changes) != 0 {
+				t.Errorf("changes = %+v, want none", diff.Changes)
+			}
+			if !reflect.DeepEqual(diff.IgnoredFields, tt.wantIgnored) {
+				t.Errorf("ignored fields = %v, want %v", diff.IgnoredFields, tt.wantIgnored)
+			}
+			if calls := garage.called("CreateKey"); len(calls) != 0 {
+				t.Errorf("created %d keys, want none", len(calls))
+			}
+		})
+	}
+}
+
+func TestRestoreConfigSnapshotCarriesRequestID(t *testing.T) {
+	garage := newGarageStub(t)
+	garage.respond("ListKeys", http.StatusOK, []interface{}{})
+	garage.respond("ListBuckets", http.StatusOK, []interface{}{})
+	garage.respond("GetClusterLayout", http.StatusOK, map[string]interface{}{"version": 1})
+	garage.respond("ImportKey", http.StatusOK, map[string]string{})
+	garage.respond("UpdateKey", http.StatusOK, map[string]string{})
+	garage.respond("CreateBucket", http.StatusOK, schema.Bucket{ID: "b1", GlobalAliases: []string{"photos"}})
+	garage.respond("GetBucketInfo", http.StatusOK, schema.Bucket{ID: "b1", GlobalAliases: []string{"photos"}})
+	garage.respond("UpdateBucket", http.StatusOK, map[string]string{})
+	garage.respond("AllowBucketKey", http.StatusOK, map[string]string{})
+	utils.InitCacheManager()
+
+	snapshot := &schema.ConfigSnapshot{
+		Keys: []schema.SnapshotKey{{AccessKeyID: "GK1", Name: "app", CreateBucket: true, SecretAccessKey: "secret"}},
+		Buckets: []schema.SnapshotBucket{{
+			ID:            "b1",
+			GlobalAliases: []string{"photos"},
+			Quotas:        schema.Quotas{MaxSize: 100},
+			Keys:          []schema.ManifestKey{{AccessKeyID: "GK1", Permissions: schema.Permissions{Read: true}}},
+		}},
+	}
+
+	result, err := restoreConfigSnapshot(utils.WithRequestID(context.Background(), "req-1"), snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BucketsCreated != 1 || result.KeysCreated != 1 {
+		t.Fatalf("result = %+v, want the bucket and key recreated", result)
+	}
+	garage.checkRequestID(t, "req-1")
+}
+
+func TestSnapshotJobKey(t *testing.T) {
+	const localBucketID = "0123456789abcdef0123"
+
+	tests := []struct {
+		name        string
+		bucket      string
+		wantWritten string
+	}{
+		{"directory store", "", ""},
+		{"bucket store", "photos", "b1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newJobManager(t)
+			newS3Stub(t)
+			garage := newGarageStub(t)
+			garage.respond("ListKeys", http.StatusOK, []interface{}{})
+			garage.respond("ListBuckets", http.StatusOK, []schema.GetBucketsRes{{ID: "b1"}, {ID: localBucketID}})
+			garage.handle("GetBucketInfo", func(r *http.Request, _ map[string]interface{}) (int, interface{}) {
+				query := r.URL.Query()
+				switch {
+				case query.Get("id") == localBucketID:
+					return http.StatusOK, schema.Bucket{ID: localBucketID}
+				case query.Get("id") == "b1" || query.Get("globalAlias") == "photos":
+					return http.StatusOK, schema.Bucket{ID: "b1", GlobalAliases: []string{"photos"}}
+				}
+				return http.StatusNotFound, map[string]string{}
+			})
+			garage.respond("GetClusterLayout", http.StatusOK, map[string]interface{}{"version": 1})
+			garage.respond("CreateKey", http.StatusOK, schema.KeyElement{AccessKeyID: "GKjob", SecretAccessKey: "secret"})
+			garage.respond("AllowBucketKey", http.StatusOK, map[string]string{})
+			garage.respond("AddBucketAlias", http.StatusOK, map[string]string{})
+			garage.respond("DeleteKey", http.StatusOK, map[string]string{})
+
+			previous := snapshotConfig
+			snapshotConfig = snapshotSettings{dir: t.TempDir(), bucket: tt.bucket}
+			t.Cleanup(func() { snapshotConfig = previous })
+
+			job, err := startSnapshot(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			job.Wait()
+			if snapshot := job.Snapshot(); snapshot.Status != schema.JobStatusCompleted {
+				t.Fatalf("job %s: %s", snapshot.Status, snapshot.Error)
+			}
+
+			if created := garage.called("CreateKey"); len(created) != 1 {
+				t.Fatalf("created %d keys, want one for all buckets", len(created))
+			}
+			for _, call := range garage.called("AllowBucketKey") {
+				permissions := call.Body["permissions"].(map[string]interface{})
+				wantWrite := call.Body["bucketId"] == tt.wantWritten
+				if permissions["owner"] != false || permissions["read"] != true || permissions["write"] != wantWrite {
+					t.Errorf("bucket %v allowed %v, want read with write %v", call.Body["bucketId"], permissions, wantWrite)
+				}
+			}
+
+			// Local aliases of a key are removed when the key is deleted
+			aliases := garage.called("AddBucketAlias")
+			if len(aliases) != 1 || aliases[0].Body["bucketId"] != localBucketID || aliases[0].Body["accessKeyId"] != "GKjob" {
+				t.Errorf("added aliases %+v, want one of the job key", aliases)
+			}
+			if deleted := garage.called("DeleteKey"); len(deleted) != 1 || deleted[0].Query != "id=GKjob" {
+				t.Errorf("deleted keys %+v, want the job key", deleted)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package router
 
 import (
 	"encoding/json"
-	"fmt"
 	"khairul169/garage-webui/utils"
 	"net/http"
 )
@@ -10,7 +9,7 @@ import (
 type Stats struct{}
 
 func (s *Stats) GetClusterStats(w http.ResponseWriter, r *http.Request) {
-	body, err := utils.Garage.Fetch("/v2/GetClusterStatistics", &utils.FetchOptions{})
+	body, err := utils.Garage.Fetch("/v2/GetClusterStatistics", &utils.FetchOptions{Context: r.Context()})
 	if err != nil {
 		utils.ResponseError(w, err)
 		return
@@ -32,6 +31,7 @@ type ClusterStatusNode struct {
 	IsUp            bool        `json:"isUp"`
 	LastSeenSecsAgo interface{} `json:"lastSeenSecsAgo"`
 	Draining        bool        `json:"draining"`
+	GarageVersion   string      `json:"garageVersion"`
 	DataPartition   *struct {
 		Available int64 `json:"available"`
 		Total     int64 `json:"total"`
@@ -77,7 +77,7 @@ type NodeStatsResult struct {
 }
 
 func (s *Stats) GetNodeStats(w http.ResponseWriter, r *http.Request) {
-	body, err := utils.Garage.Fetch("/v2/GetClusterStatus", &utils.FetchOptions{})
+	body, err := utils.Garage.Fetch("/v2/GetClusterStatus", &utils.FetchOptions{Context: r.Context()})
 	if err != nil {
 		utils.ResponseError(w, err)
 		return
@@ -128,6 +128,7 @@ func (s *Stats) GetNodeStats(w http.ResponseWriter, r *http.Request) {
 			}
 
 			statsBody, err := utils.Garage.Fetch("/v2/GetNodeStatistics", &utils.FetchOptions{
+				Context: r.Context(),
 				Params: map[string]string{"node": node.ID},
 			})
 			if err == nil {
@@ -0,0 +1,325 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Spread of the disk usage between nodes reported as imbalanced
+	healthImbalanceThreshold = 0.2
+	// Free space ratio of a data partition reported as low
+	healthLowSpaceThreshold = 0.1
+)
+
+type clusterHealth struct {
+	Status           string `json:"status"`
+	Partitions       int    `json:"partitions"`
+	PartitionsQuorum int    `json:"partitionsQuorum"`
+	PartitionsAllOk  int    `json:"partitionsAllOk"`
+}
+
+type clusterLayout struct {
+	Version int `json:"version"`
+	Roles   []struct {
+		ID       string `json:"id"`
+		Zone     string `json:"zone"`
+		Capacity *int64 `json:"capacity"`
+	} `json:"roles"`
+	Parameters struct {
+		ZoneRedundancy json.RawMessage `json:"zoneRedundancy"`
+	} `json:"parameters"`
+	StagedRoleChanges []json.RawMessage `json:"stagedRoleChanges"`
+	StagedParameters  json.RawMessage   `json:"stagedParameters"`
+}
+
+var healthSeverityOrder = map[string]int{
+	schema.HealthCritical: 0,
+	schema.HealthWarning:  1,
+	schema.HealthInfo:     2,
+}
+
+// GetHealth returns the cluster health along with diagnostics derived from
+// the status, layout and partitions of the nodes. Down nodes count as not
+// seen once they are down for staleAfter, 5m by default.
+func (s *Stats) GetHealth(w http.ResponseWriter, r *http.Request) {
+	staleAfter := 5 * time.Minute
+	if value := r.URL.Query().Get("staleAfter"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			utils.ResponseErrorStatus(w, fmt.Errorf("invalid staleAfter: %w", err), http.StatusBadRequest)
+			return
+		}
+		staleAfter = d
+	}
+
+	var health clusterHealth
+	var status ClusterStatusResponse
+	var layout clusterLayout
+	for endpoint, result := range map[string]interface{}{
+		"/v2/GetClusterHealth": &health,
+		"/v2/GetClusterStatus": &status,
+		"/v2/GetClusterLayout": &layout,
+	} {
+		body, err := utils.Garage.Fetch(endpoint, &utils.FetchOptions{Context: r.Context()})
+		if err != nil {
+			utils.ResponseError(w, err)
+			return
+		}
+		if err := json.Unmarshal(body, result); err != nil {
+			utils.ResponseError(w, err)
+			return
+		}
+	}
+
+	utils.ResponseSuccess(w, buildHealthReport(health, status, layout, utils.Garage.GetReplicationFactor(), staleAfter))
+}
+
+func buildHealthReport(health clusterHealth, status ClusterStatusResponse, layout clusterLayout, replicationFactor int, staleAfter time.Duration) schema.HealthReport {
+	report := schema.HealthReport{
+		ClusterStatus:     health.Status,
+		LayoutVersion:     layout.Version,
+		ReplicationFactor: replicationFactor,
+		Partitions:        health.Partitions,
+		PartitionsQuorum:  health.PartitionsQuorum,
+		PartitionsAllOk:   health.PartitionsAllOk,
+		Nodes:             []schema.HealthNode{},
+		Zones:             []schema.HealthZone{},
+		Diagnostics:       []schema.HealthDiagnostic{},
+	}
+	add := func(code string, severity string, message string, nodes ...string) *schema.HealthDiagnostic {
+		report.Diagnostics = append(report.Diagnostics, schema.HealthDiagnostic{Code: code, Severity: severity, Message: message, Nodes: nodes})
+		return &report.Diagnostics[len(report.Diagnostics)-1]
+	}
+
+	known := status.KnownNodes
+	if len(known) == 0 {
+		known = status.Nodes
+	}
+	nodes := map[string]*schema.HealthNode{}
+	var order []string
+	for _, node := range known {
+		entry := &schema.HealthNode{
+			ID:              node.ID,
+			Hostname:        node.Hostname,
+			IsUp:            node.IsUp,
+			LastSeenSecsAgo: parseSecsAgo(node.LastSeenSecsAgo),
+			Draining:        node.Draining,
+			GarageVersion:   node.GarageVersion,
+		}
+		if node.DataPartition != nil {
+			entry.DataTotal = node.DataPartition.Total
+			entry.DataAvailable = node.DataPartition.Available
+			if entry.DataTotal > 0 {
+				entry.DataUsedRatio = float64(entry.DataTotal-entry.DataAvailable) / float64(entry.DataTotal)
+			}
+		}
+		nodes[node.ID] = entry
+		order = append(order, node.ID)
+	}
+
+	zones := map[string]*schema.HealthZone{}
+	var zoneOrder []string
+	var storageNodes []*schema.HealthNode
+	for _, role := range layout.Roles {
+		node, ok := nodes[role.ID]
+		if !ok {
+			// In the layout but never seen by the node answering
+			node = &schema.HealthNode{ID: role.ID}
+			nodes[role.ID] = node
+			order = append(order, role.ID)
+		}
+		node.Zone = role.Zone
+		node.Capacity = role.Capacity
+		if role.Capacity == nil {
+			// Gateway nodes store no data
+			continue
+		}
+		storageNodes = append(storageNodes, node)
+
+		zone, ok := zones[role.Zone]
+		if !ok {
+			zone = &schema.HealthZone{Name: role.Zone}
+			zones[role.Zone] = zone
+			zoneOrder = append(zoneOrder, role.Zone)
+		}
+		zone.Nodes++
+		zone.Capacity += *role.Capacity
+		if node.IsUp {
+			zone.NodesUp++
+		}
+	}
+
+	for _, id := range order {
+		report.Nodes = append(report.Nodes, *nodes[id])
+	}
+	sort.Strings(zoneOrder)
+	zonesUp := 0
+	for _, name := range zoneOrder {
+		report.Zones = append(report.Zones, *zones[name])
+		if zones[name].NodesUp > 0 {
+			zonesUp++
+		}
+	}
+	report.ZoneRedundancy = parseZoneRedundancy(layout.Parameters.ZoneRedundancy, replicationFactor, len(zones))
+
+	// Cluster status
+	switch health.Status {
+	case "healthy":
+	case "degraded":
+		add("cluster_degraded", schema.HealthWarning, fmt.Sprintf("Cluster is degraded: %d of %d partitions have all their nodes up, all have quorum",
+			health.PartitionsAllOk, health.Partitions))
+	default:
+		add("cluster_unavailable", schema.HealthCritical, fmt.Sprintf("Cluster is %s: only %d of %d partitions have quorum",
+			health.Status, health.PartitionsQuorum, health.Partitions))
+	}
+
+	// Nodes down or not seen for a while
+	for _, node := range storageNodes {
+		if node.IsUp {
+			continue
+		}
+		name := healthNodeName(node)
+		switch {
+		case node.LastSeenSecsAgo == nil:
+			add("node_not_seen", schema.HealthCritical, fmt.Sprintf("Node %s has never been seen", name), node.ID)
+		case time.Duration(*node.LastSeenSecsAgo)*time.Second >= staleAfter:
+			add("node_not_seen", schema.HealthCritical, fmt.Sprintf("Node %s has not been seen for %s", name,
+				(time.Duration(*node.LastSeenSecsAgo)*time.Second).String()), node.ID)
+		default:
+			add("node_down", schema.HealthWarning, fmt.Sprintf("Node %s went down %ds ago", name, *node.LastSeenSecsAgo), node.ID)
+		}
+	}
+
+	// Replicas and zones
+	if replicationFactor > 0 && len(storageNodes) < replicationFactor {
+		add("insufficient_nodes", schema.HealthCritical, fmt.Sprintf("The layout has %d storage nodes, fewer than the replication factor of %d",
+			len(storageNodes), replicationFactor))
+	}
+	for _, name := range zoneOrder {
+		if zones[name].NodesUp == 0 {
+			add("zone_down", schema.HealthWarning, fmt.Sprintf("No node of zone %s is up", name)).Zone = name
+		}
+	}
+	if report.ZoneRedundancy > 0 && zonesUp < report.ZoneRedundancy {
+		// Copies in different zones are needed for a quorum of them
+		severity := schema.HealthWarning
+		if zonesUp < report.ZoneRedundancy/2+1 {
+			severity = schema.HealthCritical
+		}
+		add("insufficient_zones", severity, fmt.Sprintf("Only %d of the %d zones each partition is spread over have nodes up",
+			zonesUp, report.ZoneRedundancy))
+	} else if replicationFactor > 0 && len(zones) > 0 && len(zones) < replicationFactor {
+		add("shared_zones", schema.HealthInfo, fmt.Sprintf("The layout has %d zones for %d copies, so some copies share a zone",
+			len(zones), replicationFactor))
+	}
+
+	// Capacity and usage
+	var fullest, emptiest *schema.HealthNode
+	for _, node := range storageNodes {
+		if !node.IsUp || node.DataTotal == 0 {
+			continue
+		}
+		name := healthNodeName(node)
+		if fullest == nil || node.DataUsedRatio > fullest.DataUsedRatio {
+			fullest = node
+		}
+		if emptiest == nil || node.DataUsedRatio < emptiest.DataUsedRatio {
+			emptiest = node
+		}
+		if float64(node.DataAvailable)/float64(node.DataTotal) < healthLowSpaceThreshold {
+			add("low_disk_space", schema.HealthWarning, fmt.Sprintf("The data partition of node %s is %.0f%% full",
+				name, node.DataUsedRatio*100), node.ID)
+		}
+		if *node.Capacity > node.DataTotal {
+			add("capacity_exceeds_disk", schema.HealthWarning, fmt.Sprintf("Node %s has a layout capacity of %d bytes, but its data partition only has %d bytes",
+				name, *node.Capacity, node.DataTotal), node.ID)
+		}
+	}
+	if fullest != nil && fullest.DataUsedRatio-emptiest.DataUsedRatio > healthImbalanceThreshold {
+		add("capacity_imbalance", schema.HealthWarning, fmt.Sprintf("Disk usage ranges from %.0f%% on node %s to %.0f%% on node %s; check the layout capacities",
+			emptiest.DataUsedRatio*100, healthNodeName(emptiest), fullest.DataUsedRatio*100, healthNodeName(fullest)), emptiest.ID, fullest.ID)
+	}
+
+	// Draining nodes, nodes without a role and layout changes
+	for _, node := range report.Nodes {
+		if node.Draining {
+			add("node_draining", schema.HealthInfo, fmt.Sprintf("Node %s is draining its data to other nodes", healthNodeName(&node)), node.ID)
+		}
+		if node.IsUp && node.Zone == "" {
+			add("node_without_role", schema.HealthInfo, fmt.Sprintf("Node %s is connected but has no role in the layout", healthNodeName(&node)), node.ID)
+		}
+	}
+	staged := len(layout.StagedRoleChanges)
+	if len(layout.StagedParameters) > 0 && string(layout.StagedParameters) != "null" {
+		staged++
+	}
+	if staged > 0 {
+		add("staged_layout_changes", schema.HealthWarning, fmt.Sprintf("%d staged layout changes are not applied yet; apply them as layout version %d or revert them",
+			staged, layout.Version+1))
+	}
+
+	// Version skew
+	versions := map[string][]string{}
+	for _, node := range report.Nodes {
+		if node.IsUp && node.GarageVersion != "" {
+			versions[node.GarageVersion] = append(versions[node.GarageVersion], node.ID)
+		}
+	}
+	if len(versions) > 1 {
+		var parts []string
+		for version, ids := range versions {
+			parts = append(parts, fmt.Sprintf("%s on %d nodes", version, len(ids)))
+		}
+		sort.Strings(parts)
+		add("version_skew", schema.HealthWarning, "Nodes run different Garage versions: "+strings.Join(parts, ", "))
+	}
+
+	sort.SliceStable(report.Diagnostics, func(i, j int) bool {
+		return healthSeverityOrder[report.Diagnostics[i].Severity] < healthSeverityOrder[report.Diagnostics[j].Severity]
+	})
+
+	report.Status = schema.HealthOK
+	if len(report.Diagnostics) > 0 && report.Diagnostics[0].Severity != schema.HealthInfo {
+		report.Status = report.Diagnostics[0].Severity
+	}
+
+	return report
+}
+
+func healthNodeName(node *schema.HealthNode) string {
+	if node.Hostname != "" {
+		return node.Hostname
+	}
+	return node.ID[:min(len(node.ID), 16)]
+}
+
+func parseSecsAgo(value interface{}) *int64 {
+	if secs, ok := value.(float64); ok {
+		result := int64(secs)
+		return &result
+	}
+	return nil
+}
+
+// parseZoneRedundancy returns the number of zones each partition is spread
+// over: "maximum" spreads the copies over as many zones as possible, or the
+// layout sets {"atLeast": n}.
+func parseZoneRedundancy(value json.RawMessage, replicationFactor int, zones int) int {
+	var atLeast struct {
+		AtLeast int `json:"atLeast"`
+	}
+	if err := json.Unmarshal(value, &atLeast); err == nil && atLeast.AtLeast > 0 {
+		return atLeast.AtLeast
+	}
+	if replicationFactor > 0 {
+		return min(replicationFactor, zones)
+	}
+	return 0
+}
@@ -0,0 +1,348 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	syncJobType = "sync"
+
+	// Maximum number of actions listed in the result of a dry run
+	syncMaxActions = 1000
+
+	syncCheckpointInterval = 5 * time.Second
+
+	// Lifetime of the keys created for background jobs, which are deleted
+	// when the job finishes
+	jobKeyTTL = 7 * 24 * time.Hour
+)
+
+type Sync struct{}
+
+// InitSync registers the sync job, so interrupted syncs resume after a
+// restart.
+func InitSync() {
+	utils.Jobs.Register(syncJobType, runSyncJob)
+}
+
+// syncCheckpoint is the state persisted to resume a sync job after a restart.
+// Objects are processed in key order, so everything up to LastKey is done.
+type syncCheckpoint struct {
+	LastKey     string            `json:"lastKey"`
+	Result      schema.SyncResult `json:"result"`
+	AccessKeyID string            `json:"accessKeyId"`
+}
+
+// StartSync starts a background job copying objects from one bucket and
+// prefix to another using server-side copies.
+func (s *Sync) StartSync(w http.ResponseWriter, r *http.Request) {
+	var body schema.SyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	switch body.Mode {
+	case schema.SyncModeCopyMissing, schema.SyncModeOverwriteNewer, schema.SyncModeMirror:
+	case "":
+		body.Mode = schema.SyncModeCopyMissing
+	default:
+		utils.ResponseErrorStatus(w, fmt.Errorf("unknown sync mode %q", body.Mode), http.StatusBadRequest)
+		return
+	}
+
+	if body.Source.Bucket == "" || body.Target.Bucket == "" {
+		utils.ResponseErrorStatus(w, errors.New("source and target bucket are required"), http.StatusBadRequest)
+		return
+	}
+
+	source, err := getBucketTarget(r.Context(), body.Source.Bucket)
+	if err != nil {
+		utils.ResponseErrorStatus(w, fmt.Errorf("cannot find source bucket: %w", err), http.StatusNotFound)
+		return
+	}
+	target, err := getBucketTarget(r.Context(), body.Target.Bucket)
+	if err != nil {
+		utils.ResponseErrorStatus(w, fmt.Errorf("cannot find target bucket: %w", err), http.StatusNotFound)
+		return
+	}
+
+	// Syncing a prefix into itself would never terminate
+	if source.ID == target.ID && (strings.HasPrefix(body.Source.Prefix, body.Target.Prefix) ||
+		strings.HasPrefix(body.Target.Prefix, body.Source.Prefix)) {
+		utils.ResponseErrorStatus(w, errors.New("source and target must not overlap"), http.StatusBadRequest)
+		return
+	}
+
+	for _, job := range utils.Jobs.List(syncJobType) {
+		if job.Status == schema.JobStatusRunning && job.Params["targetBucketId"] == target.ID {
+			utils.ResponseErrorStatus(w, fmt.Errorf("a sync to bucket %s is already running", body.Target.Bucket), http.StatusConflict)
+			return
+		}
+	}
+
+	params := map[string]string{
+		"sourceBucket":   body.Source.Bucket,
+		"sourceBucketId": source.ID,
+		"sourcePrefix":   body.Source.Prefix,
+		"targetBucket":   body.Target.Bucket,
+		"targetBucketId": target.ID,
+		"targetPrefix":   body.Target.Prefix,
+		"mode":           body.Mode,
+		"dryRun":         strconv.FormatBool(body.DryRun),
+	}
+	job := utils.Jobs.Start(syncJobType, params, runSyncJob)
+
+	utils.ResponseSuccess(w, job.Snapshot())
+}
+
+// runSyncJob runs a sync job, continuing from its last checkpoint if it is
+// resumed after a restart.
+func runSyncJob(ctx context.Context, job *utils.Job) (interface{}, error) {
+	params := job.Snapshot().Params
+	mode := params["mode"]
+	sourcePrefix, targetPrefix := params["sourcePrefix"], params["targetPrefix"]
+
+	checkpoint := syncCheckpoint{Result: schema.SyncResult{DryRun: params["dryRun"] == "true"}}
+	if job.Checkpoint(&checkpoint) && checkpoint.AccessKeyID != "" {
+		// Key of the interrupted run
+		utils.DeleteScopedKey(ctx, checkpoint.AccessKeyID)
+	}
+	result := &checkpoint.Result
+
+	client, sourceBucket, targetBucket, accessKeyID, err := createSyncClient(ctx, job.ID(), params["sourceBucketId"], params["targetBucketId"])
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		utils.DeleteScopedKey(context.WithoutCancel(ctx), accessKeyID)
+		utils.S3Clients.Remove(accessKeyID)
+	}()
+
+	checkpoint.AccessKeyID = accessKeyID
+	if err := job.SetCheckpoint(checkpoint); err != nil {
+		return result, err
+	}
+
+	sources := newObjectIterator(ctx, client, sourceBucket, sourcePrefix, checkpoint.LastKey)
+	targets := newObjectIterator(ctx, client, targetBucket, targetPrefix, checkpoint.LastKey)
+	lastCheckpoint := time.Now()
+
+	for {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		src, err := sources.Peek()
+		if err != nil {
+			return result, fmt.Errorf("cannot list source objects: %w", err)
+		}
+		dst, err := targets.Peek()
+		if err != nil {
+			return result, fmt.Errorf("cannot list target objects: %w", err)
+		}
+		if src == nil && dst == nil {
+			break
+		}
+
+		var key, action string
+		var size int64
+
+		switch {
+		case dst == nil || (src != nil && strings.TrimPrefix(*src.Key, sourcePrefix) < strings.TrimPrefix(*dst.Key, targetPrefix)):
+			// Only in source
+			key, size, action = strings.TrimPrefix(*src.Key, sourcePrefix), aws.ToInt64(src.Size), schema.SyncActionCopy
+			sources.Pop()
+			result.Scanned++
+
+		case src == nil || strings.TrimPrefix(*dst.Key, targetPrefix) < strings.TrimPrefix(*src.Key, sourcePrefix):
+			// Only in target
+			key, size = strings.TrimPrefix(*dst.Key, targetPrefix), aws.ToInt64(dst.Size)
+			if mode == schema.SyncModeMirror {
+				action = schema.SyncActionDelete
+			}
+			targets.Pop()
+
+		default:
+			key, size = strings.TrimPrefix(*src.Key, sourcePrefix), aws.ToInt64(src.Size)
+			if shouldOverwrite(mode, src, dst) {
+				action = schema.SyncActionOverwrite
+			}
+			sources.Pop()
+			targets.Pop()
+			result.Scanned++
+		}
+
+		switch {
+		case action == "":
+			result.Skipped++
+		case result.DryRun:
+			result.ActionsTotal++
+			if len(result.Actions) < syncMaxActions {
+				result.Actions = append(result.Actions, schema.SyncAction{Action: action, Key: key, Size: size})
+			}
+		case action == schema.SyncActionDelete:
+			_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(targetBucket),
+				Key:    aws.String(targetPrefix + key),
+			})
+			if err != nil {
+				return result, fmt.Errorf("cannot delete %s: %w", key, err)
+			}
+			result.Deleted++
+		default:
+			_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:     aws.String(targetBucket),
+				Key:        aws.String(targetPrefix + key),
+				CopySource: aws.String(sourceBucket + "/" + escapeObjectKey(sourcePrefix+key)),
+			})
+			if err != nil {
+				return result, fmt.Errorf("cannot copy %s: %w", key, err)
+			}
+			result.Copied++
+			result.CopiedBytes += size
+		}
+
+		checkpoint.LastKey = key
+		job.SetProgress(result.Scanned, 0, fmt.Sprintf("copied %d, deleted %d, skipped %d", result.Copied, result.Deleted, result.Skipped))
+
+		if time.Since(lastCheckpoint) > syncCheckpointInterval {
+			if err := job.SetCheckpoint(checkpoint); err != nil {
+				slog.Error("Cannot save job checkpoint.", "job", job.ID(), "error", err)
+			}
+			lastCheckpoint = time.Now()
+		}
+	}
+
+	return result, nil
+}
+
+func shouldOverwrite(mode string, src *types.Object, dst *types.Object) bool {
+	switch mode {
+	case schema.SyncModeOverwriteNewer:
+		return src.LastModified != nil && dst.LastModified != nil && src.LastModified.After(*dst.LastModified)
+	case schema.SyncModeMirror:
+		if aws.ToInt64(src.Size) != aws.ToInt64(dst.Size) {
+			return true
+		}
+		if aws.ToString(src.ETag) == aws.ToString(dst.ETag) {
+			return false
+		}
+		// ETags of multipart uploads depend on the part sizes, so equal
+		// objects can have different ETags. The target was written after a
+		// previous copy, so it is only outdated if the source changed since.
+		return src.LastModified != nil && dst.LastModified != nil && src.LastModified.After(*dst.LastModified)
+	}
+	return false
+}
+
+// createSyncClient creates a key that can read the source and write the
+// target bucket, so the job neither depends on a browser session nor on the
+// keys of bucket owners. It returns the client along with the S3 names of
+// both buckets.
+func createSyncClient(ctx context.Context, jobID string, sourceID string, targetID string) (*s3.Client, string, string, string, error) {
+	grants := []utils.KeyGrant{{BucketID: targetID, Read: true, Write: true}}
+	if sourceID != targetID {
+		grants = append(grants, utils.KeyGrant{BucketID: sourceID, Read: true})
+	}
+
+	client, names, accessKeyID, err := createJobClient(ctx, jobID, grants)
+	if err != nil {
+		return nil, "", "", "", err
+	}
+	return client, names[sourceID], names[targetID], accessKeyID, nil
+}
+
+// createJobClient creates an expiring key for a background job with the given
+// grants. Buckets without a global alias get a local alias for the key. It
+// returns the client, the S3 names of the buckets by ID and the key ID, which
+// must be deleted with utils.DeleteScopedKey once the job is done.
+func createJobClient(ctx context.Context, jobID string, grants []utils.KeyGrant) (*s3.Client, map[string]string, string, error) {
+	names := map[string]string{}
+	for i := range grants {
+		target, err := getBucketTarget(ctx, grants[i].BucketID)
+		if err != nil {
+			return nil, nil, "", err
+		}
+
+		names[grants[i].BucketID] = target.GlobalAlias
+		if target.GlobalAlias == "" {
+			grants[i].LocalAlias = getTempLocalAlias(target.ID)
+			names[grants[i].BucketID] = grants[i].LocalAlias
+		}
+	}
+
+	name := utils.JobKeyPrefix + jobID
+	accessKeyID, secretAccessKey, err := utils.CreateScopedKey(ctx, name, time.Now().Add(jobKeyTTL), grants)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return utils.S3Clients.Get(accessKeyID, secretAccessKey), names, accessKeyID, nil
+}
+
+// objectIterator lists the objects under a prefix page by page, in key order.
+type objectIterator struct {
+	ctx        context.Context
+	client     *s3.Client
+	bucket     string
+	prefix     string
+	startAfter string
+	token      *string
+	page       []types.Object
+	done       bool
+}
+
+func newObjectIterator(ctx context.Context, client *s3.Client, bucket string, prefix string, after string) *objectIterator {
+	it := &objectIterator{ctx: ctx, client: client, bucket: bucket, prefix: prefix}
+	if after != "" {
+		it.startAfter = prefix + after
+	}
+	return it
+}
+
+// Peek returns the next object without consuming it, or nil at the end.
+func (it *objectIterator) Peek() (*types.Object, error) {
+	for len(it.page) == 0 && !it.done {
+		input := &s3.ListObjectsV2Input{
+			Bucket:            aws.String(it.bucket),
+			Prefix:            aws.String(it.prefix),
+			ContinuationToken: it.token,
+		}
+		if it.token == nil && it.startAfter != "" {
+			input.StartAfter = aws.String(it.startAfter)
+		}
+
+		objects, err := it.client.ListObjectsV2(it.ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		it.page = objects.Contents
+		it.token = objects.NextContinuationToken
+		it.done = !aws.ToBool(objects.IsTruncated)
+	}
+
+	if len(it.page) == 0 {
+		return nil, nil
+	}
+	return &it.page[0], nil
+}
+
+func (it *objectIterator) Pop() {
+	it.page = it.page[1:]
+}
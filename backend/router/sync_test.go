@@ -0,0 +1,43 @@
+package router
+
+import (
+	"khairul169/garage-webui/schema"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestShouldOverwrite(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	object := func(etag string, size int64, modified time.Time) *types.Object {
+		return &types.Object{ETag: aws.String(etag), Size: aws.Int64(size), LastModified: aws.Time(modified)}
+	}
+
+	tests := []struct {
+		name string
+		mode string
+		src  *types.Object
+		dst  *types.Object
+		want bool
+	}{
+		{"mirror equal", schema.SyncModeMirror, object(`"a"`, 10, older), object(`"a"`, 10, newer), false},
+		{"mirror size changed", schema.SyncModeMirror, object(`"a"`, 10, older), object(`"a"`, 20, newer), true},
+		{"mirror multipart copy", schema.SyncModeMirror, object(`"a-2"`, 10, older), object(`"b"`, 10, newer), false},
+		{"mirror source changed", schema.SyncModeMirror, object(`"c-2"`, 10, newer), object(`"b"`, 10, older), true},
+		{"mirror same etag newer source", schema.SyncModeMirror, object(`"a"`, 10, newer), object(`"a"`, 10, older), false},
+		{"overwrite newer", schema.SyncModeOverwriteNewer, object(`"a"`, 10, newer), object(`"a"`, 10, older), true},
+		{"overwrite older", schema.SyncModeOverwriteNewer, object(`"a"`, 10, older), object(`"b"`, 10, newer), false},
+		{"copy missing", schema.SyncModeCopyMissing, object(`"a"`, 10, newer), object(`"b"`, 20, older), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldOverwrite(tt.mode, tt.src, tt.dst); got != tt.want {
+				t.Errorf("shouldOverwrite = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+var (
+	templateNameRegex   = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	errTemplateNotFound = errors.New("template not found")
+)
+
+type Templates struct{}
+
+// templateStore keeps the bucket templates in a JSON file.
+type templateStore struct {
+	mu        sync.Mutex
+	path      string
+	templates map[string]schema.BucketTemplate
+}
+
+var bucketTemplates = &templateStore{templates: map[string]schema.BucketTemplate{}}
+
+// defaultBucketTemplates are saved when no templates file exists yet.
+var defaultBucketTemplates = []schema.BucketTemplate{
+	{
+		Name:          "static-site",
+		Description:   "Public website with a read-write deploy key",
+		WebsiteAccess: true,
+		WebsiteConfig: schema.WebsiteConfig{IndexDocument: "index.html", ErrorDocument: "404.html"},
+		Cors: []schema.CorsRule{{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "HEAD"},
+			AllowedHeaders: []string{"*"},
+		}},
+		CreateKey:      true,
+		KeyPermissions: schema.Permissions{Read: true, Write: true},
+	},
+	{
+		Name:        "app-private",
+		Description: "Private application storage with a dedicated key",
+		Lifecycle: []schema.LifecycleRule{{
+			ID:                           "abort-incomplete-uploads",
+			Enabled:                      true,
+			AbortIncompleteMultipartDays: aws.Int32(7),
+		}},
+		CreateKey:      true,
+		KeyPermissions: schema.Permissions{Read: true, Write: true},
+	},
+}
+
+// InitBucketTemplates loads the templates from BUCKET_TEMPLATES_FILE.
+func InitBucketTemplates() {
+	bucketTemplates.path = utils.GetEnv("BUCKET_TEMPLATES_FILE", utils.DataPath("templates.json"))
+
+	data, err := os.ReadFile(bucketTemplates.path)
+	if os.IsNotExist(err) {
+		for _, template := range defaultBucketTemplates {
+			bucketTemplates.templates[template.Name] = template
+		}
+		if err := bucketTemplates.save(); err != nil {
+			slog.Error("Cannot save bucket templates.", "error", err)
+		}
+		return
+	}
+	if err != nil {
+		slog.Error("Cannot read bucket templates.", "error", err)
+		return
+	}
+
+	var templates []schema.BucketTemplate
+	if err := json.Unmarshal(data, &templates); err != nil {
+		slog.Error("Cannot parse bucket templates.", "error", err)
+		return
+	}
+	for _, template := range templates {
+		bucketTemplates.templates[template.Name] = template
+	}
+}
+
+func (s *templateStore) Get(name string) (schema.BucketTemplate, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	template, ok := s.templates[name]
+	return template, ok
+}
+
+// List returns the templates sorted by name.
+func (s *templateStore) List() []schema.BucketTemplate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.listLocked()
+}
+
+func (s *templateStore) listLocked() []schema.BucketTemplate {
+	templates := make([]schema.BucketTemplate, 0, len(s.templates))
+	for _, template := range s.templates {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+func (s *templateStore) Set(template schema.BucketTemplate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.templates[template.Name]
+	s.templates[template.Name] = template
+	if err := s.saveLocked(); err != nil {
+		if existed {
+			s.templates[template.Name] = previous
+		} else {
+			delete(s.templates, template.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *templateStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	template, ok := s.templates[name]
+	if !ok {
+		return errTemplateNotFound
+	}
+
+	delete(s.templates, name)
+	if err := s.saveLocked(); err != nil {
+		s.templates[name] = template
+		return err
+	}
+	return nil
+}
+
+func (s *templateStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.saveLocked()
+}
+
+func (s *templateStore) saveLocked() error {
+	data, err := json.MarshalIndent(s.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(s.path+".tmp", s.path)
+}
+
+func (t *Templates) GetAll(w http.ResponseWriter, r *http.Request) {
+	utils.ResponseSuccess(w, bucketTemplates.List())
+}
+
+// Put creates or replaces the template with the name of the path.
+func (t *Templates) Put(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !templateNameRegex.MatchString(name) {
+		utils.ResponseErrorStatus(w, errors.New("template names may only contain letters, digits, - and _"), http.StatusBadRequest)
+		return
+	}
+
+	var template schema.BucketTemplate
+	if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+	template.Name = name
+
+	if template.CreateKey && !template.KeyPermissions.Read && !template.KeyPermissions.Write && !template.KeyPermissions.Owner {
+		utils.ResponseErrorStatus(w, errors.New("the key of the template needs at least one permission"), http.StatusBadRequest)
+		return
+	}
+
+	if err := bucketTemplates.Set(template); err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot save template: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, template)
+}
+
+func (t *Templates) Delete(w http.ResponseWriter, r *http.Request) {
+	err := bucketTemplates.Delete(r.PathValue("name"))
+	if errors.Is(err, errTemplateNotFound) {
+		utils.ResponseErrorStatus(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot delete template: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, map[string]bool{"ok": true})
+}
+
+// Create provisions a bucket from a template in one request: it creates the
+// bucket and optionally a dedicated key, then applies quotas, website, cors
+// and lifecycle settings. Everything created is rolled back if a step fails.
+func (b *Buckets) Create(w http.ResponseWriter, r *http.Request) {
+	var body schema.ProvisionBucketRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.ResponseErrorStatus(w, err, http.StatusBadRequest)
+		return
+	}
+	if body.GlobalAlias == "" {
+		utils.ResponseErrorStatus(w, errors.New("globalAlias is required"), http.StatusBadRequest)
+		return
+	}
+
+	template := schema.BucketTemplate{}
+	if body.Template != "" {
+		var ok bool
+		if template, ok = bucketTemplates.Get(body.Template); !ok {
+			utils.ResponseErrorStatus(w, errTemplateNotFound, http.StatusNotFound)
+			return
+		}
+	}
+	if body.CreateKey != nil {
+		template.CreateKey = *body.CreateKey
+	}
+	if template.CreateKey && template.KeyPermissions == (schema.Permissions{}) {
+		template.KeyPermissions = schema.Permissions{Read: true, Write: true}
+	}
+	if body.KeyName == "" {
+		body.KeyName = body.GlobalAlias
+	}
+
+	// Rollbacks and key cleanup run even if the request was cancelled
+	ctx := context.WithoutCancel(r.Context())
+	var rollback []func() error
+	fail := func(err error) {
+		for i := len(rollback) - 1; i >= 0; i-- {
+			if rbErr := rollback[i](); rbErr != nil {
+				slog.ErrorContext(r.Context(), "Cannot roll back bucket provisioning.", "bucket", body.GlobalAlias, "error", rbErr)
+			}
+		}
+		utils.ResponseError(w, fmt.Errorf("cannot provision bucket, changes were rolled back: %w", err))
+	}
+
+	data, err := utils.Garage.Fetch("/v2/CreateBucket", &utils.FetchOptions{
+		Context: r.Context(),
+		Method:  http.MethodPost,
+		Body:    map[string]string{"globalAlias": body.GlobalAlias},
+	})
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot create bucket: %w", err))
+		return
+	}
+
+	var bucket schema.Bucket
+	if err := json.Unmarshal(data, &bucket); err != nil || bucket.ID == "" {
+		// The bucket may exist without its ID being known, find it by its alias
+		rollback = append(rollback, func() error { return deleteBucketByAlias(ctx, body.GlobalAlias) })
+		if err == nil {
+			err = errors.New("the created bucket has no ID")
+		}
+		fail(fmt.Errorf("cannot read created bucket: %w", err))
+		return
+	}
+	rollback = append(rollback, func() error { return deleteEmptyBucket(ctx, bucket.ID) })
+
+	result := schema.ProvisionBucketResult{
+		BucketID:    bucket.ID,
+		GlobalAlias: body.GlobalAlias,
+		Template:    template.Name,
+	}
+
+	if template.CreateKey {
+		key, err := createBucketKey(r.Context(), bucket.ID, body.KeyName, template.KeyPermissions)
+		if key != nil {
+			rollback = append(rollback, func() error { return deleteKey(ctx, key.AccessKeyID) })
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+		result.Key = key
+	}
+
+	if template.WebsiteAccess || template.Quotas != (schema.Quotas{}) {
+		if err := updateBucketSettings(r.Context(), bucket.ID, template.WebsiteAccess, template.WebsiteConfig, template.Quotas); err != nil {
+			fail(fmt.Errorf("cannot set website and quota settings: %w", err))
+			return
+		}
+	}
+
+	if len(template.Cors) > 0 || len(template.Lifecycle) > 0 {
+		client, names, accessKeyID, err := createJobClient(r.Context(), "provision-"+bucket.ID[:min(len(bucket.ID), 16)], []utils.KeyGrant{
+			{BucketID: bucket.ID, Read: true, Owner: true},
+		})
+		if err != nil {
+			fail(err)
+			return
+		}
+		err = applyBucketS3Config(r.Context(), client, names[bucket.ID], template.Cors, template.Lifecycle)
+		utils.DeleteScopedKey(ctx, accessKeyID)
+		utils.S3Clients.Remove(accessKeyID)
+		if err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	utils.ResponseSuccess(w, result)
+}
+
+// createBucketKey creates a key with the given permissions on the bucket. The
+// key is returned even if granting the permissions or reading the created key
+// fails, as long as its ID is known, so it can be deleted again.
+func createBucketKey(ctx context.Context, bucketID string, name string, permissions schema.Permissions) (*schema.KeyElement, error) {
+	data, err := utils.Garage.Fetch("/v2/CreateKey", &utils.FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+		Body:    map[string]string{"name": name},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create key: %w", err)
+	}
+
+	var key schema.KeyElement
+	if err := json.Unmarshal(data, &key); err != nil {
+		// Return the ID if it can be read, so the key is deleted again
+		var created struct {
+			AccessKeyID string `json:"accessKeyId"`
+		}
+		if json.Unmarshal(data, &created) == nil && created.AccessKeyID != "" {
+			return &schema.KeyElement{AccessKeyID: created.AccessKeyID}, fmt.Errorf("cannot read created key: %w", err)
+		}
+		return nil, fmt.Errorf("cannot read created key: %w", err)
+	}
+	if key.AccessKeyID == "" {
+		return nil, errors.New("cannot read created key: the created key has no ID")
+	}
+	key.Permissions = permissions
+
+	_, err = utils.Garage.Fetch("/v2/AllowBucketKey", &utils.FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+		Body: map[string]interface{}{
+			"bucketId":    bucketID,
+			"accessKeyId": key.AccessKeyID,
+			"permissions": permissions,
+		},
+	})
+	if err != nil {
+		return &key, fmt.Errorf("cannot allow key on bucket: %w", err)
+	}
+
+	return &key, nil
+}
+
+func deleteKey(ctx context.Context, accessKeyID string) error {
+	_, err := utils.Garage.Fetch("/v2/DeleteKey", &utils.FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+		Params:  map[string]string{"id": accessKeyID},
+	})
+	return err
+}
+
+// deleteBucketByAlias deletes the empty bucket with the global alias.
+func deleteBucketByAlias(ctx context.Context, alias string) error {
+	data, err := utils.Garage.Fetch("/v2/GetBucketInfo", &utils.FetchOptions{
+		Context: ctx,
+		Params:  map[string]string{"globalAlias": alias},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot find bucket %s: %w", alias, err)
+	}
+
+	var bucket schema.Bucket
+	if err := json.Unmarshal(data, &bucket); err != nil {
+		return err
+	}
+	return deleteEmptyBucket(ctx, bucket.ID)
+}
+
+// deleteEmptyBucket deletes a bucket along with its aliases.
+func deleteEmptyBucket(ctx context.Context, bucketID string) error {
+	_, err := utils.Garage.Fetch("/v2/DeleteBucket", &utils.FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+		Params:  map[string]string{"id": bucketID},
+	})
+	return err
+}
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBucketTemplatesDefaultToDataDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATA_DIR", dir)
+	t.Setenv("BUCKET_TEMPLATES_FILE", "")
+
+	previous := bucketTemplates
+	bucketTemplates = &templateStore{templates: map[string]schema.BucketTemplate{}}
+	t.Cleanup(func() { bucketTemplates = previous })
+
+	InitBucketTemplates()
+	if _, err := os.Stat(filepath.Join(dir, "templates.json")); err != nil {
+		t.Fatalf("templates were not saved in DATA_DIR: %v", err)
+	}
+	if len(bucketTemplates.List()) != len(defaultBucketTemplates) {
+		t.Errorf("loaded %d templates, want %d", len(bucketTemplates.List()), len(defaultBucketTemplates))
+	}
+}
+
+func TestCreateBucketRollsBack(t *testing.T) {
+	const bucketID = "0123456789abcdef"
+
+	tests := []struct {
+		name         string
+		createStatus int
+		createResp   interface{}
+		// keyStatus and keyResp answer CreateKey if a key is created
+		keyStatus      int
+		keyResp        interface{}
+		wantStatus     int
+		wantDeleted    bool
+		wantKeyDeleted string
+	}{
+		{"created", http.StatusOK, schema.Bucket{ID: bucketID}, 0, nil, http.StatusOK, false, ""},
+		{"create fails", http.StatusConflict, map[string]string{"message": "bucket exists"}, 0, nil, http.StatusInternalServerError, false, ""},
+		{"response not decoded", http.StatusOK, "unexpected", 0, nil, http.StatusInternalServerError, true, ""},
+		{"response without ID", http.StatusOK, map[string]string{}, 0, nil, http.StatusInternalServerError, true, ""},
+		{"key fails", http.StatusOK, schema.Bucket{ID: bucketID}, http.StatusInternalServerError, map[string]string{"message": "unavailable"}, http.StatusInternalServerError, true, ""},
+		{"key response not decoded", http.StatusOK, schema.Bucket{ID: bucketID}, http.StatusOK, map[string]string{"accessKeyId": "GK1", "permissions": "unexpected"}, http.StatusInternalServerError, true, "id=GK1"},
+		{"key response without ID", http.StatusOK, schema.Bucket{ID: bucketID}, http.StatusOK, map[string]string{}, http.StatusInternalServerError, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			garage := newGarageStub(t)
+			garage.respond("CreateBucket", tt.createStatus, tt.createResp)
+			garage.respond("GetBucketInfo", http.StatusOK, schema.Bucket{ID: bucketID, GlobalAliases: []string{"photos"}})
+			garage.respond("DeleteBucket", http.StatusOK, map[string]string{})
+			garage.respond("DeleteKey", http.StatusOK, map[string]string{})
+			if tt.keyStatus != 0 {
+				garage.respond("CreateKey", tt.keyStatus, tt.keyResp)
+			}
+
+			body := `{"globalAlias":"photos","createKey":false}`
+			if tt.keyStatus != 0 {
+				body = `{"globalAlias":"photos","createKey":true}`
+			}
+			r := httptest.NewRequest(http.MethodPost, "/buckets", strings.NewReader(body))
+			r = r.WithContext(utils.WithRequestID(r.Context(), "req-1"))
+			w := httptest.NewRecorder()
+			(&Buckets{}).Create(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			deleted := garage.called("DeleteBucket")
+			if tt.wantDeleted != (len(deleted) == 1) {
+				t.Fatalf("deleted bucket %d times, want deleted %v", len(deleted), tt.wantDeleted)
+			}
+			if tt.wantDeleted && deleted[0].Query != "id="+bucketID {
+				t.Errorf("deleted %s, want id=%s", deleted[0].Query, bucketID)
+			}
+			deletedKeys := garage.called("DeleteKey")
+			if tt.wantKeyDeleted == "" && len(deletedKeys) != 0 || tt.wantKeyDeleted != "" && (len(deletedKeys) != 1 || deletedKeys[0].Query != tt.wantKeyDeleted) {
+				t.Errorf("deleted keys %+v, want %q", deletedKeys, tt.wantKeyDeleted)
+			}
+			garage.checkRequestID(t, "req-1")
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"khairul169/garage-webui/schema"
+	"khairul169/garage-webui/utils"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+var errVersioningNotSupported = errors.New("object versioning is not supported by this storage backend")
+
+type Versions struct{}
+
+func (v *Versions) GetVersions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	bucket := r.PathValue("bucket")
+	prefix := query.Get("prefix")
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil {
+		limit = 100
+	}
+
+	client, bucketName, err := getS3Client(r, bucket)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	result := schema.ObjectVersionsResult{
+		Prefixes: []string{},
+		Versions: []schema.ObjectVersion{},
+		Prefix:   prefix,
+	}
+
+	status, err := getVersioningStatus(r.Context(), client, bucketName)
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot get bucket versioning: %w", err))
+		return
+	}
+	result.Status = status
+
+	// Buckets that never had versioning enabled only have "null" versions
+	if status == "" {
+		utils.ResponseSuccess(w, result)
+		return
+	}
+
+	input := &s3.ListObjectVersionsInput{
+		Bucket:    aws.String(bucketName),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(int32(limit)),
+	}
+	if marker := query.Get("keyMarker"); marker != "" {
+		input.KeyMarker = aws.String(marker)
+	}
+	if marker := query.Get("versionIdMarker"); marker != "" {
+		input.VersionIdMarker = aws.String(marker)
+	}
+
+	versions, err := client.ListObjectVersions(r.Context(), input)
+	if err != nil {
+		if isNotImplementedError(err) {
+			utils.ResponseSuccess(w, result)
+			return
+		}
+		utils.ResponseError(w, fmt.Errorf("cannot list object versions: %w", err))
+		return
+	}
+
+	result.Supported = true
+	if aws.ToBool(versions.IsTruncated) {
+		result.NextKeyMarker = versions.NextKeyMarker
+		result.NextVersionIdMarker = versions.NextVersionIdMarker
+	}
+
+	for _, prefix := range versions.CommonPrefixes {
+		result.Prefixes = append(result.Prefixes, aws.ToString(prefix.Prefix))
+	}
+
+	for _, version := range versions.Versions {
+		key := aws.ToString(version.Key)
+		versionId := aws.ToString(version.VersionId)
+		result.Versions = append(result.Versions, schema.ObjectVersion{
+			ObjectKey:    strings.TrimPrefix(key, prefix),
+			VersionId:    versionId,
+			IsLatest:     aws.ToBool(version.IsLatest),
+			LastModified: version.LastModified,
+			Size:         version.Size,
+			ETag:         aws.ToString(version.ETag),
+			Url:          fmt.Sprintf("/browse/%s/%s?versionId=%s", bucket, key, url.QueryEscape(versionId)),
+		})
+	}
+
+	for _, marker := range versions.DeleteMarkers {
+		result.Versions = append(result.Versions, schema.ObjectVersion{
+			ObjectKey:      strings.TrimPrefix(aws.ToString(marker.Key), prefix),
+			VersionId:      aws.ToString(marker.VersionId),
+			IsLatest:       aws.ToBool(marker.IsLatest),
+			IsDeleteMarker: true,
+			LastModified:   marker.LastModified,
+		})
+	}
+
+	// Group versions of the same key together, newest first
+	sort.SliceStable(result.Versions, func(i, j int) bool {
+		a, b := result.Versions[i], result.Versions[j]
+		if a.ObjectKey != b.ObjectKey {
+			return a.ObjectKey < b.ObjectKey
+		}
+		if a.LastModified == nil || b.LastModified == nil {
+			return a.LastModified != nil
+		}
+		return a.LastModified.After(*b.LastModified)
+	})
+
+	utils.ResponseSuccess(w, result)
+}
+
+// RestoreVersion makes an older version the current one by copying it over
+// the object.
+func (v *Versions) RestoreVersion(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	key := r.PathValue("key")
+
+	var body struct {
+		VersionId string `json:"versionId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.ResponseError(w, err)
+		return
+	}
+	if body.VersionId == "" {
+		utils.ResponseErrorStatus(w, errors.New("versionId is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, bucketName, err := getWritableS3Client(r, bucket)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	if !ensureVersioningSupported(w, r, client, bucketName) {
+		return
+	}
+
+	copySource := fmt.Sprintf("%s/%s?versionId=%s", bucketName, escapeObjectKey(key), url.QueryEscape(body.VersionId))
+	result, err := client.CopyObject(r.Context(), &s3.CopyObjectInput{
+		Bucket:     aws.String(bucketName),
+		Key:        aws.String(key),
+		CopySource: aws.String(copySource),
+	})
+	if err != nil {
+		if isNotImplementedError(err) {
+			utils.ResponseErrorStatus(w, errVersioningNotSupported, http.StatusNotImplemented)
+			return
+		}
+		utils.ResponseError(w, fmt.Errorf("cannot restore version: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, result)
+}
+
+// DeleteVersion permanently deletes a single version or delete marker.
+func (v *Versions) DeleteVersion(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	key := r.PathValue("key")
+	versionId := r.URL.Query().Get("versionId")
+
+	if versionId == "" {
+		utils.ResponseErrorStatus(w, errors.New("versionId is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, bucketName, err := getWritableS3Client(r, bucket)
+	if err != nil {
+		responseS3ClientError(w, err)
+		return
+	}
+
+	// Backends without versioning would ignore the version and delete the
+	// current object instead
+	if !ensureVersioningSupported(w, r, client, bucketName) {
+		return
+	}
+
+	result, err := client.DeleteObject(r.Context(), &s3.DeleteObjectInput{
+		Bucket:    aws.String(bucketName),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionId),
+	})
+	if err != nil {
+		if isNotImplementedError(err) {
+			utils.ResponseErrorStatus(w, errVersioningNotSupported, http.StatusNotImplemented)
+			return
+		}
+		utils.ResponseError(w, fmt.Errorf("cannot delete version: %w", err))
+		return
+	}
+
+	utils.ResponseSuccess(w, result)
+}
+
+// getVersioningStatus returns the versioning status of the bucket, or an
+// empty string if versioning was never enabled or is not supported.
+func getVersioningStatus(ctx context.Context, client *s3.Client, bucket string) (string, error) {
+	versioning, err := client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		if isNotImplementedError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(versioning.Status), nil
+}
+
+func ensureVersioningSupported(w http.ResponseWriter, r *http.Request, client *s3.Client, bucket string) bool {
+	status, err := getVersioningStatus(r.Context(), client, bucket)
+	if err != nil {
+		utils.ResponseError(w, fmt.Errorf("cannot get bucket versioning: %w", err))
+		return false
+	}
+	if status == "" {
+		utils.ResponseErrorStatus(w, errVersioningNotSupported, http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+func isNotImplementedError(err error) bool {
+	var apiErr smithy.APIError
+	if !isAPIError(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "NotImplemented", "NotImplementedException":
+		return true
+	}
+	return false
+}
+
+func escapeObjectKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,111 @@
+package schema
+
+import "time"
+
+const (
+	AlertRuleNodeDown      = "node_down"
+	AlertRuleDiskFree      = "disk_free"
+	AlertRuleBucketQuota   = "bucket_quota"
+	AlertRuleBlockErrors   = "block_errors"
+	AlertRuleClusterHealth = "cluster_health"
+
+	AlertReceiverWebhook = "webhook"
+	AlertReceiverSlack   = "slack"
+	AlertReceiverEmail   = "email"
+
+	AlertStatePending  = "pending"
+	AlertStateFiring   = "firing"
+	AlertStateResolved = "resolved"
+)
+
+type AlertConfig struct {
+	Interval  string          `json:"interval" toml:"interval"`
+	Rules     []AlertRule     `json:"rules" toml:"rules"`
+	Receivers []AlertReceiver `json:"receivers" toml:"receivers"`
+	SMTP      SMTPConfig      `json:"smtp" toml:"smtp"`
+}
+
+// AlertRule raises an alert per node or bucket matching its condition. The
+// threshold is a percentage for disk_free and bucket_quota, and a number of
+// errors for block_errors.
+type AlertRule struct {
+	Name      string   `json:"name" toml:"name"`
+	Type      string   `json:"type" toml:"type"`
+	Severity  string   `json:"severity" toml:"severity"`
+	Threshold float64  `json:"threshold" toml:"threshold"`
+	Partition string   `json:"partition,omitempty" toml:"partition"`
+	Buckets   []string `json:"buckets,omitempty" toml:"buckets"`
+	For       string   `json:"for,omitempty" toml:"for"`
+	Receivers []string `json:"receivers,omitempty" toml:"receivers"`
+}
+
+type AlertReceiver struct {
+	Name         string            `json:"name" toml:"name"`
+	Type         string            `json:"type" toml:"type"`
+	URL          string            `json:"-" toml:"url"`
+	Headers      map[string]string `json:"-" toml:"headers"`
+	To           []string          `json:"to,omitempty" toml:"to"`
+	SendResolved bool              `json:"send_resolved" toml:"send_resolved"`
+}
+
+type SMTPConfig struct {
+	Host     string `json:"host" toml:"host"`
+	Port     int    `json:"port" toml:"port"`
+	Username string `json:"username" toml:"username"`
+	Password string `json:"-" toml:"password"`
+	From     string `json:"from" toml:"from"`
+}
+
+// Alert is raised by a rule for a subject, e.g. a node or a bucket. Its ID is
+// stable while the condition holds, so repeated evaluations deduplicate.
+type Alert struct {
+	ID         string     `json:"id"`
+	Rule       string     `json:"rule"`
+	Type       string     `json:"type"`
+	Severity   string     `json:"severity"`
+	Subject    string     `json:"subject"`
+	Summary    string     `json:"summary"`
+	Value      float64    `json:"value"`
+	State      string     `json:"state"`
+	StartsAt   time.Time  `json:"startsAt"`
+	FiredAt    *time.Time `json:"firedAt,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	Silenced   bool       `json:"silenced"`
+	Notified   bool       `json:"notified"`
+}
+
+// AlertEvent is an entry of the alert history.
+type AlertEvent struct {
+	Time  time.Time `json:"time"`
+	State string    `json:"state"`
+	Alert Alert     `json:"alert"`
+}
+
+// AlertSilence mutes the notifications of the alerts it matches until it
+// ends. An empty rule or subject matches any, subjects may use globs.
+type AlertSilence struct {
+	ID        string    `json:"id"`
+	Rule      string    `json:"rule"`
+	Subject   string    `json:"subject"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"createdAt"`
+	EndsAt    time.Time `json:"endsAt"`
+}
+
+type CreateAlertSilenceRequest struct {
+	Rule     string     `json:"rule"`
+	Subject  string     `json:"subject"`
+	Comment  string     `json:"comment"`
+	Duration string     `json:"duration"`
+	EndsAt   *time.Time `json:"endsAt"`
+}
+
+type AlertStatus struct {
+	Enabled     bool            `json:"enabled"`
+	Interval    string          `json:"interval,omitempty"`
+	EvaluatedAt *time.Time      `json:"evaluatedAt,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Rules       []AlertRule     `json:"rules"`
+	Receivers   []AlertReceiver `json:"receivers"`
+	Alerts      []Alert         `json:"alerts"`
+}
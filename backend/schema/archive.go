@@ -0,0 +1,44 @@
+package schema
+
+import "time"
+
+const BucketManifestVersion = 1
+
+// BucketManifest describes a bucket in an export archive, so it can be
+// recreated on import.
+type BucketManifest struct {
+	Version       int             `json:"version"`
+	ExportedAt    time.Time       `json:"exportedAt"`
+	BucketID      string          `json:"bucketId"`
+	GlobalAliases []string        `json:"globalAliases"`
+	LocalAliases  []LocalAlias    `json:"localAliases"`
+	WebsiteAccess bool            `json:"websiteAccess"`
+	WebsiteConfig WebsiteConfig   `json:"websiteConfig"`
+	Quotas        Quotas          `json:"quotas"`
+	Cors          []CorsRule      `json:"cors"`
+	Lifecycle     []LifecycleRule `json:"lifecycle"`
+	Keys          []ManifestKey   `json:"keys"`
+	Objects       int64           `json:"objects"`
+	Bytes         int64           `json:"bytes"`
+}
+
+type ManifestKey struct {
+	AccessKeyID string      `json:"accessKeyId"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type CorsRule struct {
+	AllowedOrigins []string `json:"allowedOrigins"`
+	AllowedMethods []string `json:"allowedMethods"`
+	AllowedHeaders []string `json:"allowedHeaders"`
+	ExposeHeaders  []string `json:"exposeHeaders"`
+	MaxAgeSeconds  int32    `json:"maxAgeSeconds"`
+}
+
+type BucketImportResult struct {
+	BucketID string   `json:"bucketId"`
+	Objects  int64    `json:"objects"`
+	Bytes    int64    `json:"bytes"`
+	Warnings []string `json:"warnings"`
+}
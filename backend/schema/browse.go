@@ -7,11 +7,52 @@ type BrowseObjectResult struct {
 	Objects   []BrowserObject `json:"objects"`
 	Prefix    string          `json:"prefix"`
 	NextToken *string         `json:"nextToken"`
+	ReadOnly  bool            `json:"readOnly"`
 }
 
 type BrowserObject struct {
 	ObjectKey    *string    `json:"objectKey"`
 	LastModified *time.Time `json:"lastModified"`
 	Size         *int64     `json:"size"`
+	ETag         string     `json:"etag"`
+	SHA256       string     `json:"sha256,omitempty"`
+	CRC32C       string     `json:"crc32c,omitempty"`
 	Url          string     `json:"url"`
 }
+
+type ObjectVersionsResult struct {
+	Supported           bool            `json:"supported"`
+	Status              string          `json:"status"`
+	Prefixes            []string        `json:"prefixes"`
+	Versions            []ObjectVersion `json:"versions"`
+	Prefix              string          `json:"prefix"`
+	NextKeyMarker       *string         `json:"nextKeyMarker"`
+	NextVersionIdMarker *string         `json:"nextVersionIdMarker"`
+}
+
+type ObjectVersion struct {
+	ObjectKey      string     `json:"objectKey"`
+	VersionId      string     `json:"versionId"`
+	IsLatest       bool       `json:"isLatest"`
+	IsDeleteMarker bool       `json:"isDeleteMarker"`
+	LastModified   *time.Time `json:"lastModified"`
+	Size           *int64     `json:"size,omitempty"`
+	ETag           string     `json:"etag,omitempty"`
+	Url            string     `json:"url,omitempty"`
+}
+
+type VerifyObjectResult struct {
+	Valid  bool            `json:"valid"`
+	Size   int64           `json:"size"`
+	Checks []ChecksumCheck `json:"checks"`
+}
+
+type ChecksumCheck struct {
+	Algorithm string `json:"algorithm"`
+	Source    string `json:"source"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual"`
+	Match     bool   `json:"match"`
+	// Skipped checks cannot be compared, e.g. checksums of multipart uploads
+	Skipped bool `json:"skipped,omitempty"`
+}
@@ -1,12 +1,14 @@
 package schema
 
 type Config struct {
-	RPCBindAddr   string `json:"rpc_bind_addr" toml:"rpc_bind_addr"`
-	RPCPublicAddr string `json:"rpc_public_addr" toml:"rpc_public_addr"`
-	RPCSecret     string `json:"rpc_secret" toml:"rpc_secret"`
-	Admin         Admin  `json:"admin" toml:"admin"`
-	S3API         S3API  `json:"s3_api" toml:"s3_api"`
-	S3Web         S3Web  `json:"s3_web" toml:"s3_web"`
+	RPCBindAddr       string `json:"rpc_bind_addr" toml:"rpc_bind_addr"`
+	RPCPublicAddr     string `json:"rpc_public_addr" toml:"rpc_public_addr"`
+	RPCSecret         string `json:"rpc_secret" toml:"rpc_secret"`
+	ReplicationFactor int    `json:"replication_factor" toml:"replication_factor"`
+	ReplicationMode   string `json:"replication_mode" toml:"replication_mode"`
+	Admin             Admin  `json:"admin" toml:"admin"`
+	S3API             S3API  `json:"s3_api" toml:"s3_api"`
+	S3Web             S3Web  `json:"s3_web" toml:"s3_web"`
 }
 
 type Admin struct {
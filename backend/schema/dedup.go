@@ -0,0 +1,23 @@
+package schema
+
+import "time"
+
+type DedupReport struct {
+	Bucket           string       `json:"bucket"`
+	Verified         bool         `json:"verified"`
+	ScannedObjects   int64        `json:"scannedObjects"`
+	ScannedBytes     int64        `json:"scannedBytes"`
+	DuplicateObjects int64        `json:"duplicateObjects"`
+	ReclaimableBytes int64        `json:"reclaimableBytes"`
+	Groups           []DedupGroup `json:"groups"`
+	GeneratedAt      time.Time    `json:"generatedAt"`
+}
+
+type DedupGroup struct {
+	ETag             string   `json:"etag"`
+	SHA256           string   `json:"sha256,omitempty"`
+	Size             int64    `json:"size"`
+	Keys             []string `json:"keys"`
+	Verified         bool     `json:"verified"`
+	ReclaimableBytes int64    `json:"reclaimableBytes"`
+}
@@ -0,0 +1,74 @@
+package schema
+
+import "time"
+
+const (
+	EventSnapshot = "snapshot"
+	EventNode     = "node"
+	EventLayout   = "layout"
+	EventBucket   = "bucket"
+	EventJob      = "job"
+	EventWorker   = "worker"
+	EventAlert    = "alert"
+	EventError    = "error"
+)
+
+// Event is pushed to the clients of the event stream. Its type is the SSE
+// event name, the data is one of the event payloads below.
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// EventSnapshotData is sent when a client connects, so it does not need to
+// fetch the current state separately.
+type EventSnapshotData struct {
+	LayoutVersion int                `json:"layoutVersion"`
+	Nodes         []NodeEvent        `json:"nodes"`
+	Buckets       []BucketUsageEvent `json:"buckets"`
+	Jobs          []Job              `json:"jobs"`
+	Workers       []WorkerEvent      `json:"workers"`
+	Alerts        []Alert            `json:"alerts"`
+}
+
+type NodeEvent struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	IsUp     bool   `json:"isUp"`
+	Draining bool   `json:"draining"`
+}
+
+type LayoutEvent struct {
+	Version         int `json:"version"`
+	PreviousVersion int `json:"previousVersion"`
+}
+
+// BucketUsageEvent is the usage of a bucket and its change since the last
+// event. Removed buckets are sent once with Removed set.
+type BucketUsageEvent struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Objects      int64  `json:"objects"`
+	Bytes        int64  `json:"bytes"`
+	ObjectsDelta int64  `json:"objectsDelta"`
+	BytesDelta   int64  `json:"bytesDelta"`
+	Removed      bool   `json:"removed,omitempty"`
+}
+
+// WorkerEvent is a background worker of a Garage node, e.g. a repair or a
+// scrub. Workers are sent while busy, and once more when they turn idle.
+type WorkerEvent struct {
+	Node        string `json:"node"`
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Progress    string `json:"progress,omitempty"`
+	QueueLength *int64 `json:"queueLength,omitempty"`
+	Errors      int64  `json:"errors"`
+}
+
+type ErrorEvent struct {
+	Message string `json:"message"`
+}
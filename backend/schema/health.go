@@ -0,0 +1,55 @@
+package schema
+
+const (
+	HealthOK       = "ok"
+	HealthInfo     = "info"
+	HealthWarning  = "warning"
+	HealthCritical = "critical"
+)
+
+// HealthReport combines the cluster health, status and layout with the
+// problems found in them.
+type HealthReport struct {
+	Status            string             `json:"status"`
+	ClusterStatus     string             `json:"clusterStatus"`
+	LayoutVersion     int                `json:"layoutVersion"`
+	ReplicationFactor int                `json:"replicationFactor"`
+	ZoneRedundancy    int                `json:"zoneRedundancy"`
+	Partitions        int                `json:"partitions"`
+	PartitionsQuorum  int                `json:"partitionsQuorum"`
+	PartitionsAllOk   int                `json:"partitionsAllOk"`
+	Nodes             []HealthNode       `json:"nodes"`
+	Zones             []HealthZone       `json:"zones"`
+	Diagnostics       []HealthDiagnostic `json:"diagnostics"`
+}
+
+type HealthNode struct {
+	ID              string  `json:"id"`
+	Hostname        string  `json:"hostname"`
+	Zone            string  `json:"zone,omitempty"`
+	Capacity        *int64  `json:"capacity,omitempty"`
+	IsUp            bool    `json:"isUp"`
+	LastSeenSecsAgo *int64  `json:"lastSeenSecsAgo"`
+	Draining        bool    `json:"draining"`
+	GarageVersion   string  `json:"garageVersion"`
+	DataTotal       int64   `json:"dataTotal"`
+	DataAvailable   int64   `json:"dataAvailable"`
+	DataUsedRatio   float64 `json:"dataUsedRatio"`
+}
+
+type HealthZone struct {
+	Name     string `json:"name"`
+	Nodes    int    `json:"nodes"`
+	NodesUp  int    `json:"nodesUp"`
+	Capacity int64  `json:"capacity"`
+}
+
+// HealthDiagnostic is a problem found in the cluster, with the nodes or zone
+// it concerns.
+type HealthDiagnostic struct {
+	Code     string   `json:"code"`
+	Severity string   `json:"severity"`
+	Message  string   `json:"message"`
+	Nodes    []string `json:"nodes,omitempty"`
+	Zone     string   `json:"zone,omitempty"`
+}
@@ -0,0 +1,105 @@
+package schema
+
+import "time"
+
+// ClusterSample is the health and capacity of the cluster at one point in
+// time. Partition sizes are summed over the nodes that are up.
+type ClusterSample struct {
+	Time              time.Time `json:"time"`
+	Status            string    `json:"status"`
+	KnownNodes        int       `json:"knownNodes"`
+	ConnectedNodes    int       `json:"connectedNodes"`
+	StorageNodes      int       `json:"storageNodes"`
+	StorageNodesUp    int       `json:"storageNodesUp"`
+	Partitions        int       `json:"partitions"`
+	PartitionsQuorum  int       `json:"partitionsQuorum"`
+	PartitionsAllOk   int       `json:"partitionsAllOk"`
+	DataTotal         int64     `json:"dataTotal"`
+	DataAvailable     int64     `json:"dataAvailable"`
+	DataUsed          int64     `json:"dataUsed"`
+	MetadataTotal     int64     `json:"metadataTotal"`
+	MetadataAvailable int64     `json:"metadataAvailable"`
+	Buckets           int       `json:"buckets"`
+	Objects           int64     `json:"objects"`
+	Bytes             int64     `json:"bytes"`
+}
+
+type NodesSample struct {
+	Time  time.Time    `json:"time"`
+	Nodes []NodeSample `json:"nodes"`
+}
+
+type NodeSample struct {
+	ID                string `json:"id"`
+	Hostname          string `json:"hostname"`
+	IsUp              bool   `json:"isUp"`
+	Draining          bool   `json:"draining"`
+	DataTotal         int64  `json:"dataTotal"`
+	DataAvailable     int64  `json:"dataAvailable"`
+	MetadataTotal     int64  `json:"metadataTotal"`
+	MetadataAvailable int64  `json:"metadataAvailable"`
+}
+
+type BucketSample struct {
+	Time                           time.Time `json:"time"`
+	Name                           string    `json:"name,omitempty"`
+	Objects                        int64     `json:"objects"`
+	Bytes                          int64     `json:"bytes"`
+	UnfinishedUploads              int64     `json:"unfinishedUploads"`
+	UnfinishedMultipartUploads     int64     `json:"unfinishedMultipartUploads"`
+	UnfinishedMultipartUploadBytes int64     `json:"unfinishedMultipartUploadBytes"`
+	Quotas                         Quotas    `json:"quotas"`
+}
+
+// BucketHistory is the usage of a bucket over a time range. The growth is
+// the difference between the last and the first sample.
+type BucketHistory struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name,omitempty"`
+	BytesGrowth   int64          `json:"bytesGrowth"`
+	ObjectsGrowth int64          `json:"objectsGrowth"`
+	Samples       []BucketSample `json:"samples"`
+}
+
+// NodeTimeline lists the periods a node was up or down over a time range.
+type NodeTimeline struct {
+	ID       string       `json:"id"`
+	Hostname string       `json:"hostname"`
+	Uptime   float64      `json:"uptime"`
+	Periods  []NodePeriod `json:"periods"`
+}
+
+// BucketForecast extrapolates the usage trend of a bucket to the time it
+// reaches its quotas. Times are nil if the quota is unset or usage does not
+// grow.
+type BucketForecast struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name,omitempty"`
+	Samples       int        `json:"samples"`
+	Bytes         int64      `json:"bytes"`
+	Objects       int64      `json:"objects"`
+	Quotas        Quotas     `json:"quotas"`
+	BytesPerDay   float64    `json:"bytesPerDay"`
+	ObjectsPerDay float64    `json:"objectsPerDay"`
+	MaxSizeAt     *time.Time `json:"maxSizeAt,omitempty"`
+	MaxObjectsAt  *time.Time `json:"maxObjectsAt,omitempty"`
+	DaysToQuota   *float64   `json:"daysToQuota,omitempty"`
+}
+
+// ClusterForecast extrapolates the used space of the data partitions to the
+// time they are full.
+type ClusterForecast struct {
+	Samples       int        `json:"samples"`
+	DataTotal     int64      `json:"dataTotal"`
+	DataUsed      int64      `json:"dataUsed"`
+	DataAvailable int64      `json:"dataAvailable"`
+	BytesPerDay   float64    `json:"bytesPerDay"`
+	FullAt        *time.Time `json:"fullAt,omitempty"`
+	DaysLeft      *float64   `json:"daysLeft,omitempty"`
+}
+
+type NodePeriod struct {
+	IsUp bool      `json:"isUp"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
@@ -0,0 +1,28 @@
+package schema
+
+import "time"
+
+type Job struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Params    map[string]string `json:"params"`
+	Status    string            `json:"status"`
+	Progress  JobProgress       `json:"progress"`
+	Error     string            `json:"error,omitempty"`
+	Result    interface{}       `json:"result,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+type JobProgress struct {
+	Done    int64  `json:"done"`
+	Total   int64  `json:"total"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
@@ -0,0 +1,14 @@
+package schema
+
+type LifecycleRule struct {
+	ID                           string `json:"id"`
+	Enabled                      bool   `json:"enabled"`
+	Prefix                       string `json:"prefix"`
+	ExpirationDays               *int32 `json:"expirationDays,omitempty"`
+	ExpirationDate               string `json:"expirationDate,omitempty"`
+	AbortIncompleteMultipartDays *int32 `json:"abortIncompleteMultipartDays,omitempty"`
+}
+
+type LifecycleConfig struct {
+	Rules []LifecycleRule `json:"rules"`
+}
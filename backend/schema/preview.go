@@ -0,0 +1,46 @@
+package schema
+
+type ObjectPreview struct {
+	Type        string         `json:"type"`
+	ContentType string         `json:"contentType"`
+	Size        int64          `json:"size"`
+	Text        *TextPreview   `json:"text,omitempty"`
+	Image       *ImageMetadata `json:"image,omitempty"`
+	PDF         *PDFMetadata   `json:"pdf,omitempty"`
+	Media       *MediaMetadata `json:"media,omitempty"`
+}
+
+type TextPreview struct {
+	Content   string `json:"content"`
+	Charset   string `json:"charset"`
+	Language  string `json:"language,omitempty"`
+	Truncated bool   `json:"truncated"`
+}
+
+type ImageMetadata struct {
+	Format string            `json:"format"`
+	Width  int               `json:"width"`
+	Height int               `json:"height"`
+	Exif   map[string]string `json:"exif,omitempty"`
+}
+
+type PDFMetadata struct {
+	Pages      int     `json:"pages"`
+	Width      float64 `json:"width"`
+	Height     float64 `json:"height"`
+	Title      string  `json:"title,omitempty"`
+	Author     string  `json:"author,omitempty"`
+	Creator    string  `json:"creator,omitempty"`
+	Producer   string  `json:"producer,omitempty"`
+	PreviewUrl string  `json:"previewUrl"`
+}
+
+type MediaMetadata struct {
+	Container  string  `json:"container"`
+	Duration   float64 `json:"duration"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	SampleRate int     `json:"sampleRate,omitempty"`
+	Channels   int     `json:"channels,omitempty"`
+	Bitrate    int     `json:"bitrate,omitempty"`
+}
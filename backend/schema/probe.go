@@ -0,0 +1,39 @@
+package schema
+
+import "time"
+
+const (
+	ProbeOK   = "ok"
+	ProbeFail = "fail"
+)
+
+// ReadinessReport is the result of the readiness checks. It is ready when
+// every check passes.
+type ReadinessReport struct {
+	Status    string           `json:"status"`
+	CheckedAt time.Time        `json:"checkedAt"`
+	Checks    []ReadinessCheck `json:"checks"`
+}
+
+// ReadinessCheck is the check of a dependency. Target is the address that
+// was checked, if any.
+type ReadinessCheck struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Target     string `json:"target,omitempty"`
+	Message    string `json:"message,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// ReadinessSummary is the readiness report shown to unauthenticated callers,
+// without the addresses and errors of the checks.
+type ReadinessSummary struct {
+	Status    string                 `json:"status"`
+	CheckedAt time.Time              `json:"checkedAt"`
+	Checks    []ReadinessCheckStatus `json:"checks"`
+}
+
+type ReadinessCheckStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
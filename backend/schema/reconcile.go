@@ -0,0 +1,87 @@
+package schema
+
+import "time"
+
+// DesiredState is the declarative configuration of buckets and keys. Fields
+// that are left out are not managed.
+type DesiredState struct {
+	Keys    []DesiredKey    `json:"keys"`
+	Buckets []DesiredBucket `json:"buckets"`
+}
+
+type DesiredKey struct {
+	Name         string `json:"name"`
+	ID           string `json:"id,omitempty"`
+	CreateBucket *bool  `json:"createBucket,omitempty"`
+}
+
+type DesiredBucket struct {
+	Name        string              `json:"name"`
+	Quotas      *Quotas             `json:"quotas,omitempty"`
+	Website     *DesiredWebsite     `json:"website,omitempty"`
+	Lifecycle   []LifecycleRule     `json:"lifecycle,omitempty"`
+	Permissions []DesiredPermission `json:"permissions,omitempty"`
+}
+
+type DesiredWebsite struct {
+	Enabled       bool   `json:"enabled"`
+	IndexDocument string `json:"indexDocument,omitempty"`
+	ErrorDocument string `json:"errorDocument,omitempty"`
+}
+
+type DesiredPermission struct {
+	Key   string `json:"key"`
+	Read  bool   `json:"read"`
+	Write bool   `json:"write"`
+	Owner bool   `json:"owner"`
+}
+
+const (
+	ReconcileActionCreate = "create"
+	ReconcileActionUpdate = "update"
+	ReconcileActionRevoke = "revoke"
+)
+
+type ReconcileChange struct {
+	Action string                 `json:"action"`
+	Type   string                 `json:"type"`
+	Name   string                 `json:"name"`
+	Fields []ReconcileFieldChange `json:"fields,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+type ReconcileFieldChange struct {
+	Field   string      `json:"field"`
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+type ReconcilePlan struct {
+	Changes          []ReconcileChange `json:"changes"`
+	UnmanagedBuckets []string          `json:"unmanagedBuckets"`
+	UnmanagedKeys    []string          `json:"unmanagedKeys"`
+}
+
+type ReconcileCreatedKey struct {
+	Name            string `json:"name"`
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+}
+
+type ReconcileResult struct {
+	Plan        ReconcilePlan         `json:"plan"`
+	Applied     int                   `json:"applied"`
+	Failed      int                   `json:"failed"`
+	CreatedKeys []ReconcileCreatedKey `json:"createdKeys"`
+}
+
+type ReconcileStatus struct {
+	Source    string           `json:"source"`
+	Interval  string           `json:"interval,omitempty"`
+	Enforce   bool             `json:"enforce"`
+	CheckedAt *time.Time       `json:"checkedAt,omitempty"`
+	Drift     bool             `json:"drift"`
+	Plan      *ReconcilePlan   `json:"plan,omitempty"`
+	LastApply *ReconcileResult `json:"lastApply,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
@@ -0,0 +1,52 @@
+package schema
+
+import "time"
+
+const (
+	ReplicationConflictOverwrite = "overwrite"
+	ReplicationConflictNewer     = "newer"
+	ReplicationConflictSkip      = "skip"
+)
+
+type ReplicationConfig struct {
+	Targets []ReplicationTarget `json:"targets" toml:"targets"`
+	Rules   []ReplicationRule   `json:"rules" toml:"rules"`
+}
+
+type ReplicationTarget struct {
+	Name             string `json:"name" toml:"name"`
+	Endpoint         string `json:"endpoint" toml:"endpoint"`
+	Region           string `json:"region" toml:"region"`
+	AccessKeyID      string `json:"access_key_id" toml:"access_key_id"`
+	SecretAccessKey  string `json:"-" toml:"secret_access_key"`
+	VirtualHostStyle bool   `json:"virtual_host_style" toml:"virtual_host_style"`
+	CAFile           string `json:"ca_file,omitempty" toml:"ca_file"`
+}
+
+type ReplicationRule struct {
+	Name         string `json:"name" toml:"name"`
+	Bucket       string `json:"bucket" toml:"bucket"`
+	Prefix       string `json:"prefix" toml:"prefix"`
+	Target       string `json:"target" toml:"target"`
+	TargetBucket string `json:"target_bucket" toml:"target_bucket"`
+	TargetPrefix string `json:"target_prefix" toml:"target_prefix"`
+	Interval     string `json:"interval" toml:"interval"`
+	Conflict     string `json:"conflict" toml:"conflict"`
+	Delete       bool   `json:"delete" toml:"delete"`
+}
+
+type ReplicationStatus struct {
+	Rule    ReplicationRule `json:"rule"`
+	Running bool            `json:"running"`
+	LastJob *Job            `json:"lastJob,omitempty"`
+	NextRun *time.Time      `json:"nextRun,omitempty"`
+}
+
+type ReplicationResult struct {
+	Scanned     int64 `json:"scanned"`
+	Copied      int64 `json:"copied"`
+	CopiedBytes int64 `json:"copiedBytes"`
+	Deleted     int64 `json:"deleted"`
+	Skipped     int64 `json:"skipped"`
+	Conflicts   int64 `json:"conflicts"`
+}
@@ -0,0 +1,69 @@
+package schema
+
+import "time"
+
+const (
+	ReportOwnerTenant     = "tenant"
+	ReportOwnerKey        = "key"
+	ReportOwnerUnassigned = "unassigned"
+)
+
+type ReportConfig struct {
+	Tenants  []ReportTenant `json:"tenants" toml:"tenants"`
+	Schedule ReportSchedule `json:"schedule" toml:"schedule"`
+	SMTP     SMTPConfig     `json:"smtp" toml:"smtp"`
+}
+
+// ReportTenant owns the buckets matching its bucket patterns, and the
+// buckets owned by its keys. Patterns match aliases or IDs and may use globs.
+type ReportTenant struct {
+	Name    string   `json:"name" toml:"name"`
+	Buckets []string `json:"buckets" toml:"buckets"`
+	Keys    []string `json:"keys" toml:"keys"`
+}
+
+// ReportSchedule mails the report of the previous month on a day of the
+// month.
+type ReportSchedule struct {
+	DayOfMonth int      `json:"day_of_month" toml:"day_of_month"`
+	To         []string `json:"to" toml:"to"`
+	Format     string   `json:"format" toml:"format"`
+}
+
+// UsageReport attributes the usage of buckets in a month to their owners.
+// Sizes are averaged per day, GB-days sum the daily averages in GB. Days
+// without any sample are listed in MissingDays and count as no usage.
+type UsageReport struct {
+	Month       string             `json:"month"`
+	From        time.Time          `json:"from"`
+	To          time.Time          `json:"to"`
+	GeneratedAt time.Time          `json:"generatedAt"`
+	Days        int                `json:"days"`
+	MissingDays []string           `json:"missingDays"`
+	GBDays      float64            `json:"gbDays"`
+	Owners      []UsageReportOwner `json:"owners"`
+}
+
+type UsageReportOwner struct {
+	Owner          string              `json:"owner"`
+	Kind           string              `json:"kind"`
+	GBDays         float64             `json:"gbDays"`
+	AverageBytes   float64             `json:"averageBytes"`
+	AverageObjects float64             `json:"averageObjects"`
+	PeakBytes      float64             `json:"peakBytes"`
+	Buckets        []UsageReportBucket `json:"buckets"`
+}
+
+// UsageReportBucket is the usage of a bucket attributed to an owner. Buckets
+// with several owner keys are split evenly, the share is the part of the
+// owner.
+type UsageReportBucket struct {
+	ID             string  `json:"id"`
+	Name           string  `json:"name"`
+	Share          float64 `json:"share"`
+	Days           int     `json:"days"`
+	GBDays         float64 `json:"gbDays"`
+	AverageBytes   float64 `json:"averageBytes"`
+	AverageObjects float64 `json:"averageObjects"`
+	PeakBytes      float64 `json:"peakBytes"`
+}
@@ -0,0 +1,75 @@
+package schema
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const ConfigSnapshotVersion = 1
+
+// ConfigSnapshot is the cluster configuration visible through the admin API
+// at a point in time.
+type ConfigSnapshot struct {
+	Version   int              `json:"version"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Secrets   bool             `json:"secrets"`
+	Buckets   []SnapshotBucket `json:"buckets"`
+	Keys      []SnapshotKey    `json:"keys"`
+	Layout    json.RawMessage  `json:"layout,omitempty"`
+}
+
+type SnapshotBucket struct {
+	ID            string        `json:"id"`
+	GlobalAliases []string      `json:"globalAliases"`
+	LocalAliases  []LocalAlias  `json:"localAliases"`
+	WebsiteAccess bool          `json:"websiteAccess"`
+	WebsiteConfig WebsiteConfig `json:"websiteConfig"`
+	Quotas        Quotas        `json:"quotas"`
+	Cors          []CorsRule    `json:"cors"`
+	Keys          []ManifestKey `json:"keys"`
+}
+
+type SnapshotKey struct {
+	AccessKeyID     string  `json:"accessKeyId"`
+	Name            string  `json:"name"`
+	CreateBucket    bool    `json:"createBucket"`
+	Expiration      *string `json:"expiration,omitempty"`
+	SecretAccessKey string  `json:"secretAccessKey,omitempty"`
+}
+
+type SnapshotInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+}
+
+const (
+	SnapshotChangeAdded   = "added"
+	SnapshotChangeRemoved = "removed"
+	SnapshotChangeChanged = "changed"
+)
+
+type SnapshotChange struct {
+	Type   string   `json:"type"`
+	ID     string   `json:"id"`
+	Name   string   `json:"name,omitempty"`
+	Change string   `json:"change"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// SnapshotDiff lists the changes between two snapshots. IgnoredFields are
+// bucket fields left out of the comparison.
+type SnapshotDiff struct {
+	From          string           `json:"from"`
+	To            string           `json:"to"`
+	IgnoredFields []string         `json:"ignoredFields,omitempty"`
+	Changes       []SnapshotChange `json:"changes"`
+}
+
+type SnapshotRestoreResult struct {
+	BucketsCreated int      `json:"bucketsCreated"`
+	BucketsUpdated int      `json:"bucketsUpdated"`
+	KeysCreated    int      `json:"keysCreated"`
+	KeysUpdated    int      `json:"keysUpdated"`
+	Warnings       []string `json:"warnings"`
+}
@@ -0,0 +1,42 @@
+package schema
+
+const (
+	SyncModeCopyMissing    = "copy-missing"
+	SyncModeOverwriteNewer = "overwrite-newer"
+	SyncModeMirror         = "mirror"
+)
+
+type SyncLocation struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+}
+
+type SyncRequest struct {
+	Source SyncLocation `json:"source"`
+	Target SyncLocation `json:"target"`
+	Mode   string       `json:"mode"`
+	DryRun bool         `json:"dryRun"`
+}
+
+type SyncResult struct {
+	DryRun       bool         `json:"dryRun"`
+	Scanned      int64        `json:"scanned"`
+	Copied       int64        `json:"copied"`
+	CopiedBytes  int64        `json:"copiedBytes"`
+	Deleted      int64        `json:"deleted"`
+	Skipped      int64        `json:"skipped"`
+	Actions      []SyncAction `json:"actions,omitempty"`
+	ActionsTotal int64        `json:"actionsTotal"`
+}
+
+type SyncAction struct {
+	Action string `json:"action"`
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+}
+
+const (
+	SyncActionCopy      = "copy"
+	SyncActionOverwrite = "overwrite"
+	SyncActionDelete    = "delete"
+)
@@ -0,0 +1,28 @@
+package schema
+
+// BucketTemplate is a named set of settings applied to new buckets.
+type BucketTemplate struct {
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	WebsiteAccess  bool            `json:"websiteAccess"`
+	WebsiteConfig  WebsiteConfig   `json:"websiteConfig"`
+	Quotas         Quotas          `json:"quotas"`
+	Cors           []CorsRule      `json:"cors"`
+	Lifecycle      []LifecycleRule `json:"lifecycle"`
+	CreateKey      bool            `json:"createKey"`
+	KeyPermissions Permissions     `json:"keyPermissions"`
+}
+
+type ProvisionBucketRequest struct {
+	GlobalAlias string `json:"globalAlias"`
+	Template    string `json:"template"`
+	CreateKey   *bool  `json:"createKey"`
+	KeyName     string `json:"keyName"`
+}
+
+type ProvisionBucketResult struct {
+	BucketID    string      `json:"bucketId"`
+	GlobalAlias string      `json:"globalAlias"`
+	Template    string      `json:"template,omitempty"`
+	Key         *KeyElement `json:"key,omitempty"`
+}
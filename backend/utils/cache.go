@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"strings"
 	"sync"
 	"time"
 )
@@ -32,18 +33,44 @@ func (c *CacheManager) Set(key string, value interface{}, ttl time.Duration) {
 func (c *CacheManager) Get(key string) interface{} {
 	entry, ok := c.cache.Load(key)
 	if !ok {
+		observeCacheLookup(false)
 		return nil
 	}
 
 	cacheEntry := entry.(CacheEntry)
 	if cacheEntry.expiresAt.Before(time.Now()) {
 		c.cache.Delete(key)
+		observeCacheLookup(false)
 		return nil
 	}
 
+	observeCacheLookup(true)
 	return cacheEntry.value
 }
 
+func (c *CacheManager) Delete(key string) {
+	c.cache.Delete(key)
+}
+
+func (c *CacheManager) DeletePrefix(prefix string) {
+	c.cache.Range(func(key, value any) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			c.cache.Delete(key)
+		}
+		return true
+	})
+}
+
+// DeleteFunc deletes the entries for which fn returns true.
+func (c *CacheManager) DeleteFunc(fn func(key string, value interface{}) bool) {
+	c.cache.Range(func(key, value any) bool {
+		if fn(key.(string), value.(CacheEntry).value) {
+			c.cache.Delete(key)
+		}
+		return true
+	})
+}
+
 func (c *CacheManager) IsExpired(entry CacheEntry) bool {
 	return entry.expiresAt.Before(time.Now())
 }
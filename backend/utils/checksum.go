@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+const (
+	ChecksumMetaSHA256 = "sha256"
+	ChecksumMetaCRC32C = "crc32c"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+type Checksums struct {
+	SHA256 []byte
+	CRC32C []byte
+	MD5    []byte
+}
+
+// ComputeChecksums reads the reader to the end and returns its SHA-256,
+// CRC32C and MD5 digests along with the number of bytes read.
+func ComputeChecksums(r io.Reader) (*Checksums, int64, error) {
+	sha := sha256.New()
+	crc := crc32.New(crc32cTable)
+	md := md5.New()
+
+	n, err := io.Copy(io.MultiWriter(sha, crc, md), r)
+	if err != nil {
+		return nil, n, err
+	}
+
+	return &Checksums{
+		SHA256: sha.Sum(nil),
+		CRC32C: crc.Sum(nil),
+		MD5:    md.Sum(nil),
+	}, n, nil
+}
+
+// DecodeChecksum parses a checksum given either as hex or as base64 (the
+// format used by the S3 x-amz-checksum-* headers).
+func DecodeChecksum(value string) []byte {
+	value = strings.TrimSpace(strings.Trim(value, "\""))
+	if value == "" {
+		return nil
+	}
+	if data, err := hex.DecodeString(value); err == nil {
+		return data
+	}
+	if data, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return data
+	}
+	return nil
+}
+
+// IsCompositeChecksum reports whether the S3 checksum of a multipart upload
+// is a checksum of the part checksums, e.g. "<base64>-3", which cannot be
+// compared with the digest of the whole object.
+func IsCompositeChecksum(value string) bool {
+	_, parts, ok := strings.Cut(strings.Trim(value, "\""), "-")
+	return ok && parts != ""
+}
+
+// ChecksumMatches reports whether the encoded expected checksum equals the
+// actual digest.
+func ChecksumMatches(expected string, actual []byte) bool {
+	data := DecodeChecksum(expected)
+	return data != nil && bytes.Equal(data, actual)
+}
@@ -0,0 +1,42 @@
+package utils
+
+import "testing"
+
+func TestChecksumMatches(t *testing.T) {
+	digest := []byte{0xde, 0xad, 0xbe, 0xef}
+	tests := []struct {
+		expected string
+		want     bool
+	}{
+		{"deadbeef", true},
+		{`"deadbeef"`, true},
+		{"3q2+7w==", true},
+		{"deadbeee", false},
+		{"", false},
+		{"not a checksum", false},
+	}
+
+	for _, tt := range tests {
+		if got := ChecksumMatches(tt.expected, digest); got != tt.want {
+			t.Errorf("ChecksumMatches(%q) = %v, want %v", tt.expected, got, tt.want)
+		}
+	}
+}
+
+func TestIsCompositeChecksum(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"3q2+7w==", false},
+		{"3q2+7w==-3", true},
+		{`"3q2+7w==-12"`, true},
+		{"3q2+7w==-", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsCompositeChecksum(tt.value); got != tt.want {
+			t.Errorf("IsCompositeChecksum(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
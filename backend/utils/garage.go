@@ -2,21 +2,29 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"khairul169/garage-webui/schema"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type garage struct {
-	Config schema.Config
+	Config    schema.Config
+	configErr error
 }
 
 var Garage = &garage{}
@@ -26,20 +34,28 @@ func (g *garage) LoadConfig() error {
 	data, err := os.ReadFile(path)
 
 	if err != nil {
+		g.configErr = err
 		return err
 	}
 
 	var cfg schema.Config
 	err = toml.Unmarshal(data, &cfg)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Cannot parse garage config.", "path", path, "error", err)
+		os.Exit(1)
 	}
 
 	g.Config = cfg
+	g.configErr = nil
 
 	return nil
 }
 
+// ConfigError returns why the Garage config was not loaded, or nil.
+func (g *garage) ConfigError() error {
+	return g.configErr
+}
+
 func (g *garage) GetAdminEndpoint() string {
 	endpoint := os.Getenv("API_BASE_URL")
 	if len(endpoint) > 0 {
@@ -93,22 +109,69 @@ func (g *garage) GetAdminKey() string {
 	return g.Config.Admin.AdminToken
 }
 
+// GetReplicationFactor returns the number of copies of each block, read from
+// replication_factor or the legacy replication_mode. It is 0 if unknown.
+func (g *garage) GetReplicationFactor() int {
+	if value, err := strconv.Atoi(os.Getenv("REPLICATION_FACTOR")); err == nil && value > 0 {
+		return value
+	}
+	if g.Config.ReplicationFactor > 0 {
+		return g.Config.ReplicationFactor
+	}
+
+	// Legacy modes are "none", "2", "2-dangerous", "3", "3-degraded", ...
+	mode := g.Config.ReplicationMode
+	if mode == "none" {
+		return 1
+	}
+	value, _ := strconv.Atoi(strings.SplitN(mode, "-", 2)[0])
+	return value
+}
+
 type FetchOptions struct {
 	Method  string
 	Params  map[string]string
 	Body    interface{}
 	Headers map[string]string
+	// Context of the request the call is made for, its request ID and
+	// trace context are passed on to Garage
+	Context context.Context
 }
 
+// Fetch calls the admin API in a span and records the call in the metrics,
+// labelled with the endpoint path.
 func (g *garage) Fetch(url string, options *FetchOptions) ([]byte, error) {
-	var reqBody io.Reader
-	reqUrl := fmt.Sprintf("%s%s", g.GetAdminEndpoint(), url)
+	ctx := options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	endpoint, _, _ := strings.Cut(url, "?")
 	method := http.MethodGet
-
 	if len(options.Method) > 0 {
 		method = options.Method
 	}
 
+	ctx, span := Tracer.Start(ctx, method+" "+endpoint, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.HTTPRequestMethodKey.String(method),
+		attribute.String("garage.endpoint", endpoint),
+	))
+	start := time.Now()
+	body, err := g.fetch(ctx, method, url, options)
+	EndSpan(span, err)
+	observeAdminRequest(endpoint, err, time.Since(start))
+
+	attrs := []any{"endpoint", endpoint, "duration", time.Since(start)}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	slog.DebugContext(ctx, "Admin API call.", attrs...)
+	return body, err
+}
+
+func (g *garage) fetch(ctx context.Context, method string, url string, options *FetchOptions) ([]byte, error) {
+	var reqBody io.Reader
+	reqUrl := fmt.Sprintf("%s%s", g.GetAdminEndpoint(), url)
+
 	if options.Body != nil {
 		body, err := json.Marshal(options.Body)
 		if err != nil {
@@ -117,10 +180,14 @@ func (g *garage) Fetch(url string, options *FetchOptions) ([]byte, error) {
 		reqBody = bytes.NewBuffer(body)
 	}
 
-	req, err := http.NewRequest(method, reqUrl, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl, reqBody)
 	if err != nil {
 		return nil, err
 	}
+	if id := RequestID(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	InjectTraceContext(ctx, propagation.HeaderCarrier(req.Header))
 
 	if options.Params != nil {
 		q := req.URL.Query()
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// HistoryStore keeps time series of JSON samples in an embedded database.
+// Each series is a database bucket keyed by the sample time.
+type HistoryStore struct {
+	db *bolt.DB
+}
+
+var History *HistoryStore
+
+// InitHistoryStore opens the database at HISTORY_DB. History stays nil if
+// it cannot be opened.
+func InitHistoryStore() {
+	path := GetEnv("HISTORY_DB", DataPath("history.db"))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		slog.Error("Cannot create history directory.", "error", err)
+		return
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		slog.Error("Cannot open history database.", "path", path, "error", err)
+		return
+	}
+
+	History = &HistoryStore{db: db}
+}
+
+func historyKey(at time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(at.UnixNano()))
+	return key
+}
+
+// Append stores a sample of the series at the given time, replacing any
+// sample with the same time.
+func (h *HistoryStore) Append(series string, at time.Time, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return h.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(series))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(historyKey(at), data)
+	})
+}
+
+// Range calls fn with the samples of the series between from and to, in
+// chronological order.
+func (h *HistoryStore) Range(series string, from time.Time, to time.Time, fn func(at time.Time, data []byte) error) error {
+	return h.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(series))
+		if bucket == nil {
+			return nil
+		}
+
+		end := historyKey(to)
+		cursor := bucket.Cursor()
+		for key, value := cursor.Seek(historyKey(from)); key != nil && bytes.Compare(key, end) <= 0; key, value = cursor.Next() {
+			at := time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+			if err := fn(at, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Last returns the most recent sample of the series, or nil if it is empty.
+func (h *HistoryStore) Last(series string) (time.Time, []byte, error) {
+	var at time.Time
+	var data []byte
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(series))
+		if bucket == nil {
+			return nil
+		}
+
+		key, value := bucket.Cursor().Last()
+		if key != nil {
+			at = time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+			data = append([]byte(nil), value...)
+		}
+		return nil
+	})
+	return at, data, err
+}
+
+// Series returns the names of the series starting with prefix.
+func (h *HistoryStore) Series(prefix string) ([]string, error) {
+	var names []string
+	err := h.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Cursor()
+		for name, _ := cursor.Seek([]byte(prefix)); name != nil && bytes.HasPrefix(name, []byte(prefix)); name, _ = cursor.Next() {
+			names = append(names, string(name))
+		}
+		return nil
+	})
+	return names, err
+}
+
+// Prune deletes the samples older than before from all series, and the
+// series left empty.
+func (h *HistoryStore) Prune(before time.Time) error {
+	end := historyKey(before)
+
+	return h.db.Update(func(tx *bolt.Tx) error {
+		var empty [][]byte
+		err := tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			cursor := bucket.Cursor()
+			for key, _ := cursor.First(); key != nil && bytes.Compare(key, end) < 0; key, _ = cursor.First() {
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+			}
+			if key, _ := cursor.First(); key == nil {
+				empty = append(empty, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range empty {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
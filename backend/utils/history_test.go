@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitHistoryStorePath(t *testing.T) {
+	tests := []struct {
+		name      string
+		historyDB string
+		want      string
+	}{
+		{"data dir", "", "history.db"},
+		{"history db", "custom/metrics.db", "custom/metrics.db"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("DATA_DIR", dir)
+			historyDB := tt.historyDB
+			if historyDB != "" {
+				historyDB = filepath.Join(dir, historyDB)
+			}
+			t.Setenv("HISTORY_DB", historyDB)
+
+			InitHistoryStore()
+			if History == nil {
+				t.Fatal("cannot open history database")
+			}
+			t.Cleanup(func() {
+				History.db.Close()
+				History = nil
+			})
+
+			if _, err := os.Stat(filepath.Join(dir, tt.want)); err != nil {
+				t.Errorf("history database not at %s: %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -2,25 +2,337 @@ package utils
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"image"
+	"image/draw"
 	_ "image/gif"
 	"image/jpeg"
 	_ "image/png"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/nfnt/resize"
+	"github.com/rwcarlsen/goexif/exif"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
+var ErrThumbnailTooLarge = errors.New("object is too large to create a thumbnail")
+
+type ThumbnailService struct {
+	cacheDir     string
+	maxInputSize int64
+	maxPixels    int64
+	maxCacheSize int64
+	sizes        []uint
+}
+
+var Thumbnail *ThumbnailService
+
+func InitThumbnailService() {
+	maxInputSize, err := strconv.ParseInt(GetEnv("THUMBNAIL_MAX_INPUT_SIZE", "20971520"), 10, 64)
+	if err != nil || maxInputSize <= 0 {
+		maxInputSize = 20 << 20
+	}
+
+	// Decoded images take 4 bytes per pixel, whatever their file size
+	maxPixels, err := strconv.ParseInt(GetEnv("THUMBNAIL_MAX_PIXELS", "50000000"), 10, 64)
+	if err != nil || maxPixels <= 0 {
+		maxPixels = 50_000_000
+	}
+
+	maxCacheSize, err := strconv.ParseInt(GetEnv("THUMBNAIL_CACHE_MAX_SIZE", "536870912"), 10, 64)
+	if err != nil || maxCacheSize < 0 {
+		maxCacheSize = 512 << 20
+	}
+
+	sizes := []uint{}
+	for _, s := range strings.Split(GetEnv("THUMBNAIL_SIZES", "64,128,256"), ",") {
+		size, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+		if err == nil && size > 0 {
+			sizes = append(sizes, uint(size))
+		}
+	}
+	if len(sizes) == 0 {
+		sizes = []uint{64}
+	}
+
+	cacheDir := GetEnv("THUMBNAIL_CACHE_DIR", filepath.Join(os.TempDir(), "garage-webui-thumbnails"))
+	if cacheDir != "off" {
+		if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+			slog.Warn("Cannot create thumbnail cache dir, caching disabled.", "error", err)
+			cacheDir = "off"
+		}
+	}
+	if cacheDir == "off" {
+		cacheDir = ""
+	}
+
+	Thumbnail = &ThumbnailService{
+		cacheDir:     cacheDir,
+		maxInputSize: maxInputSize,
+		maxPixels:    maxPixels,
+		maxCacheSize: maxCacheSize,
+		sizes:        sizes,
+	}
+
+	if cacheDir != "" && maxCacheSize > 0 {
+		go Thumbnail.runJanitor()
+	}
+}
+
+func (t *ThumbnailService) MaxInputSize() int64 {
+	return t.maxInputSize
+}
+
+// Size resolves the requested size to the closest allowed size, falling back
+// to the first configured size when none is requested.
+func (t *ThumbnailService) Size(requested string) uint {
+	value, err := strconv.ParseUint(requested, 10, 32)
+	if err != nil {
+		return t.sizes[0]
+	}
+
+	if slices.Contains(t.sizes, uint(value)) {
+		return uint(value)
+	}
+
+	result := t.sizes[0]
+	for _, size := range t.sizes {
+		if absDiff(size, uint(value)) < absDiff(result, uint(value)) {
+			result = size
+		}
+	}
+	return result
+}
+
+func (t *ThumbnailService) CacheKey(bucket string, key string, etag string, size uint) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%d", bucket, key, etag, size)))
+	return hex.EncodeToString(hash[:])
+}
+
+func (t *ThumbnailService) GetCached(cacheKey string) []byte {
+	if t.cacheDir == "" {
+		return nil
+	}
+
+	path := filepath.Join(t.cacheDir, cacheKey+".jpg")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	// The janitor evicts the thumbnails used least recently first
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data
+}
+
+func (t *ThumbnailService) Store(cacheKey string, data []byte) {
+	if t.cacheDir == "" {
+		return
+	}
+
+	// Write to a temp file first so concurrent readers never see partial data
+	tmp, err := os.CreateTemp(t.cacheDir, cacheKey+".*.tmp")
+	if err != nil {
+		slog.Warn("Cannot write thumbnail cache.", "error", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		return
+	}
+	os.Rename(tmp.Name(), filepath.Join(t.cacheDir, cacheKey+".jpg"))
+}
+
+func (t *ThumbnailService) runJanitor() {
+	for {
+		t.evict()
+		time.Sleep(10 * time.Minute)
+	}
+}
+
+// evict deletes the least recently used thumbnails until the cache is below
+// its maximum size, along with temporary files left over by a crash.
+func (t *ThumbnailService) evict() {
+	entries, err := os.ReadDir(t.cacheDir)
+	if err != nil {
+		slog.Warn("Cannot read thumbnail cache.", "error", err)
+		return
+	}
+
+	type cachedFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cachedFile
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(t.cacheDir, entry.Name())
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			if time.Since(info.ModTime()) > time.Hour {
+				os.Remove(path)
+			}
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), ".jpg") {
+			continue
+		}
+		files = append(files, cachedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+	if total <= t.maxCacheSize {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	removed := 0
+	for _, file := range files {
+		if total <= t.maxCacheSize {
+			break
+		}
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		total -= file.size
+		removed++
+	}
+	slog.Debug("Evicted thumbnails.", "count", removed, "cacheSize", total)
+}
+
+// Create decodes the image from the reader, applies its EXIF orientation and
+// returns a JPEG thumbnail that fits within size x size. Images with more
+// pixels than allowed are rejected before they are decoded.
+func (t *ThumbnailService) Create(reader io.Reader, size uint) ([]byte, error) {
+	buffer, err := io.ReadAll(io.LimitReader(reader, t.maxInputSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buffer)) > t.maxInputSize {
+		return nil, ErrThumbnailTooLarge
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(buffer))
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > t.maxPixels {
+		return nil, ErrThumbnailTooLarge
+	}
+
+	return CreateThumbnailImage(buffer, size, size)
+}
+
 func CreateThumbnailImage(buffer []byte, width uint, height uint) ([]byte, error) {
 	img, _, err := image.Decode(bytes.NewReader(buffer))
 	if err != nil {
 		return nil, err
 	}
 
-	thumb := resize.Thumbnail(width, height, img, resize.NearestNeighbor)
+	// The thumbnail is oriented rather than the full image, images turned by
+	// 90 degrees are fitted into the swapped bounds
+	orientation := GetImageOrientation(buffer)
+	if orientation >= 5 {
+		width, height = height, width
+	}
+
+	thumb := applyOrientation(resize.Thumbnail(width, height, img, resize.Lanczos3), orientation)
 	buf := new(bytes.Buffer)
-	if err := jpeg.Encode(buf, thumb, nil); err != nil {
+	if err := jpeg.Encode(buf, thumb, &jpeg.Options{Quality: 85}); err != nil {
 		return nil, err
 	}
 
 	return buf.Bytes(), nil
 }
+
+// GetImageOrientation returns the EXIF orientation tag (1-8) of the image, or
+// 1 if the image has no EXIF data.
+func GetImageOrientation(buffer []byte) int {
+	x, err := exif.Decode(bytes.NewReader(buffer))
+	if err != nil {
+		return 1
+	}
+
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 {
+		return img
+	}
+
+	src := image.NewNRGBA(img.Bounds().Sub(img.Bounds().Min))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	swap := orientation >= 5
+
+	dstW, dstH := w, h
+	if swap {
+		dstW, dstH = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 cw
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 ccw
+				dx, dy = y, w-1-x
+			}
+			dst.SetNRGBA(dx, dy, src.NRGBAAt(x, y))
+		}
+	}
+
+	return dst
+}
+
+func absDiff(a uint, b uint) uint {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
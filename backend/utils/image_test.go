@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+func encodePNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestThumbnailCreateLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     []byte
+		maxPixels int64
+		wantErr   error
+		wantOK    bool
+	}{
+		{"within limits", encodePNG(t, 40, 20), 1000, nil, true},
+		{"too many pixels", encodePNG(t, 40, 30), 1000, ErrThumbnailTooLarge, false},
+		{"not an image", []byte("plain text"), 1000, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &ThumbnailService{maxInputSize: 1 << 20, maxPixels: tt.maxPixels, sizes: []uint{16}}
+			thumb, err := service.Create(bytes.NewReader(tt.input), 16)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantOK != (err == nil) {
+				t.Fatalf("err = %v, want success %v", err, tt.wantOK)
+			}
+			if tt.wantOK && len(thumb) == 0 {
+				t.Error("empty thumbnail")
+			}
+		})
+	}
+}
+
+func TestThumbnailEvict(t *testing.T) {
+	type file struct {
+		name string
+		size int
+		age  time.Duration
+	}
+
+	tests := []struct {
+		name    string
+		files   []file
+		maxSize int64
+		want    []string
+	}{
+		{
+			name:    "below limit",
+			files:   []file{{"a.jpg", 10, time.Hour}, {"b.jpg", 10, time.Minute}},
+			maxSize: 20,
+			want:    []string{"a.jpg", "b.jpg"},
+		},
+		{
+			name:    "least recently used first",
+			files:   []file{{"old.jpg", 10, 3 * time.Hour}, {"mid.jpg", 10, 2 * time.Hour}, {"new.jpg", 10, time.Minute}},
+			maxSize: 15,
+			want:    []string{"new.jpg"},
+		},
+		{
+			name:    "stale temporary files",
+			files:   []file{{"a.jpg.1.tmp", 10, 2 * time.Hour}, {"b.jpg.2.tmp", 10, time.Minute}, {"c.jpg", 10, time.Minute}},
+			maxSize: 100,
+			want:    []string{"b.jpg.2.tmp", "c.jpg"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				path := filepath.Join(dir, f.name)
+				if err := os.WriteFile(path, make([]byte, f.size), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				modTime := time.Now().Add(-f.age)
+				os.Chtimes(path, modTime, modTime)
+			}
+
+			service := &ThumbnailService{cacheDir: dir, maxCacheSize: tt.maxSize}
+			service.evict()
+
+			entries, _ := os.ReadDir(dir)
+			got := []string{}
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			sort.Strings(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThumbnailCacheHitRefreshesAge(t *testing.T) {
+	dir := t.TempDir()
+	service := &ThumbnailService{cacheDir: dir, maxCacheSize: 15}
+	for _, key := range []string{"old", "new"} {
+		service.Store(key, make([]byte, 10))
+	}
+	for key, age := range map[string]time.Duration{"old": 2 * time.Hour, "new": time.Hour} {
+		modTime := time.Now().Add(-age)
+		os.Chtimes(filepath.Join(dir, key+".jpg"), modTime, modTime)
+	}
+
+	if service.GetCached("old") == nil {
+		t.Fatal("cached thumbnail not found")
+	}
+	service.evict()
+
+	if service.GetCached("old") == nil || service.GetCached("new") != nil {
+		t.Error("the thumbnail read last was evicted")
+	}
+}
+
+// withOrientation adds an EXIF segment with the orientation to the JPEG.
+func withOrientation(data []byte, orientation int) []byte {
+	tiff := []byte{
+		'I', 'I', 42, 0, 8, 0, 0, 0, // header, first IFD at offset 8
+		1, 0, // one entry
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(orientation), 0, 0, 0, // orientation, short
+		0, 0, 0, 0, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := append([]byte{0xff, 0xe1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+	return slices.Concat(data[:2], segment, data[2:])
+}
+
+func TestCreateThumbnailImageOrientation(t *testing.T) {
+	// The left half of the image is white
+	img := image.NewGray(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		orientation int
+		wantSize    image.Point
+		// wantWhite is a point of the thumbnail in the white half
+		wantWhite image.Point
+	}{
+		{1, image.Pt(100, 50), image.Pt(25, 25)},
+		{3, image.Pt(100, 50), image.Pt(75, 25)},
+		{6, image.Pt(25, 50), image.Pt(12, 12)},
+		{8, image.Pt(25, 50), image.Pt(12, 37)},
+	}
+
+	for _, tt := range tests {
+		thumb, err := CreateThumbnailImage(withOrientation(buf.Bytes(), tt.orientation), 100, 50)
+		if err != nil {
+			t.Fatalf("orientation %d: %v", tt.orientation, err)
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(thumb))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if size := decoded.Bounds().Size(); size != tt.wantSize {
+			t.Errorf("orientation %d: size = %v, want %v", tt.orientation, size, tt.wantSize)
+		}
+		black := image.Pt(decoded.Bounds().Dx()-1-tt.wantWhite.X, decoded.Bounds().Dy()-1-tt.wantWhite.Y)
+		if white, _, _, _ := decoded.At(tt.wantWhite.X, tt.wantWhite.Y).RGBA(); white < 0xc000 {
+			t.Errorf("orientation %d: %v is not white", tt.orientation, tt.wantWhite)
+		}
+		if dark, _, _, _ := decoded.At(black.X, black.Y).RGBA(); dark > 0x4000 {
+			t.Errorf("orientation %d: %v is not black", tt.orientation, black)
+		}
+	}
+}
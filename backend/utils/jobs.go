@@ -0,0 +1,375 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"khairul169/garage-webui/schema"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var errJobInterrupted = errors.New("job was interrupted by a restart")
+
+// JobKeyPrefix is the name prefix of the Garage keys created for background
+// jobs. Expired keys with this prefix are removed by the janitor.
+const JobKeyPrefix = "webui-job-"
+
+// jobJanitorInterval is the interval between deletions of expired job keys
+// and finished jobs past their retention. Job keys are only left behind if
+// the process stops during a job, so they are not cleaned up at startup.
+const jobJanitorInterval = time.Hour
+
+type JobFunc func(ctx context.Context, job *Job) (interface{}, error)
+
+type Job struct {
+	mu         sync.Mutex
+	data       schema.Job
+	checkpoint json.RawMessage
+	manager    *JobManager
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+type JobManager struct {
+	jobs     sync.Map
+	dir      string
+	handlers sync.Map
+	// retention is how long finished jobs are kept, 0 keeps them forever
+	retention time.Duration
+}
+
+// persistedJob is the on-disk state of a job, used to resume it after a
+// restart.
+type persistedJob struct {
+	schema.Job
+	Checkpoint json.RawMessage `json:"checkpoint,omitempty"`
+}
+
+var Jobs *JobManager
+
+func InitJobManager() {
+	dir := GetEnv("JOBS_DIR", DataPath("jobs"))
+	if dir != "off" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			slog.Warn("Cannot create jobs dir, jobs will not be persisted.", "error", err)
+			dir = "off"
+		}
+	}
+	if dir == "off" {
+		dir = ""
+	}
+
+	retention := 7 * 24 * time.Hour
+	value := GetEnv("JOB_RETENTION", "168h")
+	if parsed, err := time.ParseDuration(value); err == nil && parsed >= 0 {
+		retention = parsed
+	} else {
+		slog.Warn("Invalid JOB_RETENTION, using the default.", "value", value, "default", retention)
+	}
+
+	Jobs = &JobManager{dir: dir, retention: retention}
+	Jobs.load()
+	Jobs.prune()
+	go Jobs.runJanitor()
+}
+
+// runJanitor removes finished jobs past their retention and deletes the
+// expired keys of jobs that never finished, e.g. because the process
+// crashed and the job was not resumed.
+func (m *JobManager) runJanitor() {
+	for {
+		time.Sleep(jobJanitorInterval)
+		m.prune()
+
+		ctx, span := StartTask(context.Background(), "job keys cleanup")
+		DeleteExpiredKeys(ctx, JobKeyPrefix)
+		span.End()
+	}
+}
+
+// prune removes the jobs that finished longer than the retention ago.
+func (m *JobManager) prune() {
+	if m.retention == 0 {
+		return
+	}
+
+	before := time.Now().Add(-m.retention)
+	m.jobs.Range(func(key, value any) bool {
+		job := value.(*Job).Snapshot()
+		if job.Status != schema.JobStatusRunning && job.UpdatedAt.Before(before) {
+			m.Remove(job.ID)
+		}
+		return true
+	})
+}
+
+// Register sets the function resuming jobs of the given type after a
+// restart. Jobs of types without a registered function fail on restart.
+func (m *JobManager) Register(jobType string, fn JobFunc) {
+	m.handlers.Store(jobType, fn)
+}
+
+// Start runs fn in the background and returns the job tracking it.
+func (m *JobManager) Start(jobType string, params map[string]string, fn JobFunc) *Job {
+	now := time.Now()
+
+	job := &Job{
+		data: schema.Job{
+			ID:        newJobID(),
+			Type:      jobType,
+			Params:    params,
+			Status:    schema.JobStatusRunning,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		manager: m,
+	}
+	m.jobs.Store(job.data.ID, job)
+	m.run(job, fn)
+
+	return job
+}
+
+// Resume restarts the jobs that were running when the process stopped.
+func (m *JobManager) Resume() {
+	m.jobs.Range(func(key, value any) bool {
+		job := value.(*Job)
+		if job.done != nil {
+			return true
+		}
+
+		fn, ok := m.handlers.Load(job.data.Type)
+		if !ok {
+			job.data.Status = schema.JobStatusFailed
+			job.data.Error = errJobInterrupted.Error()
+			job.done = make(chan struct{})
+			job.cancel = func() {}
+			close(job.done)
+			m.save(job)
+			return true
+		}
+
+		slog.Info("Resuming job.", "job", job.data.ID, "type", job.data.Type)
+		m.run(job, fn.(JobFunc))
+		return true
+	})
+}
+
+func (m *JobManager) run(job *Job, fn JobFunc) {
+	ctx, span := StartTask(context.Background(), "job "+job.data.Type, attribute.String("job.id", job.data.ID))
+	ctx, cancel := context.WithCancel(ctx)
+	job.cancel = cancel
+	job.done = make(chan struct{})
+	m.save(job)
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+
+		result, err := fn(ctx, job)
+		EndSpan(span, err)
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+
+		job.data.UpdatedAt = time.Now()
+		job.data.Result = result
+
+		switch {
+		case errors.Is(err, context.Canceled):
+			job.data.Status = schema.JobStatusCancelled
+		case err != nil:
+			job.data.Status = schema.JobStatusFailed
+			job.data.Error = err.Error()
+			slog.Error("Job failed.", "job", job.data.ID, "type", job.data.Type, "error", err)
+		default:
+			job.data.Status = schema.JobStatusCompleted
+		}
+		m.saveLocked(job)
+	}()
+}
+
+func (m *JobManager) Get(id string) *Job {
+	job, ok := m.jobs.Load(id)
+	if !ok {
+		return nil
+	}
+	return job.(*Job)
+}
+
+// List returns a snapshot of all jobs of the given type (or all jobs if
+// jobType is empty), newest first.
+func (m *JobManager) List(jobType string) []schema.Job {
+	result := []schema.Job{}
+	m.jobs.Range(func(key, value any) bool {
+		job := value.(*Job).Snapshot()
+		if jobType == "" || job.Type == jobType {
+			result = append(result, job)
+		}
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+func (m *JobManager) Cancel(id string) bool {
+	job := m.Get(id)
+	if job == nil {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// Remove forgets a finished job. Running jobs are cancelled first.
+func (m *JobManager) Remove(id string) {
+	job := m.Get(id)
+	if job == nil {
+		return
+	}
+	job.cancel()
+	<-job.done
+	m.jobs.Delete(id)
+
+	if m.dir != "" {
+		os.Remove(filepath.Join(m.dir, id+".json"))
+	}
+}
+
+// load reads the jobs persisted by a previous process. Jobs that were
+// running are kept pending until Resume is called.
+func (m *JobManager) load() {
+	if m.dir == "" {
+		return
+	}
+
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		slog.Error("Cannot read jobs dir.", "error", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(m.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		var stored persistedJob
+		if err := json.Unmarshal(data, &stored); err != nil || stored.ID == "" {
+			slog.Error("Cannot parse job.", "file", entry.Name(), "error", err)
+			continue
+		}
+
+		job := &Job{data: stored.Job, checkpoint: stored.Checkpoint, manager: m}
+		if stored.Status != schema.JobStatusRunning {
+			job.done = make(chan struct{})
+			job.cancel = func() {}
+			close(job.done)
+		}
+		m.jobs.Store(job.data.ID, job)
+	}
+}
+
+func (m *JobManager) save(job *Job) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	m.saveLocked(job)
+}
+
+func (m *JobManager) saveLocked(job *Job) {
+	if m.dir == "" {
+		return
+	}
+
+	data, err := json.Marshal(persistedJob{Job: job.data, Checkpoint: job.checkpoint})
+	if err != nil {
+		slog.Error("Cannot encode job.", "job", job.data.ID, "error", err)
+		return
+	}
+
+	path := filepath.Join(m.dir, job.data.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		slog.Error("Cannot save job.", "job", job.data.ID, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		slog.Error("Cannot save job.", "job", job.data.ID, "error", err)
+	}
+}
+
+func (j *Job) ID() string {
+	return j.data.ID
+}
+
+func (j *Job) Snapshot() schema.Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.data
+}
+
+func (j *Job) SetProgress(done int64, total int64, message string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.data.Progress = schema.JobProgress{Done: done, Total: total, Message: message}
+	j.data.UpdatedAt = time.Now()
+}
+
+// Checkpoint decodes the last checkpoint of the job into v. It returns false
+// if the job has no checkpoint, i.e. it was not resumed.
+func (j *Job) Checkpoint(v interface{}) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.checkpoint) == 0 {
+		return false
+	}
+	return json.Unmarshal(j.checkpoint, v) == nil
+}
+
+// SetCheckpoint persists the state needed to resume the job along with its
+// progress.
+func (j *Job) SetCheckpoint(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.checkpoint = data
+	j.data.UpdatedAt = time.Now()
+	j.manager.saveLocked(j)
+	return nil
+}
+
+// Wait blocks until the job has finished.
+func (j *Job) Wait() {
+	<-j.done
+}
+
+func newJobID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
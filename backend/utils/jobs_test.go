@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"encoding/json"
+	"khairul169/garage-webui/schema"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestJobsDir(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		jobsDir string
+		want    string
+	}{
+		{"data dir", "", filepath.Join(dir, "jobs")},
+		{"jobs dir", filepath.Join(dir, "custom"), filepath.Join(dir, "custom")},
+		{"disabled", "off", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DATA_DIR", dir)
+			t.Setenv("JOBS_DIR", tt.jobsDir)
+
+			previous := Jobs
+			t.Cleanup(func() { Jobs = previous })
+
+			InitJobManager()
+			if Jobs.dir != tt.want {
+				t.Errorf("dir = %q, want %q", Jobs.dir, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobRetention(t *testing.T) {
+	now := time.Now()
+	stored := []schema.Job{
+		{ID: "recent", Status: schema.JobStatusCompleted, UpdatedAt: now.Add(-time.Hour)},
+		{ID: "old", Status: schema.JobStatusCompleted, UpdatedAt: now.Add(-48 * time.Hour)},
+		{ID: "old-failed", Status: schema.JobStatusFailed, UpdatedAt: now.Add(-48 * time.Hour)},
+		{ID: "interrupted", Status: schema.JobStatusRunning, UpdatedAt: now.Add(-48 * time.Hour)},
+	}
+
+	tests := []struct {
+		name      string
+		retention string
+		want      []string
+	}{
+		{"within retention", "72h", []string{"interrupted", "old", "old-failed", "recent"}},
+		{"past retention", "24h", []string{"interrupted", "recent"}},
+		{"kept forever", "0", []string{"interrupted", "old", "old-failed", "recent"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, job := range stored {
+				data, _ := json.Marshal(persistedJob{Job: job})
+				if err := os.WriteFile(filepath.Join(dir, job.ID+".json"), data, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("JOBS_DIR", dir)
+			t.Setenv("JOB_RETENTION", tt.retention)
+
+			previous := Jobs
+			t.Cleanup(func() { Jobs = previous })
+			InitJobManager()
+
+			var ids []string
+			for _, job := range Jobs.List("") {
+				ids = append(ids, job.ID)
+			}
+			sort.Strings(ids)
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("jobs = %v, want %v", ids, tt.want)
+			}
+
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != len(tt.want) {
+				t.Errorf("%d job files left, want %d", len(entries), len(tt.want))
+			}
+		})
+	}
+}
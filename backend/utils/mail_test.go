@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"bufio"
+	"khairul169/garage-webui/schema"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSink is a minimal SMTP server recording the mails it receives. If
+// silent, it accepts connections without ever answering.
+type smtpSink struct {
+	addr   string
+	silent bool
+	mails  chan string
+}
+
+func newSMTPSink(t *testing.T, silent bool) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	sink := &smtpSink{addr: listener.Addr().String(), silent: silent, mails: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	if s.silent {
+		time.Sleep(5 * time.Second)
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 sink ready")
+
+	var mail strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch command {
+		case "EHLO", "HELO":
+			reply("250 sink")
+		case "MAIL", "RCPT":
+			mail.WriteString(line)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				mail.WriteString(line)
+			}
+			s.mails <- mail.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+func (s *smtpSink) config() schema.SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.addr)
+	portNumber, _ := strconv.Atoi(port)
+	return schema.SMTPConfig{Host: host, Port: portNumber, From: "garage@example.com"}
+}
+
+func TestSendMail(t *testing.T) {
+	timeout := mailTimeout
+	mailTimeout = 500 * time.Millisecond
+	t.Cleanup(func() { mailTimeout = timeout })
+
+	tests := []struct {
+		name     string
+		silent   bool
+		to       []string
+		username string
+		wantErr  string
+	}{
+		{name: "delivered", to: []string{"ops@example.com", "billing@example.com"}},
+		{name: "unresponsive server", silent: true, to: []string{"ops@example.com"}, wantErr: "timeout"},
+		{name: "header injection", to: []string{"ops@example.com\r\nBcc: x@example.com"}, wantErr: "invalid mail address"},
+		{name: "auth not supported", to: []string{"ops@example.com"}, username: "user", wantErr: "does not support authentication"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newSMTPSink(t, tt.silent)
+			config := sink.config()
+			config.Username = tt.username
+
+			start := time.Now()
+			err := SendMail(config, tt.to, "Disk almost full", "Node 1 has 5% free space left",
+				MailAttachment{Name: "usage.csv", ContentType: "text/csv", Data: []byte("a,b\n")})
+			if time.Since(start) > 2*time.Second {
+				t.Errorf("SendMail took %s", time.Since(start))
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SendMail: %v", err)
+			}
+
+			mail := <-sink.mails
+			for _, want := range []string{"MAIL FROM:<garage@example.com>", "RCPT TO:<billing@example.com>", "Subject: Disk almost full", "Node 1 has 5% free space left", `filename=usage.csv`} {
+				if !strings.Contains(mail, want) {
+					t.Errorf("mail does not contain %q:\n%s", want, mail)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,563 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"khairul169/garage-webui/schema"
+	"math"
+)
+
+var ErrUnknownMediaFormat = errors.New("unknown media format")
+
+// GetMediaMetadata reads the duration and stream details of audio and video
+// files from their container headers. Only the header (and for some
+// containers, the tail) of the file is read.
+func GetMediaMetadata(r io.ReaderAt, size int64) (*schema.MediaMetadata, error) {
+	head := make([]byte, 64)
+	n, err := r.ReadAt(head, 0)
+	if n < 12 {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	head = head[:n]
+
+	switch {
+	case string(head[4:8]) == "ftyp":
+		return parseMP4(r, size)
+	case string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return parseWAV(r, size)
+	case string(head[0:4]) == "fLaC":
+		return parseFLAC(r)
+	case string(head[0:4]) == "OggS":
+		return parseOgg(r, size)
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return parseMatroska(r, size)
+	case string(head[0:3]) == "ID3" || (head[0] == 0xFF && head[1]&0xE0 == 0xE0):
+		return parseMP3(r, size)
+	}
+
+	return nil, ErrUnknownMediaFormat
+}
+
+func readAt(r io.ReaderAt, offset int64, length int) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, length)
+	n, err := r.ReadAt(buf, offset)
+	if n == length {
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+// MP4 / QuickTime
+
+const maxMP4MoovSize = 16 << 20
+
+func parseMP4(r io.ReaderAt, size int64) (*schema.MediaMetadata, error) {
+	var offset int64
+	for offset+8 <= size {
+		header, err := readAt(r, offset, 16)
+		if err != nil {
+			header, err = readAt(r, offset, 8)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if len(header) < 16 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize {
+			return nil, errors.New("invalid mp4 box size")
+		}
+
+		if boxType == "moov" {
+			if boxSize > maxMP4MoovSize {
+				return nil, errors.New("mp4 moov box is too large")
+			}
+			moov, err := readAt(r, offset+headerSize, int(boxSize-headerSize))
+			if err != nil {
+				return nil, err
+			}
+			return parseMP4Moov(moov)
+		}
+
+		offset += boxSize
+	}
+
+	return nil, errors.New("mp4 moov box not found")
+}
+
+func parseMP4Moov(moov []byte) (*schema.MediaMetadata, error) {
+	result := &schema.MediaMetadata{Container: "mp4"}
+
+	var walk func(data []byte)
+	walk = func(data []byte) {
+		for len(data) >= 8 {
+			boxSize := int(binary.BigEndian.Uint32(data[0:4]))
+			boxType := string(data[4:8])
+			if boxSize < 8 || boxSize > len(data) {
+				return
+			}
+			body := data[8:boxSize]
+
+			switch boxType {
+			case "trak", "mdia", "minf", "stbl":
+				walk(body)
+			case "mvhd":
+				if len(body) >= 20 && body[0] == 0 {
+					timescale := binary.BigEndian.Uint32(body[12:16])
+					duration := binary.BigEndian.Uint32(body[16:20])
+					if timescale > 0 {
+						result.Duration = float64(duration) / float64(timescale)
+					}
+				} else if len(body) >= 32 && body[0] == 1 {
+					timescale := binary.BigEndian.Uint32(body[20:24])
+					duration := binary.BigEndian.Uint64(body[24:32])
+					if timescale > 0 {
+						result.Duration = float64(duration) / float64(timescale)
+					}
+				}
+			case "tkhd":
+				// Width and height are the last two 16.16 fixed point fields
+				if len(body) >= 84 && result.Width == 0 {
+					width := int(binary.BigEndian.Uint32(body[len(body)-8:]) >> 16)
+					height := int(binary.BigEndian.Uint32(body[len(body)-4:]) >> 16)
+					if width > 0 && height > 0 {
+						result.Width = width
+						result.Height = height
+					}
+				}
+			case "stsd":
+				// Audio sample entry: channels and sample rate
+				if len(body) >= 8+36 && result.SampleRate == 0 {
+					entry := body[8:]
+					format := string(entry[4:8])
+					if format == "mp4a" || format == "alac" || format == "Opus" || format == "fLaC" {
+						result.Channels = int(binary.BigEndian.Uint16(entry[24:26]))
+						result.SampleRate = int(binary.BigEndian.Uint32(entry[32:36]) >> 16)
+					}
+				}
+			}
+
+			data = data[boxSize:]
+		}
+	}
+	walk(moov)
+
+	return result, nil
+}
+
+// WAV
+
+func parseWAV(r io.ReaderAt, size int64) (*schema.MediaMetadata, error) {
+	result := &schema.MediaMetadata{Container: "wav"}
+	var byteRate uint32
+
+	offset := int64(12)
+	for offset+8 <= size {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return nil, err
+		}
+		chunkID := string(header[0:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+		switch chunkID {
+		case "fmt ":
+			fmtChunk, err := readAt(r, offset+8, 16)
+			if err != nil {
+				return nil, err
+			}
+			result.Channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			result.SampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			byteRate = binary.LittleEndian.Uint32(fmtChunk[8:12])
+			result.Bitrate = int(byteRate) * 8
+		case "data":
+			if byteRate > 0 {
+				dataSize := min(chunkSize, size-offset-8)
+				result.Duration = float64(dataSize) / float64(byteRate)
+			}
+			return result, nil
+		}
+
+		offset += 8 + chunkSize + chunkSize%2
+	}
+
+	return result, nil
+}
+
+// FLAC
+
+func parseFLAC(r io.ReaderAt) (*schema.MediaMetadata, error) {
+	block, err := readAt(r, 4, 4+34)
+	if err != nil {
+		return nil, err
+	}
+	if block[0]&0x7F != 0 {
+		return nil, errors.New("flac streaminfo block not found")
+	}
+
+	info := block[4:]
+	sampleRate := int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4
+	channels := int(info[12]>>1&0x07) + 1
+	totalSamples := int64(info[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+
+	result := &schema.MediaMetadata{
+		Container:  "flac",
+		SampleRate: sampleRate,
+		Channels:   channels,
+	}
+	if sampleRate > 0 {
+		result.Duration = float64(totalSamples) / float64(sampleRate)
+	}
+	return result, nil
+}
+
+// Ogg (Vorbis / Opus)
+
+func parseOgg(r io.ReaderAt, size int64) (*schema.MediaMetadata, error) {
+	head, err := readAt(r, 0, int(min(size, 4096)))
+	if err != nil {
+		return nil, err
+	}
+	if len(head) < 27 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	// The first packet starts after the segment table of the first page
+	segments := int(head[26])
+	packet := head[min(27+segments, len(head)):]
+
+	result := &schema.MediaMetadata{Container: "ogg"}
+	var preSkip int64
+
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")) && len(packet) >= 16:
+		result.Channels = int(packet[11])
+		result.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+	case bytes.HasPrefix(packet, []byte("OpusHead")) && len(packet) >= 16:
+		result.Channels = int(packet[9])
+		result.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+		preSkip = int64(binary.LittleEndian.Uint16(packet[10:12]))
+	default:
+		return result, nil
+	}
+
+	tailSize := min(size, 65536)
+	tail, err := readAt(r, size-tailSize, int(tailSize))
+	if err != nil {
+		return result, nil
+	}
+
+	idx := bytes.LastIndex(tail, []byte("OggS"))
+	if idx < 0 || idx+14 > len(tail) {
+		return result, nil
+	}
+	granule := int64(binary.LittleEndian.Uint64(tail[idx+6 : idx+14]))
+
+	// Opus granule positions are always in 48kHz units
+	rate := int64(result.SampleRate)
+	if bytes.HasPrefix(packet, []byte("OpusHead")) {
+		rate = 48000
+	}
+	if rate > 0 && granule > preSkip {
+		result.Duration = float64(granule-preSkip) / float64(rate)
+	}
+
+	return result, nil
+}
+
+// Matroska / WebM
+
+const (
+	ebmlIDSegment       = 0x18538067
+	ebmlIDInfo          = 0x1549A966
+	ebmlIDTimecodeScale = 0x2AD7B1
+	ebmlIDDuration      = 0x4489
+	ebmlIDTracks        = 0x1654AE6B
+	ebmlIDTrackEntry    = 0xAE
+	ebmlIDVideo         = 0xE0
+	ebmlIDPixelWidth    = 0xB0
+	ebmlIDPixelHeight   = 0xBA
+	ebmlIDAudio         = 0xE1
+	ebmlIDSamplingFreq  = 0xB5
+	ebmlIDChannels      = 0x9F
+	ebmlIDDocType       = 0x4282
+)
+
+func parseMatroska(r io.ReaderAt, size int64) (*schema.MediaMetadata, error) {
+	data, err := readAt(r, 0, int(min(size, 1<<20)))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &schema.MediaMetadata{Container: "matroska"}
+	timecodeScale := 1000000.0
+	var duration float64
+
+	var walk func(data []byte)
+	walk = func(data []byte) {
+		for len(data) > 0 {
+			id, idLen := readEBMLID(data)
+			if idLen == 0 {
+				return
+			}
+			elemSize, sizeLen := readEBMLVint(data[idLen:])
+			if sizeLen == 0 {
+				return
+			}
+			start := idLen + sizeLen
+			end := len(data)
+			// Unknown sizes (all bits set) and truncated elements run to the end of the buffer
+			if elemSize >= 0 && start+int(elemSize) <= len(data) {
+				end = start + int(elemSize)
+			}
+			body := data[start:end]
+
+			switch id {
+			case ebmlIDSegment, ebmlIDInfo, ebmlIDTracks, ebmlIDTrackEntry, ebmlIDVideo, ebmlIDAudio, 0x1A45DFA3:
+				walk(body)
+			case ebmlIDDocType:
+				if string(body) == "webm" {
+					result.Container = "webm"
+				}
+			case ebmlIDTimecodeScale:
+				timecodeScale = float64(readEBMLUint(body))
+			case ebmlIDDuration:
+				duration = readEBMLFloat(body)
+			case ebmlIDPixelWidth:
+				if result.Width == 0 {
+					result.Width = int(readEBMLUint(body))
+				}
+			case ebmlIDPixelHeight:
+				if result.Height == 0 {
+					result.Height = int(readEBMLUint(body))
+				}
+			case ebmlIDSamplingFreq:
+				if result.SampleRate == 0 {
+					result.SampleRate = int(readEBMLFloat(body))
+				}
+			case ebmlIDChannels:
+				if result.Channels == 0 {
+					result.Channels = int(readEBMLUint(body))
+				}
+			}
+
+			data = data[end:]
+		}
+	}
+	walk(data)
+
+	result.Duration = duration * timecodeScale / 1e9
+	return result, nil
+}
+
+func readEBMLID(data []byte) (uint32, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+	length := 1
+	for mask := byte(0x80); length <= 4 && data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 4 || length > len(data) {
+		return 0, 0
+	}
+	var id uint32
+	for i := 0; i < length; i++ {
+		id = id<<8 | uint32(data[i])
+	}
+	return id, length
+}
+
+// readEBMLVint returns the element size, or -1 for unknown sizes.
+func readEBMLVint(data []byte) (int64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+	length := 1
+	mask := byte(0x80)
+	for data[0]&mask == 0 {
+		mask >>= 1
+		length++
+	}
+	if length > len(data) {
+		return 0, 0
+	}
+
+	value := int64(data[0] & (mask - 1))
+	allOnes := value == int64(mask-1)
+	for i := 1; i < length; i++ {
+		value = value<<8 | int64(data[i])
+		allOnes = allOnes && data[i] == 0xFF
+	}
+	if allOnes {
+		return -1, length
+	}
+	return value, length
+}
+
+func readEBMLUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func readEBMLFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+// MP3
+
+var mp3Bitrates = [2][3][16]int{
+	// MPEG-1: layer I, II, III
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+	// MPEG-2/2.5: layer I, II, III
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+}
+
+var mp3SampleRates = map[byte][3]int{
+	3: {44100, 48000, 32000}, // MPEG-1
+	2: {22050, 24000, 16000}, // MPEG-2
+	0: {11025, 12000, 8000},  // MPEG-2.5
+}
+
+func parseMP3(r io.ReaderAt, size int64) (*schema.MediaMetadata, error) {
+	var offset int64
+	if header, err := readAt(r, 0, 10); err == nil && string(header[0:3]) == "ID3" {
+		// ID3v2 tag size is a 28-bit syncsafe integer
+		tagSize := int64(header[6]&0x7F)<<21 | int64(header[7]&0x7F)<<14 | int64(header[8]&0x7F)<<7 | int64(header[9]&0x7F)
+		offset = 10 + tagSize
+		if header[5]&0x10 != 0 {
+			offset += 10
+		}
+	}
+	if offset >= size {
+		return nil, errors.New("mp3 tag exceeds file size")
+	}
+
+	buf, err := readAt(r, offset, int(min(size-offset, 8192)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the first frame sync
+	i := 0
+	for ; i+4 <= len(buf); i++ {
+		if buf[i] == 0xFF && buf[i+1]&0xE0 == 0xE0 && buf[i+1]&0x18 != 0x08 && buf[i+1]&0x06 != 0 {
+			break
+		}
+	}
+	if i+4 > len(buf) {
+		return nil, errors.New("mp3 frame not found")
+	}
+	frame := buf[i:]
+	offset += int64(i)
+
+	version := frame[1] >> 3 & 0x03
+	layer := 4 - int(frame[1]>>1&0x03)
+	bitrateIdx := frame[2] >> 4
+	rateIdx := frame[2] >> 2 & 0x03
+	channelMode := frame[3] >> 6
+
+	if rateIdx == 3 || layer < 1 || layer > 3 {
+		return nil, errors.New("invalid mp3 frame header")
+	}
+
+	versionIdx := 1
+	if version == 3 {
+		versionIdx = 0
+	}
+	bitrate := mp3Bitrates[versionIdx][layer-1][bitrateIdx] * 1000
+	sampleRate := mp3SampleRates[version][rateIdx]
+
+	channels := 2
+	if channelMode == 3 {
+		channels = 1
+	}
+
+	samplesPerFrame := 1152
+	if layer == 1 {
+		samplesPerFrame = 384
+	} else if layer == 3 && version != 3 {
+		samplesPerFrame = 576
+	}
+
+	result := &schema.MediaMetadata{
+		Container:  "mp3",
+		SampleRate: sampleRate,
+		Channels:   channels,
+		Bitrate:    bitrate,
+	}
+
+	// VBR files carry the total frame count in a Xing/Info or VBRI header
+	sideInfo := 32
+	if version != 3 {
+		sideInfo = 17
+		if channels == 1 {
+			sideInfo = 9
+		}
+	} else if channels == 1 {
+		sideInfo = 17
+	}
+
+	var frames uint32
+	if xing := 4 + sideInfo; xing+12 <= len(frame) {
+		tag := string(frame[xing : xing+4])
+		if (tag == "Xing" || tag == "Info") && frame[xing+7]&0x01 != 0 {
+			frames = binary.BigEndian.Uint32(frame[xing+8 : xing+12])
+		}
+	}
+	if frames == 0 && 36+18 <= len(frame) && string(frame[36:40]) == "VBRI" {
+		frames = binary.BigEndian.Uint32(frame[36+14 : 36+18])
+	}
+
+	switch {
+	case frames > 0 && sampleRate > 0:
+		result.Duration = float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+		if result.Duration > 0 {
+			result.Bitrate = int(float64(size-offset) * 8 / result.Duration)
+		}
+	case bitrate > 0:
+		result.Duration = float64(size-offset) * 8 / float64(bitrate)
+	}
+
+	return result, nil
+}
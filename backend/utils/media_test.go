@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// mp3Frame returns an MPEG-1 layer III frame header at 128 kbps, 44.1 kHz,
+// stereo, padded to the frame size.
+func mp3Frame() []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return frame
+}
+
+func id3Tag(size int, syncsafe [4]byte) []byte {
+	tag := append([]byte("ID3\x03\x00\x00"), syncsafe[:]...)
+	return append(tag, make([]byte, size)...)
+}
+
+func TestParseMP3(t *testing.T) {
+	cbr := bytes.Repeat(mp3Frame(), 10)
+
+	xing := mp3Frame()
+	copy(xing[36:], "Xing")
+	binary.BigEndian.PutUint32(xing[40:], 0x01)
+	binary.BigEndian.PutUint32(xing[44:], 100)
+	vbr := append(xing, bytes.Repeat(mp3Frame(), 4)...)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		wantErr      bool
+		wantDuration float64
+		wantBitrate  int
+	}{
+		{
+			name:         "cbr",
+			data:         cbr,
+			wantDuration: float64(len(cbr)) * 8 / 128000,
+			wantBitrate:  128000,
+		},
+		{
+			name:         "cbr after id3 tag",
+			data:         append(id3Tag(20, [4]byte{0, 0, 0, 20}), cbr...),
+			wantDuration: float64(len(cbr)) * 8 / 128000,
+			wantBitrate:  128000,
+		},
+		{
+			name:         "vbr with xing header",
+			data:         vbr,
+			wantDuration: 100 * 1152 / 44100.0,
+			wantBitrate:  int(float64(len(vbr)) * 8 / (100 * 1152 / 44100.0)),
+		},
+		{
+			name:    "id3 tag larger than the file",
+			data:    append(id3Tag(90, [4]byte{0x7F, 0x7F, 0x7F, 0x7F}), cbr[:10]...),
+			wantErr: true,
+		},
+		{
+			name:    "no frame sync",
+			data:    append(id3Tag(0, [4]byte{}), make([]byte, 100)...),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := GetMediaMetadata(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if result.Container != "mp3" || result.SampleRate != 44100 || result.Channels != 2 {
+				t.Errorf("got %s %d Hz %d channels, want mp3 44100 Hz 2 channels", result.Container, result.SampleRate, result.Channels)
+			}
+			if math.Abs(result.Duration-tt.wantDuration) > 1e-9 {
+				t.Errorf("duration = %v, want %v", result.Duration, tt.wantDuration)
+			}
+			if result.Bitrate != tt.wantBitrate {
+				t.Errorf("bitrate = %d, want %d", result.Bitrate, tt.wantBitrate)
+			}
+		})
+	}
+}
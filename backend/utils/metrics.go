@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "garage_webui"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Number of API requests by route pattern and status code.",
+	}, []string{"route", "code"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of API requests by route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	adminRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "garage_admin_requests_total",
+		Help:      "Number of requests to the Garage admin API by endpoint.",
+	}, []string{"endpoint"})
+
+	adminErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "garage_admin_errors_total",
+		Help:      "Number of failed requests to the Garage admin API by endpoint.",
+	}, []string{"endpoint"})
+
+	adminRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "garage_admin_request_duration_seconds",
+		Help:      "Duration of requests to the Garage admin API by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	s3Operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "s3_operations_total",
+		Help:      "Number of S3 operations by operation and result.",
+	}, []string{"operation", "result"})
+
+	s3OperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "s3_operation_duration_seconds",
+		Help:      "Duration of S3 operations by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	transferBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "transfer_bytes_total",
+		Help:      "Bytes of object data uploaded and downloaded through the web UI.",
+	}, []string{"direction"})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "logins_total",
+		Help:      "Number of login attempts by provider and result.",
+	}, []string{"provider", "result"})
+
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_requests_total",
+		Help:      "Number of cache lookups by result, hit or miss.",
+	}, []string{"result"})
+)
+
+// InitMetrics registers the metrics along with the Go runtime and process
+// collectors.
+func InitMetrics() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpRequestDuration,
+		adminRequests,
+		adminErrors,
+		adminRequestDuration,
+		s3Operations,
+		s3OperationDuration,
+		transferBytes,
+		logins,
+		cacheRequests,
+	)
+}
+
+// MetricsHandler serves the metrics in the Prometheus format. Requests need
+// the token as bearer token; without a token the endpoint is disabled.
+func MetricsHandler(token string) http.Handler {
+	handler := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func ObserveHTTPRequest(route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(route, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(route).Observe(duration.Seconds())
+}
+
+func observeAdminRequest(endpoint string, err error, duration time.Duration) {
+	adminRequests.WithLabelValues(endpoint).Inc()
+	adminRequestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+	if err != nil {
+		adminErrors.WithLabelValues(endpoint).Inc()
+	}
+}
+
+func observeS3Operation(operation string, err error, duration time.Duration) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	s3Operations.WithLabelValues(operation, result).Inc()
+	s3OperationDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// AddTransferBytes counts object data sent to ("upload") or received from
+// ("download") the S3 API on behalf of users.
+func AddTransferBytes(direction string, bytes int64) {
+	transferBytes.WithLabelValues(direction).Add(float64(bytes))
+}
+
+func ObserveLogin(provider string, success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	logins.WithLabelValues(provider, result).Inc()
+}
+
+func observeCacheLookup(hit bool) {
+	result := "hit"
+	if !hit {
+		result = "miss"
+	}
+	cacheRequests.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"khairul169/garage-webui/schema"
+	"math"
+	"sync"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// GetPDFMetadata reads the page count, first page size and document info of
+// a PDF file.
+func GetPDFMetadata(r io.ReaderAt, size int64) (result *schema.PDFMetadata, err error) {
+	defer recoverPDFError(&err)
+
+	reader, err := pdf.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	result = &schema.PDFMetadata{Pages: reader.NumPage()}
+	if result.Pages > 0 {
+		box := pdfMediaBox(reader.Page(1))
+		result.Width = box.Dx()
+		result.Height = box.Dy()
+	}
+
+	info := reader.Trailer().Key("Info")
+	result.Title = info.Key("Title").Text()
+	result.Author = info.Key("Author").Text()
+	result.Creator = info.Key("Creator").Text()
+	result.Producer = info.Key("Producer").Text()
+
+	return result, nil
+}
+
+// maxPDFRenderSize bounds both sides of a rendered page, and the size of its
+// fonts, whatever the MediaBox of the document says.
+const maxPDFRenderSize = 4096
+
+// RenderPDFPage draws the text and rectangles of a PDF page onto a white
+// canvas and returns it as a JPEG image scaled to the given width. Embedded
+// images and vector paths are not rendered.
+func RenderPDFPage(r io.ReaderAt, size int64, pageNum int, width uint) (result []byte, err error) {
+	defer recoverPDFError(&err)
+
+	reader, err := pdf.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if pageNum < 1 || pageNum > reader.NumPage() {
+		return nil, fmt.Errorf("page %d out of range", pageNum)
+	}
+
+	page := reader.Page(pageNum)
+	box := pdfMediaBox(page)
+	if box.Dx() <= 0 || box.Dy() <= 0 {
+		return nil, fmt.Errorf("invalid page size")
+	}
+
+	// Very tall pages are scaled down further to bound the canvas size
+	scale := float64(min(width, maxPDFRenderSize)) / box.Dx()
+	if box.Dy()*scale > maxPDFRenderSize {
+		scale = maxPDFRenderSize / box.Dy()
+	}
+	canvasWidth := max(int(math.Round(box.Dx()*scale)), 1)
+	canvasHeight := max(int(math.Ceil(box.Dy()*scale)), 1)
+	canvas := image.NewRGBA(image.Rect(0, 0, canvasWidth, canvasHeight))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+
+	toCanvas := func(x, y float64) (int, int) {
+		return int((x - box.X0) * scale), int((box.Y1 - y) * scale)
+	}
+
+	content := page.Content()
+
+	border := &image.Uniform{color.Gray{Y: 160}}
+	for _, rect := range content.Rect {
+		x0, y1 := toCanvas(rect.Min.X, rect.Min.Y)
+		x1, y0 := toCanvas(rect.Max.X, rect.Max.Y)
+		outline := image.Rect(x0, y0, x1, y1).Canon()
+		draw.Draw(canvas, image.Rect(outline.Min.X, outline.Min.Y, outline.Max.X, outline.Min.Y+1), border, image.Point{}, draw.Src)
+		draw.Draw(canvas, image.Rect(outline.Min.X, outline.Max.Y-1, outline.Max.X, outline.Max.Y), border, image.Point{}, draw.Src)
+		draw.Draw(canvas, image.Rect(outline.Min.X, outline.Min.Y, outline.Min.X+1, outline.Max.Y), border, image.Point{}, draw.Src)
+		draw.Draw(canvas, image.Rect(outline.Max.X-1, outline.Min.Y, outline.Max.X, outline.Max.Y), border, image.Point{}, draw.Src)
+	}
+
+	// Faces are not safe for concurrent use, so they are cached per render
+	faces := map[float64]font.Face{}
+	drawer := &font.Drawer{Dst: canvas, Src: image.Black}
+	for _, text := range content.Text {
+		face, err := getPDFFontFace(faces, text.FontSize*scale)
+		if err != nil {
+			return nil, err
+		}
+		x, y := toCanvas(text.X, text.Y)
+		drawer.Face = face
+
+		// Fonts without width tables report every glyph at the same position,
+		// so keep advancing along the line instead of overdrawing.
+		if y != drawer.Dot.Y.Round() || x > drawer.Dot.X.Round() {
+			drawer.Dot = fixed.P(x, y)
+		}
+		drawer.DrawString(text.S)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, canvas, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type pdfBox struct {
+	X0, Y0, X1, Y1 float64
+}
+
+func (b pdfBox) Dx() float64 { return b.X1 - b.X0 }
+func (b pdfBox) Dy() float64 { return b.Y1 - b.Y0 }
+
+func pdfMediaBox(page pdf.Page) pdfBox {
+	// MediaBox may be inherited from the parent page tree nodes
+	box := page.V.Key("MediaBox")
+	for parent := page.V.Key("Parent"); box.IsNull() && !parent.IsNull(); parent = parent.Key("Parent") {
+		box = parent.Key("MediaBox")
+	}
+	if box.Len() < 4 {
+		// Default to US Letter
+		return pdfBox{X1: 612, Y1: 792}
+	}
+	return pdfBox{
+		X0: box.Index(0).Float64(),
+		Y0: box.Index(1).Float64(),
+		X1: box.Index(2).Float64(),
+		Y1: box.Index(3).Float64(),
+	}
+}
+
+var (
+	pdfFont     *opentype.Font
+	pdfFontOnce sync.Once
+	pdfFontErr  error
+)
+
+func getPDFFontFace(faces map[float64]font.Face, size float64) (font.Face, error) {
+	pdfFontOnce.Do(func() {
+		pdfFont, pdfFontErr = opentype.Parse(goregular.TTF)
+	})
+	if pdfFontErr != nil {
+		return nil, pdfFontErr
+	}
+
+	// Round to half points so faces can be reused across text runs
+	size = min(max(math.Round(size*2)/2, 1), maxPDFRenderSize)
+	if face, ok := faces[size]; ok {
+		return face, nil
+	}
+
+	face, err := opentype.NewFace(pdfFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	faces[size] = face
+	return face, nil
+}
+
+// The pdf package panics on malformed documents
+func recoverPDFError(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("cannot read pdf: %v", r)
+	}
+}
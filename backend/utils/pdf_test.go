@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"testing"
+)
+
+// buildPDF returns a single page PDF with the given MediaBox and content.
+func buildPDF(mediaBox string, content string) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [%s] /Resources << /Font << /F1 << /Type /Font /Subtype /Type1 /BaseFont /Helvetica >> >> >> /Contents 4 0 R >>", mediaBox),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+
+	buf := bytes.NewBufferString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestRenderPDFPageSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		mediaBox   string
+		width      uint
+		wantWidth  int
+		wantHeight int
+	}{
+		{"letter", "0 0 612 792", 612, 612, 792},
+		{"scaled down", "0 0 612 792", 306, 306, 396},
+		{"width above the limit", "0 0 1000 1000", 100000, maxPDFRenderSize, maxPDFRenderSize},
+		{"very tall page", "0 0 10 1000000", 800, 1, maxPDFRenderSize},
+		{"very wide page", "0 0 1000000 10", 800, 800, 1},
+	}
+
+	content := "BT /F1 12 Tf 72 720 Td (Hello) Tj ET"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildPDF(tt.mediaBox, content)
+			result, err := RenderPDFPage(bytes.NewReader(data), int64(len(data)), 1, tt.width)
+			if err != nil {
+				t.Fatalf("RenderPDFPage: %v", err)
+			}
+
+			config, _, err := image.DecodeConfig(bytes.NewReader(result))
+			if err != nil {
+				t.Fatalf("decode rendered page: %v", err)
+			}
+			if config.Width != tt.wantWidth || config.Height != tt.wantHeight {
+				t.Errorf("size = %dx%d, want %dx%d", config.Width, config.Height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestRenderPDFPageHugeFont(t *testing.T) {
+	data := buildPDF("0 0 612 792", "BT /F1 100000000 Tf 72 720 Td (Hello) Tj ET")
+	if _, err := RenderPDFPage(bytes.NewReader(data), int64(len(data)), 1, 612); err != nil {
+		t.Fatalf("RenderPDFPage: %v", err)
+	}
+}
+
+func TestGetPDFMetadata(t *testing.T) {
+	data := buildPDF("0 0 595 842", "")
+	result, err := GetPDFMetadata(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("GetPDFMetadata: %v", err)
+	}
+	if result.Pages != 1 || result.Width != 595 || result.Height != 842 {
+		t.Errorf("got %d pages of %vx%v, want 1 page of 595x842", result.Pages, result.Width, result.Height)
+	}
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"khairul169/garage-webui/schema"
+	"path"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+var syntaxHints = map[string]string{
+	".c":          "c",
+	".h":          "c",
+	".cc":         "cpp",
+	".cpp":        "cpp",
+	".hpp":        "cpp",
+	".cs":         "csharp",
+	".css":        "css",
+	".csv":        "csv",
+	".dockerfile": "dockerfile",
+	".go":         "go",
+	".html":       "html",
+	".htm":        "html",
+	".ini":        "ini",
+	".java":       "java",
+	".js":         "javascript",
+	".mjs":        "javascript",
+	".jsx":        "jsx",
+	".json":       "json",
+	".kt":         "kotlin",
+	".log":        "log",
+	".lua":        "lua",
+	".md":         "markdown",
+	".php":        "php",
+	".py":         "python",
+	".rb":         "ruby",
+	".rs":         "rust",
+	".scss":       "scss",
+	".sh":         "bash",
+	".bash":       "bash",
+	".sql":        "sql",
+	".swift":      "swift",
+	".toml":       "toml",
+	".ts":         "typescript",
+	".tsx":        "tsx",
+	".txt":        "plaintext",
+	".xml":        "xml",
+	".yaml":       "yaml",
+	".yml":        "yaml",
+}
+
+var syntaxHintsByName = map[string]string{
+	"dockerfile": "dockerfile",
+	"makefile":   "makefile",
+	"go.mod":     "go",
+}
+
+// GetSyntaxHint guesses the language of a text file from its name.
+func GetSyntaxHint(key string) string {
+	name := strings.ToLower(path.Base(key))
+	if lang, ok := syntaxHintsByName[name]; ok {
+		return lang
+	}
+	return syntaxHints[path.Ext(name)]
+}
+
+// IsTextExtension reports whether the file name has a known text extension.
+func IsTextExtension(key string) bool {
+	return GetSyntaxHint(key) != ""
+}
+
+// DecodeText detects the charset of the buffer and returns its content as
+// UTF-8. The second return value is false if the buffer looks binary.
+func DecodeText(buffer []byte, truncated bool) (string, string, bool) {
+	switch {
+	case bytes.HasPrefix(buffer, []byte{0xEF, 0xBB, 0xBF}):
+		return trimIncompleteRune(buffer[3:], truncated), "utf-8", true
+	case bytes.HasPrefix(buffer, []byte{0xFF, 0xFE}):
+		return decodeUTF16(buffer[2:], false), "utf-16le", true
+	case bytes.HasPrefix(buffer, []byte{0xFE, 0xFF}):
+		return decodeUTF16(buffer[2:], true), "utf-16be", true
+	}
+
+	if bytes.IndexByte(buffer, 0) >= 0 {
+		return "", "", false
+	}
+
+	text := trimIncompleteRune(buffer, truncated)
+	if utf8.ValidString(text) {
+		for i := 0; i < len(text); i++ {
+			if text[i] >= utf8.RuneSelf {
+				return text, "utf-8", true
+			}
+		}
+		return text, "ascii", true
+	}
+
+	// Fall back to ISO-8859-1, which maps every byte to a code point
+	runes := make([]rune, len(buffer))
+	for i, b := range buffer {
+		runes[i] = rune(b)
+	}
+	return string(runes), "iso-8859-1", true
+}
+
+func trimIncompleteRune(buffer []byte, truncated bool) string {
+	if !truncated {
+		return string(buffer)
+	}
+
+	for i := 0; i < utf8.UTFMax && i < len(buffer); i++ {
+		r, size := utf8.DecodeLastRune(buffer[:len(buffer)-i])
+		if r != utf8.RuneError || size > 1 {
+			return string(buffer[:len(buffer)-i])
+		}
+	}
+	return string(buffer)
+}
+
+func decodeUTF16(buffer []byte, bigEndian bool) string {
+	units := make([]uint16, 0, len(buffer)/2)
+	for i := 0; i+1 < len(buffer); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(buffer[i])<<8|uint16(buffer[i+1]))
+		} else {
+			units = append(units, uint16(buffer[i+1])<<8|uint16(buffer[i]))
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// exifMaxHeaderSize is how much of an image is searched for EXIF tags,
+// which are stored in its header.
+const exifMaxHeaderSize = 256 << 10
+
+// GetImageMetadata reads the image dimensions and EXIF tags without decoding
+// the whole image.
+func GetImageMetadata(reader io.ReadSeeker) (*schema.ImageMetadata, error) {
+	cfg, format, err := image.DecodeConfig(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &schema.ImageMetadata{
+		Format: format,
+		Width:  cfg.Width,
+		Height: cfg.Height,
+	}
+
+	// Other formats carry no EXIF, and searching them for it would read the
+	// whole file
+	if format != "jpeg" && format != "tiff" {
+		return result, nil
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return result, nil
+	}
+
+	if x, err := exif.Decode(io.LimitReader(reader, exifMaxHeaderSize)); err == nil {
+		tags := exifTags{}
+		x.Walk(tags)
+		if len(tags) > 0 {
+			result.Exif = tags
+		}
+	}
+
+	return result, nil
+}
+
+type exifTags map[string]string
+
+func (e exifTags) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	if name == exif.MakerNote || name == exif.UserComment {
+		return nil
+	}
+
+	value := strings.Trim(tag.String(), "\"")
+	if len(value) > 256 {
+		value = value[:256]
+	}
+	e[string(name)] = value
+	return nil
+}
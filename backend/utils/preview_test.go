@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+// countingReader counts the bytes read, to check how much of an object a
+// preview downloads.
+type countingReader struct {
+	io.ReadSeeker
+	read int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadSeeker.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+func encodeJPEG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGetImageMetadataReadsHeaderOnly(t *testing.T) {
+	padding := make([]byte, 4<<20)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantFormat string
+	}{
+		{"png", append(encodePNG(t, 40, 20), padding...), "png"},
+		{"jpeg without exif", append(encodeJPEG(t, 40, 20), padding...), "jpeg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := &countingReader{ReadSeeker: bytes.NewReader(tt.data)}
+			metadata, err := GetImageMetadata(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metadata.Format != tt.wantFormat || metadata.Width != 40 || metadata.Height != 20 {
+				t.Errorf("metadata = %+v", metadata)
+			}
+			if reader.read > exifMaxHeaderSize+64<<10 {
+				t.Errorf("read %d of %d bytes", reader.read, len(tt.data))
+			}
+		})
+	}
+}
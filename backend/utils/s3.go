@@ -0,0 +1,318 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"khairul169/garage-webui/schema"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// S3ClientFactory hands out S3 clients sharing a single HTTP transport, cached
+// per access key so connections are reused across requests.
+type S3ClientFactory struct {
+	transport         *http.Transport
+	httpClient        *http.Client
+	maxAttempts       int
+	maxBackoff        time.Duration
+	defaultTimeout    time.Duration
+	operationTimeouts map[string]time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*s3Client
+	lastSweep time.Time
+}
+
+type s3Client struct {
+	secretAccessKey string
+	client          *s3.Client
+	lastUsed        time.Time
+}
+
+// Clients unused for this long are evicted, as the keys of sessions and jobs
+// come and go.
+const s3ClientIdleTimeout = 30 * time.Minute
+
+var S3Clients *S3ClientFactory
+
+// streamingOperations take time proportional to the object size, including
+// the copies done server side and the assembly of multipart uploads.
+var streamingOperations = map[string]bool{
+	"GetObject":               true,
+	"PutObject":               true,
+	"UploadPart":              true,
+	"CopyObject":              true,
+	"UploadPartCopy":          true,
+	"CompleteMultipartUpload": true,
+}
+
+func InitS3ClientFactory() {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   getEnvDuration("S3_CONNECT_TIMEOUT", 10*time.Second),
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = getEnvDuration("S3_RESPONSE_TIMEOUT", time.Minute)
+	transport.MaxIdleConns = getEnvInt("S3_MAX_IDLE_CONNS", 100)
+	transport.MaxIdleConnsPerHost = transport.MaxIdleConns
+
+	if caFile := os.Getenv("S3_CA_FILE"); caFile != "" {
+		tlsConfig, err := loadCABundle(caFile)
+		if err != nil {
+			slog.Error("Cannot load S3 CA bundle.", "error", err)
+			os.Exit(1)
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	S3Clients = &S3ClientFactory{
+		transport:         transport,
+		httpClient:        &http.Client{Transport: transport},
+		maxAttempts:       getEnvInt("S3_MAX_ATTEMPTS", retry.DefaultMaxAttempts),
+		maxBackoff:        getEnvDuration("S3_RETRY_MAX_BACKOFF", retry.DefaultMaxBackoff),
+		defaultTimeout:    getEnvDuration("S3_OPERATION_TIMEOUT", time.Minute),
+		operationTimeouts: parseOperationTimeouts(os.Getenv("S3_OPERATION_TIMEOUTS")),
+		clients:           map[string]*s3Client{},
+	}
+}
+
+// Get returns the client for the credentials, creating it on first use.
+// optFns are only applied when a new client is created.
+func (f *S3ClientFactory) Get(accessKeyID string, secretAccessKey string, optFns ...func(*s3.Options)) *s3.Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(f.lastSweep) > time.Minute {
+		for id, cached := range f.clients {
+			if now.Sub(cached.lastUsed) > s3ClientIdleTimeout {
+				delete(f.clients, id)
+			}
+		}
+		f.lastSweep = now
+	}
+
+	if cached, ok := f.clients[accessKeyID]; ok && cached.secretAccessKey == secretAccessKey {
+		cached.lastUsed = now
+		return cached.client
+	}
+
+	client := f.newClient(accessKeyID, secretAccessKey, Garage.GetS3Endpoint(), Garage.GetS3Region(), true, f.httpClient, optFns...)
+
+	f.clients[accessKeyID] = &s3Client{secretAccessKey: secretAccessKey, client: client, lastUsed: now}
+	return client
+}
+
+// NewRemote returns a client for an S3-compatible endpoint outside of the
+// Garage cluster. Remote clients are not cached.
+func (f *S3ClientFactory) NewRemote(target schema.ReplicationTarget) (*s3.Client, error) {
+	httpClient := f.httpClient
+	if target.CAFile != "" {
+		tlsConfig, err := loadCABundle(target.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load CA bundle of target %s: %w", target.Name, err)
+		}
+		transport := f.transport.Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpClient = &http.Client{Transport: transport}
+	}
+
+	region := target.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return f.newClient(target.AccessKeyID, target.SecretAccessKey, target.Endpoint, region, !target.VirtualHostStyle, httpClient), nil
+}
+
+func (f *S3ClientFactory) newClient(accessKeyID string, secretAccessKey string, endpoint string, region string, pathStyle bool, httpClient *http.Client, optFns ...func(*s3.Options)) *s3.Client {
+	awsConfig := aws.Config{
+		Credentials: credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, ""),
+		Region:      region,
+		HTTPClient:  httpClient,
+		Retryer: func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = f.maxAttempts
+				o.MaxBackoff = f.maxBackoff
+			})
+		},
+	}
+
+	return s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = pathStyle
+		o.APIOptions = append(o.APIOptions, f.withOperationTimeout, withOperationMetrics, withOperationTracing, withRequestID)
+		for _, fn := range optFns {
+			fn(o)
+		}
+	})
+}
+
+// Remove drops the cached client of the access key.
+func (f *S3ClientFactory) Remove(accessKeyID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.clients, accessKeyID)
+}
+
+// withOperationTimeout bounds each operation by its configured timeout.
+// Operations streaming object data are excluded unless configured
+// explicitly, as their duration depends on the object size.
+func (f *S3ClientFactory) withOperationTimeout(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("OperationTimeout", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		operation := awsmiddleware.GetOperationName(ctx)
+
+		timeout, ok := f.operationTimeouts[operation]
+		if !ok && !streamingOperations[operation] {
+			timeout = f.defaultTimeout
+		}
+		if timeout <= 0 {
+			return next.HandleInitialize(ctx, in)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		out, metadata, err := next.HandleInitialize(ctx, in)
+
+		// The body of a download is read after the operation returns, the
+		// timeout then ends once it is closed
+		if output, ok := out.Result.(*s3.GetObjectOutput); ok && err == nil && output.Body != nil {
+			output.Body = &cancelOnClose{ReadCloser: output.Body, cancel: cancel}
+		} else {
+			cancel()
+		}
+		return out, metadata, err
+	}), middleware.After)
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// withOperationMetrics records the count and duration of each operation,
+// including its retries.
+func withOperationMetrics(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("OperationMetrics", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		start := time.Now()
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		operation := awsmiddleware.GetOperationName(ctx)
+		observeS3Operation(operation, err, time.Since(start))
+
+		attrs := []any{"operation", operation, "duration", time.Since(start)}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		slog.DebugContext(ctx, "S3 call.", attrs...)
+		return out, metadata, err
+	}), middleware.After)
+}
+
+// withOperationTracing wraps each operation, including its retries, in a
+// span.
+func withOperationTracing(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("OperationTracing", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		operation := awsmiddleware.GetOperationName(ctx)
+		ctx, span := Tracer.Start(ctx, "S3."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			semconv.RPCSystemKey.String("aws-api"),
+			semconv.RPCService("S3"),
+			semconv.RPCMethod(operation),
+		))
+
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		EndSpan(span, err)
+		return out, metadata, err
+	}), middleware.Before)
+}
+
+// withRequestID passes the request ID and the trace context on to the S3
+// API.
+func withRequestID(stack *middleware.Stack) error {
+	return stack.Build.Add(middleware.BuildMiddlewareFunc("RequestID", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+		if req, ok := in.Request.(*smithyhttp.Request); ok {
+			if id := RequestID(ctx); id != "" {
+				req.Header.Set(RequestIDHeader, id)
+			}
+			InjectTraceContext(ctx, propagation.HeaderCarrier(req.Header))
+		}
+		return next.HandleBuild(ctx, in)
+	}), middleware.After)
+}
+
+// parseOperationTimeouts parses a comma separated list of Operation=duration
+// pairs, e.g. "PutObject=30m,ListObjectsV2=30s".
+func parseOperationTimeouts(value string) map[string]time.Duration {
+	result := map[string]time.Duration{}
+	for _, pair := range strings.Split(value, ",") {
+		operation, duration, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		timeout, err := time.ParseDuration(duration)
+		if err != nil {
+			slog.Warn("Invalid S3 timeout.", "operation", operation, "error", err)
+			continue
+		}
+		result[operation] = timeout
+	}
+	return result
+}
+
+func loadCABundle(path string) (*tls.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(GetEnv(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(GetEnv(key, ""))
+	if err != nil || value < 1 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestParseOperationTimeouts(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]time.Duration
+	}{
+		{"", map[string]time.Duration{}},
+		{"PutObject=30m", map[string]time.Duration{"PutObject": 30 * time.Minute}},
+		{"PutObject=30m, ListObjectsV2=30s", map[string]time.Duration{"PutObject": 30 * time.Minute, "ListObjectsV2": 30 * time.Second}},
+		{"GetObject=soon,HeadObject", map[string]time.Duration{}},
+	}
+
+	for _, tt := range tests {
+		if got := parseOperationTimeouts(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseOperationTimeouts(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetObjectTimeoutCoversBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		bodyDelay time.Duration
+		wantErr   bool
+	}{
+		{"body read after the operation returns", 50 * time.Millisecond, false},
+		{"body slower than the timeout", 500 * time.Millisecond, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "5")
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				time.Sleep(tt.bodyDelay)
+				w.Write([]byte("hello"))
+			}))
+			defer server.Close()
+
+			t.Setenv("S3_OPERATION_TIMEOUTS", "GetObject=200ms")
+			t.Setenv("S3_MAX_ATTEMPTS", "1")
+			InitS3ClientFactory()
+			client := S3Clients.newClient("key", "secret", server.URL, "garage", true, S3Clients.httpClient)
+
+			out, err := client.GetObject(context.Background(), &s3.GetObjectInput{
+				Bucket: aws.String("bucket"),
+				Key:    aws.String("object"),
+			})
+			if err != nil {
+				t.Fatalf("GetObject: %v", err)
+			}
+			defer out.Body.Close()
+
+			data, err := io.ReadAll(out.Body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("read body: err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(data) != "hello" {
+				t.Errorf("body = %q, want %q", data, "hello")
+			}
+		})
+	}
+}
+
+func TestOperationTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts string
+		call     func(client *s3.Client) error
+		wantErr  bool
+	}{
+		{
+			name: "list",
+			call: func(client *s3.Client) error {
+				_, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String("bucket")})
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name: "copy",
+			call: func(client *s3.Client) error {
+				_, err := client.CopyObject(context.Background(), &s3.CopyObjectInput{Bucket: aws.String("bucket"), Key: aws.String("copy"), CopySource: aws.String("bucket/object")})
+				return err
+			},
+		},
+		{
+			name:     "copy with a configured timeout",
+			timeouts: "CopyObject=100ms",
+			call: func(client *s3.Client) error {
+				_, err := client.CopyObject(context.Background(), &s3.CopyObjectInput{Bucket: aws.String("bucket"), Key: aws.String("copy"), CopySource: aws.String("bucket/object")})
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name: "complete multipart upload",
+			call: func(client *s3.Client) error {
+				_, err := client.CompleteMultipartUpload(context.Background(), &s3.CompleteMultipartUploadInput{Bucket: aws.String("bucket"), Key: aws.String("object"), UploadId: aws.String("1")})
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(300 * time.Millisecond)
+				w.Header().Set("Content-Type", "application/xml")
+				w.Write([]byte(`<Result></Result>`))
+			}))
+			defer server.Close()
+
+			t.Setenv("S3_OPERATION_TIMEOUT", "100ms")
+			t.Setenv("S3_OPERATION_TIMEOUTS", tt.timeouts)
+			t.Setenv("S3_MAX_ATTEMPTS", "1")
+			InitS3ClientFactory()
+			client := S3Clients.newClient("key", "secret", server.URL, "garage", true, S3Clients.httpClient)
+
+			if err := tt.call(client); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
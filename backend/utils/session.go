@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
 	"time"
 
@@ -31,3 +33,21 @@ func (s *SessionManager) Set(r *http.Request, key string, value interface{}) {
 func (s *SessionManager) Clear(r *http.Request) error {
 	return s.mgr.Clear(r.Context())
 }
+
+// ID returns a stable identifier of the current session, creating one if
+// needed. Unlike the session token it does not change on renewal. Requests
+// without a session cookie and without session data get an empty ID.
+func (s *SessionManager) ID(r *http.Request) string {
+	if id, ok := s.Get(r, "session_id").(string); ok && id != "" {
+		return id
+	}
+	if s.mgr.Token(r.Context()) == "" && len(s.mgr.Keys(r.Context())) == 0 {
+		return ""
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+	s.Set(r, "session_id", id)
+	return id
+}
@@ -0,0 +1,349 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionKeyPrefix is the name prefix of the Garage keys created for browsing
+// sessions. Expired keys with this prefix are removed by the janitor.
+const SessionKeyPrefix = "webui-session-"
+
+// sharedSessionID is used for requests without a session, e.g. API clients
+// not keeping cookies, so they share a key instead of creating one each.
+const sharedSessionID = "shared"
+
+type SessionKey struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	BucketID        string
+	LocalAlias      string
+	SessionID       string
+	ExpiresAt       time.Time
+}
+
+type SessionKeyManager struct {
+	enabled bool
+	ttl     time.Duration
+	mu      sync.Mutex
+	keys    map[string]*SessionKey
+	pending map[string]*sessionKeyCall
+}
+
+// sessionKeyCall is a key being created, which concurrent requests of the
+// session for the same bucket wait for.
+type sessionKeyCall struct {
+	done      chan struct{}
+	sessionID string
+	bucketID  string
+	key       *SessionKey
+	err       error
+	// discard is set if the session or bucket was revoked meanwhile
+	discard bool
+}
+
+var SessionKeys *SessionKeyManager
+
+func InitSessionKeyManager() {
+	ttl, err := time.ParseDuration(GetEnv("EPHEMERAL_KEY_TTL", "1h"))
+	if err != nil || ttl < 5*time.Minute {
+		ttl = time.Hour
+	}
+
+	SessionKeys = &SessionKeyManager{
+		enabled: GetEnv("EPHEMERAL_KEYS", "false") == "true",
+		ttl:     ttl,
+		keys:    map[string]*SessionKey{},
+		pending: map[string]*sessionKeyCall{},
+	}
+
+	if SessionKeys.enabled {
+		go SessionKeys.runJanitor()
+	}
+}
+
+func (m *SessionKeyManager) Enabled() bool {
+	return m.enabled
+}
+
+// Get returns the key of the session for the bucket, creating a new key
+// scoped to the bucket if none exists or the current one is about to expire.
+// If localAlias is set, new keys get it as local alias of the bucket so
+// buckets without a global alias can be accessed. Requests without a session
+// share a key.
+func (m *SessionKeyManager) Get(ctx context.Context, sessionID string, bucketID string, localAlias string) (*SessionKey, error) {
+	if sessionID == "" {
+		sessionID = sharedSessionID
+	}
+	id := sessionID + ":" + bucketID
+
+	m.mu.Lock()
+	if key, ok := m.keys[id]; ok {
+		if time.Until(key.ExpiresAt) > time.Minute {
+			m.mu.Unlock()
+			return key, nil
+		}
+		delete(m.keys, id)
+		go deleteSessionKey(context.WithoutCancel(ctx), key.AccessKeyID)
+	}
+
+	if call, ok := m.pending[id]; ok {
+		m.mu.Unlock()
+		<-call.done
+		return call.key, call.err
+	}
+
+	call := &sessionKeyCall{done: make(chan struct{}), sessionID: sessionID, bucketID: bucketID}
+	m.pending[id] = call
+	m.mu.Unlock()
+
+	// Other requests may wait for the key, so it is created even if this
+	// request is canceled
+	call.key, call.err = createSessionKey(context.WithoutCancel(ctx), sessionID, bucketID, localAlias, m.ttl)
+
+	m.mu.Lock()
+	delete(m.pending, id)
+	if call.err == nil {
+		if call.discard {
+			go deleteSessionKey(context.WithoutCancel(ctx), call.key.AccessKeyID)
+		} else {
+			m.keys[id] = call.key
+		}
+	}
+	m.mu.Unlock()
+	close(call.done)
+
+	return call.key, call.err
+}
+
+// Revoke deletes all keys of the session, e.g. on logout.
+func (m *SessionKeyManager) Revoke(ctx context.Context, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, key := range m.keys {
+		if key.SessionID == sessionID {
+			delete(m.keys, id)
+			go deleteSessionKey(context.WithoutCancel(ctx), key.AccessKeyID)
+		}
+	}
+	for _, call := range m.pending {
+		if call.sessionID == sessionID {
+			call.discard = true
+		}
+	}
+}
+
+// Forget deletes the keys of all sessions for the bucket, or for all buckets
+// if bucketID is empty, so they are recreated on next use.
+func (m *SessionKeyManager) Forget(ctx context.Context, bucketID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, key := range m.keys {
+		if bucketID == "" || key.BucketID == bucketID {
+			delete(m.keys, id)
+			go deleteSessionKey(context.WithoutCancel(ctx), key.AccessKeyID)
+		}
+	}
+	for _, call := range m.pending {
+		if bucketID == "" || call.bucketID == bucketID {
+			call.discard = true
+		}
+	}
+}
+
+// ForgetKey stops tracking a session key without deleting it, e.g. after S3
+// reported it does not exist. The session gets a new key on next use.
+func (m *SessionKeyManager) ForgetKey(accessKeyID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, key := range m.keys {
+		if key.AccessKeyID == accessKeyID {
+			delete(m.keys, id)
+		}
+	}
+}
+
+func (m *SessionKeyManager) runJanitor() {
+	for {
+		m.cleanup()
+		time.Sleep(5 * time.Minute)
+	}
+}
+
+// cleanup stops tracking expired keys and deletes expired session keys from
+// Garage, including the ones left over by a crash. Keys that did not expire
+// yet may belong to another replica of the web UI, so they are kept.
+func (m *SessionKeyManager) cleanup() {
+	m.mu.Lock()
+	for id, key := range m.keys {
+		if time.Now().After(key.ExpiresAt) {
+			delete(m.keys, id)
+		}
+	}
+	m.mu.Unlock()
+
+	ctx, span := StartTask(context.Background(), "session keys cleanup")
+	defer span.End()
+	DeleteExpiredKeys(ctx, SessionKeyPrefix)
+}
+
+// DeleteExpiredKeys deletes the expired keys whose name starts with one of
+// the prefixes.
+func DeleteExpiredKeys(ctx context.Context, prefixes ...string) {
+	body, err := Garage.Fetch("/v2/ListKeys", &FetchOptions{Context: ctx})
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot list keys to delete expired ones.", "error", err)
+		return
+	}
+
+	var keys []struct {
+		ID         string     `json:"id"`
+		Name       string     `json:"name"`
+		Expiration *time.Time `json:"expiration"`
+		Expired    bool       `json:"expired"`
+	}
+	if err := json.Unmarshal(body, &keys); err != nil {
+		slog.ErrorContext(ctx, "Cannot parse keys to delete expired ones.", "error", err)
+		return
+	}
+
+	for _, key := range keys {
+		expired := key.Expired || (key.Expiration != nil && time.Now().After(*key.Expiration))
+		matches := slices.ContainsFunc(prefixes, func(prefix string) bool {
+			return strings.HasPrefix(key.Name, prefix)
+		})
+		if matches && expired {
+			deleteSessionKey(ctx, key.ID)
+		}
+	}
+}
+
+func createSessionKey(ctx context.Context, sessionID string, bucketID string, localAlias string, ttl time.Duration) (*SessionKey, error) {
+	expiresAt := time.Now().Add(ttl)
+	name := fmt.Sprintf("%s%s-%s", SessionKeyPrefix, shortID(sessionID), shortID(bucketID))
+
+	accessKeyID, secretAccessKey, err := CreateScopedKey(ctx, name, expiresAt, []KeyGrant{
+		{BucketID: bucketID, LocalAlias: localAlias, Read: true, Write: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionKey{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		BucketID:        bucketID,
+		LocalAlias:      localAlias,
+		SessionID:       sessionID,
+		ExpiresAt:       expiresAt,
+	}, nil
+}
+
+// KeyGrant is a bucket permission given to a key created by CreateScopedKey.
+type KeyGrant struct {
+	BucketID   string
+	LocalAlias string
+	Read       bool
+	Write      bool
+	Owner      bool
+}
+
+// CreateScopedKey creates an expiring key that is only allowed on the given
+// buckets. The key is deleted again if any grant fails.
+func CreateScopedKey(ctx context.Context, name string, expiresAt time.Time, grants []KeyGrant) (string, string, error) {
+	body, err := Garage.Fetch("/v2/CreateKey", &FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+		Body: map[string]interface{}{
+			"name":       name,
+			"expiration": expiresAt.UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("cannot create key: %w", err)
+	}
+
+	var created struct {
+		AccessKeyID     string `json:"accessKeyId"`
+		SecretAccessKey string `json:"secretAccessKey"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil {
+		return "", "", err
+	}
+
+	for _, grant := range grants {
+		_, err = Garage.Fetch("/v2/AllowBucketKey", &FetchOptions{
+			Context: ctx,
+			Method:  http.MethodPost,
+			Body: map[string]interface{}{
+				"bucketId":    grant.BucketID,
+				"accessKeyId": created.AccessKeyID,
+				"permissions": map[string]bool{"read": grant.Read, "write": grant.Write, "owner": grant.Owner},
+			},
+		})
+		if err != nil {
+			deleteSessionKey(ctx, created.AccessKeyID)
+			return "", "", fmt.Errorf("cannot allow key on bucket: %w", err)
+		}
+
+		if grant.LocalAlias == "" {
+			continue
+		}
+		_, err = Garage.Fetch("/v2/AddBucketAlias", &FetchOptions{
+			Context: ctx,
+			Method:  http.MethodPost,
+			Body: map[string]interface{}{
+				"bucketId":    grant.BucketID,
+				"accessKeyId": created.AccessKeyID,
+				"localAlias":  grant.LocalAlias,
+			},
+		})
+		if err != nil {
+			deleteSessionKey(ctx, created.AccessKeyID)
+			return "", "", fmt.Errorf("cannot add local alias for key: %w", err)
+		}
+	}
+
+	return created.AccessKeyID, created.SecretAccessKey, nil
+}
+
+// DeleteScopedKey deletes a key created by CreateScopedKey.
+func DeleteScopedKey(ctx context.Context, accessKeyID string) {
+	deleteSessionKey(ctx, accessKeyID)
+}
+
+// deleteSessionKey deletes the key, which also revokes all its bucket
+// permissions.
+func deleteSessionKey(ctx context.Context, accessKeyID string) {
+	_, err := Garage.Fetch(fmt.Sprintf("/v2/DeleteKey?id=%s", accessKeyID), &FetchOptions{
+		Context: ctx,
+		Method:  http.MethodPost,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Session keys: cannot delete key.", "key", accessKeyID, "error", err)
+	}
+	if S3Clients != nil {
+		S3Clients.Remove(accessKeyID)
+	}
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
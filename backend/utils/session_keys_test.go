@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// adminStub serves the key endpoints of the admin API used by session keys.
+type adminStub struct {
+	mu       sync.Mutex
+	created  []string
+	deleted  []string
+	keys     []map[string]interface{}
+	blockKey string
+	release  chan struct{}
+	// requestIDs are the request IDs of all calls
+	requestIDs []string
+}
+
+func newAdminStub(t *testing.T) *adminStub {
+	stub := &adminStub{release: make(chan struct{})}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			json.Unmarshal(data, &body)
+		}
+		stub.mu.Lock()
+		stub.requestIDs = append(stub.requestIDs, r.Header.Get(RequestIDHeader))
+		stub.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/v2/CreateKey":
+			name, _ := body["name"].(string)
+			if stub.blockKey != "" && strings.Contains(name, stub.blockKey) {
+				<-stub.release
+			}
+			stub.mu.Lock()
+			stub.created = append(stub.created, name)
+			id := "GK" + strings.Repeat("0", len(stub.created))
+			stub.mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]string{"accessKeyId": id, "secretAccessKey": "secret"})
+		case "/v2/AllowBucketKey":
+			w.Write([]byte("{}"))
+		case "/v2/DeleteKey":
+			stub.mu.Lock()
+			stub.deleted = append(stub.deleted, r.URL.Query().Get("id"))
+			stub.mu.Unlock()
+			w.Write([]byte("{}"))
+		case "/v2/ListKeys":
+			json.NewEncoder(w).Encode(stub.keys)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("API_BASE_URL", server.URL)
+	t.Setenv("API_ADMIN_KEY", "admin-token")
+	InitSessionKeyManager()
+	return stub
+}
+
+func (s *adminStub) counts() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := append([]string(nil), s.deleted...)
+	sort.Strings(deleted)
+	return len(s.created), deleted
+}
+
+func TestSessionKeyGetCreatesOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions []string
+		want     int
+	}{
+		{"same session", []string{"session-a", "session-a", "session-a", "session-a"}, 1},
+		{"different sessions", []string{"session-a", "session-b", "session-c"}, 3},
+		{"requests without session share a key", []string{"", "", ""}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newAdminStub(t)
+
+			var wg sync.WaitGroup
+			keys := make([]*SessionKey, len(tt.sessions))
+			for i, session := range tt.sessions {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					key, err := SessionKeys.Get(context.Background(), session, "bucket", "")
+					if err != nil {
+						t.Error(err)
+					}
+					keys[i] = key
+				}()
+			}
+			wg.Wait()
+
+			if created, _ := stub.counts(); created != tt.want {
+				t.Errorf("created %d keys, want %d", created, tt.want)
+			}
+			for i := range tt.sessions {
+				for j := range tt.sessions {
+					if tt.sessions[i] == tt.sessions[j] && keys[i].AccessKeyID != keys[j].AccessKeyID {
+						t.Errorf("requests %d and %d of the same session got different keys", i, j)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSessionKeyGetDoesNotBlockOtherSessions(t *testing.T) {
+	stub := newAdminStub(t)
+	stub.blockKey = shortID("slow-session")
+
+	slow := make(chan error)
+	go func() {
+		_, err := SessionKeys.Get(context.Background(), "slow-session", "bucket", "")
+		slow <- err
+	}()
+
+	done := make(chan error)
+	go func() {
+		_, err := SessionKeys.Get(context.Background(), "fast-session", "bucket", "")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("key of another session is blocked by a pending creation")
+	}
+
+	close(stub.release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionKeyRevokeWhileCreating(t *testing.T) {
+	stub := newAdminStub(t)
+	stub.blockKey = shortID("session-a")
+
+	result := make(chan *SessionKey)
+	go func() {
+		key, _ := SessionKeys.Get(context.Background(), "session-a", "bucket", "")
+		result <- key
+	}()
+
+	// Wait for the creation to be pending before revoking the session
+	for {
+		SessionKeys.mu.Lock()
+		pending := len(SessionKeys.pending)
+		SessionKeys.mu.Unlock()
+		if pending > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	SessionKeys.Revoke(context.Background(), "session-a")
+	close(stub.release)
+	key := <-result
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, deleted := stub.counts(); reflect.DeepEqual(deleted, []string{key.AccessKeyID}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("key created for a revoked session was not deleted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(SessionKeys.keys) != 0 {
+		t.Errorf("revoked session still tracks %d keys", len(SessionKeys.keys))
+	}
+}
+
+func TestDeleteExpiredKeys(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	stub := newAdminStub(t)
+	stub.keys = []map[string]interface{}{
+		{"id": "GKexpired", "name": SessionKeyPrefix + "a-b", "expiration": past},
+		{"id": "GKflagged", "name": SessionKeyPrefix + "c-d", "expired": true},
+		{"id": "GKlive", "name": SessionKeyPrefix + "e-f", "expiration": future},
+		{"id": "GKforever", "name": SessionKeyPrefix + "g-h", "expiration": nil},
+		{"id": "GKother", "name": "tenant", "expiration": past},
+		{"id": "GKjob", "name": JobKeyPrefix + "sync-1", "expiration": past},
+		{"id": "GKrunning", "name": JobKeyPrefix + "sync-2", "expiration": future},
+	}
+
+	DeleteExpiredKeys(context.Background(), SessionKeyPrefix, JobKeyPrefix)
+
+	if _, deleted := stub.counts(); !reflect.DeepEqual(deleted, []string{"GKexpired", "GKflagged", "GKjob"}) {
+		t.Errorf("deleted %v, want only the expired session and job keys", deleted)
+	}
+}
+
+func TestSessionKeyCallsCarryRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{"session key", func(ctx context.Context) error {
+			_, err := SessionKeys.Get(ctx, "session-a", "bucket", "")
+			return err
+		}},
+		{"session key of cancelled request", func(ctx context.Context) error {
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err := SessionKeys.Get(ctx, "session-a", "bucket", "")
+			return err
+		}},
+		{"scoped key", func(ctx context.Context) error {
+			accessKeyID, _, err := CreateScopedKey(ctx, "job", time.Now().Add(time.Hour), []KeyGrant{{BucketID: "bucket", Read: true}})
+			if err == nil {
+				DeleteScopedKey(ctx, accessKeyID)
+			}
+			return err
+		}},
+		{"expired keys", func(ctx context.Context) error {
+			DeleteExpiredKeys(ctx, SessionKeyPrefix)
+			return nil
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newAdminStub(t)
+			stub.keys = []map[string]interface{}{{"id": "GKexpired", "name": SessionKeyPrefix + "a-b", "expired": true}}
+
+			if err := tt.call(WithRequestID(context.Background(), "req-1")); err != nil {
+				t.Fatal(err)
+			}
+
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			if len(stub.requestIDs) == 0 {
+				t.Fatal("no admin API calls")
+			}
+			for i, id := range stub.requestIDs {
+				if id != "req-1" {
+					t.Errorf("call %d has request ID %q, want req-1", i, id)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionKeyForget(t *testing.T) {
+	tests := []struct {
+		name     string
+		bucketID string
+		wantKept []string
+	}{
+		{"one bucket", "bucket-a", []string{"bucket-b"}},
+		{"all buckets", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newAdminStub(t)
+			for _, bucketID := range []string{"bucket-a", "bucket-b"} {
+				if _, err := SessionKeys.Get(context.Background(), "session-a", bucketID, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			SessionKeys.Forget(context.Background(), tt.bucketID)
+
+			var kept []string
+			SessionKeys.mu.Lock()
+			for _, key := range SessionKeys.keys {
+				kept = append(kept, key.BucketID)
+			}
+			SessionKeys.mu.Unlock()
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("kept keys of %v, want %v", kept, tt.wantKept)
+			}
+
+			// The keys are deleted in the background
+			for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+				if _, deleted := stub.counts(); len(deleted) == 2-len(tt.wantKept) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("forgotten keys were not deleted")
+				}
+			}
+		})
+	}
+}
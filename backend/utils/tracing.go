@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "khairul169/garage-webui"
+
+// Tracer creates the spans of the backend. It does nothing until
+// InitTracing installs an exporter.
+var Tracer = otel.Tracer(tracerName)
+
+// InitTracing sets up the export of traces from OTEL_TRACES_EXPORTER: otlp
+// exports over OTLP/HTTP, configured by the standard OTEL_EXPORTER_OTLP_*
+// variables, and console prints the spans to stdout. Tracing is disabled by
+// default. The trace context is propagated with W3C traceparent headers.
+// The returned function flushes the pending spans and stops the export, it
+// is called on shutdown.
+func InitTracing() func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	noop := func(context.Context) error { return nil }
+
+	exporterName := strings.ToLower(GetEnv("OTEL_TRACES_EXPORTER", "none"))
+	if exporterName == "none" {
+		return noop
+	}
+
+	exporter, err := newSpanExporter(exporterName)
+	if err != nil {
+		slog.Error("Cannot create trace exporter, tracing disabled.", "exporter", exporterName, "error", err)
+		return noop
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName("garage-webui")),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		slog.Warn("Cannot detect trace resource.", "error", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	slog.Info("Tracing enabled.", "exporter", exporterName)
+	return provider.Shutdown
+}
+
+func newSpanExporter(name string) (sdktrace.SpanExporter, error) {
+	switch name {
+	case "otlp":
+		if protocol := GetEnv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")); protocol != "" && protocol != "http/protobuf" {
+			return nil, fmt.Errorf("unsupported OTLP protocol %q, only http/protobuf is supported", protocol)
+		}
+		return otlptracehttp.New(context.Background())
+	case "console", "stdout":
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	}
+	return nil, errors.New("unknown exporter, expected otlp, console or none")
+}
+
+// ExtractTraceContext returns ctx with the trace context of an inbound
+// request.
+func ExtractTraceContext(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// InjectTraceContext adds the trace context of ctx to the headers of an
+// outbound request.
+func InjectTraceContext(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// StartTask starts the root span of background work, e.g. a scheduled poll
+// or a job, so the calls it makes are traced together.
+func StartTask(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer.Start(ctx, name, trace.WithNewRoot(), trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(attrs...))
+}
+
+// EndSpan records the error, if any, and ends the span.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
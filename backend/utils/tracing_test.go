@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInitTracingShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter string
+	}{
+		{"disabled", "none"},
+		{"unknown exporter", "zipkin"},
+		{"console", "console"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_TRACES_EXPORTER", tt.exporter)
+
+			shutdown := InitTracing()
+			if shutdown == nil {
+				t.Fatal("no shutdown function")
+			}
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("shutdown: %v", err)
+			}
+		})
+	}
+}
+
+func TestStartTaskParentsAdminCalls(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := Tracer
+	Tracer = provider.Tracer(tracerName)
+	t.Cleanup(func() { Tracer = previous })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("API_BASE_URL", server.URL)
+
+	tests := []struct {
+		name   string
+		parent context.Context
+	}{
+		{"background", context.Background()},
+		{"inside a request", func() context.Context {
+			ctx, span := Tracer.Start(context.Background(), "GET")
+			span.End()
+			return ctx
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, task := StartTask(tt.parent, "task")
+			if _, err := Garage.Fetch("/v2/GetClusterHealth", &FetchOptions{Context: ctx}); err != nil {
+				t.Fatal(err)
+			}
+			task.End()
+
+			spans := recorder.Ended()
+			call, root := spans[len(spans)-2], spans[len(spans)-1]
+			if root.Parent().IsValid() {
+				t.Errorf("task span has parent %s, want a root span", root.Parent().SpanID())
+			}
+			if call.Parent().SpanID() != root.SpanContext().SpanID() || call.SpanContext().TraceID() != root.SpanContext().TraceID() {
+				t.Errorf("admin call is not a child of the task span")
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
+	"path/filepath"
 )
 
 func GetEnv(key, defaultValue string) string {
@@ -14,6 +15,12 @@ func GetEnv(key, defaultValue string) string {
 	return value
 }
 
+// DataPath returns the path of a file or directory in DATA_DIR, where the
+// state of the web UI is kept. It defaults to "data" in the working directory.
+func DataPath(name string) string {
+	return filepath.Join(GetEnv("DATA_DIR", "data"), name)
+}
+
 func LastString(str []string) string {
 	return str[len(str)-1]
 }
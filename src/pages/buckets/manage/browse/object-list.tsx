@@ -230,7 +230,16 @@ const FilePreview = ({ ext, object }: FilePreviewProps) => {
   }
 
   if (type === "image") {
-    const thumbnailSupport = ["jpg", "jpeg", "png", "gif"].includes(ext || "");
+    const thumbnailSupport = [
+      "jpg",
+      "jpeg",
+      "png",
+      "gif",
+      "webp",
+      "bmp",
+      "tif",
+      "tiff",
+    ].includes(ext || "");
     return (
       <img
         src={API_URL + object.url + (thumbnailSupport ? "?thumb=1" : "?view=1")}
@@ -73,7 +73,7 @@ const ManageBucketPage = () => {
       {data && (
         <div className="container">
           <BucketContext.Provider
-            value={{ bucket: data, refetch, bucketName: name || "" }}
+            value={{ bucket: data, refetch, bucketName: name || data.id }}
           >
             <TabView tabs={tabs} className="bg-base-100 h-14 px-1.5" />
           </BucketContext.Provider>